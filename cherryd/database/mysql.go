@@ -23,12 +23,15 @@ package database
 
 import (
 	"database/sql"
+	"encoding/binary"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"math"
 	"net"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/dlintw/goconf"
 	"github.com/go-sql-driver/mysql"
@@ -191,13 +194,13 @@ func (r *MySQL) MAC(ip net.IP) (mac net.HardwareAddr, ok bool, err error) {
 			 FROM vip 
 			 A JOIN host B ON A.active_host_id = B.id 
 			 JOIN ip C ON A.ip_id = C.id 
-			 WHERE C.address = INET_ATON(?)
+			 WHERE C.address = INET6_ATON(?)
 		 	) 
 			UNION ALL 
 			(SELECT mac 
 			 FROM host A 
 			 JOIN ip B ON A.ip_id = B.id 
-			 WHERE B.address = INET_ATON(?)
+			 WHERE B.address = INET6_ATON(?)
 		 	) 
 			LIMIT 1`
 		row, err := db.Query(qry, ip.String(), ip.String())
@@ -231,6 +234,54 @@ func (r *MySQL) MAC(ip net.IP) (mac net.HardwareAddr, ok bool, err error) {
 	return mac, ok, err
 }
 
+// HostAddress returns the IPv4 address of the host whose MAC address is mac, and the network that the address
+// belongs to. The host registered first is used if several hosts have the same MAC address.
+func (r *MySQL) HostAddress(mac net.HardwareAddr) (ip net.IP, n network.Network, ok bool, err error) {
+	if mac == nil {
+		panic("MAC address is nil")
+	}
+
+	f := func(db *sql.DB) error {
+		qry := `SELECT INET6_NTOA(B.address), C.id, INET6_NTOA(C.address), C.mask, IFNULL(INET6_NTOA(C.gateway), '') 
+			FROM host A 
+			JOIN ip B ON A.ip_id = B.id 
+			JOIN network C ON B.network_id = C.id 
+			WHERE A.mac = ? AND LENGTH(B.address) = 4 
+			ORDER BY A.id ASC 
+			LIMIT 1`
+		row, err := db.Query(qry, []byte(mac))
+		if err != nil {
+			return err
+		}
+		defer row.Close()
+
+		// Unknown MAC address?
+		if !row.Next() {
+			return nil
+		}
+		if err := row.Err(); err != nil {
+			return err
+		}
+
+		var addr string
+		if err := row.Scan(&addr, &n.ID, &n.Address, &n.Mask, &n.Gateway); err != nil {
+			return err
+		}
+		ip = net.ParseIP(addr)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address: %v", addr)
+		}
+		ok = true
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return nil, network.Network{}, false, err
+	}
+
+	return ip, n, ok, nil
+}
+
 func (r *MySQL) Location(mac net.HardwareAddr) (dpid string, port uint32, ok bool, err error) {
 	if mac == nil {
 		panic("MAC address is nil")
@@ -271,6 +322,40 @@ func (r *MySQL) Location(mac net.HardwareAddr) (dpid string, port uint32, ok boo
 	return dpid, port, ok, err
 }
 
+func (r *MySQL) UpdateLocation(mac net.HardwareAddr, dpid uint64, port uint32) (ok bool, err error) {
+	if mac == nil {
+		panic("MAC address is nil")
+	}
+
+	f := func(db *sql.DB) error {
+		qry := `UPDATE host A 
+			JOIN port B 
+			ON B.number = ? 
+			JOIN switch C 
+			ON C.id = B.switch_id AND C.dpid = ? 
+			SET A.port_id = B.id 
+			WHERE A.mac = ?`
+		result, err := db.Exec(qry, port, dpid, []byte(mac))
+		if err != nil {
+			return err
+		}
+		nRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if nRows > 0 {
+			ok = true
+		}
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
 func (r *MySQL) Switches() (sw []network.Switch, err error) {
 	f := func(db *sql.DB) error {
 		rows, err := db.Query("SELECT id, dpid, n_ports, first_port, description FROM switch ORDER BY id DESC")
@@ -440,7 +525,7 @@ func (r *MySQL) SwitchPorts(swID uint64) (ports []network.SwitchPort, err error)
 
 func (r *MySQL) Networks() (networks []network.Network, err error) {
 	f := func(db *sql.DB) error {
-		qry := `SELECT id, INET_NTOA(address), mask
+		qry := `SELECT id, INET6_NTOA(address), mask, IFNULL(INET6_NTOA(gateway), '')
 			FROM network
 			ORDER BY id DESC`
 		rows, err := db.Query(qry)
@@ -451,7 +536,7 @@ func (r *MySQL) Networks() (networks []network.Network, err error) {
 
 		for rows.Next() {
 			v := network.Network{}
-			if err := rows.Scan(&v.ID, &v.Address, &v.Mask); err != nil {
+			if err := rows.Scan(&v.ID, &v.Address, &v.Mask, &v.Gateway); err != nil {
 				return err
 			}
 			networks = append(networks, v)
@@ -466,7 +551,7 @@ func (r *MySQL) Networks() (networks []network.Network, err error) {
 	return networks, nil
 }
 
-func (r *MySQL) AddNetwork(addr net.IP, mask net.IPMask) (netID uint64, err error) {
+func (r *MySQL) AddNetwork(addr net.IP, mask net.IPMask, gateway net.IP) (netID uint64, err error) {
 	f := func(db *sql.DB) error {
 		tx, err := db.Begin()
 		if err != nil {
@@ -474,11 +559,11 @@ func (r *MySQL) AddNetwork(addr net.IP, mask net.IPMask) (netID uint64, err erro
 		}
 		defer tx.Rollback()
 
-		netID, err = r.addNetwork(tx, addr, mask)
+		netID, err = r.addNetwork(tx, addr, mask, gateway)
 		if err != nil {
 			return err
 		}
-		if err := r.addIPAddrs(tx, netID, addr, mask); err != nil {
+		if err := r.addIPAddrs(tx, netID, addr, mask, gateway); err != nil {
 			return err
 		}
 		if err := tx.Commit(); err != nil {
@@ -494,10 +579,15 @@ func (r *MySQL) AddNetwork(addr net.IP, mask net.IPMask) (netID uint64, err erro
 	return netID, nil
 }
 
-func (r *MySQL) addNetwork(tx *sql.Tx, addr net.IP, mask net.IPMask) (netID uint64, err error) {
-	qry := "INSERT INTO network (address, mask) VALUES (INET_ATON(?), ?)"
+func (r *MySQL) addNetwork(tx *sql.Tx, addr net.IP, mask net.IPMask, gateway net.IP) (netID uint64, err error) {
+	qry := "INSERT INTO network (address, mask, gateway) VALUES (INET6_ATON(?), ?, INET6_ATON(?))"
 	ones, _ := mask.Size()
-	result, err := tx.Exec(qry, addr.String(), ones)
+	// NULL gateway if the network is not routed
+	var gw interface{}
+	if gateway != nil {
+		gw = gateway.String()
+	}
+	result, err := tx.Exec(qry, addr.String(), ones, gw)
 	if err != nil {
 		return 0, err
 	}
@@ -509,17 +599,26 @@ func (r *MySQL) addNetwork(tx *sql.Tx, addr net.IP, mask net.IPMask) (netID uint
 	return uint64(id), nil
 }
 
-func (r *MySQL) addIPAddrs(tx *sql.Tx, netID uint64, addr net.IP, mask net.IPMask) error {
-	stmt, err := tx.Prepare("INSERT INTO ip (network_id, address) VALUES (?, INET_ATON(?) + ?)")
+// addIPAddrs adds the host addresses of the network except the gateway address, which is owned by the router.
+func (r *MySQL) addIPAddrs(tx *sql.Tx, netID uint64, addr net.IP, mask net.IPMask, gateway net.IP) error {
+	stmt, err := tx.Prepare("INSERT INTO ip (network_id, address) VALUES (?, INET6_ATON(?))")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
 	ones, bits := mask.Size()
-	n_addrs := int(math.Pow(2, float64(bits-ones))) - 2 // Minus two due to network and broadcast addresses
+	n_addrs := int(math.Pow(2, float64(bits-ones))) - 1 // Minus one due to the network address
+	// IPv6 has no broadcast address
+	if addr.To4() != nil {
+		n_addrs-- // Minus one more due to the broadcast address
+	}
 	for i := 0; i < n_addrs; i++ {
-		if _, err := stmt.Exec(netID, addr.String(), i+1); err != nil {
+		ip := addOffset(addr, uint32(i+1))
+		if gateway != nil && gateway.Equal(ip) {
+			continue
+		}
+		if _, err := stmt.Exec(netID, ip.String()); err != nil {
 			return err
 		}
 	}
@@ -527,9 +626,29 @@ func (r *MySQL) addIPAddrs(tx *sql.Tx, netID uint64, addr net.IP, mask net.IPMas
 	return nil
 }
 
+// addOffset returns the IPv4 or IPv6 address that is offset away from addr.
+func addOffset(addr net.IP, offset uint32) net.IP {
+	if v4 := addr.To4(); v4 != nil {
+		v := make(net.IP, net.IPv4len)
+		binary.BigEndian.PutUint32(v, binary.BigEndian.Uint32(v4)+offset)
+		return v
+	}
+
+	v := make(net.IP, net.IPv6len)
+	copy(v, addr.To16())
+	carry := uint64(offset)
+	for i := net.IPv6len - 1; i >= 0 && carry > 0; i-- {
+		sum := uint64(v[i]) + carry
+		v[i] = byte(sum)
+		carry = sum >> 8
+	}
+
+	return v
+}
+
 func (r *MySQL) Network(addr net.IP) (n network.Network, ok bool, err error) {
 	f := func(db *sql.DB) error {
-		row, err := db.Query("SELECT id, INET_NTOA(address), mask FROM network WHERE address = INET_ATON(?)", addr.String())
+		row, err := db.Query("SELECT id, INET6_NTOA(address), mask, IFNULL(INET6_NTOA(gateway), '') FROM network WHERE address = INET6_ATON(?)", addr.String())
 		if err != nil {
 			return err
 		}
@@ -539,7 +658,7 @@ func (r *MySQL) Network(addr net.IP) (n network.Network, ok bool, err error) {
 		if !row.Next() {
 			return nil
 		}
-		if err := row.Scan(&n.ID, &n.Address, &n.Mask); err != nil {
+		if err := row.Scan(&n.ID, &n.Address, &n.Mask, &n.Gateway); err != nil {
 			return err
 		}
 		ok = true
@@ -581,7 +700,7 @@ func (r *MySQL) RemoveNetwork(id uint64) (ok bool, err error) {
 
 func (r *MySQL) IPAddrs(networkID uint64) (addresses []network.IP, err error) {
 	f := func(db *sql.DB) error {
-		qry := `SELECT A.id, INET_NTOA(A.address), A.used, C.description, CONCAT(E.description, '/', D.number) 
+		qry := `SELECT A.id, INET6_NTOA(A.address), A.used, C.description, CONCAT(E.description, '/', D.number) 
 			FROM ip A 
 			JOIN network B ON A.network_id = B.id 
 			LEFT JOIN host C ON C.ip_id = A.id 
@@ -628,7 +747,7 @@ func decodeMAC(s string) (net.HardwareAddr, error) {
 
 func (r *MySQL) Hosts() (hosts []network.Host, err error) {
 	f := func(db *sql.DB) error {
-		qry := `SELECT A.id, CONCAT(INET_NTOA(B.address), '/', E.mask), CONCAT(D.description, '/', C.number), HEX(mac), A.description 
+		qry := `SELECT A.id, CONCAT(INET6_NTOA(B.address), '/', E.mask), CONCAT(D.description, '/', C.number), HEX(mac), A.description 
 			FROM host A 
 			JOIN ip B ON A.ip_id = B.id 
 			JOIN port C ON A.port_id = C.id 
@@ -665,7 +784,7 @@ func (r *MySQL) Hosts() (hosts []network.Host, err error) {
 
 func (r *MySQL) Host(id uint64) (host network.Host, ok bool, err error) {
 	f := func(db *sql.DB) error {
-		qry := `SELECT A.id, CONCAT(INET_NTOA(B.address), '/', E.mask), CONCAT(D.description, '/', C.number), HEX(mac), A.description 
+		qry := `SELECT A.id, CONCAT(INET6_NTOA(B.address), '/', E.mask), CONCAT(D.description, '/', C.number), HEX(mac), A.description 
 			FROM host A 
 			JOIN ip B ON A.ip_id = B.id 
 			JOIN port C ON A.port_id = C.id 
@@ -789,7 +908,7 @@ func (r *MySQL) RemoveHost(id uint64) (ok bool, err error) {
 	}
 	if err = r.query(f); err != nil {
 		if isForeignkeyErr(err) {
-			return false, errors.New("failed to remove a host: it has child VIP addresses")
+			return false, errors.New("failed to remove a host: it has child VIP or floating IP addresses")
 		}
 		return false, err
 	}
@@ -833,7 +952,7 @@ func (r *MySQL) ToggleVIP(id uint64) (ip net.IP, mac net.HardwareAddr, err error
 }
 
 func getVIP(tx *sql.Tx, id uint64) (*vip, error) {
-	qry := `SELECT A.id, INET_NTOA(B.address), A.active_host_id, A.standby_host_id 
+	qry := `SELECT A.id, INET6_NTOA(B.address), A.active_host_id, A.standby_host_id 
 		FROM vip A 
 		JOIN ip B ON A.ip_id = B.id 
 		WHERE A.id = ? 
@@ -886,7 +1005,7 @@ func (r *MySQL) TogglePortVIP(swDPID uint64, portNum uint16) (result []proxyarp.
 			if err != nil {
 				return err
 			}
-			result = append(result, proxyarp.VIP{v.address, mac})
+			result = append(result, proxyarp.VIP{ID: v.id, Address: v.address, MAC: mac})
 		}
 
 		if err := tx.Commit(); err != nil {
@@ -923,7 +1042,7 @@ func (r *MySQL) ToggleDeviceVIP(swDPID uint64) (result []proxyarp.VIP, err error
 			if err != nil {
 				return err
 			}
-			result = append(result, proxyarp.VIP{v.address, mac})
+			result = append(result, proxyarp.VIP{ID: v.id, Address: v.address, MAC: mac})
 		}
 
 		if err := tx.Commit(); err != nil {
@@ -970,7 +1089,7 @@ type vip struct {
 }
 
 func getPortVIPs(tx *sql.Tx, portID uint64) (result []vip, err error) {
-	qry := `SELECT A.id, INET_NTOA(C.address), A.active_host_id, A.standby_host_id 
+	qry := `SELECT A.id, INET6_NTOA(C.address), A.active_host_id, A.standby_host_id 
 		FROM vip A 
 		JOIN host B ON A.active_host_id = B.id 
 		JOIN ip C ON A.ip_id = C.id
@@ -1002,7 +1121,7 @@ func getPortVIPs(tx *sql.Tx, portID uint64) (result []vip, err error) {
 }
 
 func getDeviceVIPs(tx *sql.Tx, swDPID uint64) (result []vip, err error) {
-	qry := `SELECT A.id, INET_NTOA(E.address), A.active_host_id, A.standby_host_id 
+	qry := `SELECT A.id, INET6_NTOA(E.address), A.active_host_id, A.standby_host_id 
 		FROM vip A 
 		JOIN host B ON A.active_host_id = B.id 
 		JOIN port C ON B.port_id = C.id 
@@ -1098,6 +1217,8 @@ func (r *MySQL) VIPs() (result []network.VIP, err error) {
 			ActiveHost:  active,
 			StandbyHost: standby,
 			Description: v.description,
+			HealthCheck: v.healthCheck,
+			HealthPort:  v.healthPort,
 		})
 	}
 
@@ -1110,11 +1231,14 @@ type registeredVIP struct {
 	active      uint64
 	standby     uint64
 	description string
+	healthCheck string
+	healthPort  uint16
 }
 
 func (r *MySQL) getVIPs() (result []registeredVIP, err error) {
 	f := func(db *sql.DB) error {
-		qry := `SELECT A.id, CONCAT(INET_NTOA(B.address), '/', C.mask), A.active_host_id, A.standby_host_id, A.description 
+		qry := `SELECT A.id, CONCAT(INET6_NTOA(B.address), '/', C.mask), A.active_host_id, A.standby_host_id, A.description, 
+			A.health_check, A.health_port 
 			FROM vip A 
 			JOIN ip B ON A.ip_id = B.id 
 			JOIN network C ON C.id = B.network_id 
@@ -1127,11 +1251,12 @@ func (r *MySQL) getVIPs() (result []registeredVIP, err error) {
 
 		for rows.Next() {
 			var id, active, standby uint64
-			var address, description string
-			if err := rows.Scan(&id, &address, &active, &standby, &description); err != nil {
+			var address, description, healthCheck string
+			var healthPort uint16
+			if err := rows.Scan(&id, &address, &active, &standby, &description, &healthCheck, &healthPort); err != nil {
 				return err
 			}
-			result = append(result, registeredVIP{id, address, active, standby, description})
+			result = append(result, registeredVIP{id, address, active, standby, description, healthCheck, healthPort})
 		}
 
 		return rows.Err()
@@ -1181,8 +1306,8 @@ func (r *MySQL) AddVIP(vip network.VIPParam) (id uint64, cidr string, err error)
 }
 
 func addNewVIP(tx *sql.Tx, vip network.VIPParam) (uint64, error) {
-	qry := "INSERT INTO vip (ip_id, active_host_id, standby_host_id, description) VALUES (?, ?, ?, ?)"
-	result, err := tx.Exec(qry, vip.IPID, vip.ActiveHostID, vip.StandbyHostID, vip.Description)
+	qry := "INSERT INTO vip (ip_id, active_host_id, standby_host_id, description, health_check, health_port) VALUES (?, ?, ?, ?, ?, ?)"
+	result, err := tx.Exec(qry, vip.IPID, vip.ActiveHostID, vip.StandbyHostID, vip.Description, vip.HealthCheck, vip.HealthPort)
 	if err != nil {
 		return 0, err
 	}
@@ -1195,7 +1320,7 @@ func addNewVIP(tx *sql.Tx, vip network.VIPParam) (uint64, error) {
 }
 
 func getIP(tx *sql.Tx, id uint64) (cidr string, err error) {
-	qry := `SELECT CONCAT(INET_NTOA(A.address), '/', B.mask) 
+	qry := `SELECT CONCAT(INET6_NTOA(A.address), '/', B.mask) 
 		FROM ip A 
 		JOIN network B ON A.network_id = B.id 
 		WHERE A.id = ?`
@@ -1238,3 +1363,710 @@ func (r *MySQL) RemoveVIP(id uint64) (ok bool, err error) {
 
 	return ok, nil
 }
+
+func (r *MySQL) Mirrors() (mirrors []network.Mirror, err error) {
+	f := func(db *sql.DB) error {
+		qry := `SELECT id, src_dpid, src_port, HEX(mac), dst_dpid, dst_port, vlan_id, description 
+			FROM mirror 
+			ORDER BY id DESC`
+		rows, err := db.Query(qry)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			v := network.Mirror{}
+			var mac sql.NullString
+			if err := rows.Scan(&v.ID, &v.SrcDPID, &v.SrcPort, &mac, &v.DstDPID, &v.DstPort, &v.VLANID, &v.Description); err != nil {
+				return err
+			}
+			// NULL MAC means that the mirror is not restricted to a specific host
+			if mac.Valid {
+				hwAddr, err := decodeMAC(mac.String)
+				if err != nil {
+					return err
+				}
+				v.MAC = hwAddr.String()
+			}
+			mirrors = append(mirrors, v)
+		}
+
+		return rows.Err()
+	}
+	if err = r.query(f); err != nil {
+		return nil, err
+	}
+
+	return mirrors, nil
+}
+
+func (r *MySQL) AddMirror(mirror network.MirrorParam) (id uint64, err error) {
+	f := func(db *sql.DB) error {
+		var mac interface{}
+		if len(mirror.MAC) > 0 {
+			hwAddr, err := net.ParseMAC(mirror.MAC)
+			if err != nil {
+				return err
+			}
+			mac = []byte(hwAddr)
+		}
+
+		qry := `INSERT INTO mirror (src_dpid, src_port, mac, dst_dpid, dst_port, vlan_id, description) 
+			VALUES (?, ?, ?, ?, ?, ?, ?)`
+		result, err := db.Exec(qry, mirror.SrcDPID, mirror.SrcPort, mac, mirror.DstDPID, mirror.DstPort, mirror.VLANID, mirror.Description)
+		if err != nil {
+			return err
+		}
+		v, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = uint64(v)
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *MySQL) RemoveMirror(id uint64) (ok bool, err error) {
+	f := func(db *sql.DB) error {
+		result, err := db.Exec("DELETE FROM mirror WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		nRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if nRows > 0 {
+			ok = true
+		}
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// ACLs returns the firewall rules in the order of evaluation.
+func (r *MySQL) ACLs() (acls []network.ACL, err error) {
+	f := func(db *sql.DB) error {
+		qry := `SELECT id, priority, CONCAT(INET_NTOA(src_address), '/', src_mask), CONCAT(INET_NTOA(dst_address), '/', dst_mask), 
+			protocol, src_port, dst_port, action, description 
+			FROM acl 
+			ORDER BY priority DESC, id ASC`
+		rows, err := db.Query(qry)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			v := network.ACL{}
+			if err := rows.Scan(&v.ID, &v.Priority, &v.Src, &v.Dst, &v.Protocol, &v.SrcPort, &v.DstPort, &v.Action, &v.Description); err != nil {
+				return err
+			}
+			acls = append(acls, v)
+		}
+
+		return rows.Err()
+	}
+	if err = r.query(f); err != nil {
+		return nil, err
+	}
+
+	return acls, nil
+}
+
+// splitCIDR returns the network address and the prefix length of cidr.
+func splitCIDR(cidr string) (addr string, ones int, err error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return "", 0, err
+	}
+	ones, _ = n.Mask.Size()
+
+	return n.IP.String(), ones, nil
+}
+
+func (r *MySQL) AddACL(acl network.ACLParam) (id uint64, err error) {
+	srcAddr, srcMask, err := splitCIDR(acl.Src)
+	if err != nil {
+		return 0, err
+	}
+	dstAddr, dstMask, err := splitCIDR(acl.Dst)
+	if err != nil {
+		return 0, err
+	}
+
+	f := func(db *sql.DB) error {
+		qry := `INSERT INTO acl (priority, src_address, src_mask, dst_address, dst_mask, protocol, src_port, dst_port, action, description) 
+			VALUES (?, INET_ATON(?), ?, INET_ATON(?), ?, ?, ?, ?, ?, ?)`
+		result, err := db.Exec(qry, acl.Priority, srcAddr, srcMask, dstAddr, dstMask, acl.Protocol, acl.SrcPort, acl.DstPort, acl.Action, acl.Description)
+		if err != nil {
+			return err
+		}
+		v, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = uint64(v)
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *MySQL) UpdateACL(id uint64, acl network.ACLParam) (ok bool, err error) {
+	srcAddr, srcMask, err := splitCIDR(acl.Src)
+	if err != nil {
+		return false, err
+	}
+	dstAddr, dstMask, err := splitCIDR(acl.Dst)
+	if err != nil {
+		return false, err
+	}
+
+	f := func(db *sql.DB) error {
+		// Check the existence separately as RowsAffected is zero if the rule is not changed
+		row, err := db.Query("SELECT id FROM acl WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		exist := row.Next()
+		row.Close()
+		if !exist {
+			return nil
+		}
+
+		qry := `UPDATE acl 
+			SET priority = ?, src_address = INET_ATON(?), src_mask = ?, dst_address = INET_ATON(?), dst_mask = ?, 
+			protocol = ?, src_port = ?, dst_port = ?, action = ?, description = ? 
+			WHERE id = ?`
+		if _, err := db.Exec(qry, acl.Priority, srcAddr, srcMask, dstAddr, dstMask, acl.Protocol, acl.SrcPort, acl.DstPort, acl.Action, acl.Description, id); err != nil {
+			return err
+		}
+		ok = true
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+func (r *MySQL) RemoveACL(id uint64) (ok bool, err error) {
+	f := func(db *sql.DB) error {
+		result, err := db.Exec("DELETE FROM acl WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		nRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if nRows > 0 {
+			ok = true
+		}
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// Frontends returns the load balancer frontends with their backends.
+func (r *MySQL) Frontends() (frontends []network.Frontend, err error) {
+	f := func(db *sql.DB) error {
+		qry := `SELECT A.id, A.name, INET_NTOA(A.ip_address), A.port, A.protocol, A.balance, 
+			B.id, B.name, INET_NTOA(B.ip_address), B.port 
+			FROM haproxy A 
+			LEFT JOIN backend B ON A.id = B.haproxy_id 
+			ORDER BY A.id ASC, B.id ASC`
+		rows, err := db.Query(qry)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			v := network.Frontend{}
+			var id sql.NullInt64
+			var name, addr sql.NullString
+			var port sql.NullInt64
+			if err := rows.Scan(&v.ID, &v.Name, &v.Address, &v.Port, &v.Protocol, &v.Balance, &id, &name, &addr, &port); err != nil {
+				return err
+			}
+			if len(frontends) == 0 || frontends[len(frontends)-1].ID != v.ID {
+				v.Backends = []network.Backend{}
+				frontends = append(frontends, v)
+			}
+			// NULL backend means that the frontend does not have any backend yet
+			if !id.Valid {
+				continue
+			}
+			last := &frontends[len(frontends)-1]
+			last.Backends = append(last.Backends, network.Backend{
+				ID: uint64(id.Int64),
+				BackendParam: network.BackendParam{
+					Name:    name.String,
+					Address: addr.String,
+					Port:    uint16(port.Int64),
+				},
+			})
+		}
+
+		return rows.Err()
+	}
+	if err = r.query(f); err != nil {
+		return nil, err
+	}
+
+	return frontends, nil
+}
+
+func (r *MySQL) AddFrontend(frontend network.FrontendParam) (id uint64, err error) {
+	f := func(db *sql.DB) error {
+		qry := `INSERT INTO haproxy (name, ip_address, port, protocol, balance) 
+			VALUES (?, INET_ATON(?), ?, ?, ?)`
+		result, err := db.Exec(qry, frontend.Name, frontend.Address, frontend.Port, frontend.Protocol, frontend.Balance)
+		if err != nil {
+			return err
+		}
+		v, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		id = uint64(v)
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// RemoveFrontend removes the frontend and its backends.
+func (r *MySQL) RemoveFrontend(id uint64) (ok bool, err error) {
+	f := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("DELETE FROM backend WHERE haproxy_id = ?", id); err != nil {
+			return err
+		}
+		result, err := tx.Exec("DELETE FROM haproxy WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		nRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if nRows == 0 {
+			return nil
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		ok = true
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// AddBackend adds a backend to the frontend whose ID is frontendID. ok is false if there is no such frontend.
+func (r *MySQL) AddBackend(frontendID uint64, backend network.BackendParam) (id uint64, ok bool, err error) {
+	f := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		row, err := tx.Query("SELECT id FROM haproxy WHERE id = ? FOR UPDATE", frontendID)
+		if err != nil {
+			return err
+		}
+		exist := row.Next()
+		row.Close()
+		if !exist {
+			return nil
+		}
+
+		qry := `INSERT INTO backend (haproxy_id, name, ip_address, port) 
+			VALUES (?, ?, INET_ATON(?), ?)`
+		result, err := tx.Exec(qry, frontendID, backend.Name, backend.Address, backend.Port)
+		if err != nil {
+			return err
+		}
+		v, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		id = uint64(v)
+		ok = true
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return 0, false, err
+	}
+
+	return id, ok, nil
+}
+
+func (r *MySQL) RemoveBackend(id uint64) (ok bool, err error) {
+	f := func(db *sql.DB) error {
+		result, err := db.Exec("DELETE FROM backend WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		nRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if nRows > 0 {
+			ok = true
+		}
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+func (r *MySQL) FloatingIPs() (result []network.FloatingIP, err error) {
+	type floatingIP struct {
+		id          uint64
+		address     string
+		host        uint64
+		description string
+	}
+	addresses := make([]floatingIP, 0)
+
+	f := func(db *sql.DB) error {
+		qry := `SELECT id, INET_NTOA(address), host_id, description 
+			FROM floating_ip 
+			ORDER BY id ASC`
+		rows, err := db.Query(qry)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			v := floatingIP{}
+			if err := rows.Scan(&v.id, &v.address, &v.host, &v.description); err != nil {
+				return err
+			}
+			addresses = append(addresses, v)
+		}
+
+		return rows.Err()
+	}
+	if err = r.query(f); err != nil {
+		return nil, err
+	}
+
+	for _, v := range addresses {
+		host, ok, err := r.Host(v.host)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("unknown host of the floating IP (ID=%v)", v.id)
+		}
+		result = append(result, network.FloatingIP{
+			ID:          v.id,
+			Address:     v.address,
+			Host:        host,
+			Description: v.description,
+		})
+	}
+
+	return result, nil
+}
+
+func (r *MySQL) AddFloatingIP(floating network.FloatingIPParam) (id uint64, err error) {
+	f := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// Floating IP should not be an address of the registered networks
+		row, err := tx.Query("SELECT id FROM ip WHERE address = INET6_ATON(?)", floating.Address)
+		if err != nil {
+			return err
+		}
+		exist := row.Next()
+		row.Close()
+		if exist {
+			return errors.New("already registered IP address")
+		}
+
+		qry := "INSERT INTO floating_ip (address, host_id, description) VALUES (INET_ATON(?), ?, ?)"
+		result, err := tx.Exec(qry, floating.Address, floating.HostID, floating.Description)
+		if err != nil {
+			return err
+		}
+		v, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		id = uint64(v)
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (r *MySQL) RemoveFloatingIP(id uint64) (ok bool, err error) {
+	f := func(db *sql.DB) error {
+		result, err := db.Exec("DELETE FROM floating_ip WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		nRows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if nRows > 0 {
+			ok = true
+		}
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, err
+	}
+
+	return ok, nil
+}
+
+// AssignFloatingIP assigns the floating IP to the host whose ID is hostID, and returns the floating IP address and
+// the MAC address of the host. ok is false if there is no such floating IP.
+func (r *MySQL) AssignFloatingIP(id, hostID uint64) (ip net.IP, mac net.HardwareAddr, ok bool, err error) {
+	f := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		row, err := tx.Query("SELECT INET_NTOA(address) FROM floating_ip WHERE id = ? FOR UPDATE", id)
+		if err != nil {
+			return err
+		}
+		if !row.Next() {
+			row.Close()
+			return nil
+		}
+		var address string
+		err = row.Scan(&address)
+		row.Close()
+		if err != nil {
+			return err
+		}
+		ip = net.ParseIP(address)
+		if ip == nil {
+			return fmt.Errorf("invalid floating IP address: %v", address)
+		}
+
+		mac, err = hostMAC(tx, hostID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("UPDATE floating_ip SET host_id = ? WHERE id = ?", hostID, id); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		ok = true
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return nil, nil, false, err
+	}
+
+	return ip, mac, ok, nil
+}
+
+// Lease acquires or renews the leader lease of the cluster for owner during ttl.
+// It returns true if owner is the leader holding the lease, and the generation ID of the lease
+// that is increased whenever the lease is taken over by another owner.
+func (r *MySQL) Lease(owner string, ttl time.Duration) (leader bool, generation uint64, err error) {
+	if len(owner) == 0 {
+		panic("empty lease owner")
+	}
+
+	f := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		// The lease is taken over only if it has been expired. Note that MySQL evaluates the
+		// assignments from left to right, so the generation is increased before updating the owner
+		// column, and the expiration is updated if owner has the lease after updating the owner column.
+		qry := `INSERT INTO cluster (id, owner, expiration, generation) 
+			VALUES (1, ?, NOW() + INTERVAL ? SECOND, 1) 
+			ON DUPLICATE KEY UPDATE 
+			generation = IF(owner <> VALUES(owner) AND expiration < NOW(), generation + 1, generation), 
+			owner = IF(owner = VALUES(owner) OR expiration < NOW(), VALUES(owner), owner), 
+			expiration = IF(owner = VALUES(owner), VALUES(expiration), expiration)`
+		if _, err := tx.Exec(qry, owner, int64(ttl.Seconds())); err != nil {
+			return err
+		}
+
+		var current string
+		if err := tx.QueryRow("SELECT owner, generation FROM cluster WHERE id = 1").Scan(&current, &generation); err != nil {
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		leader = current == owner
+
+		return nil
+	}
+	if err = r.query(f); err != nil {
+		return false, 0, err
+	}
+
+	return leader, generation, nil
+}
+
+func (r *MySQL) SaveSnapshot(s network.TopologySnapshot) error {
+	f := func(db *sql.DB) error {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec("DELETE FROM snapshot_link"); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM snapshot_device"); err != nil {
+			return err
+		}
+		for _, d := range s.Devices {
+			if _, err := tx.Exec("INSERT INTO snapshot_device (dpid, timestamp) VALUES (?, FROM_UNIXTIME(?))", d, s.Timestamp.Unix()); err != nil {
+				return err
+			}
+		}
+		for _, l := range s.Links {
+			qry := `INSERT INTO snapshot_link (first_dpid, first_port, second_dpid, second_port, timestamp) 
+				VALUES (?, ?, ?, ?, FROM_UNIXTIME(?))`
+			if _, err := tx.Exec(qry, l[0].DPID, l[0].Port, l[1].DPID, l[1].Port, s.Timestamp.Unix()); err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	}
+
+	return r.query(f)
+}
+
+func (r *MySQL) Snapshot() (s network.TopologySnapshot, ok bool, err error) {
+	f := func(db *sql.DB) error {
+		s = network.TopologySnapshot{
+			Devices: make([]string, 0),
+			Links:   make([]network.SnapshotLink, 0),
+		}
+
+		rows, err := db.Query("SELECT dpid, UNIX_TIMESTAMP(timestamp) FROM snapshot_device")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var dpid uint64
+			var timestamp int64
+			if err := rows.Scan(&dpid, &timestamp); err != nil {
+				return err
+			}
+			s.Devices = append(s.Devices, strconv.FormatUint(dpid, 10))
+			s.Timestamp = time.Unix(timestamp, 0)
+		}
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		linkRows, err := db.Query("SELECT first_dpid, first_port, second_dpid, second_port, UNIX_TIMESTAMP(timestamp) FROM snapshot_link")
+		if err != nil {
+			return err
+		}
+		defer linkRows.Close()
+
+		for linkRows.Next() {
+			var first, second uint64
+			var link network.SnapshotLink
+			var timestamp int64
+			if err := linkRows.Scan(&first, &link[0].Port, &second, &link[1].Port, &timestamp); err != nil {
+				return err
+			}
+			link[0].DPID = strconv.FormatUint(first, 10)
+			link[1].DPID = strconv.FormatUint(second, 10)
+			s.Links = append(s.Links, link)
+			s.Timestamp = time.Unix(timestamp, 0)
+		}
+		if err := linkRows.Err(); err != nil {
+			return err
+		}
+		ok = len(s.Devices) > 0 || len(s.Links) > 0
+
+		return nil
+	}
+	err = r.query(f)
+
+	return s, ok, err
+}
@@ -0,0 +1,77 @@
+-- Migration of the existing Cherry databases
+--
+-- mysql_schema.sql creates the tables only if they do not exist, so the tables of an existing database are not
+-- changed by it. Apply the sections below, in order, that are newer than the schema of your database.
+
+--
+-- Firewall: the unused `acl` table (network, mask) is replaced with the ACL rules. The old table is kept as
+-- `acl_legacy` for reference, and it can be dropped after the migration.
+--
+
+RENAME TABLE `acl` TO `acl_legacy`;
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE `acl` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `priority` smallint(5) unsigned NOT NULL,
+  `src_address` int(10) unsigned NOT NULL,
+  `src_mask` tinyint(3) unsigned NOT NULL,
+  `dst_address` int(10) unsigned NOT NULL,
+  `dst_mask` tinyint(3) unsigned NOT NULL,
+  `protocol` tinyint(3) unsigned NOT NULL,
+  `src_port` smallint(5) unsigned NOT NULL,
+  `dst_port` smallint(5) unsigned NOT NULL,
+  `action` enum('allow','deny') NOT NULL,
+  `description` varchar(255) NOT NULL,
+  PRIMARY KEY (`id`),
+  KEY `priority` (`priority`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- LoadBalancer: a frontend (`haproxy`) is not bound to a host anymore, and it can have several backends.
+--
+
+ALTER TABLE `haproxy`
+  DROP FOREIGN KEY `haproxy_ibfk_1`;
+ALTER TABLE `haproxy`
+  DROP KEY `host`,
+  DROP COLUMN `host_id`,
+  DROP COLUMN `backend_name`,
+  ADD UNIQUE KEY `frontend` (`ip_address`,`port`,`protocol`);
+ALTER TABLE `backend`
+  ADD UNIQUE KEY `address` (`haproxy_id`,`ip_address`,`port`),
+  DROP KEY `haproxy`;
+
+--
+-- VIP health check: the active host of a VIP is probed by ARP unless another health check is specified.
+--
+
+ALTER TABLE `vip`
+  ADD COLUMN `health_check` enum('arp','icmp','tcp') NOT NULL DEFAULT 'arp' AFTER `description`,
+  ADD COLUMN `health_port` smallint(5) unsigned NOT NULL DEFAULT '0' AFTER `health_check`;
+
+--
+-- IPv6 networks: the addresses of the `ip` and `network` tables are stored in the binary form of INET6_ATON()
+-- instead of the IPv4 integers, and a network can have a gateway address. The integers are converted through
+-- temporary columns because INET6_ATON() cannot be applied to an integer column in place.
+--
+
+ALTER TABLE `ip`
+  ADD COLUMN `address_v6` varbinary(16) DEFAULT NULL AFTER `address`;
+UPDATE `ip` SET `address_v6` = INET6_ATON(INET_NTOA(`address`));
+ALTER TABLE `ip`
+  DROP KEY `address`,
+  DROP COLUMN `address`,
+  CHANGE COLUMN `address_v6` `address` varbinary(16) NOT NULL,
+  ADD UNIQUE KEY `address` (`address`);
+
+ALTER TABLE `network`
+  ADD COLUMN `address_v6` varbinary(16) DEFAULT NULL AFTER `address`;
+UPDATE `network` SET `address_v6` = INET6_ATON(INET_NTOA(`address`));
+ALTER TABLE `network`
+  DROP KEY `address`,
+  DROP COLUMN `address`,
+  CHANGE COLUMN `address_v6` `address` varbinary(16) NOT NULL,
+  ADD UNIQUE KEY `address` (`address`,`mask`),
+  ADD COLUMN `gateway` varbinary(16) DEFAULT NULL AFTER `mask`;
@@ -23,10 +23,18 @@
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE IF NOT EXISTS `acl` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
-  `network` int(10) unsigned NOT NULL,
-  `mask` int(10) unsigned NOT NULL,
+  `priority` smallint(5) unsigned NOT NULL,
+  `src_address` int(10) unsigned NOT NULL,
+  `src_mask` tinyint(3) unsigned NOT NULL,
+  `dst_address` int(10) unsigned NOT NULL,
+  `dst_mask` tinyint(3) unsigned NOT NULL,
+  `protocol` tinyint(3) unsigned NOT NULL,
+  `src_port` smallint(5) unsigned NOT NULL,
+  `dst_port` smallint(5) unsigned NOT NULL,
+  `action` enum('allow','deny') NOT NULL,
+  `description` varchar(255) NOT NULL,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `acl` (`network`,`mask`)
+  KEY `priority` (`priority`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -43,11 +51,44 @@ CREATE TABLE IF NOT EXISTS `backend` (
   `ip_address` int(10) unsigned NOT NULL,
   `port` smallint(5) unsigned NOT NULL,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `haproxy` (`haproxy_id`),
+  UNIQUE KEY `address` (`haproxy_id`,`ip_address`,`port`),
   CONSTRAINT `backend_ibfk_1` FOREIGN KEY (`haproxy_id`) REFERENCES `haproxy` (`id`) ON DELETE RESTRICT ON UPDATE CASCADE
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `cluster`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE IF NOT EXISTS `cluster` (
+  `id` tinyint(3) unsigned NOT NULL,
+  `owner` varchar(255) NOT NULL,
+  `expiration` datetime NOT NULL,
+  `generation` bigint(20) unsigned NOT NULL DEFAULT 1,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `floating_ip`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE IF NOT EXISTS `floating_ip` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `address` int(10) unsigned NOT NULL,
+  `host_id` bigint(20) unsigned NOT NULL,
+  `description` varchar(255) NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `address` (`address`),
+  UNIQUE KEY `host` (`host_id`),
+  CONSTRAINT `floating_ip_ibfk_1` FOREIGN KEY (`host_id`) REFERENCES `host` (`id`) ON DELETE RESTRICT ON UPDATE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `haproxy`
 --
@@ -56,16 +97,13 @@ CREATE TABLE IF NOT EXISTS `backend` (
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE IF NOT EXISTS `haproxy` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
-  `host_id` bigint(20) unsigned NOT NULL,
   `name` varchar(50) NOT NULL,
   `ip_address` int(10) unsigned NOT NULL,
   `port` smallint(5) unsigned NOT NULL,
-  `backend_name` varchar(50) NOT NULL,
   `protocol` varchar(10) NOT NULL,
   `balance` varchar(30) NOT NULL,
   PRIMARY KEY (`id`),
-  UNIQUE KEY `host` (`host_id`),
-  CONSTRAINT `haproxy_ibfk_1` FOREIGN KEY (`host_id`) REFERENCES `host` (`id`) ON DELETE RESTRICT ON UPDATE CASCADE
+  UNIQUE KEY `frontend` (`ip_address`,`port`,`protocol`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
@@ -147,7 +185,7 @@ DELIMITER ;
 CREATE TABLE IF NOT EXISTS `ip` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
   `network_id` bigint(20) unsigned NOT NULL,
-  `address` int(10) unsigned NOT NULL,
+  `address` varbinary(16) NOT NULL,
   `used` tinyint(1) NOT NULL DEFAULT '0',
   PRIMARY KEY (`id`),
   UNIQUE KEY `address` (`address`),
@@ -157,6 +195,25 @@ CREATE TABLE IF NOT EXISTS `ip` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `mirror`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE IF NOT EXISTS `mirror` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `src_dpid` bigint(20) unsigned NOT NULL DEFAULT '0',
+  `src_port` int(10) unsigned NOT NULL DEFAULT '0',
+  `mac` binary(6) DEFAULT NULL,
+  `dst_dpid` bigint(20) unsigned NOT NULL,
+  `dst_port` int(10) unsigned NOT NULL,
+  `vlan_id` smallint(5) unsigned NOT NULL,
+  `description` varchar(255) NOT NULL,
+  PRIMARY KEY (`id`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `network`
 --
@@ -165,8 +222,9 @@ CREATE TABLE IF NOT EXISTS `ip` (
 /*!40101 SET character_set_client = utf8 */;
 CREATE TABLE IF NOT EXISTS `network` (
   `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
-  `address` int(10) unsigned NOT NULL,
+  `address` varbinary(16) NOT NULL,
   `mask` int(10) unsigned NOT NULL,
+  `gateway` varbinary(16) DEFAULT NULL,
   PRIMARY KEY (`id`),
   UNIQUE KEY `address` (`address`,`mask`)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -188,6 +246,37 @@ CREATE TABLE IF NOT EXISTS `port` (
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8;
 /*!40101 SET character_set_client = @saved_cs_client */;
 
+--
+-- Table structure for table `snapshot_device`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE IF NOT EXISTS `snapshot_device` (
+  `dpid` bigint(20) unsigned NOT NULL,
+  `timestamp` datetime NOT NULL,
+  PRIMARY KEY (`dpid`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
+--
+-- Table structure for table `snapshot_link`
+--
+
+/*!40101 SET @saved_cs_client     = @@character_set_client */;
+/*!40101 SET character_set_client = utf8 */;
+CREATE TABLE IF NOT EXISTS `snapshot_link` (
+  `id` bigint(20) unsigned NOT NULL AUTO_INCREMENT,
+  `first_dpid` bigint(20) unsigned NOT NULL,
+  `first_port` int(10) unsigned NOT NULL,
+  `second_dpid` bigint(20) unsigned NOT NULL,
+  `second_port` int(10) unsigned NOT NULL,
+  `timestamp` datetime NOT NULL,
+  PRIMARY KEY (`id`),
+  UNIQUE KEY `link` (`first_dpid`,`first_port`,`second_dpid`,`second_port`)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+/*!40101 SET character_set_client = @saved_cs_client */;
+
 --
 -- Table structure for table `switch`
 --
@@ -217,6 +306,8 @@ CREATE TABLE IF NOT EXISTS `vip` (
   `active_host_id` bigint(20) unsigned NOT NULL,
   `standby_host_id` bigint(20) unsigned NOT NULL,
   `description` varchar(255) NOT NULL,
+  `health_check` enum('arp','icmp','tcp') NOT NULL DEFAULT 'arp',
+  `health_port` smallint(5) unsigned NOT NULL DEFAULT '0',
   PRIMARY KEY (`id`),
   UNIQUE KEY `vip` (`ip_id`),
   CONSTRAINT `vip_ibfk_1` FOREIGN KEY (`ip_id`) REFERENCES `ip` (`id`) ON DELETE RESTRICT ON UPDATE CASCADE,
@@ -186,6 +186,14 @@ func (r *Graph) RemoveEdge(p Point) {
 	r.calculateMST()
 }
 
+// Recalculate calculates the minimum spanning tree again. It should be called when the weight of an edge has changed.
+func (r *Graph) Recalculate() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.calculateMST()
+}
+
 // IsEdge returns whether p is on an edge between two vertexeis.
 func (r *Graph) IsEdge(p Point) bool {
 	// Read lock
@@ -200,6 +208,38 @@ func (r *Graph) IsEdge(p Point) bool {
 	return ok
 }
 
+// Edges returns all the edges in this graph.
+func (r *Graph) Edges() []Edge {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	v := make([]Edge, 0, len(r.edges))
+	for _, e := range r.edges {
+		v = append(v, e.value)
+	}
+
+	return v
+}
+
+// Edge returns the edge on which p is. It returns nil if p is not on an edge.
+func (r *Graph) Edge(p Point) Edge {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if p == nil {
+		panic("nil point")
+	}
+
+	v, ok := r.points[p.ID()]
+	if !ok {
+		return nil
+	}
+
+	return v.value
+}
+
 // IsEnabledPoint returns whether p is an active point that is not disabled by the minimum spanning tree.
 func (r *Graph) IsEnabledPoint(p Point) bool {
 	// Read lock
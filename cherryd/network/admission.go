@@ -0,0 +1,201 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+)
+
+// admissionPolicy decides how we treat a switch that is not registered in the database.
+type admissionPolicy string
+
+const (
+	// Accept unregistered switches as if they are registered
+	policyAdmit admissionPolicy = "admit"
+	// Close the connection from unregistered switches
+	policyReject admissionPolicy = "reject"
+	// Keep unregistered switches connected but isolate them from the network
+	policyQuarantine admissionPolicy = "quarantine"
+)
+
+func parseAdmissionPolicy(s string) (admissionPolicy, error) {
+	switch p := admissionPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case policyAdmit, policyReject, policyQuarantine:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown admission policy: %v", s)
+	}
+}
+
+// Admission is an admission decision for a switch.
+type Admission struct {
+	DPID       string    `json:"dpid"`
+	Registered bool      `json:"registered"`
+	Decision   string    `json:"decision"`
+	Connected  bool      `json:"connected"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+type admission struct {
+	mutex  sync.Mutex
+	log    log.Logger
+	db     database
+	policy admissionPolicy
+	// Key is the DPID
+	decisions map[string]*Admission
+	// Key is the port ID of the neighbor ports that we isolated from quarantined switches
+	isolated map[string]isolatedPort
+}
+
+type isolatedPort struct {
+	port *Port
+	// DPID of the quarantined switch
+	quarantined string
+}
+
+func newAdmission(log log.Logger, db database, policy admissionPolicy) *admission {
+	return &admission{
+		log:       log,
+		db:        db,
+		policy:    policy,
+		decisions: make(map[string]*Admission),
+		isolated:  make(map[string]isolatedPort),
+	}
+}
+
+// decide returns the admission decision for a switch whose DPID is dpid.
+func (r *admission) decide(dpid uint64) admissionPolicy {
+	decision := policyAdmit
+	registered := true
+
+	_, ok, err := r.db.Switch(dpid)
+	if err != nil {
+		// We admit the switch if we cannot query the database to keep the network working.
+		r.log.Err(fmt.Sprintf("Admission: failed to query database, so admitting the switch (DPID=%v): %v", dpid, err))
+	} else if !ok {
+		registered = false
+		decision = r.policy
+	}
+
+	msg := fmt.Sprintf("Admission: %v the switch (DPID=%v, registered=%v)", decision, dpid, registered)
+	if registered {
+		r.log.Info(msg)
+	} else {
+		r.log.Warning(msg)
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id := fmt.Sprintf("%v", dpid)
+	r.decisions[id] = &Admission{
+		DPID:       id,
+		Registered: registered,
+		Decision:   string(decision),
+		Connected:  decision != policyReject,
+		Timestamp:  time.Now(),
+	}
+
+	return decision
+}
+
+func (r *admission) isQuarantined(dpid string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v, ok := r.decisions[dpid]
+	if !ok {
+		return false
+	}
+
+	return v.Connected && v.Decision == string(policyQuarantine)
+}
+
+func (r *admission) disconnected(dpid string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if v, ok := r.decisions[dpid]; ok {
+		v.Connected = false
+	}
+
+	// Restore the isolated ports connected to the disconnected switch
+	for k, v := range r.isolated {
+		if v.quarantined != dpid {
+			continue
+		}
+		c := v.port.Config()
+		c.NoFlood = false
+		if err := v.port.SetConfig(c); err != nil {
+			r.log.Err(fmt.Sprintf("Admission: failed to restore isolated port %v: %v", v.port.ID(), err))
+		}
+		delete(r.isolated, k)
+	}
+}
+
+// isolate stops flooding into the quarantined switch whose DPID is quarantined through p. The port is excluded from
+// the broadcast ports by the controller, and we also set OFPPC_NO_FLOOD on it for the switches that flood by themselves.
+// Note that OpenFlow 1.3 switches reject the port configuration because OpenFlow 1.3 removed OFPPC_NO_FLOOD.
+func (r *admission) isolate(p *Port, quarantined string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// The port object is renewed if the switch that p belongs to has been reconnected
+	if v, ok := r.isolated[p.ID()]; ok && v.port == p {
+		return
+	}
+	// We record the isolation even if we fail to configure the port, so that the controller does not flood into it.
+	r.isolated[p.ID()] = isolatedPort{port: p, quarantined: quarantined}
+
+	r.log.Warning(fmt.Sprintf("Admission: isolating port %v that is connected to the quarantined switch (DPID=%v)", p.ID(), quarantined))
+	c := p.Config()
+	c.NoFlood = true
+	if err := p.SetConfig(c); err != nil {
+		r.log.Err(fmt.Sprintf("Admission: failed to set no-flood on isolated port %v: %v", p.ID(), err))
+	}
+}
+
+// isIsolated returns whether p is connected to a quarantined switch.
+func (r *admission) isIsolated(p *Port) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	_, ok := r.isolated[p.ID()]
+	return ok
+}
+
+func (r *admission) list() []Admission {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v := make([]Admission, 0)
+	for _, d := range r.decisions {
+		v = append(v, *d)
+	}
+
+	return v
+}
@@ -0,0 +1,254 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const defaultLeaseTTL = 6 * time.Second
+
+// cluster elects a leader among the controller instances sharing a same database. Only the leader
+// programs flows and answers PACKET_INs, and others keep their sessions in the slave role.
+type cluster struct {
+	mutex   sync.RWMutex
+	log     log.Logger
+	db      database
+	enabled bool
+	id      string
+	ttl     time.Duration
+	leader  bool
+	// Generation ID of the role request that should be increased whenever the leader changes
+	generation uint64
+	renewed    time.Time
+	// onChange is called whenever the role of this instance changes
+	onChange func(leader bool)
+}
+
+func newCluster(log log.Logger, db database, c *clusterConfig, onChange func(leader bool)) *cluster {
+	if onChange == nil {
+		panic("nil role change callback")
+	}
+
+	return &cluster{
+		log:      log,
+		db:       db,
+		enabled:  c.enable,
+		id:       c.id,
+		ttl:      c.ttl,
+		onChange: onChange,
+	}
+}
+
+// isLeader always returns true if the cluster mode is disabled.
+func (r *cluster) isLeader() bool {
+	if !r.enabled {
+		return true
+	}
+
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.leader
+}
+
+// role returns the OpenFlow controller role of this instance and its generation ID.
+func (r *cluster) role() (openflow.ControllerRole, uint64) {
+	if !r.enabled {
+		return openflow.RoleEqual, 0
+	}
+
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.leader {
+		return openflow.RoleMaster, r.generation
+	}
+
+	return openflow.RoleSlave, r.generation
+}
+
+func (r *cluster) run() {
+	if !r.enabled {
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Cluster: starting leader election (ID=%v, TTL=%v)", r.id, r.ttl))
+	// Renew the lease several times within its TTL
+	ticker := time.NewTicker(r.ttl / 3)
+	defer ticker.Stop()
+
+	for {
+		r.elect()
+		<-ticker.C
+	}
+}
+
+func (r *cluster) elect() {
+	leader, generation, err := r.db.Lease(r.id, r.ttl)
+	now := time.Now()
+	if err != nil {
+		r.log.Err(fmt.Sprintf("Cluster: failed to renew the lease: %v", err))
+		// We cannot make sure that we still have the lease after its TTL
+		if !r.leaseExpired(now) {
+			return
+		}
+		leader = false
+	}
+
+	changed := func() bool {
+		// Write lock
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		if leader && err == nil {
+			r.renewed = now
+			// Generation ID comes from the database instead of the local clock, so that it is always greater
+			// than the previous leader's one regardless of the clock skew among the instances.
+			r.generation = generation
+		}
+		if r.leader == leader {
+			return false
+		}
+		r.leader = leader
+
+		return true
+	}()
+	if !changed {
+		return
+	}
+
+	if leader {
+		r.log.Warning(fmt.Sprintf("Cluster: this controller (ID=%v) has become the leader", r.id))
+	} else {
+		r.log.Warning(fmt.Sprintf("Cluster: this controller (ID=%v) has become a follower", r.id))
+	}
+	r.onChange(leader)
+}
+
+// leaseExpired returns whether this controller is the leader whose lease has not been renewed within its TTL.
+func (r *cluster) leaseExpired(now time.Time) bool {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.leader && now.Sub(r.renewed) >= r.ttl
+}
+
+func (r *cluster) String() string {
+	if !r.enabled {
+		return "Cluster disabled\n"
+	}
+
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return fmt.Sprintf("Cluster ID=%v, Leader=%v, Generation=%v, Renewed=%v\n", r.id, r.leader, r.generation, r.renewed)
+}
+
+// leaderListener passes the events to the next event listener only if this controller is the leader,
+// so that the applications of followers do not program flows.
+type leaderListener struct {
+	cluster *cluster
+	next    EventListener
+}
+
+func (r *leaderListener) OnPacketIn(finder Finder, ingress *Port, eth *protocol.Ethernet) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnPacketIn(finder, ingress, eth)
+}
+
+func (r *leaderListener) OnPortUp(finder Finder, port *Port) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnPortUp(finder, port)
+}
+
+func (r *leaderListener) OnPortDown(finder Finder, port *Port) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnPortDown(finder, port)
+}
+
+func (r *leaderListener) OnDeviceUp(finder Finder, device *Device) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnDeviceUp(finder, device)
+}
+
+func (r *leaderListener) OnDeviceDown(finder Finder, device *Device) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnDeviceDown(finder, device)
+}
+
+func (r *leaderListener) OnTopologyChange(finder Finder) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnTopologyChange(finder)
+}
+
+func (r *leaderListener) OnHostMoved(finder Finder, host *Node, prev *Port) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnHostMoved(finder, host, prev)
+}
+
+func (r *leaderListener) OnTopologyDiff(finder Finder, diff TopologyDiff) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnTopologyDiff(finder, diff)
+}
+
+func (r *leaderListener) OnMACFlapping(finder Finder, flapping MACFlapping) error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	return r.next.OnMACFlapping(finder, flapping)
+}
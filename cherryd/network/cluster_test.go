@@ -0,0 +1,97 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+type nullLogger struct{}
+
+func (r *nullLogger) Debug(m string) (err error) {
+	return nil
+}
+
+func (r *nullLogger) Err(m string) (err error) {
+	return nil
+}
+
+func (r *nullLogger) Info(m string) (err error) {
+	return nil
+}
+
+func (r *nullLogger) Notice(m string) (err error) {
+	return nil
+}
+
+func (r *nullLogger) Warning(m string) (err error) {
+	return nil
+}
+
+type leaseDB struct {
+	database
+	leader     bool
+	generation uint64
+	err        error
+}
+
+func (r *leaseDB) Lease(owner string, ttl time.Duration) (bool, uint64, error) {
+	return r.leader, r.generation, r.err
+}
+
+func TestClusterElection(t *testing.T) {
+	db := &leaseDB{}
+	changes := make([]bool, 0)
+	c := newCluster(new(nullLogger), db, &clusterConfig{enable: true, id: "test", ttl: 3 * time.Second}, func(leader bool) {
+		changes = append(changes, leader)
+	})
+
+	c.elect()
+	if c.isLeader() || len(changes) != 0 {
+		t.Fatalf("expected a follower without role changes, got leader=%v, changes=%v", c.isLeader(), changes)
+	}
+
+	db.leader = true
+	db.generation = 7
+	c.elect()
+	c.elect()
+	if !c.isLeader() || len(changes) != 1 {
+		t.Fatalf("expected the leader with a role change, got leader=%v, changes=%v", c.isLeader(), changes)
+	}
+	if _, generation := c.role(); generation != 7 {
+		t.Fatalf("expected the generation ID from the lease, got %v", generation)
+	}
+
+	// Keep the leadership until the lease is expired even if the database is not available
+	db.err = errors.New("connection failure")
+	c.elect()
+	if !c.isLeader() {
+		t.Fatal("expected the leader within the lease TTL")
+	}
+	c.renewed = time.Now().Add(-4 * time.Second)
+	c.elect()
+	if c.isLeader() || len(changes) != 2 || changes[1] != false {
+		t.Fatalf("expected a follower after the lease TTL, got leader=%v, changes=%v", c.isLeader(), changes)
+	}
+}
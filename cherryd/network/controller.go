@@ -22,11 +22,16 @@
 package network
 
 import (
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
+	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ant0ine/go-json-rest/rest"
 	"github.com/dlintw/goconf"
@@ -37,17 +42,39 @@ import (
 )
 
 type database interface {
+	// ACLs returns the firewall rules in the order of evaluation.
+	ACLs() ([]ACL, error)
+	AddACL(ACLParam) (id uint64, err error)
+	// AddBackend adds a backend to the frontend whose ID is frontendID. ok is false if there is no such frontend.
+	AddBackend(frontendID uint64, backend BackendParam) (id uint64, ok bool, err error)
+	AddFloatingIP(FloatingIPParam) (id uint64, err error)
+	AddFrontend(FrontendParam) (id uint64, err error)
 	AddHost(HostParam) (hostID uint64, err error)
-	AddNetwork(net.IP, net.IPMask) (netID uint64, err error)
+	AddMirror(MirrorParam) (id uint64, err error)
+	// AddNetwork adds a network whose gateway address is gateway. gateway can be nil if the network is not routed.
+	AddNetwork(addr net.IP, mask net.IPMask, gateway net.IP) (netID uint64, err error)
 	AddSwitch(SwitchParam) (swID uint64, err error)
 	AddVIP(VIPParam) (id uint64, cidr string, err error)
+	// AssignFloatingIP assigns the floating IP to the host, and returns the floating IP address and the MAC address
+	// of the host. ok is false if there is no such floating IP.
+	AssignFloatingIP(id, hostID uint64) (ip net.IP, mac net.HardwareAddr, ok bool, err error)
+	FloatingIPs() ([]FloatingIP, error)
+	// Frontends returns the load balancer frontends with their backends.
+	Frontends() ([]Frontend, error)
 	Host(hostID uint64) (host Host, ok bool, err error)
 	Hosts() ([]Host, error)
 	IPAddrs(networkID uint64) ([]IP, error)
 	Location(mac net.HardwareAddr) (dpid string, port uint32, ok bool, err error)
+	Mirrors() ([]Mirror, error)
 	Network(net.IP) (n Network, ok bool, err error)
 	Networks() ([]Network, error)
+	RemoveACL(id uint64) (ok bool, err error)
+	RemoveBackend(id uint64) (ok bool, err error)
+	RemoveFloatingIP(id uint64) (ok bool, err error)
+	// RemoveFrontend removes the frontend and its backends.
+	RemoveFrontend(id uint64) (ok bool, err error)
 	RemoveHost(id uint64) (ok bool, err error)
+	RemoveMirror(id uint64) (ok bool, err error)
 	RemoveNetwork(id uint64) (ok bool, err error)
 	RemoveSwitch(id uint64) (ok bool, err error)
 	RemoveVIP(id uint64) (ok bool, err error)
@@ -55,6 +82,15 @@ type database interface {
 	Switches() ([]Switch, error)
 	SwitchPorts(switchID uint64) ([]SwitchPort, error)
 	ToggleVIP(id uint64) (net.IP, net.HardwareAddr, error)
+	UpdateACL(id uint64, acl ACLParam) (ok bool, err error)
+	// SaveSnapshot replaces the stored topology snapshot with s.
+	SaveSnapshot(s TopologySnapshot) error
+	// Snapshot returns the last topology snapshot. ok is false if there is no snapshot.
+	Snapshot() (s TopologySnapshot, ok bool, err error)
+	// Lease acquires or renews the leader lease of the cluster, and returns whether owner holds the lease
+	// and the generation ID of the lease, which is increased whenever the lease is taken over.
+	Lease(owner string, ttl time.Duration) (leader bool, generation uint64, err error)
+	UpdateLocation(mac net.HardwareAddr, dpid uint64, port uint32) (ok bool, err error)
 	VIPs() ([]VIP, error)
 }
 
@@ -73,13 +109,27 @@ type ControllerEventListener interface {
 
 type TopologyEventListener interface {
 	OnTopologyChange(Finder) error
+	// OnHostMoved is called when a host appears on a port that is different from its previous location.
+	// prev may be nil if the previous location is not connected to the controller.
+	OnHostMoved(finder Finder, host *Node, prev *Port) error
+	// OnTopologyDiff is called once after startup when the live topology has been compared with the last snapshot.
+	OnTopologyDiff(finder Finder, diff TopologyDiff) error
+	// OnMACFlapping is called when a host moves among the ports facing hosts too frequently.
+	OnMACFlapping(finder Finder, flapping MACFlapping) error
 }
 
 type Controller struct {
-	log      log.Logger
-	topo     *topology
-	listener EventListener
-	db       database
+	log       log.Logger
+	topo      *topology
+	listener  EventListener
+	db        database
+	admission *admission
+	grace     *gracePeriod
+	stream    *eventStream
+	cluster   *cluster
+	snapshot  *snapshotter
+	limiter   *packetLimiter
+	health    *vipHealthChecker
 }
 
 func NewController(log log.Logger, db database, conf *goconf.ConfigFile) *Controller {
@@ -87,12 +137,73 @@ func NewController(log log.Logger, db database, conf *goconf.ConfigFile) *Contro
 		panic("Logger is nil")
 	}
 
-	v := &Controller{
-		log:  log,
-		topo: newTopology(log, db),
-		db:   db,
+	hostConf, err := parseHostConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing host configurations: %v (using default values)", err))
+		hostConf = &hostConfig{agingTime: defaultHostAgingTime}
+	}
+
+	policy, err := parseAdmissionConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing admission configurations: %v (admitting all switches)", err))
+		policy = policyAdmit
+	}
+
+	grace, err := parseGraceConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing grace period configurations: %v (disabling the grace period)", err))
+		grace = 0
+	}
+
+	topoConf, err := parseTopologyConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing topology configurations: %v (using default values)", err))
+		topoConf = &topologyConfig{snapshotInterval: defaultSnapshotInterval, snapshotSettle: defaultSnapshotSettle}
+	}
+
+	clusterConf, err := parseClusterConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing cluster configurations: %v (disabling the cluster mode)", err))
+		clusterConf = &clusterConfig{}
+	}
+
+	flapConf, err := parseLoopConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing loop detection configurations: %v (using default values)", err))
+		flapConf = &flapConfig{enable: true, window: defaultFlapWindow, threshold: defaultFlapThreshold, action: blockNone, holdTime: defaultFlapHoldTime}
+	}
+
+	limiterConf, err := parsePacketInConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing PACKET_IN configurations: %v (disabling the PACKET_IN limiter)", err))
+		limiterConf = &packetLimiterConfig{dropFlowTimeout: defaultDropFlowTimeout}
 	}
+
+	healthConf, err := parseVIPHealthConfig(conf)
+	if err != nil {
+		log.Err(fmt.Sprintf("Controller: parsing VIP health check configurations: %v (disabling the health checks)", err))
+		healthConf = &vipHealthConfig{}
+	}
+
+	stream := newEventStream()
+	v := &Controller{
+		log:       log,
+		topo:      newTopology(log, db, hostConf, stream, topoConf.latencyWeight, flapConf),
+		db:        db,
+		admission: newAdmission(log, db, policy),
+		grace:     newGracePeriod(log, grace),
+		stream:    stream,
+		limiter:   newPacketLimiter(log, *limiterConf),
+	}
+	v.cluster = newCluster(log, db, clusterConf, v.onRoleChanged)
+	v.snapshot = newSnapshotter(log, db, v.topo, v.cluster, topoConf, v.reportTopologyDiff)
+	v.health = newVIPHealthChecker(log, db, v.topo, v.cluster, stream, *healthConf)
+	// Events are streamed to the REST clients even if there is no event listener.
+	v.SetEventListener(nil)
 	go v.serveREST(conf)
+	go v.cluster.run()
+	go v.snapshot.run()
+	go v.health.run()
 
 	return v
 }
@@ -109,8 +220,15 @@ func (r *Controller) serveREST(conf *goconf.ConfigFile) {
 		rest.Get("/api/v1/switch", r.listSwitch),
 		rest.Post("/api/v1/switch", r.addSwitch),
 		rest.Delete("/api/v1/switch/:id", r.removeSwitch),
+		rest.Get("/api/v1/admission", r.listAdmission),
 		rest.Options("/api/v1/switch/:id", r.allowOrigin),
 		rest.Get("/api/v1/port/:switchID", r.listPort),
+		rest.Put("/api/v1/port/:dpid/:number/disable", r.disablePort),
+		rest.Options("/api/v1/port/:dpid/:number/disable", r.allowOrigin),
+		rest.Put("/api/v1/port/:dpid/:number/enable", r.enablePort),
+		rest.Options("/api/v1/port/:dpid/:number/enable", r.allowOrigin),
+		rest.Put("/api/v1/port/:dpid/:number/config", r.configurePort),
+		rest.Options("/api/v1/port/:dpid/:number/config", r.allowOrigin),
 		rest.Get("/api/v1/network", r.listNetwork),
 		rest.Post("/api/v1/network", r.addNetwork),
 		rest.Delete("/api/v1/network/:id", r.removeNetwork),
@@ -120,11 +238,33 @@ func (r *Controller) serveREST(conf *goconf.ConfigFile) {
 		rest.Post("/api/v1/host", r.addHost),
 		rest.Delete("/api/v1/host/:id", r.removeHost),
 		rest.Options("/api/v1/host/:id", r.allowOrigin),
+		rest.Get("/api/v1/learned_host", r.listLearnedHost),
+		rest.Get("/api/v1/topology", r.showTopology),
+		rest.Get("/api/v1/topology/diff", r.showTopologyDiff),
 		rest.Get("/api/v1/vip", r.listVIP),
 		rest.Post("/api/v1/vip", r.addVIP),
 		rest.Delete("/api/v1/vip/:id", r.removeVIP),
 		rest.Options("/api/v1/vip/:id", r.allowOrigin),
 		rest.Put("/api/v1/vip/:id", r.toggleVIP),
+		rest.Get("/api/v1/mirror", r.listMirror),
+		rest.Post("/api/v1/mirror", r.addMirror),
+		rest.Delete("/api/v1/mirror/:id", r.removeMirror),
+		rest.Get("/api/v1/acl", r.listACL),
+		rest.Post("/api/v1/acl", r.addACL),
+		rest.Put("/api/v1/acl/:id", r.updateACL),
+		rest.Delete("/api/v1/acl/:id", r.removeACL),
+		rest.Get("/api/v1/frontend", r.listFrontend),
+		rest.Post("/api/v1/frontend", r.addFrontend),
+		rest.Delete("/api/v1/frontend/:id", r.removeFrontend),
+		rest.Post("/api/v1/frontend/:id/backend", r.addBackend),
+		rest.Delete("/api/v1/backend/:id", r.removeBackend),
+		rest.Get("/api/v1/floating_ip", r.listFloatingIP),
+		rest.Post("/api/v1/floating_ip", r.addFloatingIP),
+		rest.Put("/api/v1/floating_ip/:id", r.assignFloatingIP),
+		rest.Delete("/api/v1/floating_ip/:id", r.removeFloatingIP),
+		rest.Options("/api/v1/mirror/:id", r.allowOrigin),
+		rest.Get("/api/v1/event", r.streamEvent),
+		rest.Get("/api/v1/trace", r.trace),
 	)
 	if err != nil {
 		r.log.Err(fmt.Sprintf("Controller: making a REST router: %v", err))
@@ -188,6 +328,332 @@ func parseRESTConfig(conf *goconf.ConfigFile) (*restConfig, error) {
 	return c, nil
 }
 
+type hostConfig struct {
+	agingTime      time.Duration
+	fallback       bool
+	updateLocation bool
+}
+
+// parseHostConfig uses default values for the options that are not specified
+// because the host section is optional.
+func parseHostConfig(conf *goconf.ConfigFile) (*hostConfig, error) {
+	c := &hostConfig{
+		agingTime: defaultHostAgingTime,
+	}
+
+	if conf.HasOption("host", "aging_time") {
+		aging, err := conf.GetInt("host", "aging_time")
+		if err != nil || aging <= 0 {
+			return nil, errors.New("invalid host/aging_time value")
+		}
+		c.agingTime = time.Duration(aging) * time.Second
+	}
+
+	if conf.HasOption("host", "fallback") {
+		fallback, err := conf.GetBool("host", "fallback")
+		if err != nil {
+			return nil, errors.New("invalid host/fallback value")
+		}
+		c.fallback = fallback
+	}
+
+	if conf.HasOption("host", "update_location") {
+		update, err := conf.GetBool("host", "update_location")
+		if err != nil {
+			return nil, errors.New("invalid host/update_location value")
+		}
+		c.updateLocation = update
+	}
+
+	return c, nil
+}
+
+// parseAdmissionConfig returns policyAdmit if default/unregistered_switch is not specified.
+func parseAdmissionConfig(conf *goconf.ConfigFile) (admissionPolicy, error) {
+	if !conf.HasOption("default", "unregistered_switch") {
+		return policyAdmit, nil
+	}
+
+	v, err := conf.GetString("default", "unregistered_switch")
+	if err != nil {
+		return "", errors.New("invalid default/unregistered_switch value")
+	}
+
+	return parseAdmissionPolicy(v)
+}
+
+// parseGraceConfig returns zero, which disables the grace period, if default/reconnect_grace is not specified.
+func parseGraceConfig(conf *goconf.ConfigFile) (time.Duration, error) {
+	if !conf.HasOption("default", "reconnect_grace") {
+		return 0, nil
+	}
+
+	v, err := conf.GetInt("default", "reconnect_grace")
+	if err != nil || v < 0 {
+		return 0, errors.New("invalid default/reconnect_grace value")
+	}
+
+	return time.Duration(v) * time.Second, nil
+}
+
+type topologyConfig struct {
+	// Use the link latency as the link weight of the spanning tree?
+	latencyWeight    bool
+	snapshotInterval time.Duration
+	snapshotSettle   time.Duration
+}
+
+// parseTopologyConfig reads the optional topology section of the config file.
+func parseTopologyConfig(conf *goconf.ConfigFile) (*topologyConfig, error) {
+	c := &topologyConfig{
+		snapshotInterval: defaultSnapshotInterval,
+		snapshotSettle:   defaultSnapshotSettle,
+	}
+
+	if conf.HasOption("topology", "latency_weight") {
+		v, err := conf.GetBool("topology", "latency_weight")
+		if err != nil {
+			return nil, errors.New("invalid topology/latency_weight value")
+		}
+		c.latencyWeight = v
+	}
+
+	if conf.HasOption("topology", "snapshot_interval") {
+		v, err := conf.GetInt("topology", "snapshot_interval")
+		if err != nil || v <= 0 {
+			return nil, errors.New("invalid topology/snapshot_interval value")
+		}
+		c.snapshotInterval = time.Duration(v) * time.Second
+	}
+
+	if conf.HasOption("topology", "snapshot_settle") {
+		v, err := conf.GetInt("topology", "snapshot_settle")
+		if err != nil || v < 0 {
+			return nil, errors.New("invalid topology/snapshot_settle value")
+		}
+		c.snapshotSettle = time.Duration(v) * time.Second
+	}
+
+	return c, nil
+}
+
+// parseLoopConfig reads the optional loop section. The MAC flapping detection is enabled by default,
+// but no port is blocked unless loop/action is specified.
+func parseLoopConfig(conf *goconf.ConfigFile) (*flapConfig, error) {
+	c := &flapConfig{
+		enable:    true,
+		window:    defaultFlapWindow,
+		threshold: defaultFlapThreshold,
+		action:    blockNone,
+		holdTime:  defaultFlapHoldTime,
+	}
+
+	if conf.HasOption("loop", "enable") {
+		v, err := conf.GetBool("loop", "enable")
+		if err != nil {
+			return nil, errors.New("invalid loop/enable value")
+		}
+		c.enable = v
+	}
+
+	if conf.HasOption("loop", "window") {
+		v, err := conf.GetInt("loop", "window")
+		if err != nil || v <= 0 {
+			return nil, errors.New("invalid loop/window value")
+		}
+		c.window = time.Duration(v) * time.Second
+	}
+
+	if conf.HasOption("loop", "threshold") {
+		v, err := conf.GetInt("loop", "threshold")
+		if err != nil || v < 2 {
+			return nil, errors.New("invalid loop/threshold value")
+		}
+		c.threshold = v
+	}
+
+	if conf.HasOption("loop", "action") {
+		v, err := conf.GetString("loop", "action")
+		if err != nil {
+			return nil, errors.New("invalid loop/action value")
+		}
+		action, err := parseBlockAction(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		c.action = action
+	}
+
+	if conf.HasOption("loop", "hold_time") {
+		v, err := conf.GetInt("loop", "hold_time")
+		if err != nil || v <= 0 {
+			return nil, errors.New("invalid loop/hold_time value")
+		}
+		c.holdTime = time.Duration(v) * time.Second
+	}
+
+	return c, nil
+}
+
+// parsePacketInConfig reads the optional packet_in section. A limiter is disabled if its rate is not specified.
+// The burst size is twice the rate if it is not specified.
+func parsePacketInConfig(conf *goconf.ConfigFile) (*packetLimiterConfig, error) {
+	c := &packetLimiterConfig{dropFlowTimeout: defaultDropFlowTimeout}
+
+	var err error
+	if c.portRate, c.portBurst, err = parseRateConfig(conf, "port"); err != nil {
+		return nil, err
+	}
+	if c.deviceRate, c.deviceBurst, err = parseRateConfig(conf, "device"); err != nil {
+		return nil, err
+	}
+
+	if conf.HasOption("packet_in", "drop_flow") {
+		v, err := conf.GetBool("packet_in", "drop_flow")
+		if err != nil {
+			return nil, errors.New("invalid packet_in/drop_flow value")
+		}
+		c.dropFlow = v
+	}
+
+	if conf.HasOption("packet_in", "drop_flow_timeout") {
+		v, err := conf.GetInt("packet_in", "drop_flow_timeout")
+		// Hard timeout of a flow is 16-bit seconds
+		if err != nil || v <= 0 || v > 0xFFFF {
+			return nil, errors.New("invalid packet_in/drop_flow_timeout value")
+		}
+		c.dropFlowTimeout = time.Duration(v) * time.Second
+	}
+
+	return c, nil
+}
+
+// parseRateConfig reads packet_in/<prefix>_rate and packet_in/<prefix>_burst.
+func parseRateConfig(conf *goconf.ConfigFile, prefix string) (rate, burst float64, err error) {
+	if !conf.HasOption("packet_in", prefix+"_rate") {
+		return 0, 0, nil
+	}
+	v, err := conf.GetInt("packet_in", prefix+"_rate")
+	if err != nil || v < 0 {
+		return 0, 0, fmt.Errorf("invalid packet_in/%v_rate value", prefix)
+	}
+	rate = float64(v)
+	burst = rate * 2
+
+	if conf.HasOption("packet_in", prefix+"_burst") {
+		v, err := conf.GetInt("packet_in", prefix+"_burst")
+		if err != nil || v < 1 {
+			return 0, 0, fmt.Errorf("invalid packet_in/%v_burst value", prefix)
+		}
+		burst = float64(v)
+	}
+
+	return rate, burst, nil
+}
+
+// parseVIPHealthConfig reads the optional vip_health section. The health checks are disabled by default.
+func parseVIPHealthConfig(conf *goconf.ConfigFile) (*vipHealthConfig, error) {
+	mac, _ := net.ParseMAC(defaultVIPHealthMAC)
+	c := &vipHealthConfig{
+		interval:  defaultVIPHealthInterval,
+		threshold: defaultVIPHealthThreshold,
+		mac:       mac,
+	}
+
+	if !conf.HasOption("vip_health", "enable") {
+		return c, nil
+	}
+	enable, err := conf.GetBool("vip_health", "enable")
+	if err != nil {
+		return nil, errors.New("invalid vip_health/enable value")
+	}
+	c.enable = enable
+
+	if conf.HasOption("vip_health", "interval") {
+		v, err := conf.GetInt("vip_health", "interval")
+		if err != nil || v <= 0 {
+			return nil, errors.New("invalid vip_health/interval value")
+		}
+		c.interval = time.Duration(v) * time.Second
+	}
+
+	if conf.HasOption("vip_health", "threshold") {
+		v, err := conf.GetInt("vip_health", "threshold")
+		if err != nil || v < 1 {
+			return nil, errors.New("invalid vip_health/threshold value")
+		}
+		c.threshold = v
+	}
+
+	if conf.HasOption("vip_health", "mac") {
+		v, err := conf.GetString("vip_health", "mac")
+		if err != nil {
+			return nil, errors.New("invalid vip_health/mac value")
+		}
+		mac, err := net.ParseMAC(strings.TrimSpace(v))
+		if err != nil {
+			return nil, errors.New("invalid vip_health/mac value")
+		}
+		c.mac = mac
+	}
+
+	// The ICMP and TCP probes are only answered to the controller if the Router application owns the gateways
+	apps, _ := conf.GetString("default", "applications")
+	for _, v := range strings.Split(apps, ",") {
+		if strings.TrimSpace(v) == "Router" {
+			c.router = true
+		}
+	}
+
+	return c, nil
+}
+
+type clusterConfig struct {
+	enable bool
+	// Unique ID of this controller instance in the cluster
+	id  string
+	ttl time.Duration
+}
+
+// parseClusterConfig reads the optional cluster section. The hostname is used as the ID if cluster/id is not specified.
+func parseClusterConfig(conf *goconf.ConfigFile) (*clusterConfig, error) {
+	c := &clusterConfig{ttl: defaultLeaseTTL}
+
+	if !conf.HasOption("cluster", "enable") {
+		return c, nil
+	}
+	enable, err := conf.GetBool("cluster", "enable")
+	if err != nil {
+		return nil, errors.New("invalid cluster/enable value")
+	}
+	c.enable = enable
+
+	if conf.HasOption("cluster", "id") {
+		id, err := conf.GetString("cluster", "id")
+		if err != nil || len(id) == 0 {
+			return nil, errors.New("invalid cluster/id value")
+		}
+		c.id = id
+	} else {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get the hostname: %v", err)
+		}
+		c.id = hostname
+	}
+
+	if conf.HasOption("cluster", "lease_ttl") {
+		ttl, err := conf.GetInt("cluster", "lease_ttl")
+		// We renew the lease every TTL/3 seconds
+		if err != nil || ttl < 3 {
+			return nil, errors.New("invalid cluster/lease_ttl value")
+		}
+		c.ttl = time.Duration(ttl) * time.Second
+	}
+
+	return c, nil
+}
+
 func (r *Controller) allowOrigin(w rest.ResponseWriter, req *rest.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "DELETE, PUT")
@@ -302,6 +768,14 @@ func (r *Controller) removeSwitch(w rest.ResponseWriter, req *rest.Request) {
 	w.WriteJson(&struct{}{})
 }
 
+func (r *Controller) listAdmission(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	w.WriteJson(&struct {
+		Admissions []Admission `json:"admissions"`
+	}{r.admission.list()})
+}
+
 type SwitchPort struct {
 	ID     uint64 `json:"id"`
 	Number uint   `json:"number"`
@@ -328,22 +802,162 @@ func (r *Controller) listPort(w rest.ResponseWriter, req *rest.Request) {
 	}{ports})
 }
 
+func (r *Controller) disablePort(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	r.setPortDown(w, req, true)
+}
+
+func (r *Controller) enablePort(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	r.setPortDown(w, req, false)
+}
+
+func (r *Controller) setPortDown(w rest.ResponseWriter, req *rest.Request, down bool) {
+	r.setPortConfig(w, req, PortConfigParam{PortDown: &down})
+}
+
+// PortConfigParam is a set of the port configuration flags to change. Nil means that the flag is left untouched.
+type PortConfigParam struct {
+	PortDown *bool `json:"port_down"`
+	// NoFlood is only supported on OpenFlow 1.0 switches.
+	NoFlood    *bool `json:"no_flood"`
+	NoPacketIn *bool `json:"no_packet_in"`
+}
+
+func (r *PortConfigParam) validate() error {
+	if r.PortDown == nil && r.NoFlood == nil && r.NoPacketIn == nil {
+		return errors.New("empty port configuration")
+	}
+
+	return nil
+}
+
+func (r *Controller) configurePort(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	p := PortConfigParam{}
+	if err := req.DecodeJsonPayload(&p); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := p.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	r.setPortConfig(w, req, p)
+}
+
+func (r *Controller) setPortConfig(w rest.ResponseWriter, req *rest.Request, p PortConfigParam) {
+	port, err := r.findPort(req.PathParam("dpid"), req.PathParam("number"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	// Keep other configuration flags
+	c := port.Config()
+	if p.PortDown != nil {
+		c.PortDown = *p.PortDown
+	}
+	if p.NoFlood != nil {
+		c.NoFlood = *p.NoFlood
+	}
+	if p.NoPacketIn != nil {
+		c.NoPacketIn = *p.NoPacketIn
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: changing the configuration of port %v: %+v", port.ID(), c))
+	if err := port.SetConfig(c); err != nil {
+		// The device does not support the requested flags, such as the no-flood flag of OpenFlow 1.3
+		if err == openflow.ErrUnsupportedPortConfig {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		r.log.Err(fmt.Sprintf("Controller: REST: failed to change the configuration of port %v: %v", port.ID(), err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteJson(&struct{}{})
+}
+
+func (r *Controller) findPort(dpid, number string) (*Port, error) {
+	device := r.topo.Device(dpid)
+	if device == nil {
+		return nil, errors.New("unknown or disconnected switch")
+	}
+	num, err := strconv.ParseUint(number, 10, 32)
+	if err != nil {
+		return nil, errors.New("invalid port number")
+	}
+	port := device.Port(uint32(num))
+	if port == nil {
+		return nil, errors.New("unknown port number")
+	}
+
+	return port, nil
+}
+
 type NetworkParam struct {
 	Address string `json:"address"`
 	Mask    uint8  `json:"mask"`
+	// Gateway is the address owned by the router of the network. Empty string means that the
+	// network is not routed.
+	Gateway string `json:"gateway"`
 }
 
+// All the host addresses of a network are registered in the database when the network is added, so the
+// networks are limited to small ones: /24 to /30 for IPv4 and /120 to /126 for IPv6. The hosts of an IPv6
+// network should be configured statically or by DHCPv6 as SLAAC requires a /64 network.
 func (r *NetworkParam) validate() error {
-	if net.ParseIP(r.Address) == nil {
+	addr := net.ParseIP(r.Address)
+	if addr == nil {
 		return errors.New("invalid network address")
 	}
+	if addr.To4() == nil {
+		if r.Mask < 120 || r.Mask > 126 {
+			return errors.New("invalid network mask: IPv6 networks should be /120 to /126")
+		}
+		// Router only routes IPv4 packets
+		if r.Gateway != "" {
+			return errors.New("gateway is not supported on IPv6 networks")
+		}
+		return nil
+	}
 	if r.Mask < 24 || r.Mask > 30 {
 		return errors.New("invalid network mask")
 	}
+	if r.Gateway == "" {
+		return nil
+	}
+
+	gateway := net.ParseIP(r.Gateway)
+	if gateway == nil || gateway.To4() == nil {
+		return errors.New("invalid gateway address")
+	}
+	mask := net.CIDRMask(int(r.Mask), 32)
+	network := &net.IPNet{IP: addr.Mask(mask), Mask: mask}
+	if !network.Contains(gateway) {
+		return errors.New("gateway address is not in the network")
+	}
+	// Network and broadcast addresses?
+	hostBits := binary.BigEndian.Uint32(gateway.To4()) &^ binary.BigEndian.Uint32(mask)
+	if hostBits == 0 || hostBits == ^binary.BigEndian.Uint32(mask) {
+		return errors.New("gateway address should be a host address of the network")
+	}
 
 	return nil
 }
 
+// addressBits returns the length of the network address in bits.
+// XXX: r should be validated before calling this function.
+func (r *NetworkParam) addressBits() int {
+	if net.ParseIP(r.Address).To4() == nil {
+		return 128
+	}
+
+	return 32
+}
+
 type Network struct {
 	ID uint64 `json:"id"`
 	NetworkParam
@@ -377,13 +991,18 @@ func (r *Controller) addNetwork(w rest.ResponseWriter, req *rest.Request) {
 		return
 	}
 
-	netMask := net.CIDRMask(int(network.Mask), 32)
+	netMask := net.CIDRMask(int(network.Mask), network.addressBits())
 	netAddr := net.ParseIP(network.Address)
 	if netAddr == nil {
 		panic("network.Address should be valid")
 	}
 	netAddr = netAddr.Mask(netMask)
 
+	var gateway net.IP
+	if network.Gateway != "" {
+		gateway = net.ParseIP(network.Gateway)
+	}
+
 	r.log.Info(fmt.Sprintf("Controller: REST: adding new network address: %v/%v", network.Address, network.Mask))
 	_, ok, err := r.db.Network(netAddr)
 	if err != nil {
@@ -396,7 +1015,7 @@ func (r *Controller) addNetwork(w rest.ResponseWriter, req *rest.Request) {
 		writeError(w, http.StatusConflict, errors.New("duplicated network address"))
 		return
 	}
-	netID, err := r.db.AddNetwork(netAddr, netMask)
+	netID, err := r.db.AddNetwork(netAddr, netMask, gateway)
 	if err != nil {
 		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
 		writeError(w, http.StatusInternalServerError, err)
@@ -510,34 +1129,111 @@ func (r *Controller) listHost(w rest.ResponseWriter, req *rest.Request) {
 	}{hosts})
 }
 
-func (r *Controller) addHost(w rest.ResponseWriter, req *rest.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
+type LearnedHostParam struct {
+	MAC       string    `json:"mac"`
+	IP        string    `json:"ip"`
+	Port      string    `json:"port"`
+	Timestamp time.Time `json:"timestamp"`
+}
 
-	host := HostParam{}
-	if err := req.DecodeJsonPayload(&host); err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
-	}
-	if err := host.validate(); err != nil {
-		writeError(w, http.StatusBadRequest, err)
-		return
-	}
+func (r *Controller) listLearnedHost(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
 
-	r.log.Info(fmt.Sprintf("Controller: REST: adding a new host (%+v)", host))
-	hostID, err := r.db.AddHost(host)
-	if err != nil {
-		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
-		writeError(w, http.StatusInternalServerError, err)
-		return
+	hosts := make([]LearnedHostParam, 0)
+	for _, v := range r.topo.LearnedHosts() {
+		ip := ""
+		if v.IP != nil {
+			ip = v.IP.String()
+		}
+		hosts = append(hosts, LearnedHostParam{
+			MAC:       v.MAC.String(),
+			IP:        ip,
+			Port:      v.Port.ID(),
+			Timestamp: v.Timestamp,
+		})
 	}
-	r.log.Info(fmt.Sprintf("Controller: REST: added the new host (%+v)", host))
 
 	w.WriteJson(&struct {
-		HostID uint64 `json:"host_id"`
-	}{hostID})
+		Hosts []LearnedHostParam `json:"hosts"`
+	}{hosts})
+}
 
-	regHost, ok, err := r.db.Host(hostID)
-	if err != nil {
+type TopologyLink struct {
+	ID    string        `json:"id"`
+	Ports []*StreamPort `json:"ports"`
+	// Enabled is false if the link is disabled by the spanning tree
+	Enabled bool `json:"enabled"`
+	// One-way latency in milliseconds. Zero means unknown or negligible.
+	Latency float64 `json:"latency"`
+}
+
+type TopologyParam struct {
+	Devices []string        `json:"devices"`
+	Links   []*TopologyLink `json:"links"`
+}
+
+func (r *Controller) showTopology(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	result := TopologyParam{
+		Devices: make([]string, 0),
+		Links:   make([]*TopologyLink, 0),
+	}
+	for _, d := range r.topo.Devices() {
+		result.Devices = append(result.Devices, d.ID())
+	}
+	for _, l := range r.topo.Links() {
+		result.Links = append(result.Links, &TopologyLink{
+			ID:      l.ID(),
+			Ports:   []*StreamPort{newStreamPort(l.ports[0]), newStreamPort(l.ports[1])},
+			Enabled: r.topo.IsEnabledBySTP(l.ports[0]),
+			Latency: l.Latency().Seconds() * 1000,
+		})
+	}
+
+	w.WriteJson(&result)
+}
+
+func (r *Controller) showTopologyDiff(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	diff := r.snapshot.Diff()
+	if diff == nil {
+		writeError(w, http.StatusNotFound, errors.New("topology has not been compared with the last snapshot yet"))
+		return
+	}
+
+	w.WriteJson(diff)
+}
+
+func (r *Controller) addHost(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	host := HostParam{}
+	if err := req.DecodeJsonPayload(&host); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := host.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: adding a new host (%+v)", host))
+	hostID, err := r.db.AddHost(host)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: added the new host (%+v)", host))
+
+	w.WriteJson(&struct {
+		HostID uint64 `json:"host_id"`
+	}{hostID})
+
+	regHost, ok, err := r.db.Host(hostID)
+	if err != nil {
 		r.log.Err(fmt.Sprintf("Controller: REST: failed to query a registered host: %v", err))
 		return
 	}
@@ -617,22 +1313,34 @@ type VIPParam struct {
 	ActiveHostID  uint64 `json:"active_host_id"`
 	StandbyHostID uint64 `json:"standby_host_id"`
 	Description   string `json:"description"`
+	// HealthCheck is arp, icmp, or tcp. Empty string means arp.
+	HealthCheck string `json:"health_check"`
+	// HealthPort is the TCP port probed by the tcp health check.
+	HealthPort uint16 `json:"health_port"`
 }
 
 func (r *VIPParam) validate() error {
 	if r.ActiveHostID == r.StandbyHostID {
 		return errors.New("same host for the active and standby")
 	}
+	check, err := parseVIPHealthCheck(r.HealthCheck, r.HealthPort)
+	if err != nil {
+		return err
+	}
+	r.HealthCheck = check
 
 	return nil
 }
 
 type VIP struct {
-	ID          uint64 `json:"id"`
-	IP          string `json:"ip"`
-	ActiveHost  Host   `json:"active_host"`
-	StandbyHost Host   `json:"standby_host"`
-	Description string `json:"description"`
+	ID          uint64    `json:"id"`
+	IP          string    `json:"ip"`
+	ActiveHost  Host      `json:"active_host"`
+	StandbyHost Host      `json:"standby_host"`
+	Description string    `json:"description"`
+	HealthCheck string    `json:"health_check"`
+	HealthPort  uint16    `json:"health_port"`
+	Health      VIPHealth `json:"health"`
 }
 
 func (r *Controller) listVIP(w rest.ResponseWriter, req *rest.Request) {
@@ -644,6 +1352,9 @@ func (r *Controller) listVIP(w rest.ResponseWriter, req *rest.Request) {
 		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
+	for i := range vip {
+		vip[i].Health = r.health.health(vip[i].ID)
+	}
 
 	w.WriteJson(&struct {
 		VIP []VIP `json:"vip"`
@@ -717,18 +1428,8 @@ func (r *Controller) removeVIP(w rest.ResponseWriter, req *rest.Request) {
 
 func (r *Controller) removeFlows(mac net.HardwareAddr) {
 	for _, sw := range r.topo.Devices() {
-		f := sw.Factory()
-		match, err := f.NewMatch()
-		if err != nil {
-			r.log.Err(fmt.Sprintf("Controller: REST: failed to create an OpenFlow match: %v", err))
-			continue
-		}
-		match.SetDstMAC(mac)
-		outPort := openflow.NewOutPort()
-		outPort.SetNone()
-
 		r.log.Debug(fmt.Sprintf("Controller: REST: removing flows whose destinatcion MAC address is %v on %v", mac, sw.ID()))
-		if err := sw.RemoveFlow(match, outPort); err != nil {
+		if err := sw.removeFlowsTo(mac); err != nil {
 			r.log.Err(fmt.Sprintf("Controller: REST: failed to remove a flow from %v: %v", sw.ID(), err))
 			continue
 		}
@@ -753,6 +1454,7 @@ func (r *Controller) toggleVIP(w rest.ResponseWriter, req *rest.Request) {
 		return
 	}
 	r.log.Debug(fmt.Sprintf("Controller: REST: toggled the VIP (ID=%v)", id))
+	r.stream.vipToggled(id, ip, mac)
 
 	for _, sw := range r.topo.Devices() {
 		r.log.Info(fmt.Sprintf("Controller: REST: sending ARP announcement for a host (IP: %v, MAC: %v) via %v", ip, mac, sw.ID()))
@@ -765,30 +1467,928 @@ func (r *Controller) toggleVIP(w rest.ResponseWriter, req *rest.Request) {
 	w.WriteJson(&struct{}{})
 }
 
-func writeError(w rest.ResponseWriter, status int, err error) {
-	w.WriteHeader(status)
+type MirrorParam struct {
+	// Mirrored port. It can be omitted if MAC is specified, and then the port is the current location of the host.
+	SrcDPID uint64 `json:"src_dpid"`
+	SrcPort uint32 `json:"src_port"`
+	// MAC restricts the mirrored traffic to the traffic to and from the host if it is not empty
+	MAC string `json:"mac"`
+	// Port connected to the analyzer
+	DstDPID uint64 `json:"dst_dpid"`
+	DstPort uint32 `json:"dst_port"`
+	// VLAN ID that carries the copies along the path if the analyzer is on another switch
+	VLANID      uint16 `json:"vlan_id"`
+	Description string `json:"description"`
+}
+
+func (r *MirrorParam) validate() error {
+	if len(r.MAC) > 0 {
+		if _, err := net.ParseMAC(r.MAC); err != nil {
+			return err
+		}
+	} else if r.SrcDPID == 0 || r.SrcPort == 0 {
+		return errors.New("source port or MAC address should be specified")
+	}
+	if r.DstDPID == 0 || r.DstPort == 0 {
+		return errors.New("invalid destination port")
+	}
+	if r.SrcDPID == r.DstDPID && r.SrcPort == r.DstPort {
+		return errors.New("same port for the source and destination")
+	}
+	if r.VLANID == 0 || r.VLANID > 4094 {
+		return errors.New("invalid VLAN ID")
+	}
+
+	return nil
+}
+
+type Mirror struct {
+	ID uint64 `json:"id"`
+	MirrorParam
+}
+
+func (r *Controller) listMirror(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	mirrors, err := r.db.Mirrors()
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
 	w.WriteJson(&struct {
-		Error string `json:"error"`
-	}{err.Error()})
+		Mirrors []Mirror `json:"mirrors"`
+	}{mirrors})
 }
 
-func (r *Controller) AddConnection(ctx context.Context, c net.Conn) {
-	conf := sessionConfig{
-		conn:     c,
-		logger:   r.log,
-		watcher:  r.topo,
-		finder:   r.topo,
-		listener: r.listener,
+func (r *Controller) addMirror(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	mirror := MirrorParam{}
+	if err := req.DecodeJsonPayload(&mirror); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
 	}
-	session := newSession(conf)
-	go session.Run(ctx)
+	if err := mirror.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: adding a new port mirror (%+v)", mirror))
+	id, err := r.db.AddMirror(mirror)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: added the new port mirror (%+v)", mirror))
+
+	w.WriteJson(&struct {
+		ID uint64 `json:"mirror_id"`
+	}{id})
 }
 
-func (r *Controller) SetEventListener(l EventListener) {
-	r.listener = l
-	r.topo.setEventListener(l)
+func (r *Controller) removeMirror(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: removing a port mirror (ID=%v)", id))
+	ok, err := r.db.RemoveMirror(id)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown mirror ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: removed the port mirror (ID=%v)", id))
+
+	w.WriteJson(&struct{}{})
+}
+
+type ACLParam struct {
+	// Rules of higher priority are evaluated first. Rules of the same priority are evaluated in the order of their IDs.
+	Priority uint16 `json:"priority"`
+	// Source and destination networks in CIDR notation. Empty string means any address.
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	// IP protocol number: 0 (any), 1 (ICMP), 6 (TCP), or 17 (UDP)
+	Protocol uint8 `json:"protocol"`
+	// TCP or UDP ports. Zero means any port.
+	SrcPort uint16 `json:"src_port"`
+	DstPort uint16 `json:"dst_port"`
+	// allow or deny
+	Action      string `json:"action"`
+	Description string `json:"description"`
+}
+
+const maxACLPriority = 10000
+
+func (r *ACLParam) validate() error {
+	if r.Priority == 0 || r.Priority > maxACLPriority {
+		return fmt.Errorf("invalid priority: it should be between 1 and %v", maxACLPriority)
+	}
+	for _, v := range []*string{&r.Src, &r.Dst} {
+		if *v == "" {
+			*v = "0.0.0.0/0"
+		}
+		ip, network, err := net.ParseCIDR(*v)
+		if err != nil || ip.To4() == nil {
+			return fmt.Errorf("invalid network address: %v", *v)
+		}
+		// Normalize the address as the network address
+		*v = network.String()
+	}
+	switch r.Protocol {
+	case 0, 1, 6, 17:
+	default:
+		return errors.New("invalid protocol: it should be 0 (any), 1 (ICMP), 6 (TCP), or 17 (UDP)")
+	}
+	if (r.SrcPort != 0 || r.DstPort != 0) && r.Protocol != 6 && r.Protocol != 17 {
+		return errors.New("ports can be specified only with TCP or UDP")
+	}
+	if r.Action != "allow" && r.Action != "deny" {
+		return errors.New("invalid action: it should be allow or deny")
+	}
+
+	return nil
+}
+
+type ACL struct {
+	ID uint64 `json:"id"`
+	ACLParam
+}
+
+func (r *Controller) listACL(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	acls, err := r.db.ACLs()
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteJson(&struct {
+		ACLs []ACL `json:"acls"`
+	}{acls})
+}
+
+func (r *Controller) addACL(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	acl := ACLParam{}
+	if err := req.DecodeJsonPayload(&acl); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := acl.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: adding a new ACL rule (%+v)", acl))
+	id, err := r.db.AddACL(acl)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: added the new ACL rule (%+v)", acl))
+
+	w.WriteJson(&struct {
+		ID uint64 `json:"acl_id"`
+	}{id})
+}
+
+func (r *Controller) updateACL(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	acl := ACLParam{}
+	if err := req.DecodeJsonPayload(&acl); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := acl.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: updating an ACL rule (ID=%v, %+v)", id, acl))
+	ok, err := r.db.UpdateACL(id, acl)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown ACL ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: updated the ACL rule (ID=%v)", id))
+
+	w.WriteJson(&struct{}{})
+}
+
+func (r *Controller) removeACL(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: removing an ACL rule (ID=%v)", id))
+	ok, err := r.db.RemoveACL(id)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown ACL ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: removed the ACL rule (ID=%v)", id))
+
+	w.WriteJson(&struct{}{})
+}
+
+type FrontendParam struct {
+	Name string `json:"name"`
+	// Virtual IPv4 address that clients connect to
+	Address string `json:"address"`
+	Port    uint16 `json:"port"`
+	// tcp or udp
+	Protocol string `json:"protocol"`
+	// roundrobin or source. Empty string means roundrobin.
+	Balance string `json:"balance"`
+}
+
+func (r *FrontendParam) validate() error {
+	if len(r.Name) == 0 || len(r.Name) > 50 {
+		return errors.New("invalid name: it should be 1 to 50 characters")
+	}
+	if ip := net.ParseIP(r.Address); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 address: %v", r.Address)
+	}
+	if r.Port == 0 {
+		return errors.New("invalid port number")
+	}
+	if r.Protocol != "tcp" && r.Protocol != "udp" {
+		return errors.New("invalid protocol: it should be tcp or udp")
+	}
+	if r.Balance == "" {
+		r.Balance = "roundrobin"
+	}
+	if r.Balance != "roundrobin" && r.Balance != "source" {
+		return errors.New("invalid balance: it should be roundrobin or source")
+	}
+
+	return nil
+}
+
+type Frontend struct {
+	ID uint64 `json:"id"`
+	FrontendParam
+	Backends []Backend `json:"backends"`
+}
+
+type BackendParam struct {
+	Name string `json:"name"`
+	// IPv4 address of a registered host
+	Address string `json:"address"`
+	Port    uint16 `json:"port"`
+}
+
+func (r *BackendParam) validate() error {
+	if len(r.Name) == 0 || len(r.Name) > 50 {
+		return errors.New("invalid name: it should be 1 to 50 characters")
+	}
+	if ip := net.ParseIP(r.Address); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 address: %v", r.Address)
+	}
+	if r.Port == 0 {
+		return errors.New("invalid port number")
+	}
+
+	return nil
+}
+
+type Backend struct {
+	ID uint64 `json:"id"`
+	BackendParam
+}
+
+func (r *Controller) listFrontend(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	frontends, err := r.db.Frontends()
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteJson(&struct {
+		Frontends []Frontend `json:"frontends"`
+	}{frontends})
+}
+
+func (r *Controller) addFrontend(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	frontend := FrontendParam{}
+	if err := req.DecodeJsonPayload(&frontend); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := frontend.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: adding a new frontend (%+v)", frontend))
+	id, err := r.db.AddFrontend(frontend)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: added the new frontend (%+v)", frontend))
+
+	w.WriteJson(&struct {
+		ID uint64 `json:"frontend_id"`
+	}{id})
+}
+
+func (r *Controller) removeFrontend(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: removing a frontend (ID=%v)", id))
+	ok, err := r.db.RemoveFrontend(id)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown frontend ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: removed the frontend (ID=%v)", id))
+
+	w.WriteJson(&struct{}{})
+}
+
+func (r *Controller) addBackend(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	frontendID, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	backend := BackendParam{}
+	if err := req.DecodeJsonPayload(&backend); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := backend.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: adding a new backend to the frontend (ID=%v, %+v)", frontendID, backend))
+	id, ok, err := r.db.AddBackend(frontendID, backend)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown frontend ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: added the new backend (%+v)", backend))
+
+	w.WriteJson(&struct {
+		ID uint64 `json:"backend_id"`
+	}{id})
+}
+
+func (r *Controller) removeBackend(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: removing a backend (ID=%v)", id))
+	ok, err := r.db.RemoveBackend(id)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown backend ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: removed the backend (ID=%v)", id))
+
+	w.WriteJson(&struct{}{})
+}
+
+type FloatingIPParam struct {
+	// Public IPv4 address that is not in the registered networks
+	Address     string `json:"address"`
+	HostID      uint64 `json:"host_id"`
+	Description string `json:"description"`
+}
+
+func (r *FloatingIPParam) validate() error {
+	if ip := net.ParseIP(r.Address); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid IPv4 address: %v", r.Address)
+	}
+	if r.HostID == 0 {
+		return errors.New("invalid host ID")
+	}
+
+	return nil
+}
+
+type FloatingIP struct {
+	ID          uint64 `json:"id"`
+	Address     string `json:"address"`
+	Host        Host   `json:"host"`
+	Description string `json:"description"`
+}
+
+func (r *Controller) listFloatingIP(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	addresses, err := r.db.FloatingIPs()
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteJson(&struct {
+		FloatingIPs []FloatingIP `json:"floating_ips"`
+	}{addresses})
+}
+
+func (r *Controller) addFloatingIP(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	floating := FloatingIPParam{}
+	if err := req.DecodeJsonPayload(&floating); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if err := floating.validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: adding a new floating IP (%+v)", floating))
+	id, err := r.db.AddFloatingIP(floating)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: added the new floating IP (%+v)", floating))
+
+	w.WriteJson(&struct {
+		ID uint64 `json:"floating_ip_id"`
+	}{id})
+
+	host, ok, err := r.db.Host(floating.HostID)
+	if err != nil {
+		r.log.Err(fmt.Sprintf("Controller: REST: failed to query the host of the floating IP: %v", err))
+		return
+	}
+	if !ok {
+		r.log.Err(fmt.Sprintf("Controller: REST: unknown host of the floating IP (ID=%v)", floating.HostID))
+		return
+	}
+	// Sends ARP announcement to all hosts to update their ARP caches (IP = Floating IP, MAC = Host's MAC)
+	if err := r.sendARPAnnouncement(floating.Address+"/32", host.MAC); err != nil {
+		r.log.Err(fmt.Sprintf("Controller: REST: failed to send ARP announcement for newly added floating IP (ID=%v): %v", id, err))
+		return
+	}
+}
+
+func (r *Controller) assignFloatingIP(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	param := struct {
+		HostID uint64 `json:"host_id"`
+	}{}
+	if err := req.DecodeJsonPayload(&param); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if param.HostID == 0 {
+		writeError(w, http.StatusBadRequest, errors.New("invalid host ID"))
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: reassigning a floating IP (ID=%v) to the host (ID=%v)", id, param.HostID))
+	ip, mac, ok, err := r.db.AssignFloatingIP(id, param.HostID)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown floating IP ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: reassigned the floating IP (ID=%v)", id))
+
+	// The hosts should send the packets for the floating IP to the new host right now
+	for _, sw := range r.topo.Devices() {
+		r.log.Info(fmt.Sprintf("Controller: REST: sending ARP announcement for a host (IP: %v, MAC: %v) via %v", ip, mac, sw.ID()))
+		if err := sw.SendARPAnnouncement(ip, mac); err != nil {
+			r.log.Err(fmt.Sprintf("Controller: REST: failed to send ARP announcement via %v: %v", sw.ID(), err))
+			continue
+		}
+	}
+
+	w.WriteJson(&struct{}{})
+}
+
+func (r *Controller) removeFloatingIP(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	id, err := strconv.ParseUint(req.PathParam("id"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("Controller: REST: removing a floating IP (ID=%v)", id))
+	ok, err := r.db.RemoveFloatingIP(id)
+	if err != nil {
+		r.log.Info(fmt.Sprintf("Controller: REST: failed to query database: %v", err))
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, errors.New("unknown floating IP ID"))
+		return
+	}
+	r.log.Info(fmt.Sprintf("Controller: REST: removed the floating IP (ID=%v)", id))
+
+	w.WriteJson(&struct{}{})
+}
+
+// streamEvent sends the controller events as Server-Sent Events until the client disconnects.
+// Events can be filtered by the comma separated type and dpid query parameters.
+func (r *Controller) streamEvent(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	writer, ok1 := w.(http.ResponseWriter)
+	flusher, ok2 := w.(http.Flusher)
+	if !ok1 || !ok2 {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+
+	query := req.URL.Query()
+	client, err := r.stream.subscribe(splitQuery(query.Get("type")), splitQuery(query.Get("dpid")))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	defer r.stream.unsubscribe(client)
+	r.log.Debug(fmt.Sprintf("Controller: REST: new event stream client from %v", req.RemoteAddr))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e := <-client.queue:
+			data, err := json.Marshal(e)
+			if err != nil {
+				r.log.Err(fmt.Sprintf("Controller: REST: marshaling a stream event: %v", err))
+				continue
+			}
+			if _, err := fmt.Fprintf(writer, "event: %v\ndata: %s\n\n", e.Type, data); err != nil {
+				r.log.Debug(fmt.Sprintf("Controller: REST: writing a stream event: %v", err))
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			r.log.Debug(fmt.Sprintf("Controller: REST: event stream client %v is disconnected", req.RemoteAddr))
+			return
+		}
+	}
+}
+
+func splitQuery(v string) []string {
+	result := make([]string, 0)
+	for _, token := range strings.Split(v, ",") {
+		token = strings.TrimSpace(token)
+		if len(token) == 0 {
+			continue
+		}
+		result = append(result, token)
+	}
+
+	return result
+}
+
+func writeError(w rest.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	w.WriteJson(&struct {
+		Error string `json:"error"`
+	}{err.Error()})
+}
+
+type TracePort struct {
+	Number  uint32 `json:"number"`
+	AdminUp bool   `json:"admin_up"`
+	LinkUp  bool   `json:"link_up"`
+	// Edge is true if the port is connected to another switch
+	Edge bool `json:"edge"`
+	// STPEnabled is always true for the ports that are not edges among switches
+	STPEnabled bool `json:"stp_enabled"`
+}
+
+type TraceFlow struct {
+	Found bool `json:"found"`
+	// The fields except Error are valid only if Found is true
+	Owner       string `json:"owner,omitempty"`
+	Cookie      uint64 `json:"cookie,omitempty"`
+	PacketCount uint64 `json:"packet_count,omitempty"`
+	ByteCount   uint64 `json:"byte_count,omitempty"`
+	// OutPorts are the output ports of the flow reported by the device
+	OutPorts []uint32 `json:"out_ports,omitempty"`
+	// Diverged is true if the flow does not forward packets through the egress port that the controller expects
+	Diverged bool `json:"diverged,omitempty"`
+	// Error is not empty if we failed to query the flow stats
+	Error string `json:"error,omitempty"`
+}
+
+type TraceHop struct {
+	DPID    string     `json:"dpid"`
+	Ingress *TracePort `json:"ingress"`
+	Egress  *TracePort `json:"egress"`
+	// Link toward the next hop, which is nil on the last hop
+	Link *TopologyLink `json:"link,omitempty"`
+	Flow TraceFlow     `json:"flow"`
+}
+
+type TraceResult struct {
+	Src  *StreamPort `json:"src"`
+	Dst  *StreamPort `json:"dst"`
+	Hops []*TraceHop `json:"hops"`
+}
+
+func (r *Controller) trace(w rest.ResponseWriter, req *rest.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	query := req.URL.Query()
+	src, status, err := r.findNode(query.Get("src"))
+	if err != nil {
+		writeError(w, status, fmt.Errorf("source host: %v", err))
+		return
+	}
+	dst, status, err := r.findNode(query.Get("dst"))
+	if err != nil {
+		writeError(w, status, fmt.Errorf("destination host: %v", err))
+		return
+	}
+
+	srcDevice := src.Port().Device()
+	dstDevice := dst.Port().Device()
+	path := r.topo.Path(srcDevice.ID(), dstDevice.ID())
+	if srcDevice.ID() != dstDevice.ID() && len(path) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("no path between the source and destination hosts"))
+		return
+	}
+
+	// Optional application name that restricts the flows to examine
+	owner := query.Get("owner")
+	result := TraceResult{
+		Src:  newStreamPort(src.Port()),
+		Dst:  newStreamPort(dst.Port()),
+		Hops: make([]*TraceHop, 0),
+	}
+	ingress := src.Port()
+	for _, p := range path {
+		result.Hops = append(result.Hops, r.traceHop(ingress, p[0], dst.MAC(), owner))
+		ingress = p[1]
+	}
+	result.Hops = append(result.Hops, r.traceHop(ingress, dst.Port(), dst.MAC(), owner))
+
+	w.WriteJson(&result)
+}
+
+// findNode returns the node whose MAC address is mac, or an error with the HTTP status code that describes it.
+func (r *Controller) findNode(mac string) (*Node, int, error) {
+	hwAddr, err := net.ParseMAC(mac)
+	if err != nil {
+		return nil, http.StatusBadRequest, errors.New("invalid MAC address")
+	}
+	node, err := r.topo.Node(hwAddr)
+	if err != nil {
+		r.log.Err(fmt.Sprintf("Controller: REST: failed to find the node: %v", err))
+		return nil, http.StatusInternalServerError, err
+	}
+	if node == nil {
+		return nil, http.StatusNotFound, errors.New("unknown host")
+	}
+
+	return node, http.StatusOK, nil
+}
+
+func (r *Controller) traceHop(ingress, egress *Port, dst net.HardwareAddr, owner string) *TraceHop {
+	v := &TraceHop{
+		DPID:    ingress.Device().ID(),
+		Ingress: r.tracePort(ingress),
+		Egress:  r.tracePort(egress),
+		Flow:    r.traceFlow(ingress.Device(), egress, dst, owner),
+	}
+	if l := r.topo.link(egress); l != nil {
+		v.Link = &TopologyLink{
+			ID:      l.ID(),
+			Ports:   []*StreamPort{newStreamPort(l.ports[0]), newStreamPort(l.ports[1])},
+			Enabled: r.topo.IsEnabledBySTP(egress),
+			Latency: l.Latency().Seconds() * 1000,
+		}
+	}
+
+	return v
+}
+
+func (r *Controller) tracePort(p *Port) *TracePort {
+	v := &TracePort{
+		Number:     p.Number(),
+		Edge:       r.topo.IsEdge(p),
+		STPEnabled: true,
+	}
+	if value := p.Value(); value != nil {
+		v.AdminUp = !value.IsPortDown()
+		v.LinkUp = !value.IsLinkDown()
+	}
+	if v.Edge {
+		v.STPEnabled = r.topo.IsEnabledBySTP(p)
+	}
+
+	return v
+}
+
+// traceFlow looks for the flow installed on device that forwards packets toward dst. The flows of all the
+// applications are examined if owner is empty. We trust the flows reported by the device rather than the flow
+// inventory so that the trace exposes the flows that differ from what the controller expects.
+func (r *Controller) traceFlow(device *Device, egress *Port, dst net.HardwareAddr, owner string) TraceFlow {
+	match, err := device.Factory().NewMatch()
+	if err != nil {
+		return TraceFlow{Error: err.Error()}
+	}
+	match.SetDstMAC(dst)
+
+	var stats []openflow.FlowStats
+	if owner != "" {
+		stats, err = device.FlowStats(owner, match)
+	} else {
+		stats, err = device.flowStats(0, 0, match)
+	}
+	if err != nil {
+		r.log.Err(fmt.Sprintf("Controller: REST: failed to query flow stats to %v: %v", device.ID(), err))
+		return TraceFlow{Error: err.Error()}
+	}
+
+	var found *openflow.FlowStats
+	for i, s := range stats {
+		if found != nil && found.Priority >= s.Priority {
+			continue
+		}
+		if _, ok := flowOwnerName(s.Cookie); !ok {
+			continue
+		}
+		found = &stats[i]
+	}
+	if found == nil {
+		return TraceFlow{}
+	}
+	app, _ := flowOwnerName(found.Cookie)
+
+	return TraceFlow{
+		Found:       true,
+		Owner:       app,
+		Cookie:      found.Cookie,
+		OutPorts:    found.OutPorts,
+		Diverged:    !hasPort(found.OutPorts, egress.Number()),
+		PacketCount: found.PacketCount,
+		ByteCount:   found.ByteCount,
+	}
+}
+
+func hasPort(ports []uint32, port uint32) bool {
+	for _, v := range ports {
+		if v == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *Controller) AddConnection(ctx context.Context, c net.Conn) {
+	conf := sessionConfig{
+		conn:      c,
+		logger:    r.log,
+		watcher:   r.topo,
+		finder:    r.topo,
+		listener:  r.listener,
+		admission: r.admission,
+		grace:     r.grace,
+		cluster:   r.cluster,
+		limiter:   r.limiter,
+		health:    r.health,
+	}
+	session := newSession(conf)
+	go session.Run(ctx)
+}
+
+func (r *Controller) SetEventListener(l EventListener) {
+	listener := &streamListener{stream: r.stream}
+	if l != nil {
+		listener.next = &leaderListener{cluster: r.cluster, next: l}
+	}
+	r.listener = listener
+	r.topo.setEventListener(listener)
+}
+
+func (r *Controller) reportTopologyDiff(diff TopologyDiff) {
+	if err := r.listener.OnTopologyDiff(r.topo, diff); err != nil {
+		r.log.Err(fmt.Sprintf("Controller: executing OnTopologyDiff: %v", err))
+	}
+}
+
+func (r *Controller) onRoleChanged(leader bool) {
+	role, generation := r.cluster.role()
+	for _, d := range r.topo.Devices() {
+		if err := d.setRole(role, generation); err != nil {
+			r.log.Err(fmt.Sprintf("Controller: failed to change the controller role on %v: %v", d.ID(), err))
+		}
+		if !leader {
+			continue
+		}
+		// The flows installed by the previous leader are unknown to our applications
+		if err := d.takeOver(); err != nil {
+			r.log.Err(fmt.Sprintf("Controller: failed to take over %v: %v", d.ID(), err))
+			continue
+		}
+		// Let the applications install their flows again on the wiped device
+		if err := r.listener.OnDeviceUp(r.topo, d); err != nil {
+			r.log.Err(fmt.Sprintf("Controller: executing OnDeviceUp for %v: %v", d.ID(), err))
+		}
+	}
+
+	if leader {
+		// Let the applications re-build their states
+		r.topo.sendEvent()
+	}
 }
 
 func (r *Controller) String() string {
-	return r.topo.String()
+	return r.cluster.String() + r.limiter.String() + r.topo.String()
 }
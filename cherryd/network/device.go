@@ -27,6 +27,7 @@ import (
 	"fmt"
 	"net"
 	"sync"
+	"time"
 
 	"github.com/superkkt/cherry/cherryd/log"
 	"github.com/superkkt/cherry/cherryd/openflow"
@@ -58,12 +59,24 @@ type Device struct {
 	flowTableID  uint8 // Table IDs that we install flows
 	factory      openflow.Factory
 	closed       bool
+	flows        *flowRegistry
+	// Quarantined device is connected but isolated from the network
+	quarantined bool
+	// Pending flow stats requests whose key is the transaction ID
+	statsMutex   sync.Mutex
+	statsWaiters map[uint32]chan openflow.FlowStatsReply
+	mirrors      []PortMirror
 }
 
 var (
 	ErrClosedDevice = errors.New("already closed device")
 )
 
+const (
+	// Maximum time to wait for all the flow stats replies of a request
+	flowStatsTimeout = 5 * time.Second
+)
+
 func newDevice(log log.Logger, s *session) *Device {
 	if log == nil {
 		panic("Logger is nil")
@@ -73,9 +86,11 @@ func newDevice(log log.Logger, s *session) *Device {
 	}
 
 	return &Device{
-		log:     log,
-		session: s,
-		ports:   make(map[uint32]*Port),
+		log:          log,
+		session:      s,
+		ports:        make(map[uint32]*Port),
+		flows:        newFlowRegistry(),
+		statsWaiters: make(map[uint32]chan openflow.FlowStatsReply),
 	}
 }
 
@@ -182,6 +197,13 @@ func (r *Device) Ports() []*Port {
 
 // A caller should make sure the mutex is locked before calling this function
 func (r *Device) setPort(num uint32, p openflow.Port) {
+	// Reuse the existing port of a reconnected device so that links and other
+	// references to the port remain valid.
+	if port, ok := r.ports[num]; ok {
+		port.SetValue(p)
+		return
+	}
+
 	port := NewPort(r, num)
 	port.SetValue(p)
 	r.ports[num] = port
@@ -245,6 +267,96 @@ func (r *Device) SendMessage(msg encoding.BinaryMarshaler) error {
 	return r.session.Write(msg)
 }
 
+// controlLatency returns the round-trip time of the control channel between the controller and this device.
+func (r *Device) controlLatency() time.Duration {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.session.trans.Latency()
+}
+
+// rebind binds this device to a new session s after reconnection.
+func (r *Device) rebind(s *session, f openflow.Factory) {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if f == nil {
+		panic("Factory is nil")
+	}
+	r.session = s
+	r.factory = f
+	r.closed = false
+	r.flows.resetPending()
+}
+
+// setRole changes the controller role of this controller on the device. It does nothing on OpenFlow 1.0 that does
+// not have the controller roles.
+func (r *Device) setRole(role openflow.ControllerRole, generation uint64) error {
+	// Read lock
+	r.mutex.RLock()
+	f, version := r.factory, r.session.version
+	r.mutex.RUnlock()
+
+	if version == openflow.OF10_VERSION {
+		return nil
+	}
+	msg, err := f.NewRoleRequest()
+	if err != nil {
+		return err
+	}
+	msg.SetRole(role)
+	msg.SetGenerationID(generation)
+
+	return r.SendMessage(msg)
+}
+
+// takeOver re-initializes the device when this controller has become the cluster leader.
+func (r *Device) takeOver() error {
+	if err := r.RemoveAllFlows(); err != nil {
+		return fmt.Errorf("removing all flows: %v", err)
+	}
+
+	// Read lock
+	r.mutex.RLock()
+	f, s := r.factory, r.session
+	r.mutex.RUnlock()
+
+	// The version handler installs the table-miss flows again on DESCRIPTION_REPLY
+	if err := sendDescriptionRequest(f, s); err != nil {
+		return fmt.Errorf("sending DESCRIPTION_REQUEST: %v", err)
+	}
+	// Update the network topology that followers do not maintain
+	for _, p := range r.Ports() {
+		v := p.Value()
+		if v == nil || v.IsPortDown() || v.IsLinkDown() {
+			continue
+		}
+		if err := sendLLDP(r.ID(), f, s, v); err != nil {
+			return fmt.Errorf("sending LLDP: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Device) IsQuarantined() bool {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.quarantined
+}
+
+func (r *Device) setQuarantined(q bool) {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.quarantined = q
+}
+
 func (r *Device) IsClosed() bool {
 	// Read lock
 	r.mutex.RLock()
@@ -283,6 +395,7 @@ func (r *Device) RemoveAllFlows() error {
 	if err := r.session.Write(flowmod); err != nil {
 		return err
 	}
+	r.flows.clear()
 
 	return setARPSender(r.factory, r.session.trans)
 }
@@ -309,6 +422,346 @@ func (r *Device) RemoveFlow(match openflow.Match, port openflow.OutPort) error {
 	return r.session.Write(flowmod)
 }
 
+// InstallFlow installs flow after assigning a cookie from the cookie range of app,
+// and records the flow in the flow inventory of this device.
+func (r *Device) InstallFlow(app string, flow openflow.FlowMod) error {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return ErrClosedDevice
+	}
+
+	return r.installFlow(app, flow, flowAction(flow), false)
+}
+
+// InstallForwardingFlow installs flow same as InstallFlow, and the traffic of the flow is also sent to the mirror
+// ports if it is related to the mirrored ports. The flow is removed when the mirrors are changed, so it should be
+// a reactive flow that is installed again by PACKET_IN.
+func (r *Device) InstallForwardingFlow(app string, flow openflow.FlowMod) error {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return ErrClosedDevice
+	}
+
+	action := flowAction(flow)
+	mirrored := len(r.mirrors) > 0 && action != nil && isPhysicalPort(action.OutPort())
+	if mirrored {
+		r.addEgressMirrors(flow.FlowMatch(), action)
+	}
+	if err := r.installFlow(app, flow, action, true); err != nil {
+		return err
+	}
+	if !mirrored {
+		return nil
+	}
+
+	return r.installIngressMirrors(app, flow, action)
+}
+
+func flowAction(flow openflow.FlowMod) openflow.Action {
+	inst := flow.FlowInstruction()
+	if inst == nil {
+		return nil
+	}
+
+	return inst.Action()
+}
+
+// A caller should make sure the mutex is locked before calling this function
+func (r *Device) installFlow(app string, flow openflow.FlowMod, action openflow.Action, mirrored bool) error {
+	cookie := r.flows.nextCookie(app)
+	flow.SetCookie(cookie)
+	if err := r.session.Write(flow); err != nil {
+		return err
+	}
+
+	r.flows.add(Flow{
+		Owner:       app,
+		Cookie:      cookie,
+		TableID:     flow.TableID(),
+		Priority:    flow.Priority(),
+		IdleTimeout: flow.IdleTimeout(),
+		HardTimeout: flow.HardTimeout(),
+		Match:       flow.FlowMatch(),
+		Action:      action,
+		Mirrored:    mirrored,
+		Timestamp:   time.Now(),
+	})
+	// The device can reject the flow asynchronously, for example, due to OFPFF_CHECK_OVERLAP
+	r.flows.track(flow.TransactionID(), cookie)
+
+	return nil
+}
+
+// flowRejected removes the flow that has been rejected by the device from the flow inventory. xid is the
+// transaction ID of the error message, which is the same as the one of the rejected request.
+func (r *Device) flowRejected(xid uint32) {
+	f, ok := r.flows.reject(xid)
+	if !ok {
+		return
+	}
+	r.log.Debug(fmt.Sprintf("Device: removed the flow rejected by %v from the flow inventory: %v", r.ID(), f))
+}
+
+func isPhysicalPort(p openflow.OutPort) bool {
+	return !p.IsTable() && !p.IsFlood() && !p.IsAll() && !p.IsController() && !p.IsInPort() && !p.IsNone()
+}
+
+// addEgressMirrors adds the mirrors of the traffic toward the mirrored port to action.
+// A caller should make sure the mutex is locked before calling this function
+func (r *Device) addEgressMirrors(match openflow.Match, action openflow.Action) {
+	wildcard, dstMAC := match.DstMAC()
+	out := action.OutPort()
+	for _, m := range r.mirrors {
+		if m.Source != out.Value() {
+			continue
+		}
+		if m.MAC != nil && (wildcard || !m.matchHost(dstMAC)) {
+			continue
+		}
+		action.AddMirror(m.mirror())
+	}
+}
+
+// installIngressMirrors installs the flows that have higher priority than flow, whose traffic comes from
+// the mirrored ports. They forward the traffic same as flow and also send it to the mirror ports.
+// A caller should make sure the mutex is locked before calling this function
+func (r *Device) installIngressMirrors(app string, flow openflow.FlowMod, action openflow.Action) error {
+	match := flow.FlowMatch()
+	// Flow for a specific ingress port?
+	if wildcard, _ := match.InPort(); !wildcard {
+		return nil
+	}
+	srcWildcard, srcMAC := match.SrcMAC()
+	out := action.OutPort()
+
+	// Mirrors of a same source share a flow. Key is the source port and the host.
+	groups := make(map[string][]PortMirror)
+	keys := make([]string, 0)
+	for _, m := range r.mirrors {
+		// Traffic from the source never goes back to the source
+		if m.Source == out.Value() {
+			continue
+		}
+		if m.MAC != nil && !srcWildcard && !m.matchHost(srcMAC) {
+			continue
+		}
+		k := fmt.Sprintf("%v/%v", m.Source, m.MAC)
+		if _, ok := groups[k]; !ok {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], m)
+	}
+
+	for _, k := range keys {
+		mirrors := groups[k]
+		if err := r.installIngressMirror(app, flow, action, mirrors); err != nil {
+			return fmt.Errorf("installing a mirror flow for %v: %v", mirrors[0], err)
+		}
+	}
+
+	return nil
+}
+
+// A caller should make sure the mutex is locked before calling this function
+func (r *Device) installIngressMirror(app string, flow openflow.FlowMod, action openflow.Action, mirrors []PortMirror) error {
+	match, err := copyMatch(r.factory, flow.FlowMatch())
+	if err != nil {
+		return err
+	}
+	inPort := openflow.NewInPort()
+	inPort.SetValue(mirrors[0].Source)
+	match.SetInPort(inPort)
+	if mirrors[0].MAC != nil {
+		match.SetSrcMAC(mirrors[0].MAC)
+	}
+
+	act, err := copyAction(r.factory, action)
+	if err != nil {
+		return err
+	}
+	for _, m := range mirrors {
+		act.AddMirror(m.mirror())
+	}
+	inst, err := r.factory.NewInstruction()
+	if err != nil {
+		return err
+	}
+	inst.ApplyAction(act)
+
+	v, err := r.factory.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	v.SetTableID(flow.TableID())
+	v.SetIdleTimeout(flow.IdleTimeout())
+	v.SetHardTimeout(flow.HardTimeout())
+	v.SetPriority(flow.Priority() + 1)
+	v.SetFlowMatch(match)
+	v.SetFlowInstruction(inst)
+
+	return r.installFlow(app, v, act, true)
+}
+
+// SetMirrors replaces the port mirrors of this device with mirrors. The flows installed by InstallForwardingFlow
+// are removed so that they are installed again with the new mirrors.
+func (r *Device) SetMirrors(mirrors []PortMirror) error {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return ErrClosedDevice
+	}
+	r.mirrors = mirrors
+
+	for _, f := range r.flows.all() {
+		if !f.Mirrored {
+			continue
+		}
+		if err := r.removeFlowStrictly(f); err != nil {
+			return err
+		}
+		r.flows.remove(f.Cookie)
+	}
+
+	return nil
+}
+
+// Mirrors returns the port mirrors of this device.
+func (r *Device) Mirrors() []PortMirror {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.mirrors
+}
+
+// Flows returns the flows installed by app.
+func (r *Device) Flows(app string) []Flow {
+	return r.flows.list(app)
+}
+
+// AllFlows returns the flows installed by all the applications.
+func (r *Device) AllFlows() []Flow {
+	return r.flows.all()
+}
+
+// PermanentFlows returns the flows installed by app that never expire.
+func (r *Device) PermanentFlows(app string) []Flow {
+	v := make([]Flow, 0)
+	for _, f := range r.flows.list(app) {
+		if f.IsPermanent() {
+			v = append(v, f)
+		}
+	}
+
+	return v
+}
+
+// RemoveAppFlow removes a flow whose cookie is cookie. The flow should be installed by app.
+func (r *Device) RemoveAppFlow(app string, cookie uint64) error {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return ErrClosedDevice
+	}
+
+	flow, ok := r.flows.get(cookie)
+	// Already removed?
+	if !ok {
+		return nil
+	}
+	if flow.Owner != app {
+		return fmt.Errorf("flow %v is not owned by %v", cookie, app)
+	}
+	if err := r.removeFlowStrictly(flow); err != nil {
+		return err
+	}
+	r.flows.remove(cookie)
+
+	return nil
+}
+
+// RemoveAppFlows removes all the flows installed by app.
+func (r *Device) RemoveAppFlows(app string) error {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return ErrClosedDevice
+	}
+
+	flowmod, err := r.factory.NewFlowMod(openflow.FlowDelete)
+	if err != nil {
+		return err
+	}
+	// OpenFlow 1.0 does not have the cookie mask, so we should remove the flows one by one.
+	if flowmod.Version() == openflow.OF10_VERSION {
+		for _, f := range r.flows.list(app) {
+			if err := r.removeFlowStrictly(f); err != nil {
+				return err
+			}
+			r.flows.remove(f.Cookie)
+		}
+		return nil
+	}
+
+	match, err := r.factory.NewMatch()
+	if err != nil {
+		return err
+	}
+	flowmod.SetCookie(flowOwnerID(app))
+	flowmod.SetCookieMask(0x1<<63 | cookieOwnerMask)
+	flowmod.SetTableID(0xFF) // ALL
+	flowmod.SetFlowMatch(match)
+	if err := r.session.Write(flowmod); err != nil {
+		return err
+	}
+	r.flows.removeOwner(app)
+
+	return nil
+}
+
+// A caller should make sure the mutex is locked before calling this function
+func (r *Device) removeFlowStrictly(f Flow) error {
+	flowmod, err := r.factory.NewFlowMod(openflow.FlowDeleteStrict)
+	if err != nil {
+		return err
+	}
+	flowmod.SetCookie(f.Cookie)
+	flowmod.SetCookieMask(0xFFFFFFFFFFFFFFFF)
+	flowmod.SetTableID(f.TableID)
+	flowmod.SetPriority(f.Priority)
+	flowmod.SetFlowMatch(f.Match)
+
+	return r.session.Write(flowmod)
+}
+
+func (r *Device) flowRemoved(cookie uint64) {
+	r.flows.remove(cookie)
+}
+
+func (r *Device) removeFlowsTo(mac net.HardwareAddr) error {
+	match, err := r.Factory().NewMatch()
+	if err != nil {
+		return err
+	}
+	match.SetDstMAC(mac)
+	outPort := openflow.NewOutPort()
+	outPort.SetNone()
+
+	return r.RemoveFlow(match, outPort)
+}
+
 func makeARPAnnouncement(ip net.IP, mac net.HardwareAddr) ([]byte, error) {
 	v := protocol.NewARPRequest(mac, ip, ip)
 	anon, err := v.MarshalBinary()
@@ -325,13 +778,11 @@ func makeARPAnnouncement(ip net.IP, mac net.HardwareAddr) ([]byte, error) {
 	return eth.MarshalBinary()
 }
 
+// SendARPAnnouncement floods an ARP announcement that tells ip is at mac. An unsolicited neighbor advertisement
+// is sent instead if ip is an IPv6 address, as IPv6 does not have ARP.
 func (r *Device) SendARPAnnouncement(ip net.IP, mac net.HardwareAddr) error {
-	// Write lock
-	r.mutex.Lock()
-	defer r.mutex.Unlock()
-
-	if r.closed {
-		return ErrClosedDevice
+	if ip.To4() == nil {
+		return r.SendNeighborAdvertisement(ip, mac)
 	}
 
 	announcement, err := makeARPAnnouncement(ip, mac)
@@ -339,25 +790,256 @@ func (r *Device) SendARPAnnouncement(ip net.IP, mac net.HardwareAddr) error {
 		return err
 	}
 
-	inPort := openflow.NewInPort()
-	inPort.SetController()
+	return r.Flood(nil, announcement)
+}
+
+// SendNeighborAdvertisement floods an unsolicited neighbor advertisement to all the nodes, which tells
+// the IPv6 address ip is at mac.
+func (r *Device) SendNeighborAdvertisement(ip net.IP, mac net.HardwareAddr) error {
+	dst := protocol.IPv6AllNodes
+	advertisement, err := protocol.NewNeighborAdvertisementPacket(mac, ip, false, protocol.IPv6MulticastMAC(dst), dst)
+	if err != nil {
+		return err
+	}
+
+	return r.Flood(nil, advertisement)
+}
+
+// broadcastPorts returns the ports that a broadcast packet received on ingress should go out of. They are the
+// active ports facing hosts and the inter-switch ports enabled by the spanning tree. ingress can be nil.
+func (r *Device) broadcastPorts(ingress *Port) []*Port {
+	// Read lock
+	r.mutex.RLock()
+	finder := r.session.finder
+	admission := r.session.admission
+	r.mutex.RUnlock()
+
+	result := make([]*Port, 0)
+	for _, p := range r.Ports() {
+		if ingress != nil && p.Number() == ingress.Number() {
+			continue
+		}
+		v := p.Value()
+		if v == nil || v.IsPortDown() || v.IsLinkDown() || v.IsNoFlood() {
+			continue
+		}
+		// Inactive port may be an inter-switch port that is not discovered by LLDP yet
+		if !p.isActivated() {
+			continue
+		}
+		if finder.IsEdge(p) && !finder.IsEnabledBySTP(p) {
+			continue
+		}
+		// Do not flood into the quarantined switches
+		if admission.isIsolated(p) {
+			continue
+		}
+		result = append(result, p)
+	}
+
+	return result
+}
 
-	action, err := r.factory.NewAction()
+// Flood sends packet out of the broadcast ports along the spanning tree instead of OFPP_FLOOD, so that
+// the packet never loops on the switches that do not honor the no-flood port configuration. A single
+// PACKET_OUT carries all the outputs. ingress is nil if the packet is originated from the controller.
+func (r *Device) Flood(ingress *Port, packet []byte) error {
+	ports := r.broadcastPorts(ingress)
+	if len(ports) == 0 {
+		return nil
+	}
+
+	f := r.Factory()
+	action, err := f.NewAction()
 	if err != nil {
 		return err
 	}
-	// Flood
-	action.SetOutPort(openflow.NewOutPort())
+	for i, p := range ports {
+		outPort := openflow.NewOutPort()
+		outPort.SetValue(p.Number())
+		if i == 0 {
+			action.SetOutPort(outPort)
+		} else {
+			action.AddOutPort(outPort)
+		}
+		for _, m := range r.Mirrors() {
+			// Broadcasts are mirrored only if the mirror is not restricted to a specific host
+			if m.Source == p.Number() && m.MAC == nil {
+				action.AddMirror(m.mirror())
+			}
+		}
+	}
+	for _, m := range r.ingressMirrors(ingress, packet) {
+		action.AddMirror(m.mirror())
+	}
 
-	out, err := r.factory.NewPacketOut()
+	inPort := openflow.NewInPort()
+	if ingress != nil {
+		inPort.SetValue(ingress.Number())
+	}
+	out, err := f.NewPacketOut()
 	if err != nil {
 		return err
 	}
 	out.SetInPort(inPort)
 	out.SetAction(action)
-	out.SetData(announcement)
+	out.SetData(packet)
+
+	return r.SendMessage(out)
+}
+
+// ingressMirrors returns the mirrors of the traffic from ingress whose source is the sender of packet.
+func (r *Device) ingressMirrors(ingress *Port, packet []byte) []PortMirror {
+	if ingress == nil || len(packet) < 12 {
+		return nil
+	}
+
+	v := make([]PortMirror, 0)
+	for _, m := range r.Mirrors() {
+		if m.Source == ingress.Number() && m.matchHost(packet[6:12]) {
+			v = append(v, m)
+		}
+	}
 
-	return r.session.Write(out)
+	return v
+}
+
+// FlowStats queries the device for the flows that match match and are installed by app.
+func (r *Device) FlowStats(app string, match openflow.Match) ([]openflow.FlowStats, error) {
+	if match == nil {
+		panic("Match is nil")
+	}
+
+	return r.flowStats(flowOwnerID(app), cookieOwnerMask, match)
+}
+
+// flowStats queries the device for the flows that match match and whose cookies masked with mask are cookie. Zero
+// cookie with cookieOwnerMask means the base flows installed by the controller itself, such as the table-miss and ARP sender flows.
+func (r *Device) flowStats(cookie, mask uint64, match openflow.Match) ([]openflow.FlowStats, error) {
+	f := r.Factory()
+	req, err := f.NewFlowStatsRequest()
+	if err != nil {
+		return nil, err
+	}
+	req.SetTableID(0xFF) // ALL
+	req.SetCookie(cookie)
+	req.SetCookieMask(mask)
+	req.SetMatch(match)
+
+	c := make(chan openflow.FlowStatsReply, 16)
+	xid := req.TransactionID()
+	r.statsMutex.Lock()
+	r.statsWaiters[xid] = c
+	r.statsMutex.Unlock()
+	defer func() {
+		r.statsMutex.Lock()
+		delete(r.statsWaiters, xid)
+		r.statsMutex.Unlock()
+	}()
+
+	if err := r.SendMessage(req); err != nil {
+		return nil, err
+	}
+
+	result := make([]openflow.FlowStats, 0)
+	timeout := time.After(flowStatsTimeout)
+	for {
+		select {
+		case reply := <-c:
+			for _, v := range reply.Flows() {
+				// OpenFlow 1.0 does not filter the flows by the cookie
+				if v.Cookie&mask != cookie {
+					continue
+				}
+				result = append(result, v)
+			}
+			if !reply.More() {
+				return result, nil
+			}
+		case <-timeout:
+			return nil, errors.New("timeout while waiting for flow stats replies")
+		}
+	}
+}
+
+// reconcileFlows removes the flows that do not exist on the device from the flow inventory. It returns true if the
+// ARP sender flow or a permanent flow has been lost, which means that the device has been restarted and lost all
+// the flows.
+func (r *Device) reconcileFlows() (lost bool, err error) {
+	match, err := r.Factory().NewMatch()
+	if err != nil {
+		return false, err
+	}
+	base, err := r.flowStats(0, cookieOwnerMask, match)
+	if err != nil {
+		return false, fmt.Errorf("querying base flow stats: %v", err)
+	}
+	// A restarted device has lost the ARP sender flow, which has the zero cookie, even if it has no application
+	// flows. The table-miss flows cannot tell it because they are installed again on DESCRIPTION_REPLY.
+	if !hasARPSender(base) {
+		return true, nil
+	}
+
+	// Key is the owner
+	owners := make(map[string][]Flow)
+	for _, f := range r.flows.all() {
+		owners[f.Owner] = append(owners[f.Owner], f)
+	}
+
+	for app, flows := range owners {
+		match, err := r.Factory().NewMatch()
+		if err != nil {
+			return false, err
+		}
+		stats, err := r.FlowStats(app, match)
+		if err != nil {
+			return false, fmt.Errorf("querying flow stats: %v", err)
+		}
+		installed := make(map[uint64]bool)
+		for _, v := range stats {
+			installed[v.Cookie] = true
+		}
+
+		for _, f := range flows {
+			if installed[f.Cookie] {
+				continue
+			}
+			// The flow has expired or been removed while we were disconnected
+			r.flows.remove(f.Cookie)
+			if f.IsPermanent() {
+				lost = true
+			}
+		}
+	}
+
+	return lost, nil
+}
+
+func hasARPSender(flows []openflow.FlowStats) bool {
+	for _, v := range flows {
+		if v.Cookie == 0 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// flowStatsReplied delivers v to the goroutine that is waiting for it in FlowStats.
+func (r *Device) flowStatsReplied(v openflow.FlowStatsReply) {
+	r.statsMutex.Lock()
+	defer r.statsMutex.Unlock()
+
+	c, ok := r.statsWaiters[v.TransactionID()]
+	if !ok {
+		r.log.Debug(fmt.Sprintf("Device: ignoring unexpected FLOW_STATS_REPLY (xid=%v) from %v", v.TransactionID(), r.id))
+		return
+	}
+	select {
+	case c <- v:
+	default:
+		r.log.Err(fmt.Sprintf("Device: too many FLOW_STATS_REPLY messages (xid=%v) from %v", v.TransactionID(), r.id))
+	}
 }
 
 func (r *Device) Close() {
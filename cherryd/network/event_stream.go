@@ -0,0 +1,339 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	StreamDeviceUp       = "device_up"
+	StreamDeviceDown     = "device_down"
+	StreamPortUp         = "port_up"
+	StreamPortDown       = "port_down"
+	StreamLinkAdded      = "link_added"
+	StreamLinkRemoved    = "link_removed"
+	StreamHostMoved      = "host_moved"
+	StreamVIPToggled     = "vip_toggled"
+	StreamTopologyChange = "topology_change"
+	StreamTopologyDiff   = "topology_diff"
+	StreamMACFlapping    = "mac_flapping"
+)
+
+var streamEventTypes = map[string]bool{
+	StreamDeviceUp:       true,
+	StreamDeviceDown:     true,
+	StreamPortUp:         true,
+	StreamPortDown:       true,
+	StreamLinkAdded:      true,
+	StreamLinkRemoved:    true,
+	StreamHostMoved:      true,
+	StreamVIPToggled:     true,
+	StreamTopologyChange: true,
+	StreamTopologyDiff:   true,
+	StreamMACFlapping:    true,
+}
+
+// Maximum number of pending events per stream client
+const streamQueueSize = 256
+
+type StreamPort struct {
+	DPID string `json:"dpid"`
+	Port uint32 `json:"port"`
+}
+
+func newStreamPort(p *Port) *StreamPort {
+	if p == nil {
+		return nil
+	}
+
+	return &StreamPort{
+		DPID: p.Device().ID(),
+		Port: p.Number(),
+	}
+}
+
+type StreamVIP struct {
+	ID  uint64 `json:"id"`
+	IP  string `json:"ip"`
+	MAC string `json:"mac"`
+}
+
+// StreamEvent is an event that is sent to the external clients through the REST server.
+type StreamEvent struct {
+	Type string `json:"type"`
+	// DPID is empty on the link, VIP and topology events
+	DPID string `json:"dpid,omitempty"`
+	// Port is the port of the port events, the new location of a moved host, or the port blocked due to MAC flapping
+	Port *StreamPort `json:"port,omitempty"`
+	// Link is the two end points of a link
+	Link []*StreamPort `json:"link,omitempty"`
+	// Ports are the ports on which a flapping host has been observed
+	Ports     []*StreamPort `json:"ports,omitempty"`
+	MAC       string        `json:"mac,omitempty"`
+	PrevPort  *StreamPort   `json:"prev_port,omitempty"`
+	VIP       *StreamVIP    `json:"vip,omitempty"`
+	Diff      *TopologyDiff `json:"diff,omitempty"`
+	Timestamp time.Time     `json:"timestamp"`
+}
+
+// isRelated returns whether this event is related to the device whose ID is dpid.
+func (r StreamEvent) isRelated(dpid string) bool {
+	if r.DPID == dpid {
+		return true
+	}
+	if r.Port != nil && r.Port.DPID == dpid {
+		return true
+	}
+	if r.PrevPort != nil && r.PrevPort.DPID == dpid {
+		return true
+	}
+	for _, p := range r.Link {
+		if p.DPID == dpid {
+			return true
+		}
+	}
+	for _, p := range r.Ports {
+		if p.DPID == dpid {
+			return true
+		}
+	}
+
+	return false
+}
+
+type streamClient struct {
+	queue chan StreamEvent
+	// Empty map means no filtering
+	types   map[string]bool
+	devices map[string]bool
+}
+
+func (r *streamClient) match(e StreamEvent) bool {
+	if len(r.types) > 0 && !r.types[e.Type] {
+		return false
+	}
+	if len(r.devices) == 0 {
+		return true
+	}
+	for dpid := range r.devices {
+		if e.isRelated(dpid) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type eventStream struct {
+	mutex   sync.Mutex
+	clients map[*streamClient]bool
+	dropped uint64
+}
+
+func newEventStream() *eventStream {
+	return &eventStream{
+		clients: make(map[*streamClient]bool),
+	}
+}
+
+// subscribe registers a new client that only receives the events whose types are in types
+// and that are related to the devices in dpids. Nil or empty filter means all.
+func (r *eventStream) subscribe(types, dpids []string) (*streamClient, error) {
+	c := &streamClient{
+		queue:   make(chan StreamEvent, streamQueueSize),
+		types:   make(map[string]bool),
+		devices: make(map[string]bool),
+	}
+	for _, t := range types {
+		if !streamEventTypes[t] {
+			return nil, fmt.Errorf("unknown event type: %v", t)
+		}
+		c.types[t] = true
+	}
+	for _, v := range dpids {
+		if _, err := strconv.ParseUint(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid DPID: %v", v)
+		}
+		c.devices[v] = true
+	}
+
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.clients[c] = true
+
+	return c, nil
+}
+
+func (r *eventStream) unsubscribe(c *streamClient) {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.clients, c)
+}
+
+// publish never blocks. The event is dropped for a client whose queue is full.
+func (r *eventStream) publish(e StreamEvent) {
+	e.Timestamp = time.Now()
+
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for c := range r.clients {
+		if !c.match(e) {
+			continue
+		}
+		select {
+		case c.queue <- e:
+		default:
+			r.dropped++
+		}
+	}
+}
+
+func (r *eventStream) linkAdded(l *link) {
+	r.publish(StreamEvent{Type: StreamLinkAdded, Link: []*StreamPort{newStreamPort(l.ports[0]), newStreamPort(l.ports[1])}})
+}
+
+func (r *eventStream) linkRemoved(l *link) {
+	r.publish(StreamEvent{Type: StreamLinkRemoved, Link: []*StreamPort{newStreamPort(l.ports[0]), newStreamPort(l.ports[1])}})
+}
+
+func (r *eventStream) vipToggled(id uint64, ip net.IP, mac net.HardwareAddr) {
+	r.publish(StreamEvent{Type: StreamVIPToggled, VIP: &StreamVIP{ID: id, IP: ip.String(), MAC: mac.String()}})
+}
+
+// streamListener publishes the controller and topology events to the event stream,
+// and then passes them to the next event listener if it exists.
+type streamListener struct {
+	stream *eventStream
+	next   EventListener
+}
+
+func (r *streamListener) OnPacketIn(finder Finder, ingress *Port, eth *protocol.Ethernet) error {
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnPacketIn(finder, ingress, eth)
+}
+
+func (r *streamListener) OnPortUp(finder Finder, port *Port) error {
+	r.stream.publish(StreamEvent{Type: StreamPortUp, DPID: port.Device().ID(), Port: newStreamPort(port)})
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnPortUp(finder, port)
+}
+
+func (r *streamListener) OnPortDown(finder Finder, port *Port) error {
+	r.stream.publish(StreamEvent{Type: StreamPortDown, DPID: port.Device().ID(), Port: newStreamPort(port)})
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnPortDown(finder, port)
+}
+
+func (r *streamListener) OnDeviceUp(finder Finder, device *Device) error {
+	r.stream.publish(StreamEvent{Type: StreamDeviceUp, DPID: device.ID()})
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnDeviceUp(finder, device)
+}
+
+func (r *streamListener) OnDeviceDown(finder Finder, device *Device) error {
+	r.stream.publish(StreamEvent{Type: StreamDeviceDown, DPID: device.ID()})
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnDeviceDown(finder, device)
+}
+
+func (r *streamListener) OnTopologyChange(finder Finder) error {
+	r.stream.publish(StreamEvent{Type: StreamTopologyChange})
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnTopologyChange(finder)
+}
+
+func (r *streamListener) OnHostMoved(finder Finder, host *Node, prev *Port) error {
+	r.stream.publish(StreamEvent{
+		Type:     StreamHostMoved,
+		DPID:     host.Port().Device().ID(),
+		Port:     newStreamPort(host.Port()),
+		MAC:      host.MAC().String(),
+		PrevPort: newStreamPort(prev),
+	})
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnHostMoved(finder, host, prev)
+}
+
+func (r *streamListener) OnTopologyDiff(finder Finder, diff TopologyDiff) error {
+	r.stream.publish(StreamEvent{Type: StreamTopologyDiff, Diff: &diff})
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnTopologyDiff(finder, diff)
+}
+
+func (r *streamListener) OnMACFlapping(finder Finder, flapping MACFlapping) error {
+	e := StreamEvent{
+		Type:  StreamMACFlapping,
+		MAC:   flapping.MAC.String(),
+		Ports: make([]*StreamPort, 0),
+	}
+	for _, p := range flapping.Ports {
+		e.Ports = append(e.Ports, newStreamPort(p))
+	}
+	// Port is the blocked port if any
+	if flapping.Blocked != nil {
+		e.DPID = flapping.Blocked.Device().ID()
+		e.Port = newStreamPort(flapping.Blocked)
+	}
+	r.stream.publish(e)
+	if r.next == nil {
+		return nil
+	}
+
+	return r.next.OnMACFlapping(finder, flapping)
+}
@@ -0,0 +1,71 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"testing"
+)
+
+func TestEventStreamFilter(t *testing.T) {
+	stream := newEventStream()
+
+	if _, err := stream.subscribe([]string{"unknown"}, nil); err == nil {
+		t.Fatal("expected an error for an unknown event type")
+	}
+	if _, err := stream.subscribe(nil, []string{"abc"}); err == nil {
+		t.Fatal("expected an error for an invalid DPID")
+	}
+
+	all, err := stream.subscribe(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filtered, err := stream.subscribe([]string{StreamLinkAdded, StreamDeviceUp}, []string{"2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream.publish(StreamEvent{Type: StreamDeviceUp, DPID: "1"})
+	stream.publish(StreamEvent{Type: StreamDeviceUp, DPID: "2"})
+	stream.publish(StreamEvent{Type: StreamDeviceDown, DPID: "2"})
+	stream.publish(StreamEvent{Type: StreamLinkAdded, Link: []*StreamPort{{DPID: "1", Port: 1}, {DPID: "2", Port: 3}}})
+	stream.publish(StreamEvent{Type: StreamTopologyChange})
+
+	if len(all.queue) != 5 {
+		t.Fatalf("expected 5 events, got %v", len(all.queue))
+	}
+	if len(filtered.queue) != 2 {
+		t.Fatalf("expected 2 events, got %v", len(filtered.queue))
+	}
+	if e := <-filtered.queue; e.Type != StreamDeviceUp || e.DPID != "2" {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+	if e := <-filtered.queue; e.Type != StreamLinkAdded {
+		t.Fatalf("unexpected event: %+v", e)
+	}
+
+	stream.unsubscribe(all)
+	stream.publish(StreamEvent{Type: StreamTopologyChange})
+	if len(all.queue) != 5 {
+		t.Fatalf("expected no more events after unsubscribing, got %v", len(all.queue))
+	}
+}
@@ -0,0 +1,352 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+)
+
+const (
+	defaultFlapWindow    = 10 * time.Second
+	defaultFlapThreshold = 4
+	defaultFlapHoldTime  = 300 * time.Second
+)
+
+// blockAction is what we do to a port that causes MAC flapping.
+type blockAction string
+
+const (
+	// Only report the flapping
+	blockNone blockAction = "none"
+	// Stop sending PACKET_INs from the port
+	blockNoPacketIn blockAction = "no_packet_in"
+	// Administratively shut down the port
+	blockPortDown blockAction = "port_down"
+)
+
+func parseBlockAction(v string) (blockAction, error) {
+	switch a := blockAction(v); a {
+	case blockNone, blockNoPacketIn, blockPortDown:
+		return a, nil
+	default:
+		return "", fmt.Errorf("invalid block action: %v", v)
+	}
+}
+
+type flapConfig struct {
+	enable bool
+	// A host is flapping if it moves threshold times among ports within window.
+	window    time.Duration
+	threshold int
+	action    blockAction
+	// Duration during which a blocked port is kept blocked
+	holdTime time.Duration
+}
+
+// MACFlapping describes a host that has moved among the ports facing hosts too frequently,
+// which usually means a forwarding loop through an unmanaged switch.
+type MACFlapping struct {
+	MAC net.HardwareAddr
+	// Ports on which the host has been observed within the detection window
+	Ports []*Port
+	// Blocked may be nil if we did not block any port
+	Blocked  *Port
+	HoldTime time.Duration
+}
+
+type hostMove struct {
+	port      *Port
+	timestamp time.Time
+}
+
+// hostOrigin is the first location of a host observed within the window.
+type hostOrigin struct {
+	port      *Port
+	timestamp time.Time
+}
+
+type flapRecord struct {
+	mac       string
+	timestamp time.Time
+}
+
+// flapDetector detects a same source MAC address appearing on multiple ports facing hosts within a short window.
+type flapDetector struct {
+	mutex     sync.Mutex
+	window    time.Duration
+	threshold int
+	// Key is the MAC address
+	moves map[string][]hostMove
+	// Flapping hosts observed on each port within the window. Key is the port.
+	flaps map[*Port][]flapRecord
+	// Key is the MAC address
+	origins map[string]hostOrigin
+}
+
+func newFlapDetector(window time.Duration, threshold int) *flapDetector {
+	if window <= 0 {
+		panic("invalid flapping window")
+	}
+	if threshold < 2 {
+		panic("invalid flapping threshold")
+	}
+
+	return &flapDetector{
+		window:    window,
+		threshold: threshold,
+		moves:     make(map[string][]hostMove),
+		flaps:     make(map[*Port][]flapRecord),
+		origins:   make(map[string]hostOrigin),
+	}
+}
+
+// observe is called when the host whose MAC address is mac has moved from prev to p at now. It returns
+// the ports involved if the host is flapping, and the ports that most likely cause the loop.
+func (r *flapDetector) observe(mac net.HardwareAddr, p, prev *Port, now time.Time) (ports []*Port, culprits []*Port) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := mac.String()
+	origin, ok := r.origins[key]
+	if !ok || now.Sub(origin.timestamp) > r.window {
+		origin.port = prev
+		if origin.port == nil {
+			origin.port = p
+		}
+	}
+	origin.timestamp = now
+	r.origins[key] = origin
+
+	moves := make([]hostMove, 0)
+	for _, m := range r.moves[key] {
+		if now.Sub(m.timestamp) <= r.window {
+			moves = append(moves, m)
+		}
+	}
+	if len(moves) == 0 && prev != nil {
+		moves = append(moves, hostMove{port: prev, timestamp: now})
+	}
+	moves = append(moves, hostMove{port: p, timestamp: now})
+	// The number of moves is the number of transitions between the observed locations
+	if len(moves)-1 < r.threshold {
+		r.moves[key] = moves
+		return nil, nil
+	}
+	delete(r.moves, key)
+
+	seen := make(map[*Port]bool)
+	for _, m := range moves {
+		if seen[m.port] {
+			continue
+		}
+		seen[m.port] = true
+		ports = append(ports, m.port)
+		r.flaps[m.port] = append(r.flaps[m.port], flapRecord{mac: key, timestamp: now})
+	}
+
+	// A looped port makes many hosts flap, so we pick the port involved in the most flapping hosts.
+	best := 0
+	tied := make([]*Port, 0)
+	for _, v := range ports {
+		s := r.score(v, now)
+		switch {
+		case s > best:
+			best = s
+			tied = []*Port{v}
+		case s == best:
+			tied = append(tied, v)
+		}
+	}
+	r.sweep(now)
+
+	return ports, pickCulprits(tied, origin.port)
+}
+
+// pickCulprits returns the ports to be blocked among the tied ports, which are involved in the same number of
+// flapping hosts. A loop through an unmanaged switch connected to two ports makes every flapping host appear
+// on both ports equally, so we keep the first location of the host, which is most likely its own port, and
+// block the others.
+func pickCulprits(tied []*Port, origin *Port) []*Port {
+	if len(tied) <= 1 {
+		return tied
+	}
+
+	culprits := make([]*Port, 0, len(tied)-1)
+	for _, v := range tied {
+		if v != origin {
+			culprits = append(culprits, v)
+		}
+	}
+
+	return culprits
+}
+
+// score returns the number of distinct flapping hosts observed on p within the window.
+// XXX: Caller should lock the mutex
+func (r *flapDetector) score(p *Port, now time.Time) int {
+	macs := make(map[string]bool)
+	for _, v := range r.flaps[p] {
+		if now.Sub(v.timestamp) <= r.window {
+			macs[v.mac] = true
+		}
+	}
+
+	return len(macs)
+}
+
+// XXX: Caller should lock the mutex
+func (r *flapDetector) sweep(now time.Time) {
+	for p, records := range r.flaps {
+		valid := records[:0]
+		for _, v := range records {
+			if now.Sub(v.timestamp) <= r.window {
+				valid = append(valid, v)
+			}
+		}
+		if len(valid) == 0 {
+			delete(r.flaps, p)
+			continue
+		}
+		r.flaps[p] = valid
+	}
+	for mac, v := range r.origins {
+		if now.Sub(v.timestamp) > r.window {
+			delete(r.origins, mac)
+		}
+	}
+}
+
+// portBlocker blocks a port for the hold time, and then clears the configuration flag that it has set.
+type portBlocker struct {
+	mutex    sync.Mutex
+	log      log.Logger
+	finder   Finder
+	action   blockAction
+	holdTime time.Duration
+	// Key is the port ID
+	blocked map[string]bool
+	// Ports that could not be unblocked because their devices were disconnected. The configuration flags stay
+	// on the devices, so they are cleared when the devices are connected again. Key is the device ID.
+	pending map[string][]uint32
+}
+
+func newPortBlocker(log log.Logger, finder Finder, action blockAction, holdTime time.Duration) *portBlocker {
+	return &portBlocker{
+		log:      log,
+		finder:   finder,
+		action:   action,
+		holdTime: holdTime,
+		blocked:  make(map[string]bool),
+		pending:  make(map[string][]uint32),
+	}
+}
+
+// block returns false if p has been already blocked or the block action is none.
+func (r *portBlocker) block(p *Port) (bool, error) {
+	if r.action == blockNone {
+		return false, nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id := p.ID()
+	if r.blocked[id] {
+		return false, nil
+	}
+	if err := r.setBlocked(p, true); err != nil {
+		return false, err
+	}
+	r.blocked[id] = true
+	r.log.Warning(fmt.Sprintf("PortBlocker: blocked %v (action=%v) for %v", id, r.action, r.holdTime))
+
+	deviceID, num := p.Device().ID(), p.Number()
+	time.AfterFunc(r.holdTime, func() {
+		r.mutex.Lock()
+		delete(r.blocked, id)
+		r.mutex.Unlock()
+
+		// The device may have been reconnected during the hold time, so we look up the current port.
+		var port *Port
+		if d := r.finder.Device(deviceID); d != nil {
+			port = d.Port(num)
+		}
+		if port == nil {
+			r.log.Info(fmt.Sprintf("PortBlocker: %v will be unblocked when its device is connected again", id))
+			r.mutex.Lock()
+			r.pending[deviceID] = append(r.pending[deviceID], num)
+			r.mutex.Unlock()
+			return
+		}
+		r.unblock(port)
+	})
+
+	return true, nil
+}
+
+// resume unblocks the ports of d whose hold time has expired while d was disconnected. It is called after
+// the ports of d have been discovered.
+func (r *portBlocker) resume(d *Device) {
+	r.mutex.Lock()
+	pending := r.pending[d.ID()]
+	delete(r.pending, d.ID())
+	r.mutex.Unlock()
+
+	for _, num := range pending {
+		port := d.Port(num)
+		if port == nil {
+			r.log.Info(fmt.Sprintf("PortBlocker: skip unblocking unknown port %v on %v", num, d.ID()))
+			continue
+		}
+		r.unblock(port)
+	}
+}
+
+func (r *portBlocker) unblock(p *Port) {
+	if err := r.setBlocked(p, false); err != nil {
+		r.log.Err(fmt.Sprintf("PortBlocker: failed to unblock %v: %v", p.ID(), err))
+		return
+	}
+	r.log.Info(fmt.Sprintf("PortBlocker: unblocked %v", p.ID()))
+}
+
+// setBlocked changes only the configuration flag of the block action, so that other changes made during the hold
+// time, for example by the REST API, are kept.
+func (r *portBlocker) setBlocked(p *Port, blocked bool) error {
+	c := p.Config()
+	switch r.action {
+	case blockNoPacketIn:
+		c.NoPacketIn = blocked
+	case blockPortDown:
+		c.PortDown = blocked
+	default:
+		return errors.New("unknown block action")
+	}
+
+	return p.SetConfig(c)
+}
@@ -0,0 +1,77 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestFlapDetector(t *testing.T) {
+	d := newFlapDetector(10*time.Second, 3)
+	host := &Port{number: 1}
+	loop := &Port{number: 2}
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	now := time.Now()
+
+	// host -> loop -> host
+	if ports, _ := d.observe(mac, loop, host, now); ports != nil {
+		t.Fatal("unexpected flapping after the first move")
+	}
+	if ports, _ := d.observe(mac, host, loop, now.Add(time.Second)); ports != nil {
+		t.Fatal("unexpected flapping after the second move")
+	}
+	// The third move within the window. Both ports are involved in a single flapping host, so the
+	// port other than the first location of the host should be blocked.
+	ports, culprits := d.observe(mac, loop, host, now.Add(2*time.Second))
+	if len(culprits) != 1 || culprits[0] != loop {
+		t.Fatalf("expected the looped port as the culprit on a tie, got %v", culprits)
+	}
+	if len(ports) != 2 {
+		t.Fatalf("expected 2 ports involved, got %v", len(ports))
+	}
+
+	// Another host flaps between its own port and the looped port. The looped port
+	// should be chosen because it is involved in more flapping hosts.
+	other := &Port{number: 3}
+	mac2 := net.HardwareAddr{0, 1, 2, 3, 4, 6}
+	d.observe(mac2, loop, other, now.Add(3*time.Second))
+	d.observe(mac2, other, loop, now.Add(3*time.Second))
+	if _, culprits := d.observe(mac2, other, loop, now.Add(4*time.Second)); len(culprits) != 1 || culprits[0] != loop {
+		t.Fatal("expected the looped port as the culprit")
+	}
+}
+
+func TestFlapDetectorWindow(t *testing.T) {
+	d := newFlapDetector(10*time.Second, 2)
+	p1 := &Port{number: 1}
+	p2 := &Port{number: 2}
+	mac := net.HardwareAddr{0, 1, 2, 3, 4, 5}
+	now := time.Now()
+
+	d.observe(mac, p2, p1, now)
+	// Moves out of the window are forgotten
+	if ports, _ := d.observe(mac, p1, p2, now.Add(time.Minute)); ports != nil {
+		t.Fatal("unexpected flapping after the window is expired")
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/openflow"
+)
+
+// Layout of the flow cookie:
+//
+//	bit 63:     marker of the table miss flows
+//	bit 48-62:  ID of the application that installed the flow (zero is reserved for the controller)
+//	bit 0-47:   sequence number assigned by the device
+const (
+	cookieOwnerShift = 48
+	cookieOwnerMask  = uint64(0x7FFF) << cookieOwnerShift
+	cookieSeqMask    = uint64(0x1)<<cookieOwnerShift - 1
+	maxFlowOwners    = 0x7FFF
+)
+
+var flowOwners = struct {
+	mutex sync.Mutex
+	// Key is the application name
+	ids  map[string]uint64
+	next uint64
+}{
+	ids:  make(map[string]uint64),
+	next: 1,
+}
+
+// flowOwnerID returns the cookie prefix assigned to the application whose name is app.
+func flowOwnerID(app string) uint64 {
+	flowOwners.mutex.Lock()
+	defer flowOwners.mutex.Unlock()
+
+	if id, ok := flowOwners.ids[app]; ok {
+		return id
+	}
+	if flowOwners.next > maxFlowOwners {
+		panic("too many flow owners")
+	}
+	id := flowOwners.next << cookieOwnerShift
+	flowOwners.ids[app] = id
+	flowOwners.next++
+
+	return id
+}
+
+// flowOwnerName returns the name of the application that installed the flow whose cookie is cookie. ok is false if
+// the flow is installed by the controller itself or an unknown application.
+func flowOwnerName(cookie uint64) (app string, ok bool) {
+	flowOwners.mutex.Lock()
+	defer flowOwners.mutex.Unlock()
+
+	id := cookie & cookieOwnerMask
+	for name, v := range flowOwners.ids {
+		if v == id {
+			return name, true
+		}
+	}
+
+	return "", false
+}
+
+// Flow is a flow entry installed through Device.InstallFlow.
+type Flow struct {
+	Owner       string
+	Cookie      uint64
+	TableID     uint8
+	Priority    uint16
+	IdleTimeout uint16
+	HardTimeout uint16
+	Match       openflow.Match
+	// Action may be nil if the flow does not have any action
+	Action openflow.Action
+	// Mirrored is true if the flow is installed through Device.InstallForwardingFlow, which applies the port mirrors
+	Mirrored  bool
+	Timestamp time.Time
+}
+
+func (r Flow) String() string {
+	return fmt.Sprintf("Flow Owner=%v, Cookie=%v, TableID=%v, Priority=%v, IdleTimeout=%v, HardTimeout=%v, Timestamp=%v", r.Owner, r.Cookie, r.TableID, r.Priority, r.IdleTimeout, r.HardTimeout, r.Timestamp)
+}
+
+// IsPermanent returns whether the flow never expires.
+func (r Flow) IsPermanent() bool {
+	return r.IdleTimeout == 0 && r.HardTimeout == 0
+}
+
+// OutPort returns the output port of the flow's action. ok is false if the flow does not have an action.
+func (r Flow) OutPort() (port openflow.OutPort, ok bool) {
+	if r.Action == nil {
+		return port, false
+	}
+
+	return r.Action.OutPort(), true
+}
+
+// Maximum time to wait for an error of FLOW_MOD before we consider that the flow has been installed
+const flowConfirmTimeout = 10 * time.Second
+
+type pendingFlow struct {
+	cookie    uint64
+	timestamp time.Time
+}
+
+// flowRegistry is the inventory of the flows installed through a device.
+type flowRegistry struct {
+	mutex sync.Mutex
+	// Key is the cookie
+	flows map[uint64]Flow
+	// Key is the transaction ID of FLOW_MOD that may be rejected by the device
+	pending map[uint32]pendingFlow
+	seq     uint64
+}
+
+func newFlowRegistry() *flowRegistry {
+	return &flowRegistry{
+		flows:   make(map[uint64]Flow),
+		pending: make(map[uint32]pendingFlow),
+	}
+}
+
+func (r *flowRegistry) nextCookie(app string) uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.seq = (r.seq + 1) & cookieSeqMask
+	if r.seq == 0 {
+		r.seq = 1
+	}
+
+	return flowOwnerID(app) | r.seq
+}
+
+func (r *flowRegistry) add(f Flow) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.flows[f.Cookie] = f
+}
+
+// track remembers the transaction ID of FLOW_MOD that has installed the flow whose cookie is cookie, so that the
+// flow can be removed from the inventory if the device rejects it.
+func (r *flowRegistry) track(xid uint32, cookie uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for k, v := range r.pending {
+		if now.Sub(v.timestamp) > flowConfirmTimeout {
+			delete(r.pending, k)
+		}
+	}
+	r.pending[xid] = pendingFlow{cookie: cookie, timestamp: now}
+}
+
+// reject removes the flow installed by FLOW_MOD whose transaction ID is xid. ok is false if there is no such flow.
+func (r *flowRegistry) reject(xid uint32) (f Flow, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v, ok := r.pending[xid]
+	if !ok {
+		return f, false
+	}
+	delete(r.pending, xid)
+	f, ok = r.flows[v.cookie]
+	delete(r.flows, v.cookie)
+
+	return f, ok
+}
+
+// resetPending forgets the transaction IDs of the previous session, which can be reused by a new session.
+func (r *flowRegistry) resetPending() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.pending = make(map[uint32]pendingFlow)
+}
+
+func (r *flowRegistry) get(cookie uint64) (f Flow, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	f, ok = r.flows[cookie]
+	return f, ok
+}
+
+func (r *flowRegistry) remove(cookie uint64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.flows, cookie)
+}
+
+func (r *flowRegistry) list(app string) []Flow {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v := make([]Flow, 0)
+	for _, f := range r.flows {
+		if f.Owner == app {
+			v = append(v, f)
+		}
+	}
+
+	return v
+}
+
+func (r *flowRegistry) all() []Flow {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v := make([]Flow, 0, len(r.flows))
+	for _, f := range r.flows {
+		v = append(v, f)
+	}
+
+	return v
+}
+
+func (r *flowRegistry) removeOwner(app string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for k, f := range r.flows {
+		if f.Owner == app {
+			delete(r.flows, k)
+		}
+	}
+}
+
+func (r *flowRegistry) clear() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.flows = make(map[uint64]Flow)
+	r.pending = make(map[uint32]pendingFlow)
+}
@@ -0,0 +1,83 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"testing"
+)
+
+func TestFlowCookie(t *testing.T) {
+	registry := newFlowRegistry()
+	c1 := registry.nextCookie("App1")
+	c2 := registry.nextCookie("App2")
+	c3 := registry.nextCookie("App1")
+
+	if c1&(0x1<<63) != 0 || c2&(0x1<<63) != 0 {
+		t.Fatalf("Table miss marker is set: %x, %x", c1, c2)
+	}
+	if c1&cookieOwnerMask != c3&cookieOwnerMask {
+		t.Fatalf("Different owner IDs for a same application: %x, %x", c1, c3)
+	}
+	if c1&cookieOwnerMask == c2&cookieOwnerMask {
+		t.Fatalf("Same owner ID for different applications: %x, %x", c1, c2)
+	}
+	if c1 == c3 {
+		t.Fatalf("Duplicated cookie: %x", c1)
+	}
+
+	registry.add(Flow{Owner: "App1", Cookie: c1})
+	registry.add(Flow{Owner: "App2", Cookie: c2})
+	registry.add(Flow{Owner: "App1", Cookie: c3})
+	if n := len(registry.list("App1")); n != 2 {
+		t.Fatalf("Unexpected number of flows: expected=2, got=%v", n)
+	}
+	registry.removeOwner("App1")
+	if n := len(registry.list("App1")); n != 0 {
+		t.Fatalf("Unexpected number of flows: expected=0, got=%v", n)
+	}
+	if n := len(registry.list("App2")); n != 1 {
+		t.Fatalf("Unexpected number of flows: expected=1, got=%v", n)
+	}
+}
+
+func TestRejectFlow(t *testing.T) {
+	registry := newFlowRegistry()
+	c1 := registry.nextCookie("App1")
+	c2 := registry.nextCookie("App1")
+	registry.add(Flow{Owner: "App1", Cookie: c1})
+	registry.track(1, c1)
+	registry.add(Flow{Owner: "App1", Cookie: c2})
+	registry.track(2, c2)
+
+	if _, ok := registry.reject(3); ok {
+		t.Fatal("Unknown transaction ID should not remove a flow")
+	}
+	if f, ok := registry.reject(2); !ok || f.Cookie != c2 {
+		t.Fatalf("Unexpected rejected flow: ok=%v, flow=%v", ok, f)
+	}
+	if _, ok := registry.get(c2); ok {
+		t.Fatal("Rejected flow is still in the inventory")
+	}
+	if _, ok := registry.get(c1); !ok {
+		t.Fatal("Accepted flow has been removed from the inventory")
+	}
+}
@@ -0,0 +1,102 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+)
+
+// gracePeriod retains disconnected devices for a while so that they can be re-bound to
+// new sessions without device down and up events if they reconnect within the period.
+type gracePeriod struct {
+	mutex  sync.Mutex
+	log    log.Logger
+	period time.Duration
+	// Key is the device ID
+	devices map[string]*heldDevice
+}
+
+type heldDevice struct {
+	device *Device
+	timer  *time.Timer
+}
+
+func newGracePeriod(log log.Logger, period time.Duration) *gracePeriod {
+	return &gracePeriod{
+		log:     log,
+		period:  period,
+		devices: make(map[string]*heldDevice),
+	}
+}
+
+func (r *gracePeriod) enabled() bool {
+	return r.period > 0
+}
+
+// hold retains d during the grace period, and then calls expired if d does not reconnect within the period.
+func (r *gracePeriod) hold(d *Device, expired func()) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	id := d.ID()
+	r.log.Info(fmt.Sprintf("GracePeriod: holding the disconnected device (DPID=%v) for %v", id, r.period))
+
+	held := &heldDevice{device: d}
+	held.timer = time.AfterFunc(r.period, func() {
+		r.mutex.Lock()
+		v, ok := r.devices[id]
+		// Already resumed or replaced by another one?
+		if !ok || v != held {
+			r.mutex.Unlock()
+			return
+		}
+		delete(r.devices, id)
+		r.mutex.Unlock()
+
+		r.log.Info(fmt.Sprintf("GracePeriod: the device (DPID=%v) did not reconnect within the grace period", id))
+		expired()
+	})
+	r.devices[id] = held
+}
+
+// resume returns the device whose ID is id if it is retained in the grace period, or nil otherwise.
+func (r *gracePeriod) resume(id string) *Device {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	held, ok := r.devices[id]
+	if !ok {
+		return nil
+	}
+	// Too late? The timer callback will remove this device.
+	if !held.timer.Stop() {
+		return nil
+	}
+	delete(r.devices, id)
+	r.log.Info(fmt.Sprintf("GracePeriod: the device (DPID=%v) reconnected within the grace period", id))
+
+	return held.device
+}
@@ -0,0 +1,213 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	defaultHostAgingTime = 300 * time.Second
+)
+
+// LearnedHost is a host location learned from the traffic observed on switch ports facing hosts.
+type LearnedHost struct {
+	MAC       net.HardwareAddr
+	IP        net.IP // IP may be nil if we have not seen any IP packet from the host yet
+	Port      *Port
+	Timestamp time.Time
+}
+
+type hostTracker struct {
+	mutex sync.Mutex
+	// Key is the MAC address
+	hosts     map[string]*LearnedHost
+	agingTime time.Duration
+	lastSweep time.Time
+}
+
+func newHostTracker(agingTime time.Duration) *hostTracker {
+	if agingTime <= 0 {
+		panic("invalid host aging time")
+	}
+
+	return &hostTracker{
+		hosts:     make(map[string]*LearnedHost),
+		agingTime: agingTime,
+		lastSweep: time.Now(),
+	}
+}
+
+func (r *hostTracker) String() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var buf bytes.Buffer
+	for _, v := range r.hosts {
+		buf.WriteString(fmt.Sprintf("Learned Host MAC=%v, IP=%v, Port=%v, Timestamp=%v\n", v.MAC, v.IP, v.Port.ID(), v.Timestamp))
+	}
+
+	return buf.String()
+}
+
+// learn updates the location of the host whose MAC address is mac. found is false if the host
+// is newly learned, and prev is the previous location if the host has been known at a different port.
+func (r *hostTracker) learn(p *Port, mac net.HardwareAddr, ip net.IP) (prev *Port, found bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	r.sweep(now)
+
+	host, found := r.hosts[mac.String()]
+	if !found {
+		host = &LearnedHost{MAC: mac}
+		r.hosts[mac.String()] = host
+	} else if host.Port != p {
+		prev = host.Port
+	}
+	host.Port = p
+	host.Timestamp = now
+	if ip != nil {
+		host.IP = ip
+	}
+
+	return prev, found
+}
+
+// XXX: Caller should lock the mutex
+func (r *hostTracker) sweep(now time.Time) {
+	if now.Sub(r.lastSweep) < r.agingTime/2 {
+		return
+	}
+	r.lastSweep = now
+
+	for k, v := range r.hosts {
+		if r.isExpired(v, now) {
+			delete(r.hosts, k)
+		}
+	}
+}
+
+func (r *hostTracker) isExpired(h *LearnedHost, now time.Time) bool {
+	return now.Sub(h.Timestamp) > r.agingTime
+}
+
+// lookup returns nil if the host whose MAC address is mac is unknown or expired.
+func (r *hostTracker) lookup(mac net.HardwareAddr) *LearnedHost {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	host, ok := r.hosts[mac.String()]
+	if !ok {
+		return nil
+	}
+	if r.isExpired(host, time.Now()) {
+		delete(r.hosts, mac.String())
+		return nil
+	}
+	v := *host
+
+	return &v
+}
+
+func (r *hostTracker) list() []LearnedHost {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	v := make([]LearnedHost, 0)
+	for _, host := range r.hosts {
+		if r.isExpired(host, now) {
+			continue
+		}
+		v = append(v, *host)
+	}
+
+	return v
+}
+
+func (r *hostTracker) removePort(p *Port) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for k, v := range r.hosts {
+		if v.Port == p {
+			delete(r.hosts, k)
+		}
+	}
+}
+
+func (r *hostTracker) removeDevice(d *Device) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for k, v := range r.hosts {
+		if v.Port.Device() == d {
+			delete(r.hosts, k)
+		}
+	}
+}
+
+func isUnicastMAC(mac net.HardwareAddr) bool {
+	if len(mac) != 6 || mac[0]&0x01 != 0 {
+		return false
+	}
+
+	return !bytes.Equal(mac, net.HardwareAddr([]byte{0, 0, 0, 0, 0, 0}))
+}
+
+// getSenderIP returns the IPv4 address of the sender of the packet, or nil if the packet
+// is neither an ARP nor an IPv4 packet.
+func getSenderIP(eth *protocol.Ethernet) net.IP {
+	var ip net.IP
+
+	switch eth.Type {
+	case 0x0806:
+		arp := new(protocol.ARP)
+		if err := arp.UnmarshalBinary(eth.Payload); err != nil {
+			return nil
+		}
+		ip = arp.SPA
+	case 0x0800:
+		ipv4 := new(protocol.IPv4)
+		if err := ipv4.UnmarshalBinary(eth.Payload); err != nil {
+			return nil
+		}
+		ip = ipv4.SrcIP
+	default:
+		return nil
+	}
+
+	// ARP probes and DHCP requests are sent from 0.0.0.0
+	if ip == nil || ip.IsUnspecified() {
+		return nil
+	}
+
+	return ip
+}
@@ -0,0 +1,65 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestHostTracker(t *testing.T) {
+	device := &Device{id: "1", ports: make(map[uint32]*Port)}
+	p1 := NewPort(device, 1)
+	p2 := NewPort(device, 2)
+	mac := net.HardwareAddr([]byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+	ip := net.IPv4(10, 0, 0, 1)
+
+	tracker := newHostTracker(100 * time.Millisecond)
+	if prev, found := tracker.learn(p1, mac, ip); prev != nil || found {
+		t.Fatalf("Unexpected learning result: prev=%v, found=%v", prev, found)
+	}
+	host := tracker.lookup(mac)
+	if host == nil || host.Port != p1 || !host.IP.Equal(ip) {
+		t.Fatalf("Unexpected learned host: %+v", host)
+	}
+
+	// IP address should be kept if the packet does not have the sender IP
+	if prev, found := tracker.learn(p2, mac, nil); prev != p1 || !found {
+		t.Fatalf("Unexpected learning result: prev=%v, found=%v", prev, found)
+	}
+	host = tracker.lookup(mac)
+	if host == nil || host.Port != p2 || !host.IP.Equal(ip) {
+		t.Fatalf("Unexpected learned host: %+v", host)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if host := tracker.lookup(mac); host != nil {
+		t.Fatalf("Expired host is returned: %+v", host)
+	}
+
+	tracker.learn(p1, mac, ip)
+	tracker.removePort(p1)
+	if host := tracker.lookup(mac); host != nil {
+		t.Fatalf("Host on the removed port is returned: %+v", host)
+	}
+}
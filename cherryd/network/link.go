@@ -23,18 +23,43 @@ package network
 
 import (
 	"fmt"
+	"math"
 	"github.com/superkkt/cherry/cherryd/graph"
 	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Weight of the latest latency sample when we smooth the link latency
+	latencyAlpha = 0.125
+	// Weight of the links whose latency is not measured yet, so that they are not preferred to the measured links.
+	unmeasuredLinkWeight = 1000
+	// The spanning tree is recalculated if the weight of a link has changed by more than this ratio since the
+	// last calculation. Small changes are ignored to avoid recalculating the tree on every LLDP.
+	weightChangeRatio = 0.2
 )
 
 type link struct {
+	mutex sync.RWMutex
 	ports [2]*Port
+	// Smoothed one-way latency measured by LLDP. Zero means unknown or less than the measurement resolution.
+	latency  time.Duration
+	measured bool
+	// Use the latency as the weight of this link?
+	latencyWeight bool
+	// Weight of this link when the spanning tree was calculated last time
+	treeWeight float64
 }
 
-func newLink(ports [2]*Port) *link {
-	return &link{
-		ports: ports,
+func newLink(ports [2]*Port, latencyWeight bool) *link {
+	v := &link{
+		ports:         ports,
+		latencyWeight: latencyWeight,
 	}
+	v.treeWeight = v.Weight()
+
+	return v
 }
 
 func (r *link) ID() string {
@@ -50,5 +75,57 @@ func (r *link) Points() [2]graph.Point {
 
 func (r *link) Weight() float64 {
 	// TODO: Calculate weight dynamically based on the link speed among these two ports
-	return 0
+	if !r.latencyWeight {
+		return 0
+	}
+
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.weight()
+}
+
+// A caller should make sure the mutex is locked before calling this function.
+func (r *link) weight() float64 {
+	if !r.measured {
+		return unmeasuredLinkWeight
+	}
+
+	// Latency in milliseconds
+	return r.latency.Seconds() * 1000
+}
+
+func (r *link) Latency() time.Duration {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.latency
+}
+
+// updateLatency smooths the latency samples using an exponentially weighted moving average. It returns true
+// if the spanning tree should be recalculated because the weight of this link has changed significantly.
+func (r *link) updateLatency(sample time.Duration) (recalculate bool) {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if !r.measured {
+		r.latency = sample
+		r.measured = true
+	} else {
+		r.latency = time.Duration((1-latencyAlpha)*float64(r.latency) + latencyAlpha*float64(sample))
+	}
+	if !r.latencyWeight {
+		return false
+	}
+
+	w := r.weight()
+	if math.Abs(w-r.treeWeight) <= r.treeWeight*weightChangeRatio {
+		return false
+	}
+	r.treeWeight = w
+
+	return true
 }
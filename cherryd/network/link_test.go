@@ -0,0 +1,80 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"testing"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+func TestLLDPTimestamp(t *testing.T) {
+	now := time.Now()
+	lldp := &protocol.LLDP{
+		ChassisID: protocol.LLDPChassisID{SubType: 7, Data: []byte("1")},
+		PortID:    protocol.LLDPPortID{SubType: 5, Data: []byte("cherry/1")},
+		TTL:       120,
+		Options:   []protocol.LLDPOption{newLLDPTimestamp(now)},
+	}
+	data, err := lldp.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := new(protocol.LLDP)
+	if err := v.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	timestamp, ok := getLLDPTimestamp(v)
+	if !ok {
+		t.Fatal("expected the timestamp TLV")
+	}
+	if !timestamp.Equal(time.Unix(0, now.UnixNano())) {
+		t.Fatalf("expected timestamp %v, got %v", now, timestamp)
+	}
+}
+
+func TestLinkLatency(t *testing.T) {
+	l := newLink([2]*Port{}, true)
+	if l.Weight() != unmeasuredLinkWeight {
+		t.Fatalf("expected the unmeasured link weight, got %v", l.Weight())
+	}
+	if !l.updateLatency(8 * time.Millisecond) {
+		t.Fatal("expected recalculation on the first sample")
+	}
+	if l.Latency() != 8*time.Millisecond {
+		t.Fatalf("expected the first sample as the latency, got %v", l.Latency())
+	}
+	if l.updateLatency(16 * time.Millisecond) {
+		t.Fatal("expected no recalculation on a small weight change")
+	}
+	if l.Latency() != 9*time.Millisecond {
+		t.Fatalf("expected the smoothed latency 9ms, got %v", l.Latency())
+	}
+	if l.Weight() != 9 {
+		t.Fatalf("expected the weight 9, got %v", l.Weight())
+	}
+	if !l.updateLatency(100 * time.Millisecond) {
+		t.Fatal("expected recalculation on a large weight change")
+	}
+}
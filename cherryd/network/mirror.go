@@ -0,0 +1,116 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/superkkt/cherry/cherryd/openflow"
+)
+
+// PortMirror duplicates the traffic to and from a port of a device to another port of the same device.
+type PortMirror struct {
+	// Source is the number of the mirrored port
+	Source uint32
+	// MAC restricts the mirrored traffic to the traffic to and from the host if it is not nil
+	MAC net.HardwareAddr
+	// Output is the number of the port that receives the copies
+	Output uint32
+	// The copies are tagged with VLANID if it is not zero, which is used to carry them to a remote analyzer
+	VLANID uint16
+}
+
+func (r PortMirror) String() string {
+	return fmt.Sprintf("PortMirror Source=%v, MAC=%v, Output=%v, VLANID=%v", r.Source, r.MAC, r.Output, r.VLANID)
+}
+
+func (r PortMirror) mirror() openflow.Mirror {
+	port := openflow.NewOutPort()
+	port.SetValue(r.Output)
+
+	return openflow.Mirror{Port: port, VLANID: r.VLANID}
+}
+
+// matchHost returns whether mac is the host restricted by this mirror.
+func (r PortMirror) matchHost(mac net.HardwareAddr) bool {
+	return r.MAC == nil || bytes.Equal(r.MAC, mac)
+}
+
+// copyMatch returns a new match whose fields are same with m.
+func copyMatch(f openflow.Factory, m openflow.Match) (openflow.Match, error) {
+	data, err := m.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	v, err := f.NewMatch()
+	if err != nil {
+		return nil, err
+	}
+	if err := v.UnmarshalBinary(data); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// copyAction returns a new action whose fields are same with a.
+func copyAction(f openflow.Factory, a openflow.Action) (openflow.Action, error) {
+	v, err := f.NewAction()
+	if err != nil {
+		return nil, err
+	}
+	v.SetOutPort(a.OutPort())
+	if ok, mac := a.SrcMAC(); ok {
+		v.SetSrcMAC(mac)
+	}
+	if ok, mac := a.DstMAC(); ok {
+		v.SetDstMAC(mac)
+	}
+	if ok, queue := a.Queue(); ok {
+		v.SetQueue(queue)
+	}
+	if ok, vid := a.VLANID(); ok {
+		v.SetVLANID(vid)
+	}
+	if ok, ip := a.SrcIP(); ok {
+		v.SetSrcIP(ip)
+	}
+	if ok, ip := a.DstIP(); ok {
+		v.SetDstIP(ip)
+	}
+	if ok, protocol, port := a.SrcPort(); ok {
+		v.SetSrcPort(protocol, port)
+	}
+	if ok, protocol, port := a.DstPort(); ok {
+		v.SetDstPort(protocol, port)
+	}
+	if a.DecTTL() {
+		v.SetDecTTL()
+	}
+	for _, m := range a.Mirrors() {
+		v.AddMirror(m)
+	}
+
+	return v, v.Error()
+}
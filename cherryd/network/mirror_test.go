@@ -0,0 +1,83 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/openflow/of13"
+)
+
+func TestPortMirrorMatchHost(t *testing.T) {
+	host, _ := net.ParseMAC("00:11:22:33:44:55")
+	other, _ := net.ParseMAC("00:11:22:33:44:66")
+
+	all := PortMirror{Source: 1, Output: 2}
+	if !all.matchHost(host) || !all.matchHost(other) {
+		t.Fatal("Mirror without a host should match all hosts")
+	}
+	restricted := PortMirror{Source: 1, MAC: host, Output: 2}
+	if !restricted.matchHost(host) {
+		t.Fatal("Mirror should match its host")
+	}
+	if restricted.matchHost(other) {
+		t.Fatal("Mirror should not match other hosts")
+	}
+}
+
+func TestCopyActionMirrors(t *testing.T) {
+	f := of13.NewFactory()
+	action, err := f.NewAction()
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := openflow.NewOutPort()
+	out.SetValue(3)
+	action.SetOutPort(out)
+	action.AddMirror(PortMirror{Source: 3, Output: 4, VLANID: 100}.mirror())
+	action.AddMirror(PortMirror{Source: 3, Output: 5}.mirror())
+
+	v, err := copyAction(f, action)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if port := v.OutPort(); port.Value() != 3 {
+		t.Fatalf("Unexpected output port: expected=3, got=%v", port.Value())
+	}
+	mirrors := v.Mirrors()
+	if len(mirrors) != 2 {
+		t.Fatalf("Unexpected number of mirrors: expected=2, got=%v", len(mirrors))
+	}
+	// Untagged mirrors should come first so that the tag does not affect them
+	first, second := mirrors[0].Port, mirrors[1].Port
+	if first.Value() != 5 || mirrors[0].VLANID != 0 {
+		t.Fatalf("Unexpected first mirror: %+v", mirrors[0])
+	}
+	if second.Value() != 4 || mirrors[1].VLANID != 100 {
+		t.Fatalf("Unexpected second mirror: %+v", mirrors[1])
+	}
+	if _, err := v.MarshalBinary(); err != nil {
+		t.Fatalf("Failed to marshal the copied action: %v", err)
+	}
+}
@@ -54,18 +54,6 @@ func (r *of10Session) OnHello(f openflow.Factory, w trans.Writer, v openflow.Hel
 	if err := sendBarrierRequest(f, w); err != nil {
 		return fmt.Errorf("failed to send BARRIER_REQUEST: %v", err)
 	}
-	if err := sendRemovingAllFlows(f, w); err != nil {
-		return fmt.Errorf("failed to send FLOW_MOD to remove all flows: %v", err)
-	}
-	if err := sendDescriptionRequest(f, w); err != nil {
-		return fmt.Errorf("failed to send DESCRIPTION_REQUEST: %v", err)
-	}
-	if err := sendBarrierRequest(f, w); err != nil {
-		return fmt.Errorf("failed to send BARRIER_REQUEST: %v", err)
-	}
-	if err := setARPSender(f, w); err != nil {
-		return fmt.Errorf("failed to set ARP sender flow: %v", err)
-	}
 
 	return nil
 }
@@ -74,7 +62,12 @@ func (r *of10Session) OnError(f openflow.Factory, w trans.Writer, v openflow.Err
 	return nil
 }
 
+// OnFeaturesReply is called after the session removes all flows if it is necessary
 func (r *of10Session) OnFeaturesReply(f openflow.Factory, w trans.Writer, v openflow.FeaturesReply) error {
+	if err := sendDescriptionRequest(f, w); err != nil {
+		return fmt.Errorf("failed to send DESCRIPTION_REQUEST: %v", err)
+	}
+
 	ports := v.Ports()
 	for _, p := range ports {
 		if p.Number() > of10.OFPP_MAX {
@@ -109,6 +102,10 @@ func (r *of10Session) OnPortDescReply(f openflow.Factory, w trans.Writer, v open
 	return nil
 }
 
+func (r *of10Session) OnFlowStatsReply(f openflow.Factory, w trans.Writer, v openflow.FlowStatsReply) error {
+	return nil
+}
+
 func (r *of10Session) OnPortStatus(f openflow.Factory, w trans.Writer, v openflow.PortStatus) error {
 	return nil
 }
@@ -49,22 +49,28 @@ func (r *of13Session) OnHello(f openflow.Factory, w trans.Writer, v openflow.Hel
 	if err := sendSetConfig(f, w); err != nil {
 		return fmt.Errorf("failed to send SET_CONFIG: %v", err)
 	}
+	// The packets whose TTL expires on the decrement TTL action of the Router flows are answered by the controller
+	// with ICMP time exceeded
+	if err := sendSetAsync(f, w); err != nil {
+		return fmt.Errorf("failed to send SET_ASYNC: %v", err)
+	}
 	if err := sendFeaturesRequest(f, w); err != nil {
 		return fmt.Errorf("failed to send FEATURE_REQUEST: %v", err)
 	}
 	if err := sendBarrierRequest(f, w); err != nil {
 		return fmt.Errorf("failed to send BARRIER_REQUEST: %v", err)
 	}
-	if err := sendRemovingAllFlows(f, w); err != nil {
-		return fmt.Errorf("failed to send FLOW_MOD to remove all flows: %v", err)
-	}
-	// Make sure that the installed flows are removed before setTableMiss() is called
-	if err := sendBarrierRequest(f, w); err != nil {
-		return fmt.Errorf("failed to send BARRIER_REQUEST: %v", err)
-	}
-	if err := setARPSender(f, w); err != nil {
-		return fmt.Errorf("failed to set ARP sender flow: %v", err)
-	}
+
+	return nil
+}
+
+func (r *of13Session) OnError(f openflow.Factory, w trans.Writer, v openflow.Error) error {
+	return nil
+}
+
+// OnFeaturesReply is called after the session removes all flows if it is necessary
+func (r *of13Session) OnFeaturesReply(f openflow.Factory, w trans.Writer, v openflow.FeaturesReply) error {
+	// Note that the installed flows should be removed before setTableMiss() is called on DESCRIPTION_REPLY
 	if err := sendDescriptionRequest(f, w); err != nil {
 		return fmt.Errorf("failed to send DESCRIPTION_REQUEST: %v", err)
 	}
@@ -79,14 +85,6 @@ func (r *of13Session) OnHello(f openflow.Factory, w trans.Writer, v openflow.Hel
 	return nil
 }
 
-func (r *of13Session) OnError(f openflow.Factory, w trans.Writer, v openflow.Error) error {
-	return nil
-}
-
-func (r *of13Session) OnFeaturesReply(f openflow.Factory, w trans.Writer, v openflow.FeaturesReply) error {
-	return nil
-}
-
 func (r *of13Session) OnGetConfigReply(f openflow.Factory, w trans.Writer, v openflow.GetConfigReply) error {
 	return nil
 }
@@ -234,6 +232,10 @@ func (r *of13Session) OnPortDescReply(f openflow.Factory, w trans.Writer, v open
 	return nil
 }
 
+func (r *of13Session) OnFlowStatsReply(f openflow.Factory, w trans.Writer, v openflow.FlowStatsReply) error {
+	return nil
+}
+
 func (r *of13Session) OnPortStatus(f openflow.Factory, w trans.Writer, v openflow.PortStatus) error {
 	return nil
 }
@@ -243,5 +245,10 @@ func (r *of13Session) OnFlowRemoved(f openflow.Factory, w trans.Writer, v openfl
 }
 
 func (r *of13Session) OnPacketIn(f openflow.Factory, w trans.Writer, v openflow.PacketIn) error {
+	// The packet is passed to the northbound applications, and the Router answers it with ICMP time exceeded
+	if v.Reason() == of13.OFPR_INVALID_TTL {
+		r.log.Debug(fmt.Sprintf("OF13Session: PACKET_IN whose TTL is invalid is received from %v:%v", r.device.ID(), v.InPort()))
+	}
+
 	return nil
 }
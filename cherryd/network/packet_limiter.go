@@ -0,0 +1,243 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+)
+
+// tokenBucket allows rate events per second on average, and up to burst events at once.
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:   rate,
+		burst:  burst,
+		tokens: burst,
+		last:   now,
+	}
+}
+
+// take consumes a token if there is one at now.
+func (r *tokenBucket) take(now time.Time) bool {
+	if elapsed := now.Sub(r.last).Seconds(); elapsed > 0 {
+		r.tokens += elapsed * r.rate
+		if r.tokens > r.burst {
+			r.tokens = r.burst
+		}
+		r.last = now
+	}
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+
+	return true
+}
+
+type limitResult int
+
+func (r limitResult) String() string {
+	switch r {
+	case limitPass:
+		return "pass"
+	case limitPort:
+		return "port"
+	case limitDevice:
+		return "device"
+	default:
+		return "unknown"
+	}
+}
+
+const (
+	limitPass limitResult = iota
+	// Denied by the limiter of the ingress port
+	limitPort
+	// Denied by the limiter of the device
+	limitDevice
+)
+
+const (
+	defaultDropFlowTimeout = 10 * time.Second
+	dropFlowPriority       = 0xF000
+	// Name of the flow owner for the drop flows
+	dropFlowOwner = "PacketLimiter"
+)
+
+type packetLimiterConfig struct {
+	// PACKET_INs per second. Zero disables the limiter.
+	portRate    float64
+	portBurst   float64
+	deviceRate  float64
+	deviceBurst float64
+	// Install a temporary flow that drops the packets from the offending host?
+	dropFlow        bool
+	dropFlowTimeout time.Duration
+}
+
+// packetLimiter limits the rate of PACKET_INs per ingress port and per device
+// so that a single host cannot flood the controller.
+type packetLimiter struct {
+	mutex sync.Mutex
+	log   log.Logger
+	conf  packetLimiterConfig
+	// Key is the port ID
+	ports map[string]*tokenBucket
+	// Key is the device ID
+	devices map[string]*tokenBucket
+	// Number of PACKET_INs dropped by the port limiters whose key is the port ID
+	portDrops map[string]uint64
+	// Number of PACKET_INs dropped by the device limiters whose key is the device ID
+	deviceDrops map[string]uint64
+	// Expiration time of the drop flows whose key is the port ID and the source MAC address
+	blocked map[string]time.Time
+}
+
+func newPacketLimiter(log log.Logger, conf packetLimiterConfig) *packetLimiter {
+	return &packetLimiter{
+		log:         log,
+		conf:        conf,
+		ports:       make(map[string]*tokenBucket),
+		devices:     make(map[string]*tokenBucket),
+		portDrops:   make(map[string]uint64),
+		deviceDrops: make(map[string]uint64),
+		blocked:     make(map[string]time.Time),
+	}
+}
+
+func (r *packetLimiter) enabled() bool {
+	return r.conf.portRate > 0 || r.conf.deviceRate > 0
+}
+
+// allow returns whether a PACKET_IN received from the port portID of the device deviceID at now is allowed.
+func (r *packetLimiter) allow(deviceID, portID string, now time.Time) limitResult {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.conf.portRate > 0 {
+		b, ok := r.ports[portID]
+		if !ok {
+			b = newTokenBucket(r.conf.portRate, r.conf.portBurst, now)
+			r.ports[portID] = b
+		}
+		if !b.take(now) {
+			r.portDrops[portID]++
+			return limitPort
+		}
+	}
+	if r.conf.deviceRate > 0 {
+		b, ok := r.devices[deviceID]
+		if !ok {
+			b = newTokenBucket(r.conf.deviceRate, r.conf.deviceBurst, now)
+			r.devices[deviceID] = b
+		}
+		if !b.take(now) {
+			r.deviceDrops[deviceID]++
+			return limitDevice
+		}
+	}
+
+	return limitPass
+}
+
+// block returns whether we should install a drop flow for the host whose MAC address is mac on the port portID.
+// It returns false if the drop flow is disabled or is already installed.
+func (r *packetLimiter) block(portID string, mac []byte, now time.Time) bool {
+	if !r.conf.dropFlow {
+		return false
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	key := fmt.Sprintf("%v/%x", portID, mac)
+	if expire, ok := r.blocked[key]; ok && now.Before(expire) {
+		return false
+	}
+	r.blocked[key] = now.Add(r.conf.dropFlowTimeout)
+
+	// Clean up the expired entries
+	for k, v := range r.blocked {
+		if !now.Before(v) {
+			delete(r.blocked, k)
+		}
+	}
+
+	return true
+}
+
+// removeDevice removes the limiters of the device whose ID is id.
+func (r *packetLimiter) removeDevice(id string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.devices, id)
+	prefix := id + ":"
+	for k := range r.ports {
+		if strings.HasPrefix(k, prefix) {
+			delete(r.ports, k)
+		}
+	}
+}
+
+func (r *packetLimiter) String() string {
+	if !r.enabled() {
+		return "PACKET_IN limiter disabled\n"
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("PACKET_IN limiter PortRate=%v, DeviceRate=%v, DropFlow=%v\n", r.conf.portRate, r.conf.deviceRate, r.conf.dropFlow))
+	for _, k := range sortedKeys(r.deviceDrops) {
+		buf.WriteString(fmt.Sprintf("\tDevice=%v, Dropped=%v\n", k, r.deviceDrops[k]))
+	}
+	for _, k := range sortedKeys(r.portDrops) {
+		buf.WriteString(fmt.Sprintf("\tPort=%v, Dropped=%v\n", k, r.portDrops[k]))
+	}
+
+	return buf.String()
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	v := make([]string, 0, len(m))
+	for k := range m {
+		v = append(v, k)
+	}
+	sort.Strings(v)
+
+	return v
+}
@@ -0,0 +1,92 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Now()
+	b := newTokenBucket(10, 2, now)
+	if !b.take(now) || !b.take(now) {
+		t.Fatal("expected the burst to be allowed")
+	}
+	if b.take(now) {
+		t.Fatal("expected the empty bucket to deny")
+	}
+	// 10 tokens per second
+	if !b.take(now.Add(100 * time.Millisecond)) {
+		t.Fatal("expected a refilled token to be allowed")
+	}
+	// Tokens do not exceed the burst size
+	later := now.Add(10 * time.Second)
+	if !b.take(later) || !b.take(later) || b.take(later) {
+		t.Fatal("expected the tokens to be capped at the burst size")
+	}
+}
+
+func TestPacketLimiter(t *testing.T) {
+	l := newPacketLimiter(&nullLogger{}, packetLimiterConfig{
+		portRate:    1,
+		portBurst:   1,
+		deviceRate:  1,
+		deviceBurst: 2,
+	})
+	now := time.Now()
+	if v := l.allow("1", "1:1", now); v != limitPass {
+		t.Fatalf("expected pass, got %v", v)
+	}
+	if v := l.allow("1", "1:1", now); v != limitPort {
+		t.Fatalf("expected the port limiter to deny, got %v", v)
+	}
+	if v := l.allow("1", "1:2", now); v != limitPass {
+		t.Fatalf("expected pass on another port, got %v", v)
+	}
+	if v := l.allow("1", "1:3", now); v != limitDevice {
+		t.Fatalf("expected the device limiter to deny, got %v", v)
+	}
+	if l.portDrops["1:1"] != 1 || l.deviceDrops["1"] != 1 {
+		t.Fatalf("unexpected drop counters: port=%v, device=%v", l.portDrops, l.deviceDrops)
+	}
+}
+
+func TestPacketLimiterBlock(t *testing.T) {
+	l := newPacketLimiter(&nullLogger{}, packetLimiterConfig{
+		portRate:        1,
+		portBurst:       1,
+		dropFlow:        true,
+		dropFlowTimeout: 10 * time.Second,
+	})
+	now := time.Now()
+	mac := []byte{0, 1, 2, 3, 4, 5}
+	if !l.block("1:1", mac, now) {
+		t.Fatal("expected the first block to install a drop flow")
+	}
+	if l.block("1:1", mac, now.Add(5*time.Second)) {
+		t.Fatal("expected the drop flow not to be installed twice")
+	}
+	if !l.block("1:1", mac, now.Add(10*time.Second)) {
+		t.Fatal("expected a new drop flow after the timeout")
+	}
+}
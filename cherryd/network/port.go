@@ -22,6 +22,7 @@
 package network
 
 import (
+	"errors"
 	"fmt"
 	"github.com/superkkt/cherry/cherryd/graph"
 	"github.com/superkkt/cherry/cherryd/openflow"
@@ -93,3 +94,42 @@ func (r *Port) duration() time.Duration {
 
 	return time.Now().Sub(r.timestamp)
 }
+
+// isActivated returns whether this port is in active state. We assume that a port is in inactive state
+// during specified time after setting its value to avoid broadcast storm.
+func (r *Port) isActivated() bool {
+	return r.duration().Seconds() > 1.5
+}
+
+// Config returns the current configuration flags of this port.
+func (r *Port) Config() openflow.PortConfig {
+	value := r.Value()
+	if value == nil {
+		return openflow.PortConfig{}
+	}
+
+	return openflow.PortConfig{
+		PortDown:   value.IsPortDown(),
+		NoFlood:    value.IsNoFlood(),
+		NoPacketIn: value.IsNoPacketIn(),
+	}
+}
+
+// SetConfig changes the configuration flags of this port by sending a PORT_MOD message.
+func (r *Port) SetConfig(c openflow.PortConfig) error {
+	value := r.Value()
+	if value == nil {
+		return errors.New("unknown port status")
+	}
+
+	f := r.device.Factory()
+	msg, err := f.NewPortMod()
+	if err != nil {
+		return err
+	}
+	msg.SetPortNumber(r.number)
+	msg.SetHWAddr(value.MAC())
+	msg.SetConfig(c)
+
+	return r.device.SendMessage(msg)
+}
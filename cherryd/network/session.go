@@ -24,6 +24,7 @@ package network
 import (
 	"bytes"
 	"encoding"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/superkkt/cherry/cherryd/log"
@@ -36,6 +37,7 @@ import (
 	"io"
 	"net"
 	"strconv"
+	"time"
 )
 
 var (
@@ -51,14 +53,25 @@ type session struct {
 	watcher    watcher
 	finder     Finder
 	listener   ControllerEventListener
+	admission  *admission
+	grace      *gracePeriod
+	cluster    *cluster
+	limiter    *packetLimiter
+	health     *vipHealthChecker
+	version    uint8
 }
 
 type sessionConfig struct {
-	conn     net.Conn
-	logger   log.Logger
-	watcher  watcher
-	finder   Finder
-	listener ControllerEventListener
+	conn      net.Conn
+	logger    log.Logger
+	watcher   watcher
+	finder    Finder
+	listener  ControllerEventListener
+	admission *admission
+	grace     *gracePeriod
+	cluster   *cluster
+	limiter   *packetLimiter
+	health    *vipHealthChecker
 }
 
 func checkParam(c sessionConfig) {
@@ -77,6 +90,21 @@ func checkParam(c sessionConfig) {
 	if c.listener == nil {
 		panic("Listener is nil")
 	}
+	if c.admission == nil {
+		panic("Admission is nil")
+	}
+	if c.grace == nil {
+		panic("GracePeriod is nil")
+	}
+	if c.cluster == nil {
+		panic("Cluster is nil")
+	}
+	if c.limiter == nil {
+		panic("PacketLimiter is nil")
+	}
+	if c.health == nil {
+		panic("VIPHealthChecker is nil")
+	}
 }
 
 func newSession(c sessionConfig) *session {
@@ -88,6 +116,11 @@ func newSession(c sessionConfig) *session {
 	v.watcher = c.watcher
 	v.finder = c.finder
 	v.listener = c.listener
+	v.admission = c.admission
+	v.grace = c.grace
+	v.cluster = c.cluster
+	v.limiter = c.limiter
+	v.health = c.health
 	v.device = newDevice(c.logger, v)
 	v.trans = trans.NewTransceiver(stream, v)
 
@@ -102,21 +135,46 @@ func (r *session) OnHello(f openflow.Factory, w trans.Writer, v openflow.Hello)
 		return nil
 	}
 
-	switch v.Version() {
-	case openflow.OF10_VERSION:
-		r.handler = newOF10Session(r.log, r.device)
-	case openflow.OF13_VERSION:
-		r.handler = newOF13Session(r.log, r.device)
-	default:
-		return fmt.Errorf("unsupported OpenFlow version: %v", v.Version())
+	handler, err := newVersionHandler(v.Version(), r.log, r.device)
+	if err != nil {
+		return err
 	}
+	r.handler = handler
+	r.version = v.Version()
 	r.device.setFactory(f)
 	r.negotiated = true
 
 	return r.handler.OnHello(f, w, v)
 }
 
+func newVersionHandler(version uint8, log log.Logger, d *Device) (trans.Handler, error) {
+	switch version {
+	case openflow.OF10_VERSION:
+		return newOF10Session(log, d), nil
+	case openflow.OF13_VERSION:
+		return newOF13Session(log, d), nil
+	default:
+		return nil, fmt.Errorf("unsupported OpenFlow version: %v", version)
+	}
+}
+
+// resume re-binds d, which is retained in the grace period, to this session.
+func (r *session) resume(d *Device) error {
+	handler, err := newVersionHandler(r.version, r.log, d)
+	if err != nil {
+		return err
+	}
+	d.rebind(r, r.device.Factory())
+	r.device = d
+	r.handler = handler
+
+	return nil
+}
+
 func (r *session) OnError(f openflow.Factory, w trans.Writer, v openflow.Error) error {
+	// The flow is not installed if the error is the reply of FLOW_MOD
+	r.device.flowRejected(v.TransactionID())
+
 	// Is this the CHECK_OVERLAP error?
 	if v.Class() == 3 && v.Code() == 1 {
 		// Ignore this CHECK_OVERLAP error
@@ -140,16 +198,63 @@ func (r *session) OnFeaturesReply(f openflow.Factory, w trans.Writer, v openflow
 	}
 
 	dpid := strconv.FormatUint(v.DPID(), 10)
-	// Already connected device?
-	if r.finder.Device(dpid) != nil {
-		return errors.New("duplicated device DPID (aux. connection is not supported yet)")
+	decision := r.admission.decide(v.DPID())
+	if decision == policyReject {
+		return fmt.Errorf("rejected unregistered device (DPID=%v)", dpid)
+	}
+	resumed := false
+	// Reconnected within the grace period? Then, we reuse the previous device without the device up event.
+	if decision != policyQuarantine {
+		if d := r.grace.resume(dpid); d != nil {
+			if err := r.resume(d); err != nil {
+				return err
+			}
+			resumed = true
+		}
 	}
-	r.device.setID(dpid)
-	// We assume a device is up after setting its DPID
-	if err := r.listener.OnDeviceUp(r.finder, r.device); err != nil {
-		return err
+	if !resumed {
+		// Already connected device?
+		if r.finder.Device(dpid) != nil {
+			return errors.New("duplicated device DPID (aux. connection is not supported yet)")
+		}
+		r.device.setID(dpid)
+	}
+
+	if err := r.sendRole(f, w); err != nil {
+		return fmt.Errorf("failed to send ROLE_REQUEST: %v", err)
+	}
+	// Followers of the cluster should not touch the flows installed by the leader.
+	if r.cluster.isLeader() {
+		if resumed {
+			// Keep the installed flows of the resumed device to avoid re-learning storm, unless the device has
+			// lost them. Flow stats replies are received by this goroutine, so we cannot wait for them here.
+			go r.reconcileFlows()
+		} else {
+			// Remove the flows before the applications install their flows on the device up event
+			if err := sendRemovingAllFlows(f, w); err != nil {
+				return fmt.Errorf("failed to send FLOW_MOD to remove all flows: %v", err)
+			}
+			// Make sure that the installed flows are removed before we install new flows
+			if err := sendBarrierRequest(f, w); err != nil {
+				return fmt.Errorf("failed to send BARRIER_REQUEST: %v", err)
+			}
+			if err := setARPSender(f, w); err != nil {
+				return fmt.Errorf("failed to set ARP sender flow: %v", err)
+			}
+		}
+	}
+
+	switch {
+	case decision == policyQuarantine:
+		// Quarantined device is not added to the network topology
+		r.device.setQuarantined(true)
+	case !resumed:
+		// We assume a device is up after setting its DPID
+		if err := r.listener.OnDeviceUp(r.finder, r.device); err != nil {
+			return err
+		}
+		r.watcher.DeviceAdded(r.device)
 	}
-	r.watcher.DeviceAdded(r.device)
 
 	features := Features{
 		DPID:       v.DPID(),
@@ -158,7 +263,62 @@ func (r *session) OnFeaturesReply(f openflow.Factory, w trans.Writer, v openflow
 	}
 	r.device.setFeatures(features)
 
-	return r.handler.OnFeaturesReply(f, w, v)
+	if err := r.handler.OnFeaturesReply(f, w, v); err != nil {
+		return err
+	}
+	// OpenFlow 1.0 devices describe their ports in FEATURES_REPLY
+	if r.version == openflow.OF10_VERSION && !r.device.IsQuarantined() {
+		r.watcher.PortsDiscovered(r.device)
+	}
+
+	return nil
+}
+
+// reconcileFlows synchronizes the flow inventory of the resumed device with the flows on the device. If the device
+// has been restarted within the grace period, we remove all the flows and send the device up event again so that
+// the applications install their flows again.
+func (r *session) reconcileFlows() {
+	lost, err := r.device.reconcileFlows()
+	if err != nil {
+		r.log.Err(fmt.Sprintf("Session: failed to reconcile the flows of the resumed device %v: %v", r.device.ID(), err))
+		// We cannot trust the flow inventory
+		lost = true
+	}
+	if !lost {
+		// Always install the base flows again in case that the device has lost some of them
+		if err := setARPSender(r.device.Factory(), r); err != nil {
+			r.log.Err(fmt.Sprintf("Session: failed to set ARP sender flow on %v: %v", r.device.ID(), err))
+		}
+		return
+	}
+
+	r.log.Warning(fmt.Sprintf("Session: the resumed device %v has lost its flows, so reinstalling all the flows", r.device.ID()))
+	if err := r.device.RemoveAllFlows(); err != nil {
+		r.log.Err(fmt.Sprintf("Session: failed to remove all flows on %v: %v", r.device.ID(), err))
+		return
+	}
+	if err := r.listener.OnDeviceUp(r.finder, r.device); err != nil {
+		r.log.Err(fmt.Sprintf("Session: executing OnDeviceUp for %v: %v", r.device.ID(), err))
+	}
+}
+
+// sendRole sends ROLE_REQUEST if the cluster mode is enabled.
+func (r *session) sendRole(f openflow.Factory, w trans.Writer) error {
+	if !r.cluster.enabled {
+		return nil
+	}
+
+	role, generation := r.cluster.role()
+	msg, err := f.NewRoleRequest()
+	if err != nil {
+		// OpenFlow 1.0 does not have the controller roles, so we just ignore PACKET_IN on the followers.
+		r.log.Debug(fmt.Sprintf("Session: skip sending ROLE_REQUEST to %v: %v", r.device.ID(), err))
+		return nil
+	}
+	msg.SetRole(role)
+	msg.SetGenerationID(generation)
+
+	return w.Write(msg)
 }
 
 func (r *session) OnGetConfigReply(f openflow.Factory, w trans.Writer, v openflow.GetConfigReply) error {
@@ -192,6 +352,10 @@ func (r *session) OnDescReply(f openflow.Factory, w trans.Writer, v openflow.Des
 		Description:  v.Description(),
 	}
 	r.device.setDescriptions(desc)
+	// Version handlers install the table-miss flows on DESCRIPTION_REPLY, which is only allowed to the leader
+	if !r.cluster.isLeader() {
+		return nil
+	}
 
 	return r.handler.OnDescReply(f, w, v)
 }
@@ -203,7 +367,64 @@ func (r *session) OnPortDescReply(f openflow.Factory, w trans.Writer, v openflow
 		return errNotNegotiated
 	}
 
-	return r.handler.OnPortDescReply(f, w, v)
+	if err := r.handler.OnPortDescReply(f, w, v); err != nil {
+		return err
+	}
+	if !r.device.IsQuarantined() {
+		r.watcher.PortsDiscovered(r.device)
+	}
+
+	return nil
+}
+
+func (r *session) OnFlowStatsReply(f openflow.Factory, w trans.Writer, v openflow.FlowStatsReply) error {
+	r.log.Debug(fmt.Sprintf("Session: FLOW_STATS_REPLY is received (# of flows=%v, more=%v)", len(v.Flows()), v.More()))
+
+	if !r.negotiated {
+		return errNotNegotiated
+	}
+	r.device.flowStatsReplied(v)
+
+	return r.handler.OnFlowStatsReply(f, w, v)
+}
+
+const (
+	// Organizationally specific TLV type
+	lldpOrgSpecificTLV = 127
+	// Subtype of our organizationally specific TLV that carries the time when we sent the LLDP packet
+	lldpTimestampSubtype = 1
+	// Interval of the LLDP probes that keep sampling the latency of the links
+	lldpProbeInterval = 10 * time.Second
+)
+
+// Arbitrary OUI for the organizationally specific TLVs that only we use
+var lldpOUI = []byte{0x00, 0x00, 0x00}
+
+func newLLDPTimestamp(t time.Time) protocol.LLDPOption {
+	data := make([]byte, 12)
+	copy(data[0:3], lldpOUI)
+	data[3] = lldpTimestampSubtype
+	binary.BigEndian.PutUint64(data[4:12], uint64(t.UnixNano()))
+
+	return protocol.LLDPOption{
+		Type: lldpOrgSpecificTLV,
+		Data: data,
+	}
+}
+
+// getLLDPTimestamp returns the time when we sent p. ok is false if p does not have our timestamp TLV.
+func getLLDPTimestamp(p *protocol.LLDP) (t time.Time, ok bool) {
+	for _, o := range p.Options {
+		if o.Type != lldpOrgSpecificTLV || len(o.Data) != 12 {
+			continue
+		}
+		if !bytes.Equal(o.Data[0:3], lldpOUI) || o.Data[3] != lldpTimestampSubtype {
+			continue
+		}
+		return time.Unix(0, int64(binary.BigEndian.Uint64(o.Data[4:12]))), true
+	}
+
+	return time.Time{}, false
 }
 
 func newLLDPEtherFrame(deviceID string, port openflow.Port) ([]byte, error) {
@@ -216,7 +437,8 @@ func newLLDPEtherFrame(deviceID string, port openflow.Port) ([]byte, error) {
 			SubType: 5, // Interface Name
 			Data:    []byte(fmt.Sprintf("cherry/%v", port.Number())),
 		},
-		TTL: 120,
+		TTL:     120,
+		Options: []protocol.LLDPOption{newLLDPTimestamp(time.Now())},
 	}
 	payload, err := lldp.MarshalBinary()
 	if err != nil {
@@ -269,7 +491,7 @@ func sendLLDP(deviceID string, f openflow.Factory, w trans.Writer, p openflow.Po
 
 func (r *session) sendPortEvent(portNum uint32, up bool) {
 	port := r.device.Port(portNum)
-	if port == nil {
+	if port == nil || r.device.IsQuarantined() {
 		return
 	}
 
@@ -328,7 +550,7 @@ func (r *session) OnPortStatus(f openflow.Factory, w trans.Writer, v openflow.Po
 	} else {
 		// Send port removed event
 		p := r.device.Port(port.Number())
-		if p != nil {
+		if p != nil && !r.device.IsQuarantined() {
 			r.watcher.PortRemoved(p)
 		}
 	}
@@ -342,6 +564,7 @@ func (r *session) OnFlowRemoved(f openflow.Factory, w trans.Writer, v openflow.F
 	if !r.negotiated {
 		return errNotNegotiated
 	}
+	r.device.flowRemoved(v.Cookie())
 
 	return r.handler.OnFlowRemoved(f, w, v)
 }
@@ -424,20 +647,50 @@ func (r *session) handleLLDP(inPort *Port, ethernet *protocol.Ethernet) error {
 		r.log.Info("Session: ignoring a LLDP packet issued by an unknown device")
 		return nil
 	}
+	// Do not accept links to quarantined devices, and stop flooding into them instead
+	if r.admission.isQuarantined(deviceID) {
+		r.admission.isolate(inPort, deviceID)
+		return nil
+	}
 	port, err := r.findNeighborPort(deviceID, portNum)
+	if r.device.IsQuarantined() {
+		if err == nil {
+			r.admission.isolate(port, r.device.ID())
+		}
+		return nil
+	}
 	if err != nil {
 		// Do nothing if we cannot find neighbor device and its port
 		r.log.Warning(fmt.Sprintf("Session: ignoring a LLDP packet: %v", err))
 		return nil
 	}
 	r.watcher.DeviceLinked([2]*Port{inPort, port})
+	if latency, ok := r.linkLatency(lldp, port.Device()); ok {
+		r.watcher.LinkLatencyMeasured([2]*Port{inPort, port}, latency)
+	}
 
 	return nil
 }
 
+// linkLatency returns the one-way latency of the link on which lldp, which has been sent by neighbor, is received.
+func (r *session) linkLatency(lldp *protocol.LLDP, neighbor *Device) (latency time.Duration, ok bool) {
+	timestamp, ok := getLLDPTimestamp(lldp)
+	if !ok {
+		return 0, false
+	}
+	// LLDP goes through the control channel of the neighbor, the link, and then our control channel.
+	// Latency of a transceiver is a round-trip time, so we subtract the halves of them.
+	latency = time.Now().Sub(timestamp) - (neighbor.controlLatency()+r.device.controlLatency())/2
+	if latency < 0 {
+		// Latency of the link is less than the resolution of our measurement
+		latency = 0
+	}
+
+	return latency, true
+}
+
 func (r *session) isActivatedPort(p *Port) bool {
-	// We assume that a port is in inactive state during specified time after setting its value to avoid broadcast storm.
-	return p.duration().Seconds() > 1.5
+	return p.isActivated()
 }
 
 func (r *session) OnPacketIn(f openflow.Factory, w trans.Writer, v openflow.PacketIn) error {
@@ -459,6 +712,19 @@ func (r *session) OnPacketIn(f openflow.Factory, w trans.Writer, v openflow.Pack
 	if isLLDP(ethernet) {
 		return r.handleLLDP(inPort, ethernet)
 	}
+	// Quarantined device should not forward any packet
+	if r.device.IsQuarantined() {
+		r.log.Debug(fmt.Sprintf("Session: ignoring PACKET_IN from %v:%v because the device is quarantined", r.device.ID(), v.InPort()))
+		return nil
+	}
+	// Only the leader of the cluster answers PACKET_IN
+	if !r.cluster.isLeader() {
+		return nil
+	}
+	// Drop PACKET_IN before it reaches the northbound applications if the ingress port or the device sends too many
+	if !r.allowPacketIn(inPort, ethernet) {
+		return nil
+	}
 	// Do nothing if the ingress port is in inactive state
 	if !r.isActivatedPort(inPort) {
 		r.log.Debug(fmt.Sprintf("Session: ignoring PACKET_IN from %v:%v because the ingress port is not in active state yet", r.device.ID(), v.InPort()))
@@ -469,6 +735,14 @@ func (r *session) OnPacketIn(f openflow.Factory, w trans.Writer, v openflow.Pack
 		r.log.Debug(fmt.Sprintf("Session: ignoring PACKET_IN from %v:%v by STP", r.device.ID(), v.InPort()))
 		return nil
 	}
+	// Learn the host location from the traffic on a port facing hosts
+	if !r.finder.IsEdge(inPort) {
+		r.watcher.HostObserved(inPort, ethernet.SrcMAC, getSenderIP(ethernet))
+	}
+	// Replies of the VIP health check probes are not passed to the northbound applications
+	if r.health.handle(inPort, ethernet) {
+		return nil
+	}
 	// Call specific version handler
 	if err := r.handler.OnPacketIn(f, w, v); err != nil {
 		return err
@@ -477,22 +751,126 @@ func (r *session) OnPacketIn(f openflow.Factory, w trans.Writer, v openflow.Pack
 	return r.listener.OnPacketIn(r.finder, inPort, ethernet)
 }
 
+func (r *session) allowPacketIn(inPort *Port, ethernet *protocol.Ethernet) bool {
+	if !r.limiter.enabled() {
+		return true
+	}
+
+	now := time.Now()
+	result := r.limiter.allow(r.device.ID(), inPort.ID(), now)
+	if result == limitPass {
+		return true
+	}
+	r.log.Debug(fmt.Sprintf("Session: dropping PACKET_IN from %v (src=%v) by the rate limiter (reason=%v)", inPort.ID(), ethernet.SrcMAC, result))
+
+	// Drop flow only makes sense for a host directly connected to the port
+	if result != limitPort || r.finder.IsEdge(inPort) || !isUnicastMAC(ethernet.SrcMAC) {
+		return false
+	}
+	if !r.limiter.block(inPort.ID(), ethernet.SrcMAC, now) {
+		return false
+	}
+	r.log.Warning(fmt.Sprintf("Session: installing a drop flow for %v on %v for %v due to too many PACKET_INs", ethernet.SrcMAC, inPort.ID(), r.limiter.conf.dropFlowTimeout))
+	if err := r.installDropFlow(inPort, ethernet.SrcMAC, r.limiter.conf.dropFlowTimeout); err != nil {
+		r.log.Err(fmt.Sprintf("Session: failed to install a drop flow: %v", err))
+	}
+
+	return false
+}
+
+func (r *session) installDropFlow(inPort *Port, mac net.HardwareAddr, timeout time.Duration) error {
+	f := r.device.Factory()
+	match, err := f.NewMatch()
+	if err != nil {
+		return err
+	}
+	port := openflow.NewInPort()
+	port.SetValue(inPort.Number())
+	match.SetInPort(port)
+	match.SetSrcMAC(mac)
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	flow.SetTableID(r.device.FlowTableID())
+	flow.SetHardTimeout(uint16(timeout.Seconds()))
+	// Higher than the priorities of the flows installed by the northbound applications
+	flow.SetPriority(dropFlowPriority)
+	flow.SetFlowMatch(match)
+	// No instruction means dropping the matched packets
+
+	return r.device.InstallFlow(dropFlowOwner, flow)
+}
+
 func (r *session) Run(ctx context.Context) {
+	probeCtx, cancel := context.WithCancel(ctx)
+	go r.probeLinks(probeCtx)
+
 	if err := r.trans.Run(ctx); err != nil && err != io.EOF {
 		r.log.Err(fmt.Sprintf("Session: transceiver is closed: %v", err))
 	}
+	cancel()
 	r.trans.Close()
 	r.device.Close()
 	r.log.Debug(fmt.Sprintf("Session: disconnected device (DPID=%v)", r.device.ID()))
 
-	if r.device.isValid() {
-		if err := r.listener.OnDeviceDown(r.finder, r.device); err != nil {
-			r.log.Err(fmt.Sprintf("Session: executing OnDeviceDown: %v", err))
+	if !r.device.isValid() {
+		return
+	}
+	r.admission.disconnected(r.device.ID())
+	if r.device.IsQuarantined() {
+		return
+	}
+
+	if r.grace.enabled() {
+		// Send the device down event only if the device does not reconnect within the grace period
+		device := r.device
+		r.grace.hold(device, func() { r.removeDevice(device) })
+		return
+	}
+	r.removeDevice(r.device)
+}
+
+// probeLinks periodically sends LLDP on the active ports of the device. Otherwise, the latency of a link is
+// sampled only when its port or device comes up, and the latency changes never reach the spanning tree.
+func (r *session) probeLinks(ctx context.Context) {
+	ticker := time.NewTicker(lldpProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		// Followers do not maintain the network topology
+		if !r.device.isValid() || r.device.IsQuarantined() || !r.cluster.isLeader() {
+			continue
+		}
+		f := r.device.Factory()
+		for _, p := range r.device.Ports() {
+			v := p.Value()
+			if v == nil || v.IsPortDown() || v.IsLinkDown() {
+				continue
+			}
+			if err := sendLLDP(r.device.ID(), f, r, v); err != nil {
+				r.log.Err(fmt.Sprintf("Session: failed to send LLDP probe (DPID=%v, Port=%v): %v", r.device.ID(), v.Number(), err))
+				break
+			}
 		}
-		r.watcher.DeviceRemoved(r.device)
 	}
 }
 
+func (r *session) removeDevice(d *Device) {
+	if err := r.listener.OnDeviceDown(r.finder, d); err != nil {
+		r.log.Err(fmt.Sprintf("Session: executing OnDeviceDown: %v", err))
+	}
+	r.watcher.DeviceRemoved(d)
+	r.limiter.removeDevice(d.ID())
+}
+
 func (r *session) Write(msg encoding.BinaryMarshaler) error {
 	return r.trans.Write(msg)
 }
@@ -517,6 +895,16 @@ func sendSetConfig(f openflow.Factory, w trans.Writer) error {
 	return w.Write(msg)
 }
 
+func sendSetAsync(f openflow.Factory, w trans.Writer) error {
+	msg, err := f.NewSetAsync()
+	if err != nil {
+		return err
+	}
+	msg.SetInvalidTTLToController(true)
+
+	return w.Write(msg)
+}
+
 func sendFeaturesRequest(f openflow.Factory, w trans.Writer) error {
 	msg, err := f.NewFeaturesRequest()
 	if err != nil {
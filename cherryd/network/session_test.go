@@ -0,0 +1,162 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/openflow/fakeswitch"
+	"golang.org/x/net/context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// memoryDB is a database that does not know any host location and registered switch.
+type memoryDB struct {
+	database
+}
+
+func (r *memoryDB) Switch(dpid uint64) (Switch, bool, error) {
+	return Switch{}, false, nil
+}
+
+func (r *memoryDB) Location(mac net.HardwareAddr) (string, uint32, bool, error) {
+	return "", 0, false, nil
+}
+
+func (r *memoryDB) UpdateLocation(mac net.HardwareAddr, dpid uint64, port uint32) (bool, error) {
+	return false, nil
+}
+
+func (r *memoryDB) Snapshot() (TopologySnapshot, bool, error) {
+	return TopologySnapshot{}, false, nil
+}
+
+func (r *memoryDB) SaveSnapshot(s TopologySnapshot) error {
+	return nil
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout while waiting for %v", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// connect connects sw to the controller through an in-memory pipe, and then waits until the controller knows all its ports.
+func connect(t *testing.T, ctx context.Context, c *Controller, sw *fakeswitch.Switch, numPorts int) {
+	controllerSide, switchSide := net.Pipe()
+	c.AddConnection(ctx, controllerSide)
+	go sw.Serve(switchSide)
+
+	id := strconv.FormatUint(sw.DPID(), 10)
+	waitFor(t, "device "+id, func() bool {
+		d := c.topo.Device(id)
+		return d != nil && len(d.Ports()) == numPorts
+	})
+}
+
+func hasFlow(sw *fakeswitch.Switch, priority uint16) bool {
+	for _, f := range sw.Flows() {
+		if f.Priority == priority {
+			return true
+		}
+	}
+
+	return false
+}
+
+func TestControllerWithFakeSwitches(t *testing.T) {
+	const numSwitches = 6
+	const numPorts = 3
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c := NewController(new(nullLogger), new(memoryDB), goconf.NewConfigFile())
+
+	// Linear topology: port 2 of a switch is linked to port 1 of the next switch, and port 3 faces hosts.
+	switches := make([]*fakeswitch.Switch, numSwitches)
+	for i := range switches {
+		version := uint8(openflow.OF13_VERSION)
+		if i%2 == 0 {
+			version = openflow.OF10_VERSION
+		}
+		sw, err := fakeswitch.New(version, uint64(i+1), numPorts)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer sw.Close()
+		switches[i] = sw
+		if i > 0 {
+			if err := fakeswitch.Link(switches[i-1], 2, sw, 1); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+	}
+	for _, sw := range switches {
+		connect(t, ctx, c, sw, numPorts)
+	}
+
+	waitFor(t, "links", func() bool { return len(c.topo.Links()) == numSwitches-1 })
+	path := c.topo.Path("1", strconv.Itoa(numSwitches))
+	if len(path) != numSwitches-1 {
+		t.Fatalf("expected a path of %v hops, got %v", numSwitches-1, len(path))
+	}
+	for i, hop := range path {
+		if hop[0].Device().ID() != strconv.Itoa(i+1) || hop[0].Number() != 2 || hop[1].Number() != 1 {
+			t.Fatalf("unexpected hop: %v -> %v", hop[0].ID(), hop[1].ID())
+		}
+	}
+
+	for _, sw := range switches {
+		// ARP sender flow
+		waitFor(t, "ARP flow", func() bool { return hasFlow(sw, 100) })
+		// OpenFlow 1.3 switches also should have the table-miss flow
+		if sw.Version() == openflow.OF13_VERSION {
+			waitFor(t, "table-miss flow", func() bool { return hasFlow(sw, 0) })
+		}
+	}
+
+	// Link down on a port removes the link
+	if err := switches[2].SetPortStatus(2, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, "link removal", func() bool { return len(c.topo.Links()) == numSwitches-2 })
+	if path := c.topo.Path("1", strconv.Itoa(numSwitches)); len(path) != 0 {
+		t.Fatalf("expected no path, got %v hops", len(path))
+	}
+
+	// Link up again sends LLDP, which restores the link
+	if err := switches[2].SetPortStatus(2, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	waitFor(t, "link recovery", func() bool { return len(c.topo.Links()) == numSwitches-1 })
+
+	// Disconnected switch is removed from the topology
+	switches[numSwitches-1].Close()
+	waitFor(t, "device removal", func() bool { return len(c.topo.Devices()) == numSwitches-1 })
+}
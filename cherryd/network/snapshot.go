@@ -0,0 +1,234 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+)
+
+const (
+	defaultSnapshotInterval = 5 * time.Minute
+	// Switches should be reconnected and their links should be discovered during this period after startup
+	defaultSnapshotSettle = 1 * time.Minute
+	// Period to check whether the topology has been changed since the last snapshot
+	snapshotCheckInterval = 5 * time.Second
+)
+
+// SnapshotLink is a link between two switch ports in a topology snapshot.
+type SnapshotLink [2]StreamPort
+
+func newSnapshotLink(l *link) SnapshotLink {
+	return SnapshotLink{*newStreamPort(l.ports[0]), *newStreamPort(l.ports[1])}
+}
+
+// ID returns the link ID that does not depend on the order of two ports.
+func (r SnapshotLink) ID() string {
+	s := []string{fmt.Sprintf("%v:%v", r[0].DPID, r[0].Port), fmt.Sprintf("%v:%v", r[1].DPID, r[1].Port)}
+	sort.Strings(s)
+
+	return fmt.Sprintf("%v/%v", s[0], s[1])
+}
+
+type TopologySnapshot struct {
+	Devices   []string       `json:"devices"`
+	Links     []SnapshotLink `json:"links"`
+	Timestamp time.Time      `json:"timestamp"`
+}
+
+// equal returns whether r and s have same devices and links regardless of their timestamps.
+func (r TopologySnapshot) equal(s TopologySnapshot) bool {
+	diff := diffSnapshot(r, s)
+	return len(diff.MissingDevices) == 0 && len(diff.NewDevices) == 0 && len(diff.MissingLinks) == 0 && len(diff.NewLinks) == 0
+}
+
+type TopologyDiff struct {
+	MissingDevices []string       `json:"missing_devices"`
+	NewDevices     []string       `json:"new_devices"`
+	MissingLinks   []SnapshotLink `json:"missing_links"`
+	NewLinks       []SnapshotLink `json:"new_links"`
+	// Time when the previous snapshot was taken
+	Snapshot  time.Time `json:"snapshot"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+func (r TopologyDiff) String() string {
+	return fmt.Sprintf("MissingDevices=%v, NewDevices=%v, MissingLinks=%v, NewLinks=%v, Snapshot=%v", r.MissingDevices, r.NewDevices, r.MissingLinks, r.NewLinks, r.Snapshot)
+}
+
+// diffSnapshot compares the current topology cur with the previous one prev.
+func diffSnapshot(prev, cur TopologySnapshot) TopologyDiff {
+	v := TopologyDiff{
+		MissingDevices: make([]string, 0),
+		NewDevices:     make([]string, 0),
+		MissingLinks:   make([]SnapshotLink, 0),
+		NewLinks:       make([]SnapshotLink, 0),
+		Snapshot:       prev.Timestamp,
+		Timestamp:      cur.Timestamp,
+	}
+
+	prevDevices := make(map[string]bool)
+	for _, d := range prev.Devices {
+		prevDevices[d] = true
+	}
+	curDevices := make(map[string]bool)
+	for _, d := range cur.Devices {
+		curDevices[d] = true
+		if !prevDevices[d] {
+			v.NewDevices = append(v.NewDevices, d)
+		}
+	}
+	for _, d := range prev.Devices {
+		if !curDevices[d] {
+			v.MissingDevices = append(v.MissingDevices, d)
+		}
+	}
+
+	prevLinks := make(map[string]bool)
+	for _, l := range prev.Links {
+		prevLinks[l.ID()] = true
+	}
+	curLinks := make(map[string]bool)
+	for _, l := range cur.Links {
+		curLinks[l.ID()] = true
+		if !prevLinks[l.ID()] {
+			v.NewLinks = append(v.NewLinks, l)
+		}
+	}
+	for _, l := range prev.Links {
+		if !curLinks[l.ID()] {
+			v.MissingLinks = append(v.MissingLinks, l)
+		}
+	}
+
+	return v
+}
+
+// snapshotter persists the topology to the database, and compares the live topology with
+// the last snapshot taken before startup.
+type snapshotter struct {
+	mutex    sync.RWMutex
+	log      log.Logger
+	db       database
+	topo     *topology
+	cluster  *cluster
+	interval time.Duration
+	settle   time.Duration
+	saved    *TopologySnapshot
+	diff     *TopologyDiff
+	// report is called when the diff has been calculated after startup
+	report func(TopologyDiff)
+}
+
+func newSnapshotter(log log.Logger, db database, topo *topology, cluster *cluster, c *topologyConfig, report func(TopologyDiff)) *snapshotter {
+	if report == nil {
+		panic("nil report callback")
+	}
+
+	return &snapshotter{
+		log:      log,
+		db:       db,
+		topo:     topo,
+		cluster:  cluster,
+		interval: c.snapshotInterval,
+		settle:   c.snapshotSettle,
+		report:   report,
+	}
+}
+
+func (r *snapshotter) run() {
+	prev, ok, err := r.db.Snapshot()
+	if err != nil {
+		// We still take new snapshots, but cannot compare the topology with the previous one
+		r.log.Err(fmt.Sprintf("Snapshot: failed to load the last topology snapshot: %v", err))
+	}
+
+	time.Sleep(r.settle)
+	if err == nil && ok {
+		r.compare(prev)
+	}
+
+	ticker := time.NewTicker(snapshotCheckInterval)
+	defer ticker.Stop()
+	for {
+		if err := r.save(); err != nil {
+			r.log.Err(fmt.Sprintf("Snapshot: failed to save the topology snapshot: %v", err))
+		}
+		<-ticker.C
+	}
+}
+
+func (r *snapshotter) compare(prev TopologySnapshot) {
+	// Followers do not maintain the network topology
+	if !r.cluster.isLeader() {
+		r.log.Info("Snapshot: skip comparing the topology with the last snapshot on a follower")
+		return
+	}
+
+	diff := diffSnapshot(prev, r.topo.snapshot())
+	func() {
+		// Write lock
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		r.diff = &diff
+	}()
+
+	if len(diff.MissingDevices) > 0 || len(diff.MissingLinks) > 0 {
+		r.log.Warning(fmt.Sprintf("Snapshot: topology differs from the last snapshot: %v", diff))
+	} else {
+		r.log.Info(fmt.Sprintf("Snapshot: compared the topology with the last snapshot: %v", diff))
+	}
+	r.report(diff)
+}
+
+// save stores the current topology if it has been changed or the snapshot interval has been elapsed.
+func (r *snapshotter) save() error {
+	if !r.cluster.isLeader() {
+		return nil
+	}
+
+	cur := r.topo.snapshot()
+	if r.saved != nil && r.saved.equal(cur) && cur.Timestamp.Sub(r.saved.Timestamp) < r.interval {
+		return nil
+	}
+	if err := r.db.SaveSnapshot(cur); err != nil {
+		return err
+	}
+	r.saved = &cur
+	r.log.Debug(fmt.Sprintf("Snapshot: saved the topology snapshot (devices=%v, links=%v)", len(cur.Devices), len(cur.Links)))
+
+	return nil
+}
+
+// Diff returns nil if the topology has not been compared with the last snapshot yet.
+func (r *snapshotter) Diff() *TopologyDiff {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return r.diff
+}
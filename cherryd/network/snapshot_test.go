@@ -0,0 +1,61 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"testing"
+)
+
+func TestDiffSnapshot(t *testing.T) {
+	prev := TopologySnapshot{
+		Devices: []string{"1", "2", "3"},
+		Links: []SnapshotLink{
+			{{DPID: "1", Port: 1}, {DPID: "2", Port: 1}},
+			{{DPID: "2", Port: 2}, {DPID: "3", Port: 1}},
+		},
+	}
+	cur := TopologySnapshot{
+		Devices: []string{"1", "2", "4"},
+		Links: []SnapshotLink{
+			// Same link in the reversed order
+			{{DPID: "2", Port: 1}, {DPID: "1", Port: 1}},
+			{{DPID: "2", Port: 3}, {DPID: "4", Port: 1}},
+		},
+	}
+
+	diff := diffSnapshot(prev, cur)
+	if len(diff.MissingDevices) != 1 || diff.MissingDevices[0] != "3" {
+		t.Fatalf("unexpected missing devices: %v", diff.MissingDevices)
+	}
+	if len(diff.NewDevices) != 1 || diff.NewDevices[0] != "4" {
+		t.Fatalf("unexpected new devices: %v", diff.NewDevices)
+	}
+	if len(diff.MissingLinks) != 1 || diff.MissingLinks[0].ID() != "2:2/3:1" {
+		t.Fatalf("unexpected missing links: %v", diff.MissingLinks)
+	}
+	if len(diff.NewLinks) != 1 || diff.NewLinks[0].ID() != "2:3/4:1" {
+		t.Fatalf("unexpected new links: %v", diff.NewLinks)
+	}
+	if prev.equal(cur) || !prev.equal(prev) {
+		t.Fatal("unexpected snapshot equality")
+	}
+}
@@ -27,14 +27,21 @@ import (
 	"github.com/superkkt/cherry/cherryd/graph"
 	"github.com/superkkt/cherry/cherryd/log"
 	"net"
+	"sort"
+	"strconv"
 	"sync"
+	"time"
 )
 
 type watcher interface {
 	DeviceAdded(*Device)
 	DeviceLinked([2]*Port)
+	LinkLatencyMeasured(ports [2]*Port, latency time.Duration)
 	DeviceRemoved(*Device)
 	PortRemoved(*Port)
+	// PortsDiscovered is called when the ports of a connected device have been discovered.
+	PortsDiscovered(*Device)
+	HostObserved(p *Port, mac net.HardwareAddr, ip net.IP)
 }
 
 type Finder interface {
@@ -48,6 +55,12 @@ type Finder interface {
 	Path(srcDeviceID, dstDeviceID string) [][2]*Port
 }
 
+// VIPNotifier is implemented by the Finder that publishes the VIP failovers done by the applications to the event
+// stream.
+type VIPNotifier interface {
+	VIPToggled(id uint64, ip net.IP, mac net.HardwareAddr)
+}
+
 type topology struct {
 	mutex sync.RWMutex
 	// Key is the device ID
@@ -56,15 +69,51 @@ type topology struct {
 	graph    *graph.Graph
 	listener TopologyEventListener
 	db       database
+	hosts    *hostTracker
+	hostConf hostConfig
+	stream   *eventStream
+	// Use the link latency as the link weight of the spanning tree?
+	latencyWeight bool
+	// flap and blocker are nil if the MAC flapping detection is disabled
+	flap    *flapDetector
+	blocker *portBlocker
+	// Observed hosts whose locations should be compared with the previous ones, which are handled by
+	// handleObservations so that the switch sessions are not blocked by the database and the flow removals.
+	observations chan observation
 }
 
-func newTopology(log log.Logger, db database) *topology {
-	return &topology{
-		devices: make(map[string]*Device),
-		log:     log,
-		graph:   graph.New(),
-		db:      db,
+// observation is a host observed at a new location.
+type observation struct {
+	port *Port
+	mac  net.HardwareAddr
+	// prev is the previous learned location. It is nil if the host has not been learned.
+	prev  *Port
+	found bool
+}
+
+// Number of the observed hosts that can wait for handleObservations. HostObserved blocks if the queue is full.
+const observationQueueSize = 1024
+
+func newTopology(log log.Logger, db database, c *hostConfig, stream *eventStream, latencyWeight bool, flap *flapConfig) *topology {
+	v := &topology{
+		devices:       make(map[string]*Device),
+		log:           log,
+		graph:         graph.New(),
+		db:            db,
+		hosts:         newHostTracker(c.agingTime),
+		hostConf:      *c,
+		stream:        stream,
+		latencyWeight: latencyWeight,
+		observations:  make(chan observation, observationQueueSize),
+	}
+	if flap.enable {
+		v.flap = newFlapDetector(flap.window, flap.threshold)
+		v.blocker = newPortBlocker(log, v, flap.action, flap.holdTime)
 	}
+
+	go v.handleObservations()
+
+	return v
 }
 
 func (r *topology) String() string {
@@ -77,6 +126,7 @@ func (r *topology) String() string {
 		buf.WriteString(fmt.Sprintf("%v\n", v))
 	}
 	buf.WriteString(fmt.Sprintf("%v\n", r.graph))
+	buf.WriteString(r.hosts.String())
 
 	return buf.String()
 }
@@ -139,34 +189,151 @@ func (r *topology) removeDevice(d *Device) {
 }
 
 func (r *topology) DeviceRemoved(d *Device) {
+	var removed []*link
+
 	func() {
 		// Write lock
 		r.mutex.Lock()
 		defer r.mutex.Unlock()
 
+		// Links connected to this device will be removed together with the device
+		for _, p := range d.Ports() {
+			if l, ok := r.graph.Edge(p).(*link); ok {
+				removed = append(removed, l)
+			}
+		}
 		r.removeDevice(d)
 		r.graph.RemoveVertex(d)
 	}()
+	r.hosts.removeDevice(d)
+	for _, l := range removed {
+		r.stream.linkRemoved(l)
+	}
 	r.sendEvent()
 }
 
 func (r *topology) DeviceLinked(ports [2]*Port) {
+	var added *link
+
 	func() {
 		// Write lock
 		r.mutex.Lock()
 		defer r.mutex.Unlock()
 
-		link := newLink(ports)
-		if err := r.graph.AddEdge(link); err != nil {
+		l := newLink(ports, r.latencyWeight)
+		// LLDP is periodically received on a link that already exists
+		if v, ok := r.graph.Edge(ports[0]).(*link); ok && v.ID() == l.ID() {
+			return
+		}
+		if err := r.graph.AddEdge(l); err != nil {
 			r.log.Err(fmt.Sprintf("Topology: adding new graph edge: %v", err))
 			return
 		}
+		added = l
 	}()
+	if added != nil {
+		r.stream.linkAdded(added)
+	}
 	r.sendEvent()
 }
 
+func (r *topology) LinkLatencyMeasured(ports [2]*Port, latency time.Duration) {
+	recalculate := func() bool {
+		// Read lock
+		r.mutex.RLock()
+		defer r.mutex.RUnlock()
+
+		l, ok := r.graph.Edge(ports[0]).(*link)
+		if !ok || l.ID() != newLink(ports, false).ID() {
+			return false
+		}
+		if !l.updateLatency(latency) {
+			return false
+		}
+		r.log.Debug(fmt.Sprintf("Topology: recalculating the spanning tree because the latency of link %v has changed to %v", l.ID(), l.Latency()))
+		r.graph.Recalculate()
+
+		return true
+	}()
+	if recalculate {
+		r.sendEvent()
+	}
+}
+
+// Links returns all the links among the switches.
+func (r *topology) Links() []*link {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	v := make([]*link, 0)
+	for _, e := range r.graph.Edges() {
+		if l, ok := e.(*link); ok {
+			v = append(v, l)
+		}
+	}
+
+	return v
+}
+
+// link returns the link whose one end is p. It returns nil if p is not an edge among switches.
+func (r *topology) link(p *Port) *link {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	l, ok := r.graph.Edge(p).(*link)
+	if !ok {
+		return nil
+	}
+
+	return l
+}
+
+// snapshot returns the current devices and links.
+func (r *topology) snapshot() TopologySnapshot {
+	v := TopologySnapshot{
+		Devices:   make([]string, 0),
+		Links:     make([]SnapshotLink, 0),
+		Timestamp: time.Now(),
+	}
+	for _, d := range r.Devices() {
+		v.Devices = append(v.Devices, d.ID())
+	}
+	sort.Strings(v.Devices)
+	for _, l := range r.Links() {
+		v.Links = append(v.Links, newSnapshotLink(l))
+	}
+
+	return v
+}
+
 // Node may return nil if a node whose MAC is mac does not exist
 func (r *topology) Node(mac net.HardwareAddr) (*Node, error) {
+	node, err := r.registeredNode(mac)
+	if err != nil {
+		return nil, err
+	}
+	// Unregistered hosts are only allowed in the fallback mode
+	if node == nil && !r.hostConf.fallback {
+		return nil, nil
+	}
+	// The learned location can be spoofed by any host, so the registered one is trusted unless the learned
+	// locations are allowed by host/update_location or host/fallback.
+	if node != nil && !r.hostConf.updateLocation && !r.hostConf.fallback {
+		return node, nil
+	}
+
+	// The learned location is more recent than the registered one, which is updated asynchronously.
+	host := r.hosts.lookup(mac)
+	if host == nil {
+		return node, nil
+	}
+
+	return NewNode(host.Port, mac), nil
+}
+
+func (r *topology) registeredNode(mac net.HardwareAddr) (*Node, error) {
 	// Read lock
 	r.mutex.RLock()
 	defer r.mutex.RUnlock()
@@ -191,8 +358,151 @@ func (r *topology) Node(mac net.HardwareAddr) (*Node, error) {
 	return NewNode(port, mac), nil
 }
 
+// HostObserved is called when a packet whose source is mac is received from p, which is not an edge among two switches.
+func (r *topology) HostObserved(p *Port, mac net.HardwareAddr, ip net.IP) {
+	if !isUnicastMAC(mac) {
+		return
+	}
+
+	prev, found := r.hosts.learn(p, mac, ip)
+	// Same location?
+	if found && prev == nil {
+		return
+	}
+	r.observations <- observation{port: p, mac: mac, prev: prev, found: found}
+}
+
+// handleObservations handles the hosts observed at new locations in order.
+func (r *topology) handleObservations() {
+	for v := range r.observations {
+		r.handleObservation(v)
+	}
+}
+
+// handleObservation compares the location of the observed host with the previous one, which is the registered
+// location if the host has not been learned, and then handles the host movement.
+func (r *topology) handleObservation(v observation) {
+	p, mac, prev := v.port, v.mac, v.prev
+	if !v.found {
+		// Newly learned host. Compare its location with the registered one.
+		moved, err := r.isMovedFromRegistered(p, mac)
+		if err != nil {
+			r.log.Err(fmt.Sprintf("Topology: checking the registered location of %v: %v", mac, err))
+			return
+		}
+		if !moved {
+			return
+		}
+		// prev may be nil if the registered device is not connected
+		if node, err := r.registeredNode(mac); err == nil && node != nil {
+			prev = node.Port()
+		}
+	}
+	r.hostMoved(p, mac, prev)
+}
+
+func (r *topology) isMovedFromRegistered(p *Port, mac net.HardwareAddr) (bool, error) {
+	dpid, portNum, ok, err := r.db.Location(mac)
+	if err != nil {
+		return false, err
+	}
+	// Unregistered host?
+	if !ok {
+		return false, nil
+	}
+
+	return dpid != p.Device().ID() || portNum != p.Number(), nil
+}
+
+func (r *topology) hostMoved(p *Port, mac net.HardwareAddr, prev *Port) {
+	r.log.Warning(fmt.Sprintf("Topology: host %v has moved from %v to %v", mac, prev, p))
+	if r.flap != nil {
+		if ports, culprits := r.flap.observe(mac, p, prev, time.Now()); ports != nil {
+			r.macFlapping(mac, ports, culprits)
+		}
+	}
+
+	if r.hostConf.updateLocation {
+		if err := r.updateHostLocation(p, mac); err != nil {
+			r.log.Err(fmt.Sprintf("Topology: updating the location of %v: %v", mac, err))
+		}
+	}
+
+	// Remove stale flows toward the host
+	for _, d := range r.Devices() {
+		if err := d.removeFlowsTo(mac); err != nil {
+			r.log.Err(fmt.Sprintf("Topology: removing flows toward %v from %v: %v", mac, d.ID(), err))
+			continue
+		}
+	}
+
+	if r.listener == nil {
+		return
+	}
+	if err := r.listener.OnHostMoved(r, NewNode(p, mac), prev); err != nil {
+		r.log.Err(fmt.Sprintf("Topology: executing OnHostMoved: %v", err))
+	}
+}
+
+// macFlapping blocks culprits, and then sends the MAC flapping event for each blocked port. The event is sent
+// once without a blocked port if no port has been blocked.
+func (r *topology) macFlapping(mac net.HardwareAddr, ports []*Port, culprits []*Port) {
+	r.log.Err(fmt.Sprintf("Topology: host %v is flapping among %v (possible forwarding loop through %v)", mac, ports, culprits))
+	events := make([]MACFlapping, 0)
+	for _, p := range culprits {
+		ok, err := r.blocker.block(p)
+		if err != nil {
+			r.log.Err(fmt.Sprintf("Topology: failed to block %v: %v", p, err))
+		}
+		if ok {
+			events = append(events, MACFlapping{MAC: mac, Ports: ports, Blocked: p, HoldTime: r.blocker.holdTime})
+		}
+	}
+	if len(events) == 0 {
+		events = append(events, MACFlapping{MAC: mac, Ports: ports, HoldTime: r.blocker.holdTime})
+	}
+
+	if r.listener == nil {
+		return
+	}
+	for _, v := range events {
+		if err := r.listener.OnMACFlapping(r, v); err != nil {
+			r.log.Err(fmt.Sprintf("Topology: executing OnMACFlapping: %v", err))
+		}
+	}
+}
+
+func (r *topology) updateHostLocation(p *Port, mac net.HardwareAddr) error {
+	dpid, err := strconv.ParseUint(p.Device().ID(), 10, 64)
+	if err != nil {
+		return err
+	}
+	ok, err := r.db.UpdateLocation(mac, dpid, p.Number())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%v is not registered in the database", p.ID())
+	}
+	r.log.Info(fmt.Sprintf("Topology: updated the location of %v to %v", mac, p.ID()))
+
+	return nil
+}
+
+// LearnedHosts returns host locations learned from the observed traffic.
+func (r *topology) PortsDiscovered(d *Device) {
+	if r.blocker != nil {
+		r.blocker.resume(d)
+	}
+}
+
+func (r *topology) LearnedHosts() []LearnedHost {
+	return r.hosts.list()
+}
+
 func (r *topology) PortRemoved(p *Port) {
 	edge := false
+	var removed *link
 
 	func() {
 		// Write lock
@@ -200,10 +510,15 @@ func (r *topology) PortRemoved(p *Port) {
 		defer r.mutex.Unlock()
 
 		if edge = r.graph.IsEdge(p); edge == true {
+			removed, _ = r.graph.Edge(p).(*link)
 			// Remove an edge from the graph if this port is an edge connected to another switch
 			r.graph.RemoveEdge(p)
 		}
 	}()
+	r.hosts.removePort(p)
+	if removed != nil {
+		r.stream.linkRemoved(removed)
+	}
 
 	if edge {
 		// XXX: Make sure the mutex is unlocked before calling sendEvent()
@@ -251,3 +566,7 @@ func (r *topology) IsEdge(p *Port) bool {
 func (r *topology) IsEnabledBySTP(p *Port) bool {
 	return r.graph.IsEnabledPoint(p)
 }
+
+func (r *topology) VIPToggled(id uint64, ip net.IP, mac net.HardwareAddr) {
+	r.stream.vipToggled(id, ip, mac)
+}
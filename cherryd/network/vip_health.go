@@ -0,0 +1,587 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	defaultVIPHealthInterval  = 3 * time.Second
+	defaultVIPHealthThreshold = 3
+	defaultVIPHealthMAC       = "02:00:00:00:00:fb"
+	// ICMP identifier and TCP source port of the health check probes, which distinguish their replies
+	vipProbeID   = 0xC4E7
+	vipProbePort = 47047
+)
+
+const (
+	// Only ARP requests are sent to the active host
+	VIPCheckARP = "arp"
+	// ICMP echo requests are sent in addition to the ARP requests
+	VIPCheckICMP = "icmp"
+	// TCP SYNs to the health check port are sent in addition to the ARP requests
+	VIPCheckTCP = "tcp"
+)
+
+const (
+	VIPHealthUnknown = "unknown"
+	VIPHealthAlive   = "alive"
+	VIPHealthDead    = "dead"
+)
+
+// VIPHealth is the health of the active host of a VIP. It is always unknown on the followers of the cluster
+// as only the leader probes the hosts.
+type VIPHealth struct {
+	State string `json:"state"`
+	// Number of the consecutive probe rounds that have not been answered
+	Failures int `json:"failures"`
+	// Last time the active host answered all the probes. It is zero if the host has never answered.
+	LastAlive time.Time `json:"last_alive"`
+}
+
+// Probes are ARP and IPv4 packets, so the VIPs of IPv6 hosts are not probed.
+var errIPv6VIPHost = errors.New("health check of an IPv6 host is not supported")
+
+type vipHealthConfig struct {
+	enable   bool
+	interval time.Duration
+	// The active host is dead if it does not answer threshold consecutive probe rounds.
+	threshold int
+	// Source MAC address of the probes
+	mac net.HardwareAddr
+	// Is the Router application enabled? The ICMP and TCP probes are sent from the gateway addresses, so their
+	// replies only come to the controller if the Router application owns the gateways.
+	router bool
+}
+
+// vipTarget is the active host of a VIP that is being probed.
+type vipTarget struct {
+	hostID string
+	ip     net.IP
+	mac    net.HardwareAddr
+	check  string
+	port   uint16
+	// Source address of the ICMP and TCP probes, which is the gateway address of the host's network.
+	// It is nil if the network is not routed by the Router application, and then only the ARP probes are sent.
+	source net.IP
+	// Probes of the current round that have not been answered yet
+	waitARP, waitL4 bool
+	health          VIPHealth
+}
+
+// isSame returns whether r is the target of the active host and the health check of v.
+func (r *vipTarget) isSame(v VIP) bool {
+	return r.hostID == v.ActiveHost.ID && r.check == v.HealthCheck && r.port == v.HealthPort
+}
+
+func (r *vipTarget) waiting() bool {
+	return r.waitARP || r.waitL4
+}
+
+// failed is called when a probe round has not been answered. It returns true if the host has just been declared dead.
+func (r *vipTarget) failed(threshold int) bool {
+	r.health.Failures++
+	if r.health.Failures < threshold || r.health.State == VIPHealthDead {
+		return false
+	}
+	r.health.State = VIPHealthDead
+
+	return true
+}
+
+// answered is called when a probe has been answered at now. It returns true if the host has just become alive.
+func (r *vipTarget) answered(now time.Time) bool {
+	if r.waiting() {
+		return false
+	}
+	prev := r.health.State
+	r.health = VIPHealth{State: VIPHealthAlive, LastAlive: now}
+
+	return prev != VIPHealthAlive
+}
+
+// vipHealthChecker probes the active hosts of the VIPs periodically by sending the probes out of their
+// attachment switch ports, and toggles a VIP to its standby host if the active one does not answer the
+// probes several times in a row, which catches a hung server whose link is still up.
+type vipHealthChecker struct {
+	mutex   sync.Mutex
+	log     log.Logger
+	db      database
+	topo    *topology
+	cluster *cluster
+	stream  *eventStream
+	conf    vipHealthConfig
+	seq     uint16
+	// Key is the VIP ID
+	targets map[uint64]*vipTarget
+}
+
+func newVIPHealthChecker(log log.Logger, db database, topo *topology, cluster *cluster, stream *eventStream, conf vipHealthConfig) *vipHealthChecker {
+	return &vipHealthChecker{
+		log:     log,
+		db:      db,
+		topo:    topo,
+		cluster: cluster,
+		stream:  stream,
+		conf:    conf,
+		targets: make(map[uint64]*vipTarget),
+	}
+}
+
+func (r *vipHealthChecker) enabled() bool {
+	return r.conf.enable
+}
+
+// health returns the health of the active host of the VIP whose ID is id.
+func (r *vipHealthChecker) health(id uint64) VIPHealth {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	t, ok := r.targets[id]
+	if !ok {
+		return VIPHealth{State: VIPHealthUnknown}
+	}
+
+	return t.health
+}
+
+func (r *vipHealthChecker) run() {
+	if !r.conf.enable {
+		return
+	}
+
+	r.log.Info(fmt.Sprintf("VIPHealth: starting the health checks of the VIP hosts (interval=%v, threshold=%v)", r.conf.interval, r.conf.threshold))
+	ticker := time.NewTicker(r.conf.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		// Only the leader receives the replies of the probes
+		if !r.cluster.isLeader() {
+			r.reset()
+			continue
+		}
+		r.check()
+	}
+}
+
+func (r *vipHealthChecker) reset() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.targets = make(map[uint64]*vipTarget)
+}
+
+// check finishes the previous probe round, fails over the VIPs whose active hosts are dead, and then
+// starts a new probe round. The database queries and the probes are done without the mutex so that
+// the replies of the probes, which are handled by the switch sessions, are not blocked by them.
+func (r *vipHealthChecker) check() {
+	vips, err := r.db.VIPs()
+	if err != nil {
+		r.log.Err(fmt.Sprintf("VIPHealth: failed to query the VIPs: %v", err))
+		return
+	}
+
+	// Start over if the active host or its health check has been changed
+	targets := make(map[uint64]*vipTarget)
+	for _, v := range r.changed(vips) {
+		t, err := r.newTarget(v)
+		if err != nil {
+			if err == errIPv6VIPHost {
+				r.log.Debug(fmt.Sprintf("VIPHealth: skipping the VIP (ID=%v): %v", v.ID, err))
+			} else {
+				r.log.Err(fmt.Sprintf("VIPHealth: skipping the VIP (ID=%v): %v", v.ID, err))
+			}
+			continue
+		}
+		targets[v.ID] = t
+	}
+
+	dead := make([]VIP, 0)
+	probes := make([]vipTarget, 0, len(vips))
+	var seq uint16
+	func() {
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+
+		r.seq++
+		seq = r.seq
+		registered := make(map[uint64]bool)
+		for _, v := range vips {
+			registered[v.ID] = true
+			t, ok := targets[v.ID]
+			if ok {
+				r.targets[v.ID] = t
+			} else if t, ok = r.targets[v.ID]; !ok || !t.isSame(v) {
+				// Skipped above
+				delete(r.targets, v.ID)
+				continue
+			} else if t.waiting() && t.failed(r.conf.threshold) {
+				r.log.Warning(fmt.Sprintf("VIPHealth: active host %v (%v) of the VIP %v is dead: no answer for %v probe rounds", t.ip, t.mac, v.IP, t.health.Failures))
+			}
+			if t.health.State == VIPHealthDead {
+				dead = append(dead, v)
+			}
+			t.waitARP = true
+			t.waitL4 = t.check != VIPCheckARP && t.source != nil
+			probes = append(probes, *t)
+		}
+		for id := range r.targets {
+			if !registered[id] {
+				delete(r.targets, id)
+			}
+		}
+	}()
+
+	for _, t := range probes {
+		r.probe(t, seq)
+	}
+	for _, v := range dead {
+		r.failover(v)
+	}
+}
+
+// changed returns the VIPs whose targets should be created again.
+func (r *vipHealthChecker) changed(vips []VIP) []VIP {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := make([]VIP, 0)
+	for _, v := range vips {
+		if t, ok := r.targets[v.ID]; !ok || !t.isSame(v) {
+			result = append(result, v)
+		}
+	}
+
+	return result
+}
+
+func (r *vipHealthChecker) newTarget(v VIP) (*vipTarget, error) {
+	ip, ipnet, err := net.ParseCIDR(v.ActiveHost.IP)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active host address: %v", v.ActiveHost.IP)
+	}
+	if ip.To4() == nil {
+		return nil, errIPv6VIPHost
+	}
+	mac, err := net.ParseMAC(v.ActiveHost.MAC)
+	if err != nil {
+		return nil, fmt.Errorf("invalid active host MAC address: %v", v.ActiveHost.MAC)
+	}
+
+	t := &vipTarget{
+		hostID: v.ActiveHost.ID,
+		ip:     ip.To4(),
+		mac:    mac,
+		check:  v.HealthCheck,
+		port:   v.HealthPort,
+		health: VIPHealth{State: VIPHealthUnknown},
+	}
+	if t.check == VIPCheckARP {
+		return t, nil
+	}
+	// A physical gateway does not send the replies to the controller, and then every probe fails.
+	if !r.conf.router {
+		r.log.Warning(fmt.Sprintf("VIPHealth: only ARP probes are sent to %v of the VIP (ID=%v) because the Router application is not enabled", ip, v.ID))
+		return t, nil
+	}
+
+	n, ok, err := r.db.Network(ipnet.IP)
+	if err != nil {
+		return nil, err
+	}
+	if !ok || n.Gateway == "" {
+		r.log.Warning(fmt.Sprintf("VIPHealth: only ARP probes are sent to %v of the VIP (ID=%v) because its network is not routed", ip, v.ID))
+		return t, nil
+	}
+	t.source = net.ParseIP(n.Gateway).To4()
+
+	return t, nil
+}
+
+// probe sends the probes of the round seq to t.
+func (r *vipHealthChecker) probe(t vipTarget, seq uint16) {
+	node, err := r.topo.Node(t.mac)
+	if err != nil {
+		r.log.Err(fmt.Sprintf("VIPHealth: failed to find the location of %v: %v", t.mac, err))
+		return
+	}
+	// The probes are not answered if we do not know where the host is
+	if node == nil {
+		r.log.Debug(fmt.Sprintf("VIPHealth: unknown location of %v", t.mac))
+		return
+	}
+
+	packets := make([][]byte, 0)
+	// ARP probe whose sender address is zero so that the host does not update its ARP cache
+	arp, err := makeEthernet(r.conf.mac, t.mac, 0x0806, protocol.NewARPRequest(r.conf.mac, net.IPv4zero.To4(), t.ip))
+	if err != nil {
+		r.log.Err(fmt.Sprintf("VIPHealth: failed to make an ARP probe: %v", err))
+		return
+	}
+	packets = append(packets, arp)
+	if t.waitL4 {
+		v, err := r.makeL4Probe(t, seq)
+		if err != nil {
+			r.log.Err(fmt.Sprintf("VIPHealth: failed to make a %v probe: %v", t.check, err))
+			return
+		}
+		packets = append(packets, v)
+	}
+
+	for _, v := range packets {
+		if err := sendPacketOut(node.Port(), v); err != nil {
+			r.log.Err(fmt.Sprintf("VIPHealth: failed to send a probe to %v via %v: %v", t.ip, node.Port().ID(), err))
+			return
+		}
+	}
+}
+
+func (r *vipHealthChecker) makeL4Probe(t vipTarget, seq uint16) ([]byte, error) {
+	var payload []byte
+	var proto uint8
+	var err error
+
+	switch t.check {
+	case VIPCheckICMP:
+		proto = 1
+		payload, err = protocol.NewICMPEchoRequest(vipProbeID, seq, nil).MarshalBinary()
+	case VIPCheckTCP:
+		proto = 6
+		syn := &protocol.TCP{
+			SrcPort:    vipProbePort,
+			DstPort:    t.port,
+			Sequence:   rand.Uint32(),
+			Flags:      0x002, // SYN
+			WindowSize: 1024,
+		}
+		syn.SetPseudoHeader(t.source, t.ip)
+		payload, err = syn.MarshalBinary()
+	default:
+		return nil, fmt.Errorf("unknown health check: %v", t.check)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return makeEthernet(r.conf.mac, t.mac, 0x0800, protocol.NewIPv4(t.source, t.ip, proto, payload))
+}
+
+func makeEthernet(src, dst net.HardwareAddr, etherType uint16, payload encoding.BinaryMarshaler) ([]byte, error) {
+	v, err := payload.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	eth := protocol.Ethernet{
+		SrcMAC:  src,
+		DstMAC:  dst,
+		Type:    etherType,
+		Payload: v,
+	}
+
+	return eth.MarshalBinary()
+}
+
+func sendPacketOut(p *Port, packet []byte) error {
+	f := p.Device().Factory()
+
+	outPort := openflow.NewOutPort()
+	outPort.SetValue(p.Number())
+	action, err := f.NewAction()
+	if err != nil {
+		return err
+	}
+	action.SetOutPort(outPort)
+
+	out, err := f.NewPacketOut()
+	if err != nil {
+		return err
+	}
+	// From controller
+	out.SetInPort(openflow.NewInPort())
+	out.SetAction(action)
+	out.SetData(packet)
+
+	return p.Device().SendMessage(out)
+}
+
+// failover toggles the VIP to its standby host, and then sends the ARP announcements for the new active host.
+func (r *vipHealthChecker) failover(v VIP) {
+	standby, err := net.ParseMAC(v.StandbyHost.MAC)
+	if err != nil {
+		r.log.Err(fmt.Sprintf("VIPHealth: invalid standby host MAC address of the VIP (ID=%v): %v", v.ID, v.StandbyHost.MAC))
+		return
+	}
+	// Toggling to a standby host that is not connected makes things worse
+	node, err := r.topo.Node(standby)
+	if err != nil || node == nil {
+		r.log.Warning(fmt.Sprintf("VIPHealth: not toggling the VIP %v because its standby host %v is not connected", v.IP, standby))
+		return
+	}
+
+	ip, mac, err := r.db.ToggleVIP(v.ID)
+	if err != nil {
+		r.log.Err(fmt.Sprintf("VIPHealth: failed to toggle the VIP (ID=%v): %v", v.ID, err))
+		return
+	}
+	r.log.Warning(fmt.Sprintf("VIPHealth: toggled the VIP %v to the standby host %v", ip, mac))
+	r.stream.vipToggled(v.ID, ip, mac)
+
+	for _, sw := range r.topo.Devices() {
+		if err := sw.SendARPAnnouncement(ip, mac); err != nil {
+			r.log.Err(fmt.Sprintf("VIPHealth: failed to send ARP announcement via %v: %v", sw.ID(), err))
+			continue
+		}
+	}
+}
+
+// handle consumes the packet if it is a reply of the probes. It returns false if the packet is not a reply.
+func (r *vipHealthChecker) handle(ingress *Port, eth *protocol.Ethernet) bool {
+	if !r.conf.enable {
+		return false
+	}
+
+	switch eth.Type {
+	case 0x0806:
+		arp := new(protocol.ARP)
+		if err := arp.UnmarshalBinary(eth.Payload); err != nil {
+			return false
+		}
+		// Replies of the ARP probes are sent to our MAC address
+		if arp.Operation != 2 || !bytes.Equal(arp.THA, r.conf.mac) {
+			return false
+		}
+		r.replied(arp.SPA, arp.SHA, func(t *vipTarget) bool { return t.waitARP }, func(t *vipTarget) { t.waitARP = false })
+		return true
+	case 0x0800:
+		ip := new(protocol.IPv4)
+		if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+			return false
+		}
+		return r.handleL4(ingress, eth, ip)
+	default:
+		return false
+	}
+}
+
+func (r *vipHealthChecker) handleL4(ingress *Port, eth *protocol.Ethernet, ip *protocol.IPv4) bool {
+	switch ip.Protocol {
+	case 1:
+		echo := new(protocol.ICMPEcho)
+		if err := echo.UnmarshalBinary(ip.Payload); err != nil || echo.Type != 0 || echo.ID != vipProbeID {
+			return false
+		}
+		r.replied(ip.SrcIP, eth.SrcMAC, func(t *vipTarget) bool {
+			return t.check == VIPCheckICMP && t.source.Equal(ip.DstIP)
+		}, func(t *vipTarget) { t.waitL4 = false })
+		return true
+	case 6:
+		tcp := new(protocol.TCP)
+		if err := tcp.UnmarshalBinary(ip.Payload); err != nil || tcp.DstPort != vipProbePort {
+			return false
+		}
+		// RST means that the host is alive but the service is not.
+		if tcp.Flags&0x012 != 0x012 {
+			return true
+		}
+		r.replied(ip.SrcIP, eth.SrcMAC, func(t *vipTarget) bool {
+			return t.check == VIPCheckTCP && t.port == tcp.SrcPort && t.source.Equal(ip.DstIP)
+		}, func(t *vipTarget) { t.waitL4 = false })
+		// Close the half-open connection
+		if err := r.sendRST(ingress, eth, ip, tcp); err != nil {
+			r.log.Err(fmt.Sprintf("VIPHealth: failed to send TCP RST to %v: %v", ip.SrcIP, err))
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// replied marks the probes of the targets whose address is ip and MAC is mac as answered. match selects the
+// targets waiting for the reply, and done clears their waiting state.
+func (r *vipHealthChecker) replied(ip net.IP, mac net.HardwareAddr, match func(*vipTarget) bool, done func(*vipTarget)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for id, t := range r.targets {
+		if !t.ip.Equal(ip) || !bytes.Equal(t.mac, mac) || !match(t) {
+			continue
+		}
+		done(t)
+		if t.answered(now) {
+			r.log.Info(fmt.Sprintf("VIPHealth: active host %v (%v) of the VIP (ID=%v) is alive", t.ip, t.mac, id))
+		}
+	}
+}
+
+func (r *vipHealthChecker) sendRST(ingress *Port, eth *protocol.Ethernet, ip *protocol.IPv4, synack *protocol.TCP) error {
+	rst := &protocol.TCP{
+		SrcPort:  synack.DstPort,
+		DstPort:  synack.SrcPort,
+		Sequence: synack.Acknowledgment,
+		Flags:    0x004, // RST
+	}
+	rst.SetPseudoHeader(ip.DstIP, ip.SrcIP)
+	payload, err := rst.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	packet, err := makeEthernet(r.conf.mac, eth.SrcMAC, 0x0800, protocol.NewIPv4(ip.DstIP, ip.SrcIP, 6, payload))
+	if err != nil {
+		return err
+	}
+
+	return sendPacketOut(ingress, packet)
+}
+
+// parseVIPHealthCheck returns the default health check if v is empty.
+func parseVIPHealthCheck(v string, port uint16) (string, error) {
+	switch v {
+	case "", VIPCheckARP, VIPCheckICMP:
+		if port != 0 {
+			return "", errors.New("health check port is only allowed for the TCP health check")
+		}
+		if v == "" {
+			return VIPCheckARP, nil
+		}
+		return v, nil
+	case VIPCheckTCP:
+		if port == 0 {
+			return "", errors.New("TCP health check requires the health check port")
+		}
+		return v, nil
+	default:
+		return "", fmt.Errorf("invalid health check: %v", v)
+	}
+}
@@ -0,0 +1,92 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package network
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVIPTargetHealth(t *testing.T) {
+	target := &vipTarget{check: VIPCheckTCP, health: VIPHealth{State: VIPHealthUnknown}}
+
+	// Alive only after all the probes of a round have been answered
+	target.waitL4 = true
+	if target.answered(time.Now()) {
+		t.Fatal("expected the host not to be alive before the TCP probe is answered")
+	}
+	target.waitL4 = false
+	now := time.Now()
+	if !target.answered(now) {
+		t.Fatal("expected the host to become alive")
+	}
+	if target.health.State != VIPHealthAlive || !target.health.LastAlive.Equal(now) {
+		t.Fatalf("unexpected health: %+v", target.health)
+	}
+
+	for i := 1; i < 3; i++ {
+		if target.failed(3) {
+			t.Fatalf("expected the host not to be dead after %v failures", i)
+		}
+	}
+	if !target.failed(3) || target.health.State != VIPHealthDead {
+		t.Fatalf("expected the host to be dead: %+v", target.health)
+	}
+	// Declared only once
+	if target.failed(3) || target.health.Failures != 4 {
+		t.Fatalf("unexpected health: %+v", target.health)
+	}
+
+	if !target.answered(time.Now()) || target.health.Failures != 0 {
+		t.Fatalf("expected the host to recover: %+v", target.health)
+	}
+}
+
+func TestParseVIPHealthCheck(t *testing.T) {
+	tests := []struct {
+		check    string
+		port     uint16
+		expected string
+		fail     bool
+	}{
+		{"", 0, VIPCheckARP, false},
+		{"arp", 0, VIPCheckARP, false},
+		{"icmp", 0, VIPCheckICMP, false},
+		{"tcp", 80, VIPCheckTCP, false},
+		{"tcp", 0, "", true},
+		{"icmp", 80, "", true},
+		{"http", 0, "", true},
+	}
+
+	for _, v := range tests {
+		check, err := parseVIPHealthCheck(v.check, v.port)
+		if v.fail {
+			if err == nil {
+				t.Fatalf("expected an error: check=%v, port=%v", v.check, v.port)
+			}
+			continue
+		}
+		if err != nil || check != v.expected {
+			t.Fatalf("unexpected result: check=%v, port=%v, result=%v, err=%v", v.check, v.port, check, err)
+		}
+	}
+}
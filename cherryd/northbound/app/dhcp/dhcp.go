@@ -0,0 +1,337 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package dhcp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	defaultMAC       = "02:00:00:00:00:fd"
+	defaultLeaseTime = 86400 // Seconds
+	serverPort       = 67
+	clientPort       = 68
+)
+
+// DHCP answers the DHCP requests of the hosts with the IP addresses bound to their MAC addresses in the
+// database. The requests from unknown MAC addresses are refused, and the DHCP server messages sent by
+// the hosts are dropped as only the controller is allowed to answer the requests.
+type DHCP struct {
+	app.BaseProcessor
+	conf *goconf.ConfigFile
+	log  log.Logger
+	db   database
+	mac  net.HardwareAddr
+	// Server identifier. It is nil if the gateway address of the network is used as the identifier.
+	serverAddr net.IP
+	// Default gateway for the networks that do not have a gateway address. It can be nil.
+	gateway   net.IP
+	dns       []net.IP
+	leaseTime uint32
+}
+
+type database interface {
+	HostAddress(mac net.HardwareAddr) (ip net.IP, n network.Network, ok bool, err error)
+}
+
+func New(conf *goconf.ConfigFile, log log.Logger, db database) *DHCP {
+	return &DHCP{
+		conf:      conf,
+		log:       log,
+		db:        db,
+		leaseTime: defaultLeaseTime,
+	}
+}
+
+func (r *DHCP) Init() error {
+	mac := defaultMAC
+	if r.conf.HasOption("dhcp", "mac") {
+		v, err := r.conf.GetString("dhcp", "mac")
+		if err != nil {
+			return errors.New("invalid dhcp/mac in the config file")
+		}
+		mac = v
+	}
+	v, err := net.ParseMAC(mac)
+	if err != nil || len(v) != 6 {
+		return errors.New("invalid dhcp/mac in the config file")
+	}
+	r.mac = v
+
+	if r.serverAddr, err = r.getIPv4("server_address"); err != nil {
+		return err
+	}
+	if r.gateway, err = r.getIPv4("gateway"); err != nil {
+		return err
+	}
+	if r.conf.HasOption("dhcp", "dns") {
+		v, err := r.conf.GetString("dhcp", "dns")
+		if err != nil {
+			return errors.New("invalid dhcp/dns in the config file")
+		}
+		r.dns = make([]net.IP, 0)
+		for _, addr := range strings.Split(v, ",") {
+			ip := net.ParseIP(strings.TrimSpace(addr))
+			if ip == nil || ip.To4() == nil {
+				return errors.New("invalid dhcp/dns in the config file")
+			}
+			r.dns = append(r.dns, ip)
+		}
+	}
+	if r.conf.HasOption("dhcp", "lease_time") {
+		v, err := r.conf.GetInt("dhcp", "lease_time")
+		if err != nil || v <= 0 {
+			return errors.New("invalid dhcp/lease_time in the config file")
+		}
+		r.leaseTime = uint32(v)
+	}
+
+	return nil
+}
+
+// getIPv4 returns the IPv4 address of the option in the dhcp section. It returns nil if the option does not exist.
+func (r *DHCP) getIPv4(option string) (net.IP, error) {
+	if !r.conf.HasOption("dhcp", option) {
+		return nil, nil
+	}
+	v, err := r.conf.GetString("dhcp", option)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dhcp/%v in the config file", option)
+	}
+	ip := net.ParseIP(strings.TrimSpace(v))
+	if ip == nil || ip.To4() == nil {
+		return nil, fmt.Errorf("invalid dhcp/%v in the config file", option)
+	}
+
+	return ip, nil
+}
+
+func (r *DHCP) Name() string {
+	return "DHCP"
+}
+
+func (r *DHCP) String() string {
+	return fmt.Sprintf("%v", r.Name())
+}
+
+func (r *DHCP) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	// IPv4?
+	if eth.Type != 0x0800 {
+		return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+	}
+	ip := new(protocol.IPv4)
+	if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+		return err
+	}
+	// UDP?
+	if ip.Protocol != 17 {
+		return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+	}
+	udp := new(protocol.UDP)
+	if err := udp.UnmarshalBinary(ip.Payload); err != nil {
+		return err
+	}
+
+	switch {
+	case udp.SrcPort == serverPort && udp.DstPort == clientPort:
+		r.log.Info(fmt.Sprintf("DHCP: drop a DHCP server message from %v (SrcMAC=%v, SrcIP=%v)", ingress.ID(), eth.SrcMAC, ip.SrcIP))
+		return nil
+	case udp.DstPort == serverPort:
+		return r.serve(finder, ingress, ip, udp)
+	default:
+		return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+	}
+}
+
+func (r *DHCP) serve(finder network.Finder, ingress *network.Port, ip *protocol.IPv4, udp *protocol.UDP) error {
+	// The request has been answered by the switch that the client is attached to if it comes from another switch.
+	if finder.IsEdge(ingress) {
+		return nil
+	}
+
+	request := new(protocol.DHCP)
+	if err := request.UnmarshalBinary(udp.Payload); err != nil {
+		r.log.Debug(fmt.Sprintf("DHCP: drop an invalid DHCP message from %v: %v", ingress.ID(), err))
+		return nil
+	}
+	// BOOTREQUEST?
+	if request.Op != 1 {
+		return nil
+	}
+	t, ok := request.MessageType()
+	if !ok {
+		r.log.Debug(fmt.Sprintf("DHCP: drop a BOOTP message from %v", request.CHAddr))
+		return nil
+	}
+
+	addr, n, ok, err := r.db.HostAddress(request.CHAddr)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		r.log.Info(fmt.Sprintf("DHCP: refusing the DHCP message (type=%v) from unknown MAC address %v", t, request.CHAddr))
+		return nil
+	}
+	serverID := r.serverID(n)
+	if serverID == nil {
+		return fmt.Errorf("DHCP: no server identifier for the network %v/%v: gateway of the network or dhcp/server_address is required", n.Address, n.Mask)
+	}
+
+	switch t {
+	case protocol.DHCPDiscover:
+		r.log.Debug(fmt.Sprintf("DHCP: offering %v to %v", addr, request.CHAddr))
+		return r.sendReply(ingress, request, r.makeLease(request, protocol.DHCPOffer, addr, n, serverID), serverID)
+	case protocol.DHCPRequest:
+		if r.isSelectingOthers(request, serverID) {
+			return nil
+		}
+		requested, ok := request.Option(protocol.DHCPOptRequestedIP)
+		if !ok {
+			// Renewing or rebinding
+			requested = request.CIAddr
+		}
+		if !net.IP(requested).Equal(addr) {
+			r.log.Info(fmt.Sprintf("DHCP: refusing the request for %v from %v whose address is %v", net.IP(requested), request.CHAddr, addr))
+			return r.sendReply(ingress, request, protocol.NewDHCPReply(request, protocol.DHCPNak), serverID)
+		}
+		r.log.Debug(fmt.Sprintf("DHCP: leasing %v to %v", addr, request.CHAddr))
+		return r.sendReply(ingress, request, r.makeLease(request, protocol.DHCPAck, addr, n, serverID), serverID)
+	default:
+		r.log.Debug(fmt.Sprintf("DHCP: ignoring the DHCP message (type=%v) from %v", t, request.CHAddr))
+		return nil
+	}
+}
+
+// isSelectingOthers returns whether the client has selected another DHCP server whose identifier is not serverID.
+func (r *DHCP) isSelectingOthers(request *protocol.DHCP, serverID net.IP) bool {
+	v, ok := request.Option(protocol.DHCPOptServerID)
+	if !ok {
+		return false
+	}
+
+	return !net.IP(v).Equal(serverID)
+}
+
+// serverID returns the server identifier for the clients in n.
+func (r *DHCP) serverID(n network.Network) net.IP {
+	if r.serverAddr != nil {
+		return r.serverAddr
+	}
+
+	return r.gatewayOf(n)
+}
+
+// gatewayOf returns the gateway address of n, or the default gateway if n does not have one.
+func (r *DHCP) gatewayOf(n network.Network) net.IP {
+	if v := net.ParseIP(n.Gateway); v != nil {
+		return v
+	}
+
+	return r.gateway
+}
+
+func (r *DHCP) makeLease(request *protocol.DHCP, t uint8, addr net.IP, n network.Network, serverID net.IP) *protocol.DHCP {
+	reply := protocol.NewDHCPReply(request, t)
+	reply.YIAddr = addr
+	reply.AddOption(protocol.DHCPOptServerID, serverID.To4())
+	reply.AddOption(protocol.DHCPOptLeaseTime, uint32Bytes(r.leaseTime))
+	reply.AddOption(protocol.DHCPOptRenewalTime, uint32Bytes(r.leaseTime/2))
+	reply.AddOption(protocol.DHCPOptRebindTime, uint32Bytes(r.leaseTime/8*7))
+	reply.AddOption(protocol.DHCPOptSubnetMask, net.CIDRMask(int(n.Mask), 32))
+	if gateway := r.gatewayOf(n); gateway != nil {
+		reply.AddOption(protocol.DHCPOptRouter, gateway.To4())
+	}
+	if len(r.dns) > 0 {
+		v := make([]byte, 0)
+		for _, ip := range r.dns {
+			v = append(v, ip.To4()...)
+		}
+		reply.AddOption(protocol.DHCPOptDNS, v)
+	}
+
+	return reply
+}
+
+func uint32Bytes(v uint32) []byte {
+	b := make([]byte, 4)
+	binary.BigEndian.PutUint32(b, v)
+
+	return b
+}
+
+// sendReply sends reply to the client attached to ingress. The addresses of the reply are decided by RFC 2131 section 4.1.
+func (r *DHCP) sendReply(ingress *network.Port, request, reply *protocol.DHCP, serverID net.IP) error {
+	dstIP := net.IPv4bcast
+	dstMAC := net.HardwareAddr([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF})
+	t, _ := reply.MessageType()
+	switch {
+	case t == protocol.DHCPNak || request.IsBroadcast():
+		// Broadcast
+	case !request.CIAddr.Equal(net.IPv4zero):
+		dstIP = request.CIAddr
+		dstMAC = request.CHAddr
+	default:
+		dstIP = reply.YIAddr
+		dstMAC = request.CHAddr
+	}
+
+	payload, err := reply.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	udp := protocol.UDP{
+		SrcPort: serverPort,
+		DstPort: clientPort,
+		Length:  uint16(8 + len(payload)),
+		Payload: payload,
+	}
+	udp.SetPseudoHeader(serverID, dstIP)
+	datagram, err := udp.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	ip, err := protocol.NewIPv4(serverID, dstIP, 17, datagram).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  r.mac,
+		DstMAC:  dstMAC,
+		Type:    0x0800,
+		Payload: ip,
+	}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return r.PacketOut(ingress, packet)
+}
@@ -0,0 +1,109 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package app
+
+import (
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+type EventType uint8
+
+const (
+	EventPacketIn EventType = iota
+	EventPortUp
+	EventPortDown
+	EventDeviceUp
+	EventDeviceDown
+	EventTopologyChange
+	EventHostMoved
+	EventTopologyDiff
+	EventMACFlapping
+)
+
+func (r EventType) String() string {
+	switch r {
+	case EventPacketIn:
+		return "PacketIn"
+	case EventPortUp:
+		return "PortUp"
+	case EventPortDown:
+		return "PortDown"
+	case EventDeviceUp:
+		return "DeviceUp"
+	case EventDeviceDown:
+		return "DeviceDown"
+	case EventTopologyChange:
+		return "TopologyChange"
+	case EventHostMoved:
+		return "HostMoved"
+	case EventTopologyDiff:
+		return "TopologyDiff"
+	case EventMACFlapping:
+		return "MACFlapping"
+	default:
+		return "Unknown"
+	}
+}
+
+type Event struct {
+	Type   EventType
+	Finder network.Finder
+	// Device is nil on the topology change and diff events, and the MAC flapping event without a blocked port
+	Device *network.Device
+	// Port is the ingress port of PACKET_IN, the port of port events, the new location of a moved host,
+	// or the port blocked due to MAC flapping
+	Port   *network.Port
+	Packet *protocol.Ethernet
+	// Host and PrevPort are only for the host moved event. PrevPort may be nil.
+	Host     *network.Node
+	PrevPort *network.Port
+	// Diff is only for the topology diff event
+	Diff *network.TopologyDiff
+	// Flapping is only for the MAC flapping event
+	Flapping  *network.MACFlapping
+	Timestamp time.Time
+}
+
+// DeviceID returns an empty string if the event is not related to a specific device.
+func (r Event) DeviceID() string {
+	if r.Device == nil {
+		return ""
+	}
+
+	return r.Device.ID()
+}
+
+type EventHandler func(Event) error
+
+// EventBus delivers events to the subscribed handlers asynchronously. Events of a same device
+// are delivered in order, and handlers that run too long are reported.
+type EventBus interface {
+	Subscribe(name string, types []EventType, h EventHandler)
+}
+
+// Subscriber is an application that subscribes to the event bus when it is enabled.
+type Subscriber interface {
+	Subscribe(bus EventBus)
+}
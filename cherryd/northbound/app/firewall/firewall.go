@@ -0,0 +1,596 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package firewall
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	defaultSyncInterval = 5 * time.Second
+	// Flow priority of the first rule. The following rules have lower priorities, and all of them are
+	// higher than the flows of the other northbound applications.
+	maxFlowPriority = 0xE000
+	minFlowPriority = 1000
+	// Idle timeout of the flows that forward the traffic allowed by an exception rule
+	exceptionIdleTimeout = 30
+	// Hard timeout of the flows that forward the traffic allowed by an exception rule, which makes them follow
+	// the changes of the flows they are copied from
+	exceptionHardTimeout = 300
+)
+
+// Firewall enforces the ACL rules in the database. The rules are evaluated in order and the first matched
+// rule decides whether an IPv4 packet is allowed or denied. The packets that do not match any rule are allowed.
+//
+// The deny rules are installed on the switches as drop flows that have higher priorities than the flows of the
+// other applications. An allow rule that overrides a lower deny rule, which is called an exception rule, is
+// installed as a flow that sends the packets to the controller. The packets are checked again on the reactive
+// path and handed to the other applications, and then the flow that the application installed for the packet is
+// copied into a flow that has a higher priority than the exception rule. So, the allowed traffic is forwarded the
+// same way as it would be without the firewall, including the rewrites of the Router, LoadBalancer and FloatingIP.
+type Firewall struct {
+	app.BaseProcessor
+	conf     *goconf.ConfigFile
+	log      log.Logger
+	db       database
+	vlanID   uint16
+	interval time.Duration
+	mutex    sync.Mutex
+	// Last finder passed by the events, which is used to synchronize the rules periodically
+	finder network.Finder
+	// Compiled rules in the order of evaluation
+	rules []rule
+	// Key is the device, and value is the description of the rules applied to the device
+	applied map[*network.Device]string
+}
+
+type database interface {
+	ACLs() ([]network.ACL, error)
+}
+
+func New(conf *goconf.ConfigFile, log log.Logger, db database) *Firewall {
+	return &Firewall{
+		conf:     conf,
+		log:      log,
+		db:       db,
+		interval: defaultSyncInterval,
+		applied:  make(map[*network.Device]string),
+	}
+}
+
+func (r *Firewall) Init() error {
+	vlanID, err := r.conf.GetInt("default", "vlan_id")
+	if err != nil || vlanID < 0 || vlanID > 4095 {
+		return errors.New("invalid default VLAN ID in the config file")
+	}
+	r.vlanID = uint16(vlanID)
+
+	if r.conf.HasOption("firewall", "sync_interval") {
+		v, err := r.conf.GetInt("firewall", "sync_interval")
+		if err != nil || v <= 0 {
+			return errors.New("invalid firewall/sync_interval in the config file")
+		}
+		r.interval = time.Duration(v) * time.Second
+	}
+	// Load the rules before any packet is received
+	if err := r.sync(nil); err != nil {
+		return fmt.Errorf("loading the ACL rules: %v", err)
+	}
+	// The rules added or removed through the REST API are applied by the periodic synchronization.
+	go r.run()
+
+	return nil
+}
+
+func (r *Firewall) Name() string {
+	return "Firewall"
+}
+
+func (r *Firewall) String() string {
+	return fmt.Sprintf("%v", r.Name())
+}
+
+func (r *Firewall) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mutex.Lock()
+		finder := r.finder
+		r.mutex.Unlock()
+
+		if err := r.sync(finder); err != nil {
+			r.log.Err(fmt.Sprintf("Firewall: failed to synchronize the ACL rules: %v", err))
+		}
+	}
+}
+
+// rule is a compiled ACL rule.
+type rule struct {
+	id       uint64
+	allow    bool
+	src, dst *net.IPNet
+	protocol uint8
+	srcPort  uint16
+	dstPort  uint16
+	// Flow priority of this rule
+	priority uint16
+	// Is this rule installed as a flow? Deny rules and exception rules are installed.
+	installed bool
+}
+
+func (r rule) String() string {
+	return fmt.Sprintf("%v:%v:%v:%v:%v:%v:%v:%v:%v", r.id, r.allow, r.src, r.dst, r.protocol, r.srcPort, r.dstPort, r.priority, r.installed)
+}
+
+// overlaps returns whether there is a packet that matches both r and other.
+func (r rule) overlaps(other rule) bool {
+	if !r.src.Contains(other.src.IP) && !other.src.Contains(r.src.IP) {
+		return false
+	}
+	if !r.dst.Contains(other.dst.IP) && !other.dst.Contains(r.dst.IP) {
+		return false
+	}
+
+	return overlapField(uint16(r.protocol), uint16(other.protocol)) && overlapField(r.srcPort, other.srcPort) && overlapField(r.dstPort, other.dstPort)
+}
+
+// overlapField returns whether the two fields whose zero value means any value overlap.
+func overlapField(a, b uint16) bool {
+	return a == b || a == 0 || b == 0
+}
+
+func (r rule) matches(p packet) bool {
+	if !r.src.Contains(p.srcIP) || !r.dst.Contains(p.dstIP) {
+		return false
+	}
+	if r.protocol != 0 && r.protocol != p.protocol {
+		return false
+	}
+	if r.srcPort != 0 && r.srcPort != p.srcPort {
+		return false
+	}
+	if r.dstPort != 0 && r.dstPort != p.dstPort {
+		return false
+	}
+
+	return true
+}
+
+// compile converts acls, which should be sorted in the order of evaluation, into the rules.
+func compile(acls []network.ACL) ([]rule, error) {
+	if len(acls) > (maxFlowPriority-minFlowPriority)/2 {
+		return nil, fmt.Errorf("too many ACL rules: %v", len(acls))
+	}
+
+	rules := make([]rule, 0, len(acls))
+	for i, v := range acls {
+		_, src, err := net.ParseCIDR(v.Src)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source network of the ACL rule (ID=%v): %v", v.ID, err)
+		}
+		_, dst, err := net.ParseCIDR(v.Dst)
+		if err != nil {
+			return nil, fmt.Errorf("invalid destination network of the ACL rule (ID=%v): %v", v.ID, err)
+		}
+		rules = append(rules, rule{
+			id:       v.ID,
+			allow:    v.Action == "allow",
+			src:      src,
+			dst:      dst,
+			protocol: v.Protocol,
+			srcPort:  v.SrcPort,
+			dstPort:  v.DstPort,
+			// Two priorities for each rule. The higher one is used by the flows forwarding the allowed traffic.
+			priority: uint16(maxFlowPriority - i*2),
+		})
+	}
+
+	for i := range rules {
+		if !rules[i].allow {
+			rules[i].installed = true
+			continue
+		}
+		// Exception rule?
+		for _, v := range rules[i+1:] {
+			if !v.allow && rules[i].overlaps(v) {
+				rules[i].installed = true
+				break
+			}
+		}
+	}
+
+	return rules, nil
+}
+
+func describe(rules []rule) string {
+	v := make([]string, 0)
+	for _, r := range rules {
+		if r.installed {
+			v = append(v, r.String())
+		}
+	}
+
+	return strings.Join(v, ",")
+}
+
+// sync loads the rules from the database, and applies them to the devices whose rules have been changed since the
+// last synchronization. finder can be nil, and then the rules are only loaded.
+func (r *Firewall) sync(finder network.Finder) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	acls, err := r.db.ACLs()
+	if err != nil {
+		return err
+	}
+	rules, err := compile(acls)
+	if err != nil {
+		return err
+	}
+	r.rules = rules
+	if finder == nil {
+		return nil
+	}
+	r.finder = finder
+
+	desc := describe(rules)
+	count := 0
+	for _, v := range rules {
+		if v.installed {
+			count++
+		}
+	}
+	applied := make(map[*network.Device]string)
+	for _, d := range finder.Devices() {
+		if d.IsClosed() {
+			continue
+		}
+		// Devices that have never had a rule are also skipped here. The flows may have been removed without us,
+		// for example by Device.RemoveAllFlows, so we also check the flows that are actually installed.
+		if r.applied[d] == desc && len(d.PermanentFlows(r.Name())) == count {
+			applied[d] = desc
+			continue
+		}
+
+		r.log.Info(fmt.Sprintf("Firewall: applying the ACL rules to %v: %v", d.ID(), desc))
+		if err := r.apply(d, rules); err != nil {
+			// This device will be retried on the next synchronization
+			r.log.Err(fmt.Sprintf("Firewall: failed to apply the ACL rules to %v: %v", d.ID(), err))
+			continue
+		}
+		applied[d] = desc
+	}
+	// Forget the disconnected devices
+	r.applied = applied
+
+	return nil
+}
+
+func (r *Firewall) apply(device *network.Device, rules []rule) error {
+	if err := device.RemoveAppFlows(r.Name()); err != nil {
+		return err
+	}
+	for _, v := range rules {
+		if !v.installed {
+			continue
+		}
+		if err := r.installRule(device, v); err != nil {
+			return fmt.Errorf("installing the ACL rule (ID=%v): %v", v.id, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *Firewall) newMatch(f openflow.Factory, v rule) (openflow.Match, error) {
+	match, err := f.NewMatch()
+	if err != nil {
+		return nil, err
+	}
+	match.SetVLANID(r.vlanID)
+	match.SetEtherType(0x0800)
+	if ones, _ := v.src.Mask.Size(); ones > 0 {
+		match.SetSrcIP(v.src)
+	}
+	if ones, _ := v.dst.Mask.Size(); ones > 0 {
+		match.SetDstIP(v.dst)
+	}
+	if v.protocol != 0 {
+		match.SetIPProtocol(v.protocol)
+	}
+	if v.srcPort != 0 {
+		match.SetSrcPort(v.srcPort)
+	}
+	if v.dstPort != 0 {
+		match.SetDstPort(v.dstPort)
+	}
+
+	return match, match.Error()
+}
+
+// installRule installs a permanent flow that drops the packets matched with the deny rule, or sends the packets
+// matched with the exception rule to the controller.
+func (r *Firewall) installRule(device *network.Device, v rule) error {
+	f := device.Factory()
+	match, err := r.newMatch(f, v)
+	if err != nil {
+		return err
+	}
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	flow.SetTableID(device.FlowTableID())
+	flow.SetIdleTimeout(0)
+	flow.SetHardTimeout(0)
+	flow.SetPriority(v.priority)
+	flow.SetFlowMatch(match)
+	// No instruction means dropping the matched packets
+	if v.allow {
+		out := openflow.NewOutPort()
+		out.SetController()
+		action, err := f.NewAction()
+		if err != nil {
+			return err
+		}
+		action.SetOutPort(out)
+		inst, err := f.NewInstruction()
+		if err != nil {
+			return err
+		}
+		inst.ApplyAction(action)
+		flow.SetFlowInstruction(inst)
+	}
+
+	return device.InstallFlow(r.Name(), flow)
+}
+
+// packet is the fields of an IPv4 packet that are matched with the rules.
+type packet struct {
+	srcIP, dstIP     net.IP
+	protocol         uint8
+	srcPort, dstPort uint16
+}
+
+func parsePacket(eth *protocol.Ethernet) (packet, error) {
+	ip := new(protocol.IPv4)
+	if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+		return packet{}, err
+	}
+	p := packet{srcIP: ip.SrcIP, dstIP: ip.DstIP, protocol: ip.Protocol}
+	// Fragments other than the first one do not have the transport header
+	if ip.Offset != 0 {
+		return p, nil
+	}
+
+	switch ip.Protocol {
+	case 6:
+		tcp := new(protocol.TCP)
+		if err := tcp.UnmarshalBinary(ip.Payload); err != nil {
+			return packet{}, err
+		}
+		p.srcPort, p.dstPort = tcp.SrcPort, tcp.DstPort
+	case 17:
+		udp := new(protocol.UDP)
+		if err := udp.UnmarshalBinary(ip.Payload); err != nil {
+			return packet{}, err
+		}
+		p.srcPort, p.dstPort = udp.SrcPort, udp.DstPort
+	}
+
+	return p, nil
+}
+
+// evaluate returns the first rule matched with p. ok is false if there is no matched rule.
+func (r *Firewall) evaluate(p packet) (v rule, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, v := range r.rules {
+		if v.matches(p) {
+			return v, true
+		}
+	}
+
+	return rule{}, false
+}
+
+func (r *Firewall) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	// IPv4?
+	if eth.Type != 0x0800 {
+		return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+	}
+
+	p, err := parsePacket(eth)
+	if err != nil {
+		return err
+	}
+	v, ok := r.evaluate(p)
+	// Allowed by default?
+	if !ok {
+		return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+	}
+	if !v.allow {
+		r.log.Debug(fmt.Sprintf("Firewall: denied by the ACL rule (ID=%v): ingress=%v, %+v", v.id, ingress.ID(), p))
+		return nil
+	}
+	if err := r.BaseProcessor.OnPacketIn(finder, ingress, eth); err != nil || !v.installed {
+		return err
+	}
+	if err := r.installException(ingress, eth, v); err != nil {
+		r.log.Err(fmt.Sprintf("Firewall: failed to install a flow for the exception rule (ID=%v): %v", v.id, err))
+	}
+
+	return nil
+}
+
+// installException copies the flow that the other applications have installed on the ingress device for eth into
+// a flow for the exception rule v, which has a higher priority than the rule. Nothing is installed if there is no
+// such flow, and then the packets keep going through the controller until the applications install one.
+func (r *Firewall) installException(ingress *network.Port, eth *protocol.Ethernet, v rule) error {
+	device := ingress.Device()
+	// Already installed?
+	for _, f := range device.Flows(r.Name()) {
+		if f.Priority == v.priority+1 && app.FlowMatches(f, ingress.Number(), eth) {
+			return nil
+		}
+	}
+
+	target, ok := app.MatchedFlow(device, ingress.Number(), eth, r.Name())
+	// No flow for this packet yet?
+	if !ok {
+		return nil
+	}
+
+	f := device.Factory()
+	match, err := r.newMatch(f, v)
+	if err != nil {
+		return err
+	}
+	narrowMatch(match, target.Match, v)
+	if err := match.Error(); err != nil {
+		return err
+	}
+	inst, err := f.NewInstruction()
+	if err != nil {
+		return err
+	}
+	inst.ApplyAction(target.Action)
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	flow.SetTableID(device.FlowTableID())
+	flow.SetIdleTimeout(exceptionIdleTimeout)
+	flow.SetHardTimeout(exceptionHardTimeout)
+	flow.SetPriority(v.priority + 1)
+	flow.SetFlowMatch(match)
+	flow.SetFlowInstruction(inst)
+	if err := device.InstallFlow(r.Name(), flow); err != nil {
+		return err
+	}
+	r.log.Debug(fmt.Sprintf("Firewall: installed a flow for the exception rule (ID=%v): device=%v, copied from %v", v.id, device.ID(), target))
+
+	return nil
+}
+
+// narrowMatch adds the fields of src that are more specific than the exception rule v into dst, which is the
+// match of v. Both of src and v should match with the same packet.
+func narrowMatch(dst, src openflow.Match, v rule) {
+	if wildcard, port := src.InPort(); !wildcard {
+		dst.SetInPort(port)
+	}
+	if wildcard, mac := src.SrcMAC(); !wildcard {
+		dst.SetSrcMAC(mac)
+	}
+	if wildcard, mac := src.DstMAC(); !wildcard {
+		dst.SetDstMAC(mac)
+	}
+	ruleOnes, _ := v.src.Mask.Size()
+	if ip := src.SrcIP(); ip != nil {
+		if ones, _ := ip.Mask.Size(); ones > ruleOnes {
+			dst.SetSrcIP(ip)
+		}
+	}
+	ruleOnes, _ = v.dst.Mask.Size()
+	if ip := src.DstIP(); ip != nil {
+		if ones, _ := ip.Mask.Size(); ones > ruleOnes {
+			dst.SetDstIP(ip)
+		}
+	}
+	if wildcard, proto := src.IPProtocol(); !wildcard && v.protocol == 0 {
+		dst.SetIPProtocol(proto)
+	}
+	if wildcard, port := src.SrcPort(); !wildcard && v.srcPort == 0 {
+		dst.SetSrcPort(port)
+	}
+	if wildcard, port := src.DstPort(); !wildcard && v.dstPort == 0 {
+		dst.SetDstPort(port)
+	}
+}
+
+func (r *Firewall) OnDeviceUp(finder network.Finder, device *network.Device) error {
+	if err := r.sync(finder); err != nil {
+		r.log.Err(fmt.Sprintf("Firewall: failed to synchronize the ACL rules: %v", err))
+	}
+
+	return r.BaseProcessor.OnDeviceUp(finder, device)
+}
+
+func (r *Firewall) OnTopologyChange(finder network.Finder) error {
+	// The egress ports of the flows for the exception rules may not be on the current path anymore
+	r.removeExceptions(finder.Devices(), func(f network.Flow) bool { return true })
+
+	return r.BaseProcessor.OnTopologyChange(finder)
+}
+
+func (r *Firewall) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	r.removeExceptions(finder.Devices(), func(f network.Flow) bool {
+		wildcard, mac := f.Match.DstMAC()
+		return !wildcard && bytes.Equal(mac, host.MAC())
+	})
+
+	return r.BaseProcessor.OnHostMoved(finder, host, prev)
+}
+
+func (r *Firewall) OnPortDown(finder network.Finder, port *network.Port) error {
+	r.removeExceptions([]*network.Device{port.Device()}, func(f network.Flow) bool {
+		out, ok := f.OutPort()
+		return ok && out.Value() == port.Number()
+	})
+
+	return r.BaseProcessor.OnPortDown(finder, port)
+}
+
+// removeExceptions removes the flows for the exception rules that satisfy filter from devices.
+func (r *Firewall) removeExceptions(devices []*network.Device, filter func(network.Flow) bool) {
+	for _, d := range devices {
+		if d.IsClosed() {
+			continue
+		}
+		for _, f := range d.Flows(r.Name()) {
+			// Flows for the exception rules are the only ones that have the idle timeout
+			if f.IdleTimeout == 0 || !filter(f) {
+				continue
+			}
+			if err := d.RemoveAppFlow(r.Name(), f.Cookie); err != nil {
+				r.log.Err(fmt.Sprintf("Firewall: failed to remove a flow from %v: %v", d.ID(), err))
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package firewall
+
+import (
+	"net"
+	"testing"
+
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/openflow/of13"
+)
+
+func newACL(id uint64, action, src, dst string, proto uint8, dstPort uint16) network.ACL {
+	return network.ACL{
+		ID: id,
+		ACLParam: network.ACLParam{
+			Src:      src,
+			Dst:      dst,
+			Protocol: proto,
+			DstPort:  dstPort,
+			Action:   action,
+		},
+	}
+}
+
+func TestCompile(t *testing.T) {
+	acls := []network.ACL{
+		newACL(1, "allow", "10.0.0.0/24", "10.0.1.10/32", 6, 80),
+		newACL(2, "allow", "10.1.2.0/24", "0.0.0.0/0", 0, 0),
+		newACL(3, "deny", "10.0.0.0/16", "10.0.1.0/24", 0, 0),
+		newACL(4, "allow", "0.0.0.0/0", "0.0.0.0/0", 17, 53),
+	}
+	rules, err := compile(acls)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rule 1 overrides rule 3, but rule 2 does not overlap it, and rule 4 has no lower deny rule.
+	installed := []bool{true, false, true, false}
+	for i, v := range rules {
+		if v.installed != installed[i] {
+			t.Fatalf("unexpected installed flag of rule %v: expected=%v, got=%v", v.id, installed[i], v.installed)
+		}
+		if i > 0 && rules[i-1].priority != v.priority+2 {
+			t.Fatalf("unexpected flow priority of rule %v: %v", v.id, v.priority)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	acls := []network.ACL{
+		newACL(1, "allow", "10.0.0.0/24", "10.0.1.10/32", 6, 80),
+		newACL(2, "deny", "10.0.0.0/16", "10.0.1.0/24", 0, 0),
+	}
+	rules, err := compile(acls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw := &Firewall{rules: rules}
+
+	tests := []struct {
+		p     packet
+		ok    bool
+		allow bool
+	}{
+		{packet{srcIP: net.IPv4(10, 0, 0, 1), dstIP: net.IPv4(10, 0, 1, 10), protocol: 6, srcPort: 40000, dstPort: 80}, true, true},
+		{packet{srcIP: net.IPv4(10, 0, 0, 1), dstIP: net.IPv4(10, 0, 1, 10), protocol: 6, srcPort: 40000, dstPort: 22}, true, false},
+		{packet{srcIP: net.IPv4(10, 0, 5, 1), dstIP: net.IPv4(10, 0, 1, 20), protocol: 17, srcPort: 53, dstPort: 53}, true, false},
+		{packet{srcIP: net.IPv4(10, 1, 0, 1), dstIP: net.IPv4(10, 0, 1, 20), protocol: 1}, false, false},
+	}
+	for _, v := range tests {
+		r, ok := fw.evaluate(v.p)
+		if ok != v.ok || (ok && r.allow != v.allow) {
+			t.Fatalf("unexpected verdict for %+v: expected=%v/%v, got=%v/%v", v.p, v.ok, v.allow, ok, r.allow)
+		}
+	}
+}
+
+func TestNarrowMatch(t *testing.T) {
+	rules, err := compile([]network.ACL{
+		newACL(1, "allow", "10.0.0.0/24", "10.0.1.0/24", 6, 80),
+		newACL(2, "deny", "10.0.0.0/16", "10.0.1.0/24", 0, 0),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	routerMAC := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+
+	// Flow of the router, which forwards the packets toward 10.0.1.10
+	m := of13.NewMatch()
+	m.SetEtherType(0x0800)
+	m.SetDstMAC(routerMAC)
+	m.SetDstIP(&net.IPNet{IP: net.IPv4(10, 0, 1, 10).To4(), Mask: net.CIDRMask(32, 32)})
+
+	fw := &Firewall{}
+	match, err := fw.newMatch(of13.NewFactory(), rules[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	narrowMatch(match, m, rules[0])
+	if wildcard, mac := match.DstMAC(); wildcard || mac.String() != routerMAC.String() {
+		t.Fatalf("unexpected destination MAC: %v", mac)
+	}
+	if ip := match.DstIP(); ip.String() != "10.0.1.10/32" {
+		t.Fatalf("unexpected destination IP: %v", ip)
+	}
+	if ip := match.SrcIP(); ip.String() != "10.0.0.0/24" {
+		t.Fatalf("unexpected source IP: %v", ip)
+	}
+	if wildcard, port := match.DstPort(); wildcard || port != 80 {
+		t.Fatalf("unexpected destination port: %v", port)
+	}
+	if wildcard, _ := match.InPort(); !wildcard {
+		t.Fatal("expected the wildcard ingress port")
+	}
+}
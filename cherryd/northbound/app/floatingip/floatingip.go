@@ -0,0 +1,743 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package floatingip
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	defaultSyncInterval = 5 * time.Second
+	// Priority of the flows that send the packets for the floating IPs to the controller. It is higher than the
+	// flows of L2Switch and Router, and lower than the flows of Firewall.
+	trapPriority = 25
+	// Priority of the flows that translate the addresses of the packets between a host and its peer
+	natPriority    = trapPriority + 1
+	natIdleTimeout = 30
+	// Hard timeout of the translation flows copied from the flows of the next processors, which makes them follow
+	// the changes of the flows they are copied from
+	handOffHardTimeout = 300
+	// Duration during which the replies of a host to a peer are translated after the last packet from the peer
+	peerTimeout = 300 * time.Second
+)
+
+// FloatingIP maps the floating IPs, which are public IPv4 addresses, to the private addresses of the registered
+// hosts (1:1 NAT). It answers ARP requests for a floating IP with the MAC address of its host as the VIP does,
+// and installs flows on every switch that send the packets for the floating IPs and the packets from their hosts
+// to the controller. When a peer sends a packet to a floating IP, or a host sends a packet to a peer outside its
+// network, two flows are installed for the peer: one on the peer's switch that rewrites the destination to the
+// private address, and the other on the host's switch that rewrites the source to the floating IP. The packets
+// from a host to the other hosts in its network are forwarded without translation unless they are the replies to
+// a peer that has sent packets to the floating IP. The packets from a host to the MAC address of the Router or the
+// LoadBalancer are translated and handed to them, and their flows are copied into the translation flows. The
+// switches update the checksums of the rewritten packets.
+type FloatingIP struct {
+	app.BaseProcessor
+	conf     *goconf.ConfigFile
+	log      log.Logger
+	db       database
+	vlanID   uint16
+	interval time.Duration
+	mutex    sync.Mutex
+	// Last finder passed by the events, which is used to synchronize the floating IPs periodically
+	finder   network.Finder
+	mappings []mapping
+	// Peers that have sent packets to the floating IPs, and the time of their last packets
+	peers map[peer]time.Time
+	// Key is the device, and value is the description of the mappings applied to the device
+	applied map[*network.Device]string
+}
+
+type database interface {
+	FloatingIPs() ([]network.FloatingIP, error)
+}
+
+func New(conf *goconf.ConfigFile, log log.Logger, db database) *FloatingIP {
+	return &FloatingIP{
+		conf:     conf,
+		log:      log,
+		db:       db,
+		interval: defaultSyncInterval,
+		applied:  make(map[*network.Device]string),
+		peers:    make(map[peer]time.Time),
+	}
+}
+
+func (r *FloatingIP) Init() error {
+	vlanID, err := r.conf.GetInt("default", "vlan_id")
+	if err != nil || vlanID < 0 || vlanID > 4095 {
+		return errors.New("invalid default VLAN ID in the config file")
+	}
+	r.vlanID = uint16(vlanID)
+
+	if r.conf.HasOption("floatingip", "sync_interval") {
+		v, err := r.conf.GetInt("floatingip", "sync_interval")
+		if err != nil || v <= 0 {
+			return errors.New("invalid floatingip/sync_interval in the config file")
+		}
+		r.interval = time.Duration(v) * time.Second
+	}
+	// Load the floating IPs before any packet is received
+	if err := r.sync(nil); err != nil {
+		return fmt.Errorf("loading the floating IPs: %v", err)
+	}
+	// The floating IPs added, reassigned, or removed through the REST API are applied by the periodic synchronization.
+	go r.run()
+
+	return nil
+}
+
+func (r *FloatingIP) Name() string {
+	return "FloatingIP"
+}
+
+func (r *FloatingIP) String() string {
+	return fmt.Sprintf("%v", r.Name())
+}
+
+func (r *FloatingIP) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mutex.Lock()
+		finder := r.finder
+		r.mutex.Unlock()
+
+		if err := r.sync(finder); err != nil {
+			r.log.Err(fmt.Sprintf("FloatingIP: failed to synchronize the floating IPs: %v", err))
+		}
+	}
+}
+
+// mapping is a floating IP and its host.
+type mapping struct {
+	id      uint64
+	public  net.IP
+	private net.IP
+	// Network of the host
+	network *net.IPNet
+	mac     net.HardwareAddr
+}
+
+func (r mapping) String() string {
+	return fmt.Sprintf("%v:%v:%v:%v", r.id, r.public, r.private, r.mac)
+}
+
+// newMappings converts addresses into the mappings. Invalid ones are skipped.
+func (r *FloatingIP) newMappings(addresses []network.FloatingIP) []mapping {
+	result := make([]mapping, 0, len(addresses))
+	for _, v := range addresses {
+		public := net.ParseIP(v.Address).To4()
+		private, network, err := net.ParseCIDR(v.Host.IP)
+		if public == nil || err != nil || private.To4() == nil {
+			r.log.Err(fmt.Sprintf("FloatingIP: skipping the invalid floating IP (ID=%v): address=%v, host=%v", v.ID, v.Address, v.Host.IP))
+			continue
+		}
+		mac, err := net.ParseMAC(v.Host.MAC)
+		if err != nil {
+			r.log.Err(fmt.Sprintf("FloatingIP: skipping the floating IP (ID=%v) whose host has an invalid MAC address: %v", v.ID, v.Host.MAC))
+			continue
+		}
+		result = append(result, mapping{id: v.ID, public: public, private: private.To4(), network: network, mac: mac})
+	}
+
+	return result
+}
+
+func describe(mappings []mapping) string {
+	v := make([]string, 0, len(mappings))
+	for _, m := range mappings {
+		v = append(v, m.String())
+	}
+
+	return strings.Join(v, ",")
+}
+
+// sync loads the floating IPs from the database, and applies them to the devices whose mappings have been changed
+// since the last synchronization. finder can be nil, and then the floating IPs are only loaded.
+func (r *FloatingIP) sync(finder network.Finder) error {
+	addresses, err := r.db.FloatingIPs()
+	if err != nil {
+		return err
+	}
+	mappings := r.newMappings(addresses)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.mappings = mappings
+	now := time.Now()
+	for k, v := range r.peers {
+		if now.Sub(v) >= peerTimeout {
+			delete(r.peers, k)
+		}
+	}
+	if finder == nil {
+		return nil
+	}
+	r.finder = finder
+
+	desc := describe(mappings)
+	applied := make(map[*network.Device]string)
+	for _, d := range finder.Devices() {
+		if d.IsClosed() {
+			continue
+		}
+		// Devices that have never had a floating IP are also skipped here. The trap flows may have been removed
+		// without us, for example by Device.RemoveAllFlows, so we also check the flows that are actually installed.
+		// Each mapping has two trap flows: one for the floating IP and the other for its host.
+		if r.applied[d] == desc && len(d.PermanentFlows(r.Name())) == len(mappings)*2 {
+			applied[d] = desc
+			continue
+		}
+
+		r.log.Info(fmt.Sprintf("FloatingIP: applying the floating IPs to %v: %v", d.ID(), desc))
+		if err := r.apply(d, mappings); err != nil {
+			// This device will be retried on the next synchronization
+			r.log.Err(fmt.Sprintf("FloatingIP: failed to apply the floating IPs to %v: %v", d.ID(), err))
+			continue
+		}
+		applied[d] = desc
+	}
+	// Forget the disconnected devices
+	r.applied = applied
+
+	return nil
+}
+
+// apply replaces all the flows of this application on device with the ones that send the packets for the floating
+// IPs and the packets from their hosts to the controller. The translation flows of the previous mappings are also
+// removed.
+func (r *FloatingIP) apply(device *network.Device, mappings []mapping) error {
+	if err := device.RemoveAppFlows(r.Name()); err != nil {
+		return err
+	}
+	for _, m := range mappings {
+		if err := r.installTrap(device, nil, m.public); err != nil {
+			return fmt.Errorf("installing the flow for the floating IP %v: %v", m.public, err)
+		}
+		if err := r.installTrap(device, m.private, nil); err != nil {
+			return fmt.Errorf("installing the flow for the host %v: %v", m.private, err)
+		}
+	}
+
+	return nil
+}
+
+// installTrap installs the flow that sends the packets from srcIP or to dstIP to the controller. One of srcIP and
+// dstIP should be nil.
+func (r *FloatingIP) installTrap(device *network.Device, srcIP, dstIP net.IP) error {
+	f := device.Factory()
+	match, err := f.NewMatch()
+	if err != nil {
+		return err
+	}
+	match.SetVLANID(r.vlanID)
+	match.SetEtherType(0x0800)
+	if srcIP != nil {
+		match.SetSrcIP(&net.IPNet{IP: srcIP, Mask: net.CIDRMask(32, 32)})
+	}
+	if dstIP != nil {
+		match.SetDstIP(&net.IPNet{IP: dstIP, Mask: net.CIDRMask(32, 32)})
+	}
+
+	out := openflow.NewOutPort()
+	out.SetController()
+	action, err := f.NewAction()
+	if err != nil {
+		return err
+	}
+	action.SetOutPort(out)
+	inst, err := f.NewInstruction()
+	if err != nil {
+		return err
+	}
+	inst.ApplyAction(action)
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	flow.SetTableID(device.FlowTableID())
+	flow.SetIdleTimeout(0)
+	flow.SetHardTimeout(0)
+	flow.SetPriority(trapPriority)
+	flow.SetFlowMatch(match)
+	flow.SetFlowInstruction(inst)
+
+	return device.InstallFlow(r.Name(), flow)
+}
+
+// findMapping returns the mapping whose floating IP is ip.
+func (r *FloatingIP) findMapping(ip net.IP) (m mapping, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, v := range r.mappings {
+		if v.public.Equal(ip) {
+			return v, true
+		}
+	}
+
+	return mapping{}, false
+}
+
+// findHost returns the mapping whose host has the private address ip.
+func (r *FloatingIP) findHost(ip net.IP) (m mapping, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, v := range r.mappings {
+		if v.private.Equal(ip) {
+			return v, true
+		}
+	}
+
+	return mapping{}, false
+}
+
+// peer is a peer that has sent packets to the floating IP of a mapping.
+type peer struct {
+	mapping uint64
+	ip      string
+}
+
+func (r *FloatingIP) addPeer(m mapping, ip net.IP) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.peers[peer{mapping: m.id, ip: ip.String()}] = time.Now()
+}
+
+// isPeer returns whether ip has sent packets to the floating IP of m recently.
+func (r *FloatingIP) isPeer(m mapping, ip net.IP) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v, ok := r.peers[peer{mapping: m.id, ip: ip.String()}]
+	return ok && time.Since(v) < peerTimeout
+}
+
+func (r *FloatingIP) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	switch eth.Type {
+	case 0x0806:
+		drop, err := r.handleARP(ingress, eth)
+		if drop || err != nil {
+			return err
+		}
+	case 0x0800:
+		ip := new(protocol.IPv4)
+		if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+			return err
+		}
+		if m, ok := r.findMapping(ip.DstIP); ok {
+			return r.translate(finder, ingress, eth, ip, m)
+		}
+		if m, ok := r.findHost(ip.SrcIP); ok {
+			drop, err := r.handleHost(finder, ingress, eth, ip, m)
+			if drop || err != nil {
+				return err
+			}
+		}
+	}
+
+	return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+}
+
+// handleARP answers the ARP request for the floating IPs with the MAC addresses of their hosts. drop is false
+// if the request is not for the floating IPs.
+func (r *FloatingIP) handleARP(ingress *network.Port, eth *protocol.Ethernet) (drop bool, err error) {
+	arp := new(protocol.ARP)
+	if err := arp.UnmarshalBinary(eth.Payload); err != nil {
+		return false, err
+	}
+	// ARP request?
+	if arp.Operation != 1 {
+		return false, nil
+	}
+	m, ok := r.findMapping(arp.TPA)
+	if !ok {
+		return false, nil
+	}
+	r.log.Debug(fmt.Sprintf("FloatingIP: ARP request for the floating IP %v from %v", arp.TPA, ingress.ID()))
+
+	reply, err := protocol.NewARPReply(m.mac, arp.SHA, arp.TPA, arp.SPA).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  m.mac,
+		DstMAC:  arp.SHA,
+		Type:    0x0806,
+		Payload: reply,
+	}).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+
+	return true, r.PacketOut(ingress, packet)
+}
+
+// translate forwards the packet heading to the floating IP of m to its host after installing the translation
+// flows between the host and the sender of the packet.
+func (r *FloatingIP) translate(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4, m mapping) error {
+	node, err := finder.Node(m.mac)
+	if err != nil {
+		return fmt.Errorf("locating a node (MAC=%v): %v", m.mac, err)
+	}
+	// Unknown or disconnected host?
+	if node == nil || node.Port().Value().IsPortDown() || node.Port().Value().IsLinkDown() {
+		r.log.Debug(fmt.Sprintf("FloatingIP: unreachable host %v of the floating IP %v", m.private, m.public))
+		return nil
+	}
+	egress := app.NextHop(finder, ingress.Device(), node.Port())
+	reverse := app.NextHop(finder, node.Port().Device(), ingress)
+	if egress == nil || reverse == nil {
+		r.log.Debug(fmt.Sprintf("FloatingIP: no path between %v and the host %v", ingress.ID(), m.private))
+		return nil
+	}
+	// Drop this packet if it goes back to the ingress port to avoid duplicated packets
+	if ingress.Device().ID() == egress.Device().ID() && ingress.Number() == egress.Number() {
+		return nil
+	}
+	// The replies of the host to this peer should be translated even if the peer is in the network of the host
+	r.addPeer(m, ip.SrcIP)
+
+	// Install the flow for the replies first so that the first reply is not sent with the private address
+	reply := rewrite{srcIP: m.private, dstIP: ip.SrcIP, setSrcIP: m.public, setDstMAC: eth.SrcMAC}
+	if err := r.installFlow(node.Port().Device(), reply, reverse.Number()); err != nil {
+		return fmt.Errorf("installing a flow on %v: %v", node.Port().Device().ID(), err)
+	}
+	request := rewrite{srcIP: ip.SrcIP, dstIP: m.public, setDstIP: m.private, setDstMAC: m.mac}
+	if err := r.installFlow(ingress.Device(), request, egress.Number()); err != nil {
+		return fmt.Errorf("installing a flow on %v: %v", ingress.Device().ID(), err)
+	}
+
+	payload, err := protocol.RewriteIPv4(eth.Payload, nil, m.private, 0, 0)
+	if err != nil {
+		return err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  eth.SrcMAC,
+		DstMAC:  m.mac,
+		Type:    0x0800,
+		Payload: payload,
+	}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return r.PacketOut(egress, packet)
+}
+
+// handleHost translates the source of the packet from the host of m to the floating IP if the packet goes to a peer
+// outside the network of the host, or to a peer that has sent packets to the floating IP. The other packets are
+// forwarded without translation by the next processors, and then drop is false.
+func (r *FloatingIP) handleHost(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4, m mapping) (drop bool, err error) {
+	// Broadcast and multicast packets are not translated
+	if ip.DstIP.Equal(net.IPv4bcast) || ip.DstIP.IsMulticast() {
+		return false, nil
+	}
+	if m.network.Contains(ip.DstIP) && !r.isPeer(m, ip.DstIP) {
+		if err := r.bypass(finder, ingress, eth, ip); err != nil {
+			r.log.Err(fmt.Sprintf("FloatingIP: failed to install a flow for the host %v: %v", m.private, err))
+		}
+		return false, nil
+	}
+
+	node, err := finder.Node(eth.DstMAC)
+	if err != nil {
+		return true, fmt.Errorf("locating a node (MAC=%v): %v", eth.DstMAC, err)
+	}
+	// The next hop may be the Router or the LoadBalancer, whose MAC address is not a learned node
+	if node == nil {
+		return true, r.handOff(finder, ingress, eth, ip, m)
+	}
+	// The packet should not leave with the private address
+	if node.Port().Value().IsPortDown() || node.Port().Value().IsLinkDown() {
+		r.log.Debug(fmt.Sprintf("FloatingIP: unreachable next hop %v of the packet from %v to %v", eth.DstMAC, m.private, ip.DstIP))
+		return true, nil
+	}
+	egress := app.NextHop(finder, ingress.Device(), node.Port())
+	reverse := app.NextHop(finder, node.Port().Device(), ingress)
+	if egress == nil || reverse == nil {
+		r.log.Debug(fmt.Sprintf("FloatingIP: no path between %v and the next hop %v", ingress.ID(), eth.DstMAC))
+		return true, nil
+	}
+	// Drop this packet if it goes back to the ingress port to avoid duplicated packets
+	if ingress.Device().ID() == egress.Device().ID() && ingress.Number() == egress.Number() {
+		return true, nil
+	}
+
+	// Install the flow for the replies first so that the first reply does not come to the controller
+	request := rewrite{srcIP: ip.DstIP, dstIP: m.public, setDstIP: m.private, setDstMAC: m.mac}
+	if err := r.installFlow(node.Port().Device(), request, reverse.Number()); err != nil {
+		return true, fmt.Errorf("installing a flow on %v: %v", node.Port().Device().ID(), err)
+	}
+	reply := rewrite{srcIP: m.private, dstIP: ip.DstIP, setSrcIP: m.public, setDstMAC: eth.DstMAC}
+	if err := r.installFlow(ingress.Device(), reply, egress.Number()); err != nil {
+		return true, fmt.Errorf("installing a flow on %v: %v", ingress.Device().ID(), err)
+	}
+
+	payload, err := protocol.RewriteIPv4(eth.Payload, m.public, nil, 0, 0)
+	if err != nil {
+		return true, err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  eth.SrcMAC,
+		DstMAC:  eth.DstMAC,
+		Type:    0x0800,
+		Payload: payload,
+	}).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+
+	return true, r.PacketOut(egress, packet)
+}
+
+// handOff translates the source of the packet from the host of m to the floating IP, and then passes it to the
+// next processors that own the destination MAC address, such as Router and LoadBalancer. The flow that they have
+// installed on the ingress device for the translated packet is copied into the translation flow of the host so that
+// the following packets do not come to the controller.
+func (r *FloatingIP) handOff(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4, m mapping) error {
+	payload, err := protocol.RewriteIPv4(eth.Payload, m.public, nil, 0, 0)
+	if err != nil {
+		return err
+	}
+	translated := &protocol.Ethernet{
+		SrcMAC:  eth.SrcMAC,
+		DstMAC:  eth.DstMAC,
+		Type:    0x0800,
+		Payload: payload,
+	}
+	if err := r.BaseProcessor.OnPacketIn(finder, ingress, translated); err != nil {
+		return err
+	}
+
+	device := ingress.Device()
+	target, ok := app.MatchedFlow(device, ingress.Number(), translated, r.Name())
+	// The next processors have not installed a flow for the packet, or they have dropped it.
+	if !ok {
+		r.log.Debug(fmt.Sprintf("FloatingIP: no flow of the next hop %v for the packet from %v to %v", eth.DstMAC, m.private, ip.DstIP))
+		return nil
+	}
+	action, err := app.CopyAction(device.Factory(), target.Action)
+	if err != nil {
+		return err
+	}
+	request := rewrite{srcIP: m.private, dstIP: ip.DstIP, setSrcIP: m.public}
+	if err := r.installAction(device, request, action, handOffHardTimeout); err != nil {
+		return fmt.Errorf("installing a flow on %v: %v", device.ID(), err)
+	}
+
+	return nil
+}
+
+// bypass installs the flow that forwards the packets from the host to a host in its network without translation
+// so that they are not sent to the controller by the trap flow. Nothing is installed if the destination is not
+// a known host.
+func (r *FloatingIP) bypass(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4) error {
+	node, err := finder.Node(eth.DstMAC)
+	if err != nil {
+		return fmt.Errorf("locating a node (MAC=%v): %v", eth.DstMAC, err)
+	}
+	// Unknown or disconnected node?
+	if node == nil || node.Port().Value().IsPortDown() || node.Port().Value().IsLinkDown() {
+		return nil
+	}
+	egress := app.NextHop(finder, ingress.Device(), node.Port())
+	// Going back to the ingress port?
+	if egress == nil || (ingress.Device().ID() == egress.Device().ID() && ingress.Number() == egress.Number()) {
+		return nil
+	}
+
+	return r.installFlow(ingress.Device(), rewrite{srcIP: ip.SrcIP, dstIP: ip.DstIP}, egress.Number())
+}
+
+// rewrite is the match and the rewriting of a translation flow. The flow only forwards the packets if all the
+// rewriting fields are nil.
+type rewrite struct {
+	srcIP, dstIP       net.IP
+	setSrcIP, setDstIP net.IP
+	setDstMAC          net.HardwareAddr
+}
+
+// isTranslation returns whether v rewrites the addresses of the packets.
+func (r rewrite) isTranslation() bool {
+	return r.setSrcIP != nil || r.setDstIP != nil
+}
+
+// findFlow returns the flow whose match is same with v on device.
+func (r *FloatingIP) findFlow(device *network.Device, v rewrite) (flow network.Flow, ok bool) {
+	for _, f := range device.Flows(r.Name()) {
+		src, dst := f.Match.SrcIP(), f.Match.DstIP()
+		if f.Priority == natPriority && src != nil && dst != nil && src.IP.Equal(v.srcIP) && dst.IP.Equal(v.dstIP) {
+			return f, true
+		}
+	}
+
+	return network.Flow{}, false
+}
+
+// isTranslation returns whether the flow f rewrites the addresses of the packets.
+func isTranslation(f network.Flow) bool {
+	if f.Action == nil {
+		return false
+	}
+	src, _ := f.Action.SrcIP()
+	dst, _ := f.Action.DstIP()
+
+	return src || dst
+}
+
+// installFlow installs the flow that applies the rewriting of v to the packets matched with v and sends them to
+// outPort.
+func (r *FloatingIP) installFlow(device *network.Device, v rewrite, outPort uint32) error {
+	out := openflow.NewOutPort()
+	out.SetValue(outPort)
+	action, err := device.Factory().NewAction()
+	if err != nil {
+		return err
+	}
+	action.SetOutPort(out)
+
+	return r.installAction(device, v, action, 0)
+}
+
+// installAction installs the flow that applies the rewriting of v and then action to the packets matched with v.
+func (r *FloatingIP) installAction(device *network.Device, v rewrite, action openflow.Action, hardTimeout uint16) error {
+	if f, ok := r.findFlow(device, v); ok {
+		if isTranslation(f) == v.isTranslation() {
+			return nil
+		}
+		// The bypass flow of a host is replaced with the translation flow when the peer starts to send packets to
+		// the floating IP, and vice versa.
+		if err := device.RemoveAppFlow(r.Name(), f.Cookie); err != nil {
+			return err
+		}
+	}
+
+	f := device.Factory()
+	match, err := f.NewMatch()
+	if err != nil {
+		return err
+	}
+	match.SetVLANID(r.vlanID)
+	match.SetEtherType(0x0800)
+	match.SetSrcIP(&net.IPNet{IP: v.srcIP, Mask: net.CIDRMask(32, 32)})
+	match.SetDstIP(&net.IPNet{IP: v.dstIP, Mask: net.CIDRMask(32, 32)})
+
+	if v.setSrcIP != nil {
+		action.SetSrcIP(v.setSrcIP)
+	}
+	if v.setDstIP != nil {
+		action.SetDstIP(v.setDstIP)
+	}
+	if v.setDstMAC != nil {
+		action.SetDstMAC(v.setDstMAC)
+	}
+	inst, err := f.NewInstruction()
+	if err != nil {
+		return err
+	}
+	inst.ApplyAction(action)
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	flow.SetTableID(device.FlowTableID())
+	flow.SetIdleTimeout(natIdleTimeout)
+	flow.SetHardTimeout(hardTimeout)
+	flow.SetPriority(natPriority)
+	flow.SetFlowMatch(match)
+	flow.SetFlowInstruction(inst)
+	if err := device.InstallFlow(r.Name(), flow); err != nil {
+		return err
+	}
+	out := action.OutPort()
+	r.log.Debug(fmt.Sprintf("FloatingIP: installed a flow rule.. Device=%v, SrcIP=%v, DstIP=%v, OutPort=%v", device.ID(), v.srcIP, v.dstIP, out.Value()))
+
+	return nil
+}
+
+func (r *FloatingIP) OnDeviceUp(finder network.Finder, device *network.Device) error {
+	if err := r.sync(finder); err != nil {
+		r.log.Err(fmt.Sprintf("FloatingIP: failed to synchronize the floating IPs: %v", err))
+	}
+
+	return r.BaseProcessor.OnDeviceUp(finder, device)
+}
+
+func (r *FloatingIP) OnTopologyChange(finder network.Finder) error {
+	// The egress ports of the translation flows may not be on the current path anymore
+	r.removeTranslations(finder.Devices(), func(f network.Flow) bool { return true })
+
+	return r.BaseProcessor.OnTopologyChange(finder)
+}
+
+func (r *FloatingIP) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	r.removeTranslations(finder.Devices(), func(f network.Flow) bool { return true })
+
+	return r.BaseProcessor.OnHostMoved(finder, host, prev)
+}
+
+func (r *FloatingIP) OnPortDown(finder network.Finder, port *network.Port) error {
+	r.removeTranslations([]*network.Device{port.Device()}, func(f network.Flow) bool {
+		out, ok := f.OutPort()
+		return ok && out.Value() == port.Number()
+	})
+
+	return r.BaseProcessor.OnPortDown(finder, port)
+}
+
+// removeTranslations removes the translation flows that satisfy filter from devices.
+func (r *FloatingIP) removeTranslations(devices []*network.Device, filter func(network.Flow) bool) {
+	for _, d := range devices {
+		if d.IsClosed() {
+			continue
+		}
+		for _, f := range d.Flows(r.Name()) {
+			// Translation and bypass flows are the only ones that have the idle timeout
+			if f.IdleTimeout == 0 || !filter(f) {
+				continue
+			}
+			if err := d.RemoveAppFlow(r.Name(), f.Cookie); err != nil {
+				r.log.Err(fmt.Sprintf("FloatingIP: failed to remove a flow from %v: %v", d.ID(), err))
+			}
+		}
+	}
+}
@@ -0,0 +1,157 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package floatingip
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+func TestNewMappings(t *testing.T) {
+	addresses := []network.FloatingIP{
+		{
+			ID:      1,
+			Address: "203.0.113.10",
+			Host:    network.Host{IP: "10.0.0.5/24", MAC: "00:00:00:00:00:05"},
+		},
+	}
+
+	r := &FloatingIP{}
+	mappings := r.newMappings(addresses)
+	if len(mappings) != 1 {
+		t.Fatalf("unexpected number of mappings: expected=1, got=%v", len(mappings))
+	}
+	m := mappings[0]
+	if !m.public.Equal(net.IPv4(203, 0, 113, 10)) || !m.private.Equal(net.IPv4(10, 0, 0, 5)) || m.mac.String() != "00:00:00:00:00:05" {
+		t.Fatalf("unexpected mapping: %v", m)
+	}
+	// The packets from the host to the peers outside its network are translated
+	if !m.network.Contains(net.IPv4(10, 0, 0, 200)) || m.network.Contains(net.IPv4(198, 51, 100, 1)) {
+		t.Fatalf("unexpected network of the mapping: %v", m.network)
+	}
+
+	// Reassigning the floating IP to another host should change the description so that the flows are replaced
+	desc := describe(mappings)
+	addresses[0].Host = network.Host{IP: "10.0.0.6/24", MAC: "00:00:00:00:00:06"}
+	if describe(r.newMappings(addresses)) == desc {
+		t.Fatal("description should be changed after reassigning the floating IP")
+	}
+}
+
+func TestPeers(t *testing.T) {
+	r := &FloatingIP{peers: make(map[peer]time.Time)}
+	m := mapping{id: 1}
+	peerIP := net.IPv4(10, 0, 0, 200)
+	if r.isPeer(m, peerIP) {
+		t.Fatal("unknown peer should not be translated")
+	}
+	r.addPeer(m, peerIP)
+	if !r.isPeer(m, peerIP) {
+		t.Fatal("peer that has sent a packet to the floating IP should be translated")
+	}
+	if r.isPeer(mapping{id: 2}, peerIP) {
+		t.Fatal("peer of another floating IP should not be translated")
+	}
+	r.peers[peer{mapping: 1, ip: peerIP.String()}] = time.Now().Add(-peerTimeout)
+	if r.isPeer(m, peerIP) {
+		t.Fatal("expired peer should not be translated")
+	}
+}
+
+// finder knows no node, like the MAC addresses of Router and LoadBalancer.
+type finder struct{}
+
+func (r finder) Device(id string) *network.Device                        { return nil }
+func (r finder) Devices() []*network.Device                              { return nil }
+func (r finder) IsEnabledBySTP(p *network.Port) bool                     { return true }
+func (r finder) IsEdge(p *network.Port) bool                             { return false }
+func (r finder) Node(mac net.HardwareAddr) (*network.Node, error)        { return nil, nil }
+func (r finder) Path(srcDeviceID, dstDeviceID string) [][2]*network.Port { return nil }
+
+var errRouter = errors.New("router stops here")
+
+// router records the packets passed by FloatingIP.
+type router struct {
+	app.BaseProcessor
+	packets []*protocol.Ethernet
+}
+
+func (r *router) String() string {
+	return "router"
+}
+
+func (r *router) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	r.packets = append(r.packets, eth)
+	// Stop here so that FloatingIP does not look for the flows of the router on the ingress device
+	return errRouter
+}
+
+func TestHandOffToRouter(t *testing.T) {
+	m := mapping{
+		id:      1,
+		public:  net.IPv4(203, 0, 113, 10).To4(),
+		private: net.IPv4(10, 0, 0, 5).To4(),
+		network: &net.IPNet{IP: net.IPv4(10, 0, 0, 0).To4(), Mask: net.CIDRMask(24, 32)},
+		mac:     net.HardwareAddr{0x00, 0x00, 0x00, 0x00, 0x00, 0x05},
+	}
+	next := &router{}
+	r := &FloatingIP{mappings: []mapping{m}, peers: make(map[peer]time.Time)}
+	r.SetNext(next)
+
+	// Packet from the floating host to another network through the router
+	dst := net.IPv4(10, 0, 1, 10).To4()
+	payload, err := protocol.NewIPv4(m.private, dst, 17, make([]byte, 8)).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	routerMAC := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	eth := &protocol.Ethernet{SrcMAC: m.mac, DstMAC: routerMAC, Type: 0x0800, Payload: payload}
+	ip := new(protocol.IPv4)
+	if err := ip.UnmarshalBinary(payload); err != nil {
+		t.Fatal(err)
+	}
+
+	drop, err := r.handleHost(finder{}, network.NewPort(nil, 1), eth, ip, m)
+	if !drop || err != errRouter {
+		t.Fatalf("unexpected result: drop=%v, err=%v", drop, err)
+	}
+	if len(next.packets) != 1 {
+		t.Fatalf("unexpected number of the packets passed to the router: %v", len(next.packets))
+	}
+	v := next.packets[0]
+	if v.DstMAC.String() != routerMAC.String() {
+		t.Fatalf("unexpected destination MAC: %v", v.DstMAC)
+	}
+	translated := new(protocol.IPv4)
+	if err := translated.UnmarshalBinary(v.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if !translated.SrcIP.Equal(m.public) || !translated.DstIP.Equal(dst) {
+		t.Fatalf("unexpected addresses of the packet passed to the router: src=%v, dst=%v", translated.SrcIP, translated.DstIP)
+	}
+}
@@ -0,0 +1,180 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package app
+
+import (
+	"bytes"
+	"net"
+
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+// header is the fields of a packet that are compared with the flow matches.
+type header struct {
+	inPort           uint32
+	eth              *protocol.Ethernet
+	srcIP, dstIP     net.IP
+	protocol         uint8
+	srcPort, dstPort uint16
+}
+
+func parseHeader(inPort uint32, eth *protocol.Ethernet) header {
+	h := header{inPort: inPort, eth: eth}
+	// IPv4?
+	if eth.Type != 0x0800 {
+		return h
+	}
+	ip := new(protocol.IPv4)
+	if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+		return h
+	}
+	h.srcIP, h.dstIP, h.protocol = ip.SrcIP, ip.DstIP, ip.Protocol
+	// Fragments other than the first one do not have the transport header
+	if ip.Offset != 0 {
+		return h
+	}
+
+	switch ip.Protocol {
+	case 6:
+		tcp := new(protocol.TCP)
+		if err := tcp.UnmarshalBinary(ip.Payload); err == nil {
+			h.srcPort, h.dstPort = tcp.SrcPort, tcp.DstPort
+		}
+	case 17:
+		udp := new(protocol.UDP)
+		if err := udp.UnmarshalBinary(ip.Payload); err == nil {
+			h.srcPort, h.dstPort = udp.SrcPort, udp.DstPort
+		}
+	}
+
+	return h
+}
+
+func (r header) matches(f network.Flow) bool {
+	m := f.Match
+	if wildcard, port := m.InPort(); !wildcard && port.Value() != r.inPort {
+		return false
+	}
+	if wildcard, mac := m.SrcMAC(); !wildcard && !bytes.Equal(mac, r.eth.SrcMAC) {
+		return false
+	}
+	if wildcard, mac := m.DstMAC(); !wildcard && !bytes.Equal(mac, r.eth.DstMAC) {
+		return false
+	}
+	if wildcard, t := m.EtherType(); !wildcard && t != r.eth.Type {
+		return false
+	}
+	if !containsIP(m.SrcIP(), r.srcIP) || !containsIP(m.DstIP(), r.dstIP) {
+		return false
+	}
+	if wildcard, proto := m.IPProtocol(); !wildcard && proto != r.protocol {
+		return false
+	}
+	if wildcard, port := m.SrcPort(); !wildcard && port != r.srcPort {
+		return false
+	}
+	if wildcard, port := m.DstPort(); !wildcard && port != r.dstPort {
+		return false
+	}
+
+	return true
+}
+
+// containsIP returns whether network contains ip. A nil or zero-length network contains any address.
+func containsIP(network *net.IPNet, ip net.IP) bool {
+	if network == nil {
+		return true
+	}
+	if ones, _ := network.Mask.Size(); ones == 0 {
+		return true
+	}
+
+	return ip != nil && network.Contains(ip)
+}
+
+// FlowMatches returns whether the packet eth received from the port inPort matches with the flow f.
+func FlowMatches(f network.Flow, inPort uint32, eth *protocol.Ethernet) bool {
+	return parseHeader(inPort, eth).matches(f)
+}
+
+// MatchedFlow returns the highest priority flow on device that forwards the packet eth received from the port
+// inPort. The flows installed by the application whose name is exclude, the flows without actions, and the flows
+// that send the packets to the controller are ignored. ok is false if there is no such flow.
+func MatchedFlow(device *network.Device, inPort uint32, eth *protocol.Ethernet, exclude string) (flow network.Flow, ok bool) {
+	h := parseHeader(inPort, eth)
+	for _, f := range device.AllFlows() {
+		if f.Owner == exclude || f.Action == nil || !h.matches(f) {
+			continue
+		}
+		if out := f.Action.OutPort(); out.IsController() {
+			continue
+		}
+		if !ok || f.Priority > flow.Priority {
+			flow, ok = f, true
+		}
+	}
+
+	return flow, ok
+}
+
+// CopyAction returns a new action made by f that has the same rewrites and outputs as src.
+func CopyAction(f openflow.Factory, src openflow.Action) (openflow.Action, error) {
+	action, err := f.NewAction()
+	if err != nil {
+		return nil, err
+	}
+	if ok, mac := src.SrcMAC(); ok {
+		action.SetSrcMAC(mac)
+	}
+	if ok, mac := src.DstMAC(); ok {
+		action.SetDstMAC(mac)
+	}
+	if ok, ip := src.SrcIP(); ok {
+		action.SetSrcIP(ip)
+	}
+	if ok, ip := src.DstIP(); ok {
+		action.SetDstIP(ip)
+	}
+	if ok, proto, port := src.SrcPort(); ok {
+		action.SetSrcPort(proto, port)
+	}
+	if ok, proto, port := src.DstPort(); ok {
+		action.SetDstPort(proto, port)
+	}
+	if ok, vid := src.VLANID(); ok {
+		action.SetVLANID(vid)
+	}
+	if ok, queue := src.Queue(); ok {
+		action.SetQueue(queue)
+	}
+	if src.DecTTL() {
+		action.SetDecTTL()
+	}
+	for _, m := range src.Mirrors() {
+		action.AddMirror(m)
+	}
+	action.SetOutPort(src.OutPort())
+
+	return action, action.Error()
+}
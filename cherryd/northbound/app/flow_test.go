@@ -0,0 +1,73 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package app
+
+import (
+	"net"
+	"testing"
+
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/openflow/of13"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+func newTCPPacket(t *testing.T, dstMAC net.HardwareAddr, src, dst net.IP, dstPort uint16) *protocol.Ethernet {
+	segment := &protocol.TCP{SrcPort: 40000, DstPort: dstPort}
+	segment.SetPseudoHeader(src, dst)
+	tcp, err := segment.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := protocol.NewIPv4(src, dst, 6, tcp).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &protocol.Ethernet{
+		SrcMAC:  net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x66},
+		DstMAC:  dstMAC,
+		Type:    0x0800,
+		Payload: ip,
+	}
+}
+
+func TestFlowMatches(t *testing.T) {
+	routerMAC := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	// Flow of the router, which forwards the packets toward 10.0.1.10
+	m := of13.NewMatch()
+	m.SetEtherType(0x0800)
+	m.SetDstMAC(routerMAC)
+	m.SetDstIP(&net.IPNet{IP: net.IPv4(10, 0, 1, 10).To4(), Mask: net.CIDRMask(32, 32)})
+	f := network.Flow{Match: m}
+
+	if !FlowMatches(f, 1, newTCPPacket(t, routerMAC, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 1, 10), 80)) {
+		t.Fatal("expected the router flow to match the packet")
+	}
+	if FlowMatches(f, 1, newTCPPacket(t, routerMAC, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 1, 11), 80)) {
+		t.Fatal("expected the router flow not to match the packet to another host")
+	}
+	m.SetIPProtocol(6)
+	m.SetDstPort(22)
+	if FlowMatches(f, 1, newTCPPacket(t, routerMAC, net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 1, 10), 80)) {
+		t.Fatal("expected the flow for another port not to match the packet")
+	}
+}
@@ -26,6 +26,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/dlintw/goconf"
@@ -44,6 +46,9 @@ type L2Switch struct {
 	vlanID    uint16
 	cache     *flowCache
 	stormCtrl *stormController
+	mutex     sync.Mutex
+	// Ports that were ends of the spanning tree links on the last topology change. Key is the port ID.
+	treePorts map[string]struct{}
 }
 
 type flowCache struct {
@@ -83,6 +88,19 @@ func (r *flowCache) add(flow flowParam) {
 	r.cache.Add(r.getKeyString(flow), time.Now())
 }
 
+func (r *flowCache) remove(flow flowParam) {
+	r.cache.Remove(r.getKeyString(flow))
+}
+
+// removeDstMAC removes all the cache entries whose destination MAC address is mac.
+func (r *flowCache) removeDstMAC(mac net.HardwareAddr) {
+	for _, k := range r.cache.Keys() {
+		if strings.Contains(k.(string), fmt.Sprintf("/%v/", mac)) {
+			r.cache.Remove(k)
+		}
+	}
+}
+
 func New(conf *goconf.ConfigFile, log log.Logger) *L2Switch {
 	return &L2Switch{
 		conf:      conf,
@@ -95,29 +113,7 @@ func New(conf *goconf.ConfigFile, log log.Logger) *L2Switch {
 type flooder struct{}
 
 func (r *flooder) flood(ingress *network.Port, packet []byte) error {
-	f := ingress.Device().Factory()
-
-	inPort := openflow.NewInPort()
-	inPort.SetValue(ingress.Number())
-
-	outPort := openflow.NewOutPort()
-	outPort.SetFlood()
-
-	action, err := f.NewAction()
-	if err != nil {
-		return err
-	}
-	action.SetOutPort(outPort)
-
-	out, err := f.NewPacketOut()
-	if err != nil {
-		return err
-	}
-	out.SetInPort(inPort)
-	out.SetAction(action)
-	out.SetData(packet)
-
-	return ingress.Device().SendMessage(out)
+	return ingress.Device().Flood(ingress, packet)
 }
 
 func (r *L2Switch) Init() error {
@@ -189,7 +185,8 @@ func (r *L2Switch) installFlow(p flowParam) error {
 	flow.SetFlowMatch(match)
 	flow.SetFlowInstruction(inst)
 
-	if err := p.device.SendMessage(flow); err != nil {
+	// Forwarding flows of the hosts are mirrored if they are related to the mirrored ports
+	if err := p.device.InstallForwardingFlow(r.Name(), flow); err != nil {
 		return err
 	}
 	barrier, err := f.NewBarrierRequest()
@@ -310,28 +307,168 @@ func (r *L2Switch) processPacket(finder network.Finder, ingress *network.Port, e
 func (r *L2Switch) OnTopologyChange(finder network.Finder) error {
 	r.log.Debug("L2Switch: OnTopologyChange..")
 
-	// We should remove all edges from all switch devices when the network topology is changed.
-	// Otherwise, installed flow rules in switches may result in incorrect packet routing based on the previous topology.
-	if err := r.removeAllFlows(finder.Devices()); err != nil {
-		return err
-	}
+	// Installed flow rules in switches may result in incorrect packet routing based on the previous topology.
+	// So, we remove the flows whose egress port is not on the current path toward their destination nodes.
+	// Other flows are still valid and we leave them untouched to avoid re-learning storm of PACKET_INs.
+	r.removeStaleFlows(finder)
 
 	return r.BaseProcessor.OnTopologyChange(finder)
 }
 
-func (r *L2Switch) removeAllFlows(devices []*network.Device) error {
-	r.log.Debug("L2Switch: removing all flows from all devices..")
+// treePorts returns the ports that are ends of the spanning tree links. Key is the port ID.
+func treePorts(finder network.Finder) map[string]struct{} {
+	v := make(map[string]struct{})
+	for _, d := range finder.Devices() {
+		if d.IsClosed() {
+			continue
+		}
+		for _, p := range d.Ports() {
+			if finder.IsEdge(p) && finder.IsEnabledBySTP(p) {
+				v[portID(d, p.Number())] = struct{}{}
+			}
+		}
+	}
+
+	return v
+}
+
+func portID(d *network.Device, num uint32) string {
+	return fmt.Sprintf("%v:%v", d.ID(), num)
+}
 
-	for _, d := range devices {
+func (r *L2Switch) removeStaleFlows(finder network.Finder) {
+	current := treePorts(finder)
+	r.mutex.Lock()
+	prev := r.treePorts
+	r.treePorts = current
+	r.mutex.Unlock()
+
+	// The paths among the switches are not changed if the spanning tree links are same as before.
+	if prev != nil && len(prev) == len(current) {
+		changed := false
+		for id := range current {
+			if _, ok := prev[id]; !ok {
+				changed = true
+				break
+			}
+		}
+		if !changed {
+			r.log.Debug("L2Switch: spanning tree links are not changed")
+			return
+		}
+	}
+
+	checker := &staleFlowChecker{
+		log:     r.log,
+		finder:  finder,
+		prev:    prev,
+		current: current,
+		nodes:   make(map[string]*network.Node),
+		egress:  make(map[[2]string]*network.Port),
+	}
+	for _, d := range finder.Devices() {
 		if d.IsClosed() {
 			continue
 		}
-		if err := d.RemoveAllFlows(); err != nil {
-			return err
+
+		for _, f := range d.Flows(r.Name()) {
+			wildcard, dstMAC := f.Match.DstMAC()
+			outPort, ok := f.OutPort()
+			if wildcard || !ok {
+				continue
+			}
+			if !checker.isStale(d, dstMAC, outPort.Value()) {
+				continue
+			}
+
+			r.log.Debug(fmt.Sprintf("L2Switch: removing a stale flow: deviceID=%v, dstMAC=%v, outPort=%v", d.ID(), dstMAC, outPort.Value()))
+			if err := d.RemoveAppFlow(r.Name(), f.Cookie); err != nil {
+				// Keep going to remove the remaining stale flows.
+				r.log.Err(fmt.Sprintf("L2Switch: failed to remove a stale flow (deviceID=%v, dstMAC=%v): %v", d.ID(), dstMAC, err))
+				continue
+			}
+			r.cache.remove(flowParam{device: d, dstMAC: dstMAC, outPort: outPort.Value()})
+		}
+	}
+}
+
+type staleFlowChecker struct {
+	log    log.Logger
+	finder network.Finder
+	// Spanning tree ports on the previous and current topology. Key is the port ID.
+	prev, current map[string]struct{}
+	// Key is the MAC address. We query the location of each node once.
+	nodes map[string]*network.Node
+	// Key is the source and destination device IDs. We query the path between two devices once.
+	egress map[[2]string]*network.Port
+}
+
+// isStale returns whether the flow on d toward dstMAC through outPort is not on the current path.
+func (r *staleFlowChecker) isStale(d *network.Device, dstMAC net.HardwareAddr, outPort uint32) bool {
+	id := portID(d, outPort)
+	// The flow goes through a spanning tree link that has been removed or disabled.
+	if _, ok := r.prev[id]; ok {
+		if _, ok := r.current[id]; !ok {
+			return true
 		}
 	}
 
-	return nil
+	node, err := r.node(dstMAC)
+	if err != nil {
+		// We cannot verify the flow, so remove it to be safe. It will be reinstalled by the next PACKET_IN.
+		r.log.Err(fmt.Sprintf("L2Switch: failed to locate a node (MAC=%v): %v", dstMAC, err))
+		return true
+	}
+	// Unknown node?
+	if node == nil {
+		return true
+	}
+	egress := r.egressPort(d, node)
+	if egress == nil {
+		return true
+	}
+
+	return egress.Number() != outPort
+}
+
+func (r *staleFlowChecker) node(mac net.HardwareAddr) (*network.Node, error) {
+	if node, ok := r.nodes[mac.String()]; ok {
+		return node, nil
+	}
+	node, err := r.finder.Node(mac)
+	if err != nil {
+		return nil, err
+	}
+	r.nodes[mac.String()] = node
+
+	return node, nil
+}
+
+// egressPort returns the egress port on the current path from d to node. It returns nil if there is no path.
+func (r *staleFlowChecker) egressPort(d *network.Device, node *network.Node) *network.Port {
+	if d.ID() == node.Port().Device().ID() {
+		return node.Port()
+	}
+
+	key := [2]string{d.ID(), node.Port().Device().ID()}
+	if p, ok := r.egress[key]; ok {
+		return p
+	}
+	var p *network.Port
+	if path := r.finder.Path(key[0], key[1]); len(path) > 0 {
+		p = path[0][0]
+	}
+	r.egress[key] = p
+
+	return p
+}
+
+func (r *L2Switch) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	// The stale flows toward the host have been removed by the controller, so we should
+	// forget the cached flows to install new flows heading to the new location.
+	r.cache.removeDstMAC(host.MAC())
+
+	return r.BaseProcessor.OnHostMoved(finder, host, prev)
 }
 
 func (r *L2Switch) String() string {
@@ -342,17 +479,14 @@ func (r *L2Switch) OnPortDown(finder network.Finder, port *network.Port) error {
 	r.log.Debug(fmt.Sprintf("L2Switch: port down! removing all flows heading to that port (%v)..", port.ID()))
 
 	device := port.Device()
-	factory := device.Factory()
-	// Wildcard match
-	match, err := factory.NewMatch()
-	if err != nil {
-		return err
-	}
-	outPort := openflow.NewOutPort()
-	outPort.SetValue(port.Number())
-
-	if err := device.RemoveFlow(match, outPort); err != nil {
-		return fmt.Errorf("removing flows heading to port %v: %v", port.ID(), err)
+	for _, f := range device.Flows(r.Name()) {
+		outPort, ok := f.OutPort()
+		if !ok || outPort.Value() != port.Number() {
+			continue
+		}
+		if err := device.RemoveAppFlow(r.Name(), f.Cookie); err != nil {
+			return fmt.Errorf("removing flows heading to port %v: %v", port.ID(), err)
+		}
 	}
 
 	return r.BaseProcessor.OnPortDown(finder, port)
@@ -0,0 +1,950 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package loadbalancer
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	defaultMAC          = "02:00:00:00:00:fc"
+	defaultSyncInterval = 5 * time.Second
+	// Higher than the flows of L2Switch and Router, and lower than the flows of Firewall
+	flowPriority    = 30
+	flowIdleTimeout = 30
+	// Seconds during which a connection keeps its backend after its flows have expired or its last PACKET_IN. It is
+	// longer than the idle timeout of the flows so that an idle connection goes to the same backend when its flows
+	// are installed again.
+	sessionTimeout = 300 * time.Second
+)
+
+// LoadBalancer distributes the TCP and UDP connections to the virtual addresses of the frontends among their
+// backends. It answers ARP requests for the virtual addresses with its own MAC address, picks a backend for
+// each new connection in round-robin or by the hash of the client address, and then installs two flows for
+// the connection: one on the client's switch that rewrites the destination to the backend, and the other on
+// the backend's switch that rewrites the source of the replies back to the virtual address.
+type LoadBalancer struct {
+	app.BaseProcessor
+	conf     *goconf.ConfigFile
+	log      log.Logger
+	db       database
+	mac      net.HardwareAddr
+	vlanID   uint16
+	interval time.Duration
+	mutex    sync.Mutex
+	// Last finder passed by the events, which is used to synchronize the frontends periodically
+	finder    network.Finder
+	frontends []*frontend
+	sessions  map[session]*binding
+	// Index of the sessions by the replies from their backends
+	replies map[reply]session
+}
+
+type database interface {
+	Frontends() ([]network.Frontend, error)
+	MAC(ip net.IP) (mac net.HardwareAddr, ok bool, err error)
+}
+
+func New(conf *goconf.ConfigFile, log log.Logger, db database) *LoadBalancer {
+	return &LoadBalancer{
+		conf:     conf,
+		log:      log,
+		db:       db,
+		interval: defaultSyncInterval,
+		sessions: make(map[session]*binding),
+		replies:  make(map[reply]session),
+	}
+}
+
+func (r *LoadBalancer) Init() error {
+	vlanID, err := r.conf.GetInt("default", "vlan_id")
+	if err != nil || vlanID < 0 || vlanID > 4095 {
+		return errors.New("invalid default VLAN ID in the config file")
+	}
+	r.vlanID = uint16(vlanID)
+
+	mac := defaultMAC
+	if r.conf.HasOption("loadbalancer", "mac") {
+		mac, err = r.conf.GetString("loadbalancer", "mac")
+		if err != nil {
+			return errors.New("invalid loadbalancer/mac in the config file")
+		}
+	}
+	r.mac, err = net.ParseMAC(mac)
+	if err != nil || len(r.mac) != 6 {
+		return errors.New("invalid loadbalancer/mac in the config file")
+	}
+
+	if r.conf.HasOption("loadbalancer", "sync_interval") {
+		v, err := r.conf.GetInt("loadbalancer", "sync_interval")
+		if err != nil || v <= 0 {
+			return errors.New("invalid loadbalancer/sync_interval in the config file")
+		}
+		r.interval = time.Duration(v) * time.Second
+	}
+	// Load the frontends before any packet is received
+	if err := r.sync(nil); err != nil {
+		return fmt.Errorf("loading the frontends: %v", err)
+	}
+	// The frontends and backends added or removed through the REST API are applied by the periodic synchronization.
+	go r.run()
+
+	return nil
+}
+
+func (r *LoadBalancer) Name() string {
+	return "LoadBalancer"
+}
+
+func (r *LoadBalancer) String() string {
+	return fmt.Sprintf("%v", r.Name())
+}
+
+func (r *LoadBalancer) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mutex.Lock()
+		finder := r.finder
+		r.mutex.Unlock()
+
+		if err := r.sync(finder); err != nil {
+			r.log.Err(fmt.Sprintf("LoadBalancer: failed to synchronize the frontends: %v", err))
+		}
+	}
+}
+
+type frontend struct {
+	id       uint64
+	address  net.IP
+	port     uint16
+	protocol uint8
+	balance  string
+	backends []backend
+	// Index of the backend that will be selected next in round-robin
+	next int
+}
+
+type backend struct {
+	address net.IP
+	port    uint16
+}
+
+func (r backend) String() string {
+	return fmt.Sprintf("%v:%v", r.address, r.port)
+}
+
+// session is a connection from a client to a frontend.
+type session struct {
+	frontend   uint64
+	clientIP   string
+	clientPort uint16
+}
+
+// binding is the backend selected for a session.
+type binding struct {
+	backend  backend
+	lastSeen time.Time
+	// Connection and the ingress port of the client that are used to install the flow for the replies again
+	// after it has expired. conn is valid only if the flows of the session have been installed.
+	conn          connection
+	ingressDevice string
+	ingressPort   uint32
+	installed     bool
+}
+
+// member is a backend of a frontend.
+type member struct {
+	frontend uint64
+	backend  string
+}
+
+// reply is a packet from a backend to a client.
+type reply struct {
+	protocol   uint8
+	backend    string
+	clientIP   string
+	clientPort uint16
+}
+
+func parseProtocol(v string) (uint8, error) {
+	switch strings.ToLower(v) {
+	case "tcp":
+		return 6, nil
+	case "udp":
+		return 17, nil
+	default:
+		return 0, fmt.Errorf("unsupported protocol: %v", v)
+	}
+}
+
+// compile converts frontends into the internal ones. Invalid frontends and backends are skipped.
+func (r *LoadBalancer) compile(frontends []network.Frontend) []*frontend {
+	result := make([]*frontend, 0, len(frontends))
+	for _, v := range frontends {
+		address := net.ParseIP(v.Address).To4()
+		protocol, err := parseProtocol(v.Protocol)
+		if address == nil || err != nil {
+			r.log.Err(fmt.Sprintf("LoadBalancer: skipping the invalid frontend (ID=%v): address=%v, protocol=%v", v.ID, v.Address, v.Protocol))
+			continue
+		}
+		f := &frontend{
+			id:       v.ID,
+			address:  address,
+			port:     v.Port,
+			protocol: protocol,
+			balance:  v.Balance,
+			backends: make([]backend, 0, len(v.Backends)),
+		}
+		for _, b := range v.Backends {
+			address := net.ParseIP(b.Address).To4()
+			if address == nil {
+				r.log.Err(fmt.Sprintf("LoadBalancer: skipping the invalid backend (ID=%v): address=%v", b.ID, b.Address))
+				continue
+			}
+			f.backends = append(f.backends, backend{address: address, port: b.Port})
+		}
+		result = append(result, f)
+	}
+
+	return result
+}
+
+// sync loads the frontends from the database, and removes the connection flows and the sessions whose backends
+// have been removed from their frontends. The sessions whose flows are still installed are refreshed, because the
+// packets of their connections do not come to the controller. finder can be nil, and then the frontends are only
+// loaded.
+func (r *LoadBalancer) sync(finder network.Finder) error {
+	v, err := r.db.Frontends()
+	if err != nil {
+		return err
+	}
+	frontends := r.compile(v)
+	// Connections that have the flows on the devices. The flows are removed from the inventory of the devices
+	// when they have expired.
+	live := make(map[reply]bool)
+	if finder != nil {
+		for _, d := range finder.Devices() {
+			for _, f := range d.Flows(r.Name()) {
+				if c, ok := flowConnection(f); ok {
+					live[c.reply()] = true
+				}
+			}
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Keep the round-robin positions of the existing frontends
+	prev := make(map[uint64]*frontend)
+	for _, f := range r.frontends {
+		prev[f.id] = f
+	}
+	members := make(map[member]bool)
+	for _, f := range frontends {
+		if p, ok := prev[f.id]; ok && len(f.backends) > 0 {
+			f.next = p.next % len(f.backends)
+		}
+		for _, b := range f.backends {
+			members[member{frontend: f.id, backend: b.String()}] = true
+		}
+	}
+	r.frontends = frontends
+
+	now := time.Now()
+	for k, v := range r.sessions {
+		if v.installed && live[v.conn.reply()] {
+			v.lastSeen = now
+		}
+		if now.Sub(v.lastSeen) >= sessionTimeout || !members[member{frontend: k.frontend, backend: v.backend.String()}] {
+			delete(r.sessions, k)
+			if v.installed {
+				delete(r.replies, v.conn.reply())
+			}
+		}
+	}
+
+	if finder == nil {
+		return nil
+	}
+	r.finder = finder
+	r.removeFlows(finder.Devices(), func(f network.Flow) bool {
+		c, ok := flowConnection(f)
+		if !ok {
+			return false
+		}
+		for _, v := range frontends {
+			if v.address.Equal(c.virtualIP) && v.port == c.virtualPort && v.protocol == c.protocol {
+				return !members[member{frontend: v.id, backend: c.backend.String()}]
+			}
+		}
+		// The frontend has been removed
+		return true
+	})
+
+	return nil
+}
+
+// flowConnection returns the connection of the flow f, which is installed for the packets from the client to the
+// backend or for the replies. Only the protocol and the addresses and ports of the client, the frontend, and the
+// backend are set.
+func flowConnection(f network.Flow) (c connection, ok bool) {
+	if f.Action == nil {
+		return connection{}, false
+	}
+	src, dst := f.Match.SrcIP(), f.Match.DstIP()
+	srcWildcard, srcPort := f.Match.SrcPort()
+	dstWildcard, dstPort := f.Match.DstPort()
+	wildcard, protocol := f.Match.IPProtocol()
+	if src == nil || dst == nil || srcWildcard || dstWildcard || wildcard {
+		return connection{}, false
+	}
+	c.protocol = protocol
+
+	// Flow from the client to the backend?
+	if ok, ip := f.Action.DstIP(); ok {
+		_, _, port := f.Action.DstPort()
+		c.clientIP, c.clientPort = src.IP, srcPort
+		c.virtualIP, c.virtualPort = dst.IP, dstPort
+		c.backend = backend{address: ip, port: port}
+		return c, true
+	}
+	// Flow from the backend to the client?
+	if ok, ip := f.Action.SrcIP(); ok {
+		_, _, port := f.Action.SrcPort()
+		c.clientIP, c.clientPort = dst.IP, dstPort
+		c.virtualIP, c.virtualPort = ip, port
+		c.backend = backend{address: src.IP, port: srcPort}
+		return c, true
+	}
+
+	return connection{}, false
+}
+
+// removeFlows removes the connection flows that satisfy filter from devices.
+func (r *LoadBalancer) removeFlows(devices []*network.Device, filter func(network.Flow) bool) {
+	for _, d := range devices {
+		if d.IsClosed() {
+			continue
+		}
+		for _, f := range d.Flows(r.Name()) {
+			if !filter(f) {
+				continue
+			}
+			if err := d.RemoveAppFlow(r.Name(), f.Cookie); err != nil {
+				r.log.Err(fmt.Sprintf("LoadBalancer: failed to remove a flow from %v: %v", d.ID(), err))
+			}
+		}
+	}
+}
+
+// isVirtual returns whether ip is the virtual address of a frontend.
+func (r *LoadBalancer) isVirtual(ip net.IP) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, f := range r.frontends {
+		if f.address.Equal(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findFrontend returns the frontend whose virtual address, port, and protocol are same with the arguments.
+func (r *LoadBalancer) findFrontend(ip net.IP, port uint16, protocol uint8) (f frontend, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for _, v := range r.frontends {
+		if v.address.Equal(ip) && v.port == port && v.protocol == protocol {
+			return *v, true
+		}
+	}
+
+	return frontend{}, false
+}
+
+// selectBackend returns the backend for the connection from the client to the frontend. The connection keeps
+// its backend until the session expires. ok is false if the frontend does not have any backend.
+func (r *LoadBalancer) selectBackend(frontendID uint64, clientIP net.IP, clientPort uint16) (b backend, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var f *frontend
+	for _, v := range r.frontends {
+		if v.id == frontendID {
+			f = v
+			break
+		}
+	}
+	if f == nil || len(f.backends) == 0 {
+		return backend{}, false
+	}
+
+	key := session{frontend: frontendID, clientIP: clientIP.String(), clientPort: clientPort}
+	if v, ok := r.sessions[key]; ok {
+		v.lastSeen = time.Now()
+		return v.backend, true
+	}
+
+	b = pick(f, clientIP)
+	r.sessions[key] = &binding{backend: b, lastSeen: time.Now()}
+
+	return b, true
+}
+
+// pick selects a backend of f, which should have at least one backend, according to its balance algorithm.
+func pick(f *frontend, clientIP net.IP) backend {
+	if f.balance == "source" {
+		h := fnv.New32a()
+		h.Write(clientIP.To4())
+		return f.backends[h.Sum32()%uint32(len(f.backends))]
+	}
+
+	b := f.backends[f.next%len(f.backends)]
+	f.next = (f.next + 1) % len(f.backends)
+
+	return b
+}
+
+func (r *LoadBalancer) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	r.mutex.Lock()
+	r.finder = finder
+	r.mutex.Unlock()
+
+	switch eth.Type {
+	case 0x0806:
+		drop, err := r.handleARP(ingress, eth)
+		if drop || err != nil {
+			return err
+		}
+	case 0x0800:
+		ip := new(protocol.IPv4)
+		if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+			return err
+		}
+		if r.isVirtual(ip.DstIP) {
+			return r.balance(finder, ingress, eth, ip)
+		}
+		drop, err := r.handleReply(finder, ingress, eth, ip)
+		if drop || err != nil {
+			return err
+		}
+	}
+
+	return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+}
+
+// handleARP answers the ARP request for the virtual addresses. drop is false if the request is not for
+// the virtual addresses.
+func (r *LoadBalancer) handleARP(ingress *network.Port, eth *protocol.Ethernet) (drop bool, err error) {
+	arp := new(protocol.ARP)
+	if err := arp.UnmarshalBinary(eth.Payload); err != nil {
+		return false, err
+	}
+	// ARP request for a virtual address?
+	if arp.Operation != 1 || !r.isVirtual(arp.TPA) {
+		return false, nil
+	}
+	r.log.Debug(fmt.Sprintf("LoadBalancer: ARP request for the virtual address %v from %v", arp.TPA, ingress.ID()))
+
+	reply, err := protocol.NewARPReply(r.mac, arp.SHA, arp.TPA, arp.SPA).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  r.mac,
+		DstMAC:  arp.SHA,
+		Type:    0x0806,
+		Payload: reply,
+	}).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+
+	return true, r.PacketOut(ingress, packet)
+}
+
+// balance forwards the packet heading to a virtual address to a backend of the frontend. The packets that do not
+// belong to any frontend are dropped.
+func (r *LoadBalancer) balance(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4) error {
+	// Only the first fragment has the transport header
+	if (ip.Protocol != 6 && ip.Protocol != 17) || ip.Offset != 0 || len(ip.Payload) < 4 {
+		return nil
+	}
+	srcPort := binary.BigEndian.Uint16(ip.Payload[0:2])
+	dstPort := binary.BigEndian.Uint16(ip.Payload[2:4])
+	f, ok := r.findFrontend(ip.DstIP, dstPort, ip.Protocol)
+	if !ok {
+		r.log.Debug(fmt.Sprintf("LoadBalancer: no frontend for %v:%v (protocol=%v)", ip.DstIP, dstPort, ip.Protocol))
+		return nil
+	}
+	b, ok := r.selectBackend(f.id, ip.SrcIP, srcPort)
+	if !ok {
+		r.log.Debug(fmt.Sprintf("LoadBalancer: no backend for the frontend (ID=%v)", f.id))
+		return nil
+	}
+	r.log.Debug(fmt.Sprintf("LoadBalancer: %v:%v -> %v:%v is balanced to %v", ip.SrcIP, srcPort, ip.DstIP, dstPort, b))
+
+	mac, node, err := r.locate(finder, b.address)
+	if err != nil {
+		return err
+	}
+	if node == nil {
+		r.log.Debug(fmt.Sprintf("LoadBalancer: unreachable backend %v", b))
+		return nil
+	}
+	egress := app.NextHop(finder, ingress.Device(), node.Port())
+	reverse := app.NextHop(finder, node.Port().Device(), ingress)
+	if egress == nil || reverse == nil {
+		r.log.Debug(fmt.Sprintf("LoadBalancer: no path between %v and the backend %v", ingress.ID(), b))
+		return nil
+	}
+	// Drop this packet if it goes back to the ingress port to avoid duplicated packets
+	if ingress.Device().ID() == egress.Device().ID() && ingress.Number() == egress.Number() {
+		return nil
+	}
+
+	c := connection{
+		protocol:   ip.Protocol,
+		clientMAC:  eth.SrcMAC,
+		clientIP:   ip.SrcIP,
+		clientPort: srcPort,
+		// MAC address that the client sent the packet to, which can be the one of Router
+		virtualMAC:  eth.DstMAC,
+		virtualIP:   f.address,
+		virtualPort: f.port,
+		backendMAC:  mac,
+		backend:     b,
+	}
+	r.bind(session{frontend: f.id, clientIP: ip.SrcIP.String(), clientPort: srcPort}, c, ingress)
+	// Install the flow for the replies first so that the first reply does not come to the controller
+	if err := r.installFlow(node.Port().Device(), c.reverseFlow(), reverse.Number()); err != nil {
+		return fmt.Errorf("installing a flow on %v: %v", node.Port().Device().ID(), err)
+	}
+	if err := r.installFlow(ingress.Device(), c.forwardFlow(), egress.Number()); err != nil {
+		return fmt.Errorf("installing a flow on %v: %v", ingress.Device().ID(), err)
+	}
+
+	payload, err := protocol.RewriteIPv4(eth.Payload, nil, b.address, 0, b.port)
+	if err != nil {
+		return err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  eth.SrcMAC,
+		DstMAC:  mac,
+		Type:    0x0800,
+		Payload: payload,
+	}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return r.PacketOut(egress, packet)
+}
+
+// bind records the connection and the ingress port of the client for the session so that the replies from
+// the backend can be translated after the flow for the replies has expired.
+func (r *LoadBalancer) bind(key session, c connection, ingress *network.Port) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	b, ok := r.sessions[key]
+	if !ok {
+		return
+	}
+	if b.installed {
+		delete(r.replies, b.conn.reply())
+	}
+	b.conn = c
+	b.ingressDevice = ingress.Device().ID()
+	b.ingressPort = ingress.Number()
+	b.installed = true
+	r.replies[c.reply()] = key
+}
+
+// findReply returns the connection and the ingress port of the client for the reply from a backend. The session
+// of the connection is refreshed if refresh is true.
+func (r *LoadBalancer) findReply(key reply, refresh bool) (c connection, deviceID string, port uint32, ok bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	s, ok := r.replies[key]
+	if !ok {
+		return connection{}, "", 0, false
+	}
+	b, ok := r.sessions[s]
+	if !ok {
+		delete(r.replies, key)
+		return connection{}, "", 0, false
+	}
+	if refresh {
+		b.lastSeen = time.Now()
+	}
+
+	return b.conn, b.ingressDevice, b.ingressPort, true
+}
+
+// clientPort returns the port where the client of c is connected. The ingress port of the client recorded in the
+// session is used if the client is not a known node, for example when the client is behind the Router.
+func (r *LoadBalancer) clientPort(finder network.Finder, c connection, deviceID string, port uint32) *network.Port {
+	node, err := finder.Node(c.clientMAC)
+	if err != nil {
+		r.log.Err(fmt.Sprintf("LoadBalancer: failed to locate a node (MAC=%v): %v", c.clientMAC, err))
+	}
+	if node != nil && !node.Port().Value().IsPortDown() && !node.Port().Value().IsLinkDown() {
+		return node.Port()
+	}
+	device := finder.Device(deviceID)
+	if device == nil || device.IsClosed() {
+		return nil
+	}
+
+	return device.Port(port)
+}
+
+// handleReply translates the source of the reply from a backend back to the virtual address, which comes to
+// the controller when the flow for the replies has expired or has been removed, and installs the flow again.
+// drop is false if the packet is not a reply of a session.
+func (r *LoadBalancer) handleReply(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4) (drop bool, err error) {
+	if (ip.Protocol != 6 && ip.Protocol != 17) || ip.Offset != 0 || len(ip.Payload) < 4 {
+		return false, nil
+	}
+	srcPort := binary.BigEndian.Uint16(ip.Payload[0:2])
+	dstPort := binary.BigEndian.Uint16(ip.Payload[2:4])
+	key := reply{
+		protocol:   ip.Protocol,
+		backend:    backend{address: ip.SrcIP, port: srcPort}.String(),
+		clientIP:   ip.DstIP.String(),
+		clientPort: dstPort,
+	}
+	c, deviceID, portNum, ok := r.findReply(key, true)
+	if !ok {
+		return false, nil
+	}
+	r.log.Debug(fmt.Sprintf("LoadBalancer: %v:%v -> %v:%v is a reply from the backend", ip.SrcIP, srcPort, ip.DstIP, dstPort))
+
+	// The reply of a session should not go to the client with the address of the backend
+	client := r.clientPort(finder, c, deviceID, portNum)
+	if client == nil {
+		r.log.Debug(fmt.Sprintf("LoadBalancer: the client %v is not reachable anymore", c.clientIP))
+		return true, nil
+	}
+	egress := app.NextHop(finder, ingress.Device(), client)
+	if egress == nil {
+		r.log.Debug(fmt.Sprintf("LoadBalancer: no path between %v and the client %v", ingress.ID(), c.clientIP))
+		return true, nil
+	}
+	// Drop this packet if it goes back to the ingress port to avoid duplicated packets
+	if ingress.Device().ID() == egress.Device().ID() && ingress.Number() == egress.Number() {
+		return true, nil
+	}
+	if err := r.installFlow(ingress.Device(), c.reverseFlow(), egress.Number()); err != nil {
+		return true, fmt.Errorf("installing a flow on %v: %v", ingress.Device().ID(), err)
+	}
+
+	payload, err := protocol.RewriteIPv4(eth.Payload, c.virtualIP, nil, c.virtualPort, 0)
+	if err != nil {
+		return true, err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  c.virtualMAC,
+		DstMAC:  c.clientMAC,
+		Type:    0x0800,
+		Payload: payload,
+	}).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+
+	return true, r.PacketOut(egress, packet)
+}
+
+// locate returns the MAC address and the node of the host whose IP address is ip. node is nil if the host
+// is unknown or disconnected.
+func (r *LoadBalancer) locate(finder network.Finder, ip net.IP) (mac net.HardwareAddr, node *network.Node, err error) {
+	mac, ok, err := r.db.MAC(ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+	node, err = finder.Node(mac)
+	if err != nil {
+		return nil, nil, fmt.Errorf("locating a node (MAC=%v): %v", mac, err)
+	}
+	// Unknown or disconnected node?
+	if node == nil || node.Port().Value().IsPortDown() || node.Port().Value().IsLinkDown() {
+		return nil, nil, nil
+	}
+
+	return mac, node, nil
+}
+
+// connection is a balanced connection between a client and a backend.
+type connection struct {
+	protocol    uint8
+	clientMAC   net.HardwareAddr
+	clientIP    net.IP
+	clientPort  uint16
+	virtualMAC  net.HardwareAddr
+	virtualIP   net.IP
+	virtualPort uint16
+	backendMAC  net.HardwareAddr
+	backend     backend
+}
+
+// flowSpec is the match and the rewriting of a connection flow.
+type flowSpec struct {
+	protocol         uint8
+	srcIP, dstIP     net.IP
+	srcPort, dstPort uint16
+	// Rewriting of the source or destination
+	setSrcMAC, setDstMAC net.HardwareAddr
+	setSrcIP, setDstIP   net.IP
+	setSrcPort           uint16
+	setDstPort           uint16
+}
+
+// forwardFlow returns the flow that rewrites the destination of the packets from the client to the backend.
+func (r connection) forwardFlow() flowSpec {
+	return flowSpec{
+		protocol:   r.protocol,
+		srcIP:      r.clientIP,
+		dstIP:      r.virtualIP,
+		srcPort:    r.clientPort,
+		dstPort:    r.virtualPort,
+		setDstMAC:  r.backendMAC,
+		setDstIP:   r.backend.address,
+		setDstPort: r.backend.port,
+	}
+}
+
+// reply returns the key of the replies from the backend to the client.
+func (r connection) reply() reply {
+	return reply{
+		protocol:   r.protocol,
+		backend:    r.backend.String(),
+		clientIP:   r.clientIP.String(),
+		clientPort: r.clientPort,
+	}
+}
+
+// reverseFlow returns the flow that rewrites the source of the packets from the backend to the client.
+func (r connection) reverseFlow() flowSpec {
+	return flowSpec{
+		protocol:   r.protocol,
+		srcIP:      r.backend.address,
+		dstIP:      r.clientIP,
+		srcPort:    r.backend.port,
+		dstPort:    r.clientPort,
+		setSrcMAC:  r.virtualMAC,
+		setDstMAC:  r.clientMAC,
+		setSrcIP:   r.virtualIP,
+		setSrcPort: r.virtualPort,
+	}
+}
+
+// isInstalled returns whether the flow of spec has already been installed on device, which happens when
+// several packets of a connection come to the controller before the flows are installed.
+func (r *LoadBalancer) isInstalled(device *network.Device, spec flowSpec) bool {
+	for _, f := range device.Flows(r.Name()) {
+		src, dst := f.Match.SrcIP(), f.Match.DstIP()
+		if src == nil || dst == nil || !src.IP.Equal(spec.srcIP) || !dst.IP.Equal(spec.dstIP) {
+			continue
+		}
+		_, srcPort := f.Match.SrcPort()
+		_, dstPort := f.Match.DstPort()
+		if srcPort == spec.srcPort && dstPort == spec.dstPort {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *LoadBalancer) installFlow(device *network.Device, spec flowSpec, outPort uint32) error {
+	if r.isInstalled(device, spec) {
+		return nil
+	}
+
+	f := device.Factory()
+	match, err := f.NewMatch()
+	if err != nil {
+		return err
+	}
+	match.SetVLANID(r.vlanID)
+	match.SetEtherType(0x0800)
+	match.SetIPProtocol(spec.protocol)
+	match.SetSrcIP(&net.IPNet{IP: spec.srcIP, Mask: net.CIDRMask(32, 32)})
+	match.SetDstIP(&net.IPNet{IP: spec.dstIP, Mask: net.CIDRMask(32, 32)})
+	match.SetSrcPort(spec.srcPort)
+	match.SetDstPort(spec.dstPort)
+	if err := match.Error(); err != nil {
+		return err
+	}
+
+	out := openflow.NewOutPort()
+	out.SetValue(outPort)
+	action, err := f.NewAction()
+	if err != nil {
+		return err
+	}
+	if spec.setSrcMAC != nil {
+		action.SetSrcMAC(spec.setSrcMAC)
+	}
+	if spec.setDstMAC != nil {
+		action.SetDstMAC(spec.setDstMAC)
+	}
+	if spec.setSrcIP != nil {
+		action.SetSrcIP(spec.setSrcIP)
+		action.SetSrcPort(spec.protocol, spec.setSrcPort)
+	}
+	if spec.setDstIP != nil {
+		action.SetDstIP(spec.setDstIP)
+		action.SetDstPort(spec.protocol, spec.setDstPort)
+	}
+	action.SetOutPort(out)
+	inst, err := f.NewInstruction()
+	if err != nil {
+		return err
+	}
+	inst.ApplyAction(action)
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	flow.SetTableID(device.FlowTableID())
+	flow.SetIdleTimeout(flowIdleTimeout)
+	flow.SetPriority(flowPriority)
+	flow.SetFlowMatch(match)
+	flow.SetFlowInstruction(inst)
+	if err := device.InstallFlow(r.Name(), flow); err != nil {
+		return err
+	}
+	r.log.Debug(fmt.Sprintf("LoadBalancer: installed a flow rule.. Device=%v, %v:%v -> %v:%v, OutPort=%v", device.ID(), spec.srcIP, spec.srcPort, spec.dstIP, spec.dstPort, outPort))
+
+	return nil
+}
+
+func (r *LoadBalancer) OnDeviceUp(finder network.Finder, device *network.Device) error {
+	r.mutex.Lock()
+	r.finder = finder
+	r.mutex.Unlock()
+
+	return r.BaseProcessor.OnDeviceUp(finder, device)
+}
+
+func (r *LoadBalancer) OnTopologyChange(finder network.Finder) error {
+	// The connections keep their backends by the sessions when the removed flows are installed again.
+	r.removeStaleFlows(finder)
+
+	return r.BaseProcessor.OnTopologyChange(finder)
+}
+
+func (r *LoadBalancer) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	// The flows toward the moved host do not send the packets to its new location anymore
+	r.removeStaleFlows(finder)
+
+	return r.BaseProcessor.OnHostMoved(finder, host, prev)
+}
+
+// removeStaleFlows removes the connection flows whose egress ports are not on the current path toward their
+// destinations, which are the backends or the clients.
+func (r *LoadBalancer) removeStaleFlows(finder network.Finder) {
+	// Key is the MAC address of a backend
+	backends := make(map[string]*network.Port)
+	target := func(f network.Flow) *network.Port {
+		c, ok := flowConnection(f)
+		if !ok {
+			return nil
+		}
+		// Flow for the replies?
+		if ok, _ := f.Action.SrcIP(); ok {
+			conn, deviceID, port, ok := r.findReply(c.reply(), false)
+			if !ok {
+				return nil
+			}
+			return r.clientPort(finder, conn, deviceID, port)
+		}
+
+		_, mac := f.Action.DstMAC()
+		if p, ok := backends[mac.String()]; ok {
+			return p
+		}
+		node, err := finder.Node(mac)
+		if err != nil {
+			r.log.Err(fmt.Sprintf("LoadBalancer: failed to locate a node (MAC=%v): %v", mac, err))
+		}
+		var p *network.Port
+		if node != nil && !node.Port().Value().IsPortDown() && !node.Port().Value().IsLinkDown() {
+			p = node.Port()
+		}
+		backends[mac.String()] = p
+
+		return p
+	}
+
+	for _, d := range finder.Devices() {
+		r.removeFlows([]*network.Device{d}, func(f network.Flow) bool {
+			out, ok := f.OutPort()
+			if !ok {
+				return true
+			}
+			dst := target(f)
+			if dst == nil {
+				return true
+			}
+			egress := app.NextHop(finder, d, dst)
+			return egress == nil || egress.Number() != out.Value()
+		})
+	}
+}
+
+func (r *LoadBalancer) OnPortDown(finder network.Finder, port *network.Port) error {
+	r.removeFlows([]*network.Device{port.Device()}, func(f network.Flow) bool {
+		out, ok := f.OutPort()
+		return ok && out.Value() == port.Number()
+	})
+
+	return r.BaseProcessor.OnPortDown(finder, port)
+}
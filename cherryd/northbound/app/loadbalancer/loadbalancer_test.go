@@ -0,0 +1,211 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package loadbalancer
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/openflow/of13"
+)
+
+func newFrontend(balance string) *frontend {
+	return &frontend{
+		id:       1,
+		address:  net.IPv4(10, 0, 0, 100).To4(),
+		port:     80,
+		protocol: 6,
+		balance:  balance,
+		backends: []backend{
+			{address: net.IPv4(10, 0, 1, 1).To4(), port: 8080},
+			{address: net.IPv4(10, 0, 1, 2).To4(), port: 8080},
+			{address: net.IPv4(10, 0, 1, 3).To4(), port: 8081},
+		},
+	}
+}
+
+func TestPickRoundRobin(t *testing.T) {
+	f := newFrontend("roundrobin")
+	client := net.IPv4(10, 0, 0, 5)
+	for i := 0; i < 6; i++ {
+		b := pick(f, client)
+		if expected := f.backends[i%3]; b.String() != expected.String() {
+			t.Fatalf("unexpected backend at %v: expected=%v, got=%v", i, expected, b)
+		}
+	}
+}
+
+func TestPickSource(t *testing.T) {
+	f := newFrontend("source")
+	client := net.IPv4(10, 0, 0, 5)
+	first := pick(f, client)
+	for i := 0; i < 5; i++ {
+		if b := pick(f, client); b.String() != first.String() {
+			t.Fatalf("same client should go to the same backend: expected=%v, got=%v", first, b)
+		}
+	}
+}
+
+func TestConnectionFlows(t *testing.T) {
+	c := connection{
+		protocol:    6,
+		clientMAC:   net.HardwareAddr{0, 0, 0, 0, 0, 1},
+		clientIP:    net.IPv4(10, 0, 0, 5),
+		clientPort:  40000,
+		virtualMAC:  net.HardwareAddr{2, 0, 0, 0, 0, 0xfc},
+		virtualIP:   net.IPv4(10, 0, 0, 100),
+		virtualPort: 80,
+		backendMAC:  net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		backend:     backend{address: net.IPv4(10, 0, 1, 1), port: 8080},
+	}
+
+	forward := c.forwardFlow()
+	if !forward.srcIP.Equal(c.clientIP) || !forward.dstIP.Equal(c.virtualIP) || forward.srcPort != 40000 || forward.dstPort != 80 {
+		t.Fatalf("unexpected match of the forward flow: %+v", forward)
+	}
+	if !forward.setDstIP.Equal(c.backend.address) || forward.setDstPort != 8080 || !bytes.Equal(forward.setDstMAC, c.backendMAC) || forward.setSrcIP != nil {
+		t.Fatalf("unexpected rewriting of the forward flow: %+v", forward)
+	}
+
+	reverse := c.reverseFlow()
+	if !reverse.srcIP.Equal(c.backend.address) || !reverse.dstIP.Equal(c.clientIP) || reverse.srcPort != 8080 || reverse.dstPort != 40000 {
+		t.Fatalf("unexpected match of the reverse flow: %+v", reverse)
+	}
+	if !reverse.setSrcIP.Equal(c.virtualIP) || reverse.setSrcPort != 80 || !bytes.Equal(reverse.setSrcMAC, c.virtualMAC) ||
+		!bytes.Equal(reverse.setDstMAC, c.clientMAC) || reverse.setDstIP != nil {
+		t.Fatalf("unexpected rewriting of the reverse flow: %+v", reverse)
+	}
+	// Key of the replies that come to the controller after the reverse flow has expired
+	key := reply{protocol: 6, backend: backend{address: net.IPv4(10, 0, 1, 1).To4(), port: 8080}.String(), clientIP: net.IPv4(10, 0, 0, 5).To4().String(), clientPort: 40000}
+	if c.reply() != key {
+		t.Fatalf("unexpected key of the replies: expected=%+v, got=%+v", key, c.reply())
+	}
+}
+
+func newConnectionFlow(t *testing.T, spec flowSpec) network.Flow {
+	match := of13.NewMatch()
+	match.SetEtherType(0x0800)
+	match.SetIPProtocol(spec.protocol)
+	match.SetSrcIP(&net.IPNet{IP: spec.srcIP, Mask: net.CIDRMask(32, 32)})
+	match.SetDstIP(&net.IPNet{IP: spec.dstIP, Mask: net.CIDRMask(32, 32)})
+	match.SetSrcPort(spec.srcPort)
+	match.SetDstPort(spec.dstPort)
+	action := of13.NewAction()
+	if spec.setSrcIP != nil {
+		action.SetSrcIP(spec.setSrcIP)
+		action.SetSrcPort(spec.protocol, spec.setSrcPort)
+	}
+	if spec.setDstIP != nil {
+		action.SetDstIP(spec.setDstIP)
+		action.SetDstPort(spec.protocol, spec.setDstPort)
+	}
+	if err := match.Error(); err != nil {
+		t.Fatal(err)
+	}
+
+	return network.Flow{Match: match, Action: action}
+}
+
+func TestFlowConnection(t *testing.T) {
+	c := connection{
+		protocol:    6,
+		clientIP:    net.IPv4(10, 0, 0, 5).To4(),
+		clientPort:  40000,
+		virtualIP:   net.IPv4(10, 0, 0, 100).To4(),
+		virtualPort: 80,
+		backendMAC:  net.HardwareAddr{0, 0, 0, 0, 0, 2},
+		backend:     backend{address: net.IPv4(10, 0, 1, 1).To4(), port: 8080},
+	}
+
+	for _, spec := range []flowSpec{c.forwardFlow(), c.reverseFlow()} {
+		v, ok := flowConnection(newConnectionFlow(t, spec))
+		if !ok {
+			t.Fatalf("failed to get the connection of the flow: %+v", spec)
+		}
+		if v.reply() != c.reply() || !v.virtualIP.Equal(c.virtualIP) || v.virtualPort != c.virtualPort {
+			t.Fatalf("unexpected connection of the flow: expected=%+v, got=%+v", c, v)
+		}
+	}
+}
+
+type mockDB struct {
+	frontends []network.Frontend
+}
+
+func (r *mockDB) Frontends() ([]network.Frontend, error) {
+	return r.frontends, nil
+}
+
+func (r *mockDB) MAC(ip net.IP) (mac net.HardwareAddr, ok bool, err error) {
+	return nil, false, nil
+}
+
+func newBackend(address string) network.Backend {
+	return network.Backend{BackendParam: network.BackendParam{Address: address, Port: 8080}}
+}
+
+func TestSyncSessions(t *testing.T) {
+	db := &mockDB{
+		frontends: []network.Frontend{
+			{ID: 1, FrontendParam: network.FrontendParam{Address: "10.0.0.100", Port: 80, Protocol: "tcp"}, Backends: []network.Backend{newBackend("10.0.1.1"), newBackend("10.0.1.2")}},
+			{ID: 2, FrontendParam: network.FrontendParam{Address: "10.0.0.101", Port: 80, Protocol: "tcp"}, Backends: []network.Backend{newBackend("10.0.1.1")}},
+		},
+	}
+	r := New(nil, nil, db)
+	if err := r.sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	client := net.IPv4(10, 0, 0, 5)
+	first, _ := r.selectBackend(1, client, 40000)
+	if first.String() != "10.0.1.1:8080" {
+		t.Fatalf("unexpected backend of the first session: %v", first)
+	}
+	if b, _ := r.selectBackend(2, client, 40001); b.String() != "10.0.1.1:8080" {
+		t.Fatalf("unexpected backend of the second session: %v", b)
+	}
+
+	// Remove the backend only from the first frontend
+	db.frontends[0].Backends = db.frontends[0].Backends[1:]
+	if err := r.sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.sessions[session{frontend: 1, clientIP: client.String(), clientPort: 40000}]; ok {
+		t.Fatal("session of the removed backend should be dropped")
+	}
+	if _, ok := r.sessions[session{frontend: 2, clientIP: client.String(), clientPort: 40001}]; !ok {
+		t.Fatal("session of the other frontend should be kept")
+	}
+	if b, _ := r.selectBackend(1, client, 40000); b.String() != "10.0.1.2:8080" {
+		t.Fatalf("unexpected backend after removing the backend: %v", b)
+	}
+	// Expired session
+	r.sessions[session{frontend: 2, clientIP: client.String(), clientPort: 40001}].lastSeen = time.Now().Add(-sessionTimeout)
+	if err := r.sync(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.sessions[session{frontend: 2, clientIP: client.String(), clientPort: 40001}]; ok {
+		t.Fatal("expired session should be dropped")
+	}
+}
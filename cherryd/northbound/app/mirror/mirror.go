@@ -0,0 +1,353 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package mirror
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/openflow"
+)
+
+const (
+	defaultSyncInterval = 5 * time.Second
+	// Higher than the flows of L2Switch and the ARP flow toward the controller
+	transitFlowPriority = 150
+)
+
+// Mirror duplicates the traffic to and from the mirrored ports to the analyzer ports. The copies are
+// tagged with the VLAN ID of the mirror and carried along the computed path if the analyzer is on
+// another switch.
+type Mirror struct {
+	app.BaseProcessor
+	conf     *goconf.ConfigFile
+	log      log.Logger
+	db       database
+	interval time.Duration
+	mutex    sync.Mutex
+	// Last finder passed by the events, which is used to synchronize the mirrors periodically
+	finder network.Finder
+	// Key is the device, and value is the description of the plan applied to the device
+	applied map[*network.Device]string
+}
+
+type database interface {
+	Mirrors() ([]network.Mirror, error)
+}
+
+func New(conf *goconf.ConfigFile, log log.Logger, db database) *Mirror {
+	return &Mirror{
+		conf:     conf,
+		log:      log,
+		db:       db,
+		interval: defaultSyncInterval,
+		applied:  make(map[*network.Device]string),
+	}
+}
+
+func (r *Mirror) Init() error {
+	if r.conf.HasOption("mirror", "sync_interval") {
+		v, err := r.conf.GetInt("mirror", "sync_interval")
+		if err != nil || v <= 0 {
+			return errors.New("invalid mirror/sync_interval in the config file")
+		}
+		r.interval = time.Duration(v) * time.Second
+	}
+	// The mirrors added or removed through the REST API are applied by the periodic synchronization.
+	go r.run()
+
+	return nil
+}
+
+func (r *Mirror) Name() string {
+	return "Mirror"
+}
+
+func (r *Mirror) String() string {
+	return fmt.Sprintf("%v", r.Name())
+}
+
+func (r *Mirror) run() {
+	ticker := time.NewTicker(r.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		r.mutex.Lock()
+		finder := r.finder
+		r.mutex.Unlock()
+		// No event has been received yet?
+		if finder == nil {
+			continue
+		}
+
+		if err := r.sync(finder); err != nil {
+			r.log.Err(fmt.Sprintf("Mirror: failed to synchronize the mirrors: %v", err))
+		}
+	}
+}
+
+func (r *Mirror) OnDeviceUp(finder network.Finder, device *network.Device) error {
+	if err := r.sync(finder); err != nil {
+		r.log.Err(fmt.Sprintf("Mirror: failed to synchronize the mirrors: %v", err))
+	}
+
+	return r.BaseProcessor.OnDeviceUp(finder, device)
+}
+
+func (r *Mirror) OnTopologyChange(finder network.Finder) error {
+	// The path toward a remote analyzer may be changed
+	if err := r.sync(finder); err != nil {
+		r.log.Err(fmt.Sprintf("Mirror: failed to synchronize the mirrors: %v", err))
+	}
+
+	return r.BaseProcessor.OnTopologyChange(finder)
+}
+
+func (r *Mirror) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	// The mirrored port of a mirror specified by the host MAC address follows the host
+	if err := r.sync(finder); err != nil {
+		r.log.Err(fmt.Sprintf("Mirror: failed to synchronize the mirrors: %v", err))
+	}
+
+	return r.BaseProcessor.OnHostMoved(finder, host, prev)
+}
+
+// transit is a flow that carries the tagged copies toward a remote analyzer.
+type transit struct {
+	inPort  uint32
+	outPort uint32
+	vlanID  uint16
+}
+
+// plan is the mirrors and transit flows that should be applied to a device.
+type plan struct {
+	mirrors  []network.PortMirror
+	transits []transit
+}
+
+func (r *plan) String() string {
+	if r == nil {
+		return ""
+	}
+
+	return fmt.Sprintf("%v/%+v", r.mirrors, r.transits)
+}
+
+func (r *plan) numTransits() int {
+	if r == nil {
+		return 0
+	}
+
+	return len(r.transits)
+}
+
+// sync applies the mirrors in the database to the devices whose plan has been changed since the last synchronization.
+func (r *Mirror) sync(finder network.Finder) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.finder = finder
+	mirrors, err := r.db.Mirrors()
+	if err != nil {
+		return err
+	}
+
+	plans := make(map[*network.Device]*plan)
+	getPlan := func(d *network.Device) *plan {
+		p, ok := plans[d]
+		if !ok {
+			p = &plan{mirrors: make([]network.PortMirror, 0), transits: make([]transit, 0)}
+			plans[d] = p
+		}
+		return p
+	}
+	for _, m := range mirrors {
+		if err := addPlan(finder, m, getPlan); err != nil {
+			r.log.Debug(fmt.Sprintf("Mirror: skipping the mirror (ID=%v): %v", m.ID, err))
+			continue
+		}
+	}
+
+	applied := make(map[*network.Device]string)
+	for _, d := range finder.Devices() {
+		if d.IsClosed() {
+			continue
+		}
+		p := plans[d]
+		// Devices that have never had a mirror are also skipped here. The transit flows may have been removed
+		// without us, for example by Device.RemoveAllFlows, so we also check the flows that are actually installed.
+		if r.applied[d] == p.String() && len(d.PermanentFlows(r.Name())) == p.numTransits() {
+			applied[d] = p.String()
+			continue
+		}
+
+		r.log.Info(fmt.Sprintf("Mirror: applying the mirrors to %v: %v", d.ID(), p))
+		if err := r.apply(d, p); err != nil {
+			// This device will be retried on the next synchronization
+			r.log.Err(fmt.Sprintf("Mirror: failed to apply the mirrors to %v: %v", d.ID(), err))
+			continue
+		}
+		applied[d] = p.String()
+	}
+	// Forget the disconnected devices
+	r.applied = applied
+
+	return nil
+}
+
+// addPlan adds the mirror and transit flows required by m to the plans of the devices.
+func addPlan(finder network.Finder, m network.Mirror, getPlan func(*network.Device) *plan) error {
+	src, mac, err := findSource(finder, m)
+	if err != nil {
+		return err
+	}
+	dst := finder.Device(strconv.FormatUint(m.DstDPID, 10))
+	if dst == nil {
+		return errors.New("destination switch is not connected")
+	}
+	if dst.Port(m.DstPort) == nil {
+		return errors.New("unknown destination port")
+	}
+
+	// Analyzer on the same switch?
+	if src.Device().ID() == dst.ID() {
+		p := getPlan(src.Device())
+		p.mirrors = append(p.mirrors, network.PortMirror{Source: src.Number(), MAC: mac, Output: m.DstPort})
+		return nil
+	}
+
+	path := finder.Path(src.Device().ID(), dst.ID())
+	if len(path) == 0 {
+		return errors.New("no path toward the destination switch")
+	}
+	p := getPlan(src.Device())
+	p.mirrors = append(p.mirrors, network.PortMirror{Source: src.Number(), MAC: mac, Output: path[0][0].Number(), VLANID: m.VLANID})
+	// Intermediate switches forward the copies received from the previous hop to the next hop
+	for i := 1; i < len(path); i++ {
+		p := getPlan(path[i][0].Device())
+		p.transits = append(p.transits, transit{inPort: path[i-1][1].Number(), outPort: path[i][0].Number(), vlanID: m.VLANID})
+	}
+	last := getPlan(dst)
+	last.transits = append(last.transits, transit{inPort: path[len(path)-1][1].Number(), outPort: m.DstPort, vlanID: m.VLANID})
+
+	return nil
+}
+
+// findSource returns the mirrored port of m and the host that restricts the mirrored traffic.
+func findSource(finder network.Finder, m network.Mirror) (*network.Port, net.HardwareAddr, error) {
+	var mac net.HardwareAddr
+	if len(m.MAC) > 0 {
+		v, err := net.ParseMAC(m.MAC)
+		if err != nil {
+			return nil, nil, err
+		}
+		mac = v
+	}
+
+	if m.SrcDPID != 0 {
+		device := finder.Device(strconv.FormatUint(m.SrcDPID, 10))
+		if device == nil {
+			return nil, nil, errors.New("source switch is not connected")
+		}
+		port := device.Port(m.SrcPort)
+		if port == nil {
+			return nil, nil, errors.New("unknown source port")
+		}
+		return port, mac, nil
+	}
+
+	node, err := finder.Node(mac)
+	if err != nil {
+		return nil, nil, err
+	}
+	if node == nil {
+		return nil, nil, fmt.Errorf("unknown location of host %v", mac)
+	}
+
+	return node.Port(), mac, nil
+}
+
+func (r *Mirror) apply(device *network.Device, p *plan) error {
+	if p == nil {
+		p = &plan{}
+	}
+	if err := device.SetMirrors(p.mirrors); err != nil {
+		return err
+	}
+	if err := device.RemoveAppFlows(r.Name()); err != nil {
+		return err
+	}
+	for _, t := range p.transits {
+		if err := installTransit(r.Name(), device, t); err != nil {
+			return fmt.Errorf("installing a transit flow: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func installTransit(owner string, device *network.Device, t transit) error {
+	f := device.Factory()
+	match, err := f.NewMatch()
+	if err != nil {
+		return err
+	}
+	inPort := openflow.NewInPort()
+	inPort.SetValue(t.inPort)
+	match.SetInPort(inPort)
+	match.SetVLANID(t.vlanID)
+
+	outPort := openflow.NewOutPort()
+	outPort.SetValue(t.outPort)
+	action, err := f.NewAction()
+	if err != nil {
+		return err
+	}
+	action.SetOutPort(outPort)
+	inst, err := f.NewInstruction()
+	if err != nil {
+		return err
+	}
+	inst.ApplyAction(action)
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	// Permanent flow that is removed when the mirrors are changed
+	flow.SetTableID(device.FlowTableID())
+	flow.SetIdleTimeout(0)
+	flow.SetHardTimeout(0)
+	flow.SetPriority(transitFlowPriority)
+	flow.SetFlowMatch(match)
+	flow.SetFlowInstruction(inst)
+
+	return device.InstallFlow(owner, flow)
+}
@@ -28,7 +28,6 @@ import (
 
 	"github.com/dlintw/goconf"
 	"github.com/superkkt/cherry/cherryd/log"
-	"github.com/superkkt/cherry/cherryd/network"
 	"github.com/superkkt/cherry/cherryd/northbound/app"
 )
 
@@ -64,28 +63,66 @@ func (r *Monitor) String() string {
 	return fmt.Sprintf("%v", r.Name())
 }
 
-func (r *Monitor) OnDeviceUp(finder network.Finder, device *network.Device) error {
-	go func() {
-		subject := "Cherry: device is up!"
-		body := fmt.Sprintf("DPID: %v", device.ID())
-		if err := r.sendAlarm(subject, body); err != nil {
-			r.log.Err(fmt.Sprintf("Monitor: failed to send an alarm email: %v", err))
-		}
-	}()
+// Subscribe implements app.Subscriber. Alarm emails are sent by the event bus
+// so that a slow SMTP server does not delay other applications.
+func (r *Monitor) Subscribe(bus app.EventBus) {
+	bus.Subscribe(r.Name(), []app.EventType{app.EventDeviceUp, app.EventDeviceDown}, r.onDeviceEvent)
+	bus.Subscribe(r.Name(), []app.EventType{app.EventTopologyDiff}, r.onTopologyDiff)
+	bus.Subscribe(r.Name(), []app.EventType{app.EventMACFlapping}, r.onMACFlapping)
+}
 
-	return r.BaseProcessor.OnDeviceUp(finder, device)
+func (r *Monitor) onDeviceEvent(e app.Event) error {
+	var subject string
+	switch e.Type {
+	case app.EventDeviceUp:
+		subject = "Cherry: device is up!"
+	case app.EventDeviceDown:
+		subject = "Cherry: device is down!"
+	default:
+		return nil
+	}
+	body := fmt.Sprintf("DPID: %v", e.DeviceID())
+	if err := r.sendAlarm(subject, body); err != nil {
+		return fmt.Errorf("failed to send an alarm email: %v", err)
+	}
+
+	return nil
 }
 
-func (r *Monitor) OnDeviceDown(finder network.Finder, device *network.Device) error {
-	go func() {
-		subject := "Cherry: device is down!"
-		body := fmt.Sprintf("DPID: %v", device.ID())
-		if err := r.sendAlarm(subject, body); err != nil {
-			r.log.Err(fmt.Sprintf("Monitor: failed to send an alarm email: %v", err))
-		}
-	}()
+func (r *Monitor) onTopologyDiff(e app.Event) error {
+	diff := e.Diff
+	if len(diff.MissingDevices) == 0 && len(diff.MissingLinks) == 0 {
+		return nil
+	}
+
+	subject := "Cherry: topology differs from the last snapshot!"
+	body := fmt.Sprintf("Missing devices: %v\r\nMissing links: %v\r\nNew devices: %v\r\nNew links: %v\r\nSnapshot: %v",
+		diff.MissingDevices, diff.MissingLinks, diff.NewDevices, diff.NewLinks, diff.Snapshot)
+	if err := r.sendAlarm(subject, body); err != nil {
+		return fmt.Errorf("failed to send an alarm email: %v", err)
+	}
 
-	return r.BaseProcessor.OnDeviceDown(finder, device)
+	return nil
+}
+
+func (r *Monitor) onMACFlapping(e app.Event) error {
+	flapping := e.Flapping
+	ports := make([]string, 0)
+	for _, p := range flapping.Ports {
+		ports = append(ports, p.ID())
+	}
+	blocked := "none"
+	if flapping.Blocked != nil {
+		blocked = fmt.Sprintf("%v (for %v)", flapping.Blocked.ID(), flapping.HoldTime)
+	}
+
+	subject := "Cherry: possible forwarding loop is detected!"
+	body := fmt.Sprintf("Flapping host: %v\r\nPorts: %v\r\nBlocked port: %v", flapping.MAC, strings.Join(ports, ", "), blocked)
+	if err := r.sendAlarm(subject, body); err != nil {
+		return fmt.Errorf("failed to send an alarm email: %v", err)
+	}
+
+	return nil
 }
 
 func (r *Monitor) sendAlarm(subject, body string) error {
@@ -0,0 +1,39 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package app
+
+import (
+	"github.com/superkkt/cherry/cherryd/network"
+)
+
+// NextHop returns the egress port on device toward dst. It returns nil if there is no path.
+func NextHop(finder network.Finder, device *network.Device, dst *network.Port) *network.Port {
+	if device.ID() == dst.Device().ID() {
+		return dst
+	}
+	path := finder.Path(device.ID(), dst.Device().ID())
+	if len(path) == 0 {
+		return nil
+	}
+
+	return path[0][0]
+}
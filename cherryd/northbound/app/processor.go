@@ -110,6 +110,33 @@ func (r *BaseProcessor) OnTopologyChange(finder network.Finder) error {
 	return next.OnTopologyChange(finder)
 }
 
+func (r *BaseProcessor) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	// Do nothging and execute the next processor if it exists
+	next, ok := r.Next()
+	if !ok {
+		return nil
+	}
+	return next.OnHostMoved(finder, host, prev)
+}
+
+func (r *BaseProcessor) OnTopologyDiff(finder network.Finder, diff network.TopologyDiff) error {
+	// Do nothging and execute the next processor if it exists
+	next, ok := r.Next()
+	if !ok {
+		return nil
+	}
+	return next.OnTopologyDiff(finder, diff)
+}
+
+func (r *BaseProcessor) OnMACFlapping(finder network.Finder, flapping network.MACFlapping) error {
+	// Do nothging and execute the next processor if it exists
+	next, ok := r.Next()
+	if !ok {
+		return nil
+	}
+	return next.OnMACFlapping(finder, flapping)
+}
+
 func (r *BaseProcessor) Next() (next Processor, ok bool) {
 	if r.next != nil {
 		return r.next, true
@@ -169,6 +169,7 @@ func (r *ProxyARP) String() string {
 }
 
 type VIP struct {
+	ID      uint64
 	Address net.IP
 	MAC     net.HardwareAddr
 }
@@ -206,7 +207,11 @@ func (r *ProxyARP) OnDeviceDown(finder network.Finder, device *network.Device) e
 }
 
 func (r *ProxyARP) broadcastARPAnnouncement(finder network.Finder, vips []VIP) {
+	notifier, ok := finder.(network.VIPNotifier)
 	for _, v := range vips {
+		if ok {
+			notifier.VIPToggled(v.ID, v.Address, v.MAC)
+		}
 		for _, d := range finder.Devices() {
 			if err := d.SendARPAnnouncement(v.Address, v.MAC); err != nil {
 				r.log.Err(fmt.Sprintf("ProxyARP: failed to broadcast ARP announcement: %v", err))
@@ -0,0 +1,200 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package proxyndp
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+// ProxyNDP is the IPv6 counterpart of ProxyARP. It answers the neighbor solicitations for the registered
+// IPv6 addresses with the MAC addresses in the host table, and drops the neighbor advertisements for them
+// sent by the hosts. Solicitations for the link-local and other unregistered addresses are passed to the
+// next processor as they are not managed by the controller.
+type ProxyNDP struct {
+	app.BaseProcessor
+	conf *goconf.ConfigFile
+	log  log.Logger
+	db   database
+}
+
+type database interface {
+	MAC(ip net.IP) (mac net.HardwareAddr, ok bool, err error)
+}
+
+func New(conf *goconf.ConfigFile, log log.Logger, db database) *ProxyNDP {
+	return &ProxyNDP{
+		conf: conf,
+		log:  log,
+		db:   db,
+	}
+}
+
+func (r *ProxyNDP) Init() error {
+	return nil
+}
+
+func (r *ProxyNDP) Name() string {
+	return "ProxyNDP"
+}
+
+func (r *ProxyNDP) String() string {
+	return fmt.Sprintf("%v", r.Name())
+}
+
+func (r *ProxyNDP) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	// IPv6?
+	if eth.Type != 0x86DD {
+		return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+	}
+	ip := new(protocol.IPv6)
+	if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+		return err
+	}
+	// ICMPv6?
+	if ip.NextHeader != protocol.ICMPv6Protocol {
+		return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+	}
+	icmp := new(protocol.ICMPv6)
+	if err := icmp.UnmarshalBinary(ip.Payload); err != nil {
+		return err
+	}
+
+	var drop bool
+	var err error
+	switch icmp.Type {
+	case protocol.ICMPv6NeighborSolicitation:
+		drop, err = r.handleSolicitation(ingress, eth, ip, icmp)
+	case protocol.ICMPv6NeighborAdvertisement:
+		drop, err = r.handleAdvertisement(ingress, ip, icmp)
+	}
+	if drop || err != nil {
+		return err
+	}
+
+	return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+}
+
+// handleSolicitation answers the neighbor solicitation for a registered address. drop is false if the target
+// is not a registered address.
+func (r *ProxyNDP) handleSolicitation(ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv6, icmp *protocol.ICMPv6) (drop bool, err error) {
+	ns := new(protocol.NeighborSolicitation)
+	if err := ns.UnmarshalBinary(icmp.Payload); err != nil {
+		return false, err
+	}
+	mac, ok, err := r.lookup(ns.Target)
+	if err != nil || !ok {
+		return false, err
+	}
+	// NDP messages that may have been forwarded by a router are invalid (RFC 4861)
+	if ip.HopLimit != 255 {
+		r.log.Info(fmt.Sprintf("ProxyNDP: drop the neighbor solicitation whose hop limit is %v.. ingress=%v", ip.HopLimit, ingress.ID()))
+		return true, nil
+	}
+
+	var reply []byte
+	// Duplicate address detection?
+	if ip.SrcIP.IsUnspecified() {
+		// The owner of the address is checking that no one else uses its address
+		if bytes.Equal(mac, eth.SrcMAC) {
+			r.log.Debug(fmt.Sprintf("ProxyNDP: drop the duplicate address detection for %v from its owner", ns.Target))
+			return true, nil
+		}
+		r.log.Info(fmt.Sprintf("ProxyNDP: defending %v (%v) against the duplicate address detection from %v", ns.Target, mac, eth.SrcMAC))
+		// Answer to all the nodes as the sender does not have an address yet
+		reply, err = protocol.NewNeighborAdvertisementPacket(mac, ns.Target, false, protocol.IPv6MulticastMAC(protocol.IPv6AllNodes), protocol.IPv6AllNodes)
+	} else {
+		dstMAC := ns.SourceLinkAddr
+		if dstMAC == nil {
+			dstMAC = eth.SrcMAC
+		}
+		r.log.Debug(fmt.Sprintf("ProxyNDP: neighbor solicitation for %v (%v)", ns.Target, mac))
+		reply, err = protocol.NewNeighborAdvertisementPacket(mac, ns.Target, true, dstMAC, ip.SrcIP)
+	}
+	if err != nil {
+		return true, err
+	}
+	r.log.Debug(fmt.Sprintf("ProxyNDP: sending neighbor advertisement to %v..", ingress.ID()))
+
+	return true, sendPacket(ingress, reply)
+}
+
+// handleAdvertisement drops the neighbor advertisement for a registered address. drop is false if the target
+// is not a registered address.
+func (r *ProxyNDP) handleAdvertisement(ingress *network.Port, ip *protocol.IPv6, icmp *protocol.ICMPv6) (drop bool, err error) {
+	na := new(protocol.NeighborAdvertisement)
+	if err := na.UnmarshalBinary(icmp.Payload); err != nil {
+		return false, err
+	}
+	_, ok, err := r.lookup(na.Target)
+	if err != nil || !ok {
+		return false, err
+	}
+	// We don't allow a host advertises a registered address to the network as we do for the ARP announcements.
+	// This controller only can advertise it on behalf of the host.
+	r.log.Info(fmt.Sprintf("ProxyNDP: drop the neighbor advertisement for %v from %v", na.Target, ingress.ID()))
+
+	return true, nil
+}
+
+// lookup returns the MAC address of the registered address ip. Link-local addresses are never registered.
+func (r *ProxyNDP) lookup(ip net.IP) (mac net.HardwareAddr, ok bool, err error) {
+	if ip.IsLinkLocalUnicast() {
+		return nil, false, nil
+	}
+
+	return r.db.MAC(ip)
+}
+
+func sendPacket(ingress *network.Port, packet []byte) error {
+	f := ingress.Device().Factory()
+
+	inPort := openflow.NewInPort()
+	inPort.SetController()
+
+	outPort := openflow.NewOutPort()
+	outPort.SetValue(ingress.Number())
+
+	action, err := f.NewAction()
+	if err != nil {
+		return err
+	}
+	action.SetOutPort(outPort)
+
+	out, err := f.NewPacketOut()
+	if err != nil {
+		return err
+	}
+	out.SetInPort(inPort)
+	out.SetAction(action)
+	out.SetData(packet)
+
+	return ingress.Device().SendMessage(out)
+}
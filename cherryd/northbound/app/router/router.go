@@ -0,0 +1,483 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package router
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/dlintw/goconf"
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+const (
+	defaultMAC = "02:00:00:00:00:fe"
+	// Higher than the flows of L2Switch that match only the destination MAC address
+	flowPriority    = 20
+	flowIdleTimeout = 30
+)
+
+// ICMP destination unreachable codes
+const (
+	netUnreachable  = 0
+	hostUnreachable = 1
+)
+
+// Router routes IPv4 packets between the networks that have a gateway address. It answers ARP requests
+// for the gateway addresses with its own MAC address, and installs a flow on the ingress switch that
+// rewrites the MAC addresses and decrements the TTL of the packets heading to a host in another network.
+// The rewritten packets are then switched by L2Switch along the computed path. The switches send the packets
+// whose TTL expires on the flow to the controller, and they are answered with ICMP time exceeded.
+//
+// OpenFlow 1.0 does not have an action to decrement the TTL, so the packets coming from OpenFlow 1.0
+// switches are routed by the controller one by one.
+type Router struct {
+	app.BaseProcessor
+	conf   *goconf.ConfigFile
+	log    log.Logger
+	db     database
+	mac    net.HardwareAddr
+	vlanID uint16
+}
+
+type database interface {
+	MAC(ip net.IP) (mac net.HardwareAddr, ok bool, err error)
+	Networks() ([]network.Network, error)
+}
+
+func New(conf *goconf.ConfigFile, log log.Logger, db database) *Router {
+	return &Router{
+		conf: conf,
+		log:  log,
+		db:   db,
+	}
+}
+
+func (r *Router) Init() error {
+	vlanID, err := r.conf.GetInt("default", "vlan_id")
+	if err != nil || vlanID < 0 || vlanID > 4095 {
+		return errors.New("invalid default VLAN ID in the config file")
+	}
+	r.vlanID = uint16(vlanID)
+
+	mac := defaultMAC
+	if r.conf.HasOption("router", "mac") {
+		mac, err = r.conf.GetString("router", "mac")
+		if err != nil {
+			return errors.New("invalid router/mac in the config file")
+		}
+	}
+	r.mac, err = net.ParseMAC(mac)
+	if err != nil || len(r.mac) != 6 {
+		return errors.New("invalid router/mac in the config file")
+	}
+
+	return nil
+}
+
+func (r *Router) Name() string {
+	return "Router"
+}
+
+func (r *Router) String() string {
+	return fmt.Sprintf("%v", r.Name())
+}
+
+// gateway is a routed network and the address of the router in that network.
+type gateway struct {
+	network *net.IPNet
+	address net.IP
+}
+
+func (r *Router) gateways() ([]gateway, error) {
+	networks, err := r.db.Networks()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]gateway, 0)
+	for _, v := range networks {
+		// Not routed?
+		if v.Gateway == "" {
+			continue
+		}
+		address := net.ParseIP(v.Gateway)
+		if address == nil {
+			r.log.Err(fmt.Sprintf("Router: invalid gateway address of the network %v/%v: %v", v.Address, v.Mask, v.Gateway))
+			continue
+		}
+		mask := net.CIDRMask(int(v.Mask), 32)
+		result = append(result, gateway{
+			network: &net.IPNet{IP: net.ParseIP(v.Address).Mask(mask), Mask: mask},
+			address: address,
+		})
+	}
+
+	return result, nil
+}
+
+// findGateway returns the gateway whose address is ip.
+func findGateway(gateways []gateway, ip net.IP) (gw gateway, ok bool) {
+	for _, v := range gateways {
+		if v.address.Equal(ip) {
+			return v, true
+		}
+	}
+
+	return gateway{}, false
+}
+
+// findNetwork returns the gateway of the network that ip belongs to.
+func findNetwork(gateways []gateway, ip net.IP) (gw gateway, ok bool) {
+	for _, v := range gateways {
+		if v.network.Contains(ip) {
+			return v, true
+		}
+	}
+
+	return gateway{}, false
+}
+
+func (r *Router) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	switch {
+	case eth.Type == 0x0806:
+		drop, err := r.handleARP(ingress, eth)
+		if drop || err != nil {
+			return err
+		}
+	case eth.Type == 0x0800 && bytes.Equal(eth.DstMAC, r.mac):
+		return r.route(finder, ingress, eth)
+	}
+
+	return r.BaseProcessor.OnPacketIn(finder, ingress, eth)
+}
+
+// handleARP answers the ARP request for the gateway addresses. drop is false if the request is not for
+// the gateway addresses.
+func (r *Router) handleARP(ingress *network.Port, eth *protocol.Ethernet) (drop bool, err error) {
+	arp := new(protocol.ARP)
+	if err := arp.UnmarshalBinary(eth.Payload); err != nil {
+		return false, err
+	}
+	// ARP request?
+	if arp.Operation != 1 {
+		return false, nil
+	}
+
+	gateways, err := r.gateways()
+	if err != nil {
+		return false, err
+	}
+	if _, ok := findGateway(gateways, arp.TPA); !ok {
+		return false, nil
+	}
+	r.log.Debug(fmt.Sprintf("Router: ARP request for the gateway address %v from %v", arp.TPA, ingress.ID()))
+
+	reply, err := protocol.NewARPReply(r.mac, arp.SHA, arp.TPA, arp.SPA).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  r.mac,
+		DstMAC:  arp.SHA,
+		Type:    0x0806,
+		Payload: reply,
+	}).MarshalBinary()
+	if err != nil {
+		return true, err
+	}
+
+	return true, r.PacketOut(ingress, packet)
+}
+
+func (r *Router) route(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	ip := new(protocol.IPv4)
+	if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+		return err
+	}
+	r.log.Debug(fmt.Sprintf("Router: PACKET_IN.. Ingress=%v, SrcIP=%v, DstIP=%v", ingress.ID(), ip.SrcIP, ip.DstIP))
+
+	gateways, err := r.gateways()
+	if err != nil {
+		return err
+	}
+	// Packet for the router itself?
+	if _, ok := findGateway(gateways, ip.DstIP); ok {
+		return r.replyEcho(ingress, eth, ip)
+	}
+	if _, ok := findNetwork(gateways, ip.DstIP); !ok {
+		r.log.Debug(fmt.Sprintf("Router: no route to %v", ip.DstIP))
+		return r.sendICMPError(ingress, eth, ip, gateways, protocol.NewICMPDestinationUnreachable(netUnreachable, eth.Payload))
+	}
+	if ip.TTL <= 1 {
+		r.log.Debug(fmt.Sprintf("Router: TTL exceeded in transit to %v", ip.DstIP))
+		return r.sendICMPError(ingress, eth, ip, gateways, protocol.NewICMPTimeExceeded(0, eth.Payload))
+	}
+
+	egress, dstMAC, err := r.nextHop(finder, ingress, ip.DstIP)
+	if err != nil {
+		return err
+	}
+	if egress == nil {
+		r.log.Debug(fmt.Sprintf("Router: unreachable host %v", ip.DstIP))
+		return r.sendICMPError(ingress, eth, ip, gateways, protocol.NewICMPDestinationUnreachable(hostUnreachable, eth.Payload))
+	}
+	// Drop this packet if it goes back to the ingress port to avoid duplicated packet routing
+	if ingress.Device().ID() == egress.Device().ID() && ingress.Number() == egress.Number() {
+		r.log.Debug(fmt.Sprintf("Router: ignore routing path that goes back to the ingress port (SrcIP=%v, DstIP=%v)", ip.SrcIP, ip.DstIP))
+		return nil
+	}
+
+	err = r.installFlow(ingress.Device(), ip.DstIP, dstMAC, egress.Number())
+	switch {
+	case err == openflow.ErrUnsupportedAction:
+		r.log.Debug(fmt.Sprintf("Router: %v does not support TTL decrement, routing the packet to %v by the controller", ingress.Device().ID(), ip.DstIP))
+	case err != nil:
+		return err
+	}
+
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  r.mac,
+		DstMAC:  dstMAC,
+		Type:    0x0800,
+		Payload: decrementTTL(eth.Payload),
+	}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return r.PacketOut(egress, packet)
+}
+
+// nextHop returns the egress port on ingress.Device() toward the host whose IP address is ip, and the
+// MAC address of the host. egress is nil if the host is unknown or unreachable.
+func (r *Router) nextHop(finder network.Finder, ingress *network.Port, ip net.IP) (egress *network.Port, mac net.HardwareAddr, err error) {
+	mac, ok, err := r.db.MAC(ip)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !ok {
+		return nil, nil, nil
+	}
+	node, err := finder.Node(mac)
+	if err != nil {
+		return nil, nil, fmt.Errorf("locating a node (MAC=%v): %v", mac, err)
+	}
+	// Unknown node?
+	if node == nil {
+		return nil, nil, nil
+	}
+	// Disconnected node?
+	port := node.Port().Value()
+	if port.IsPortDown() || port.IsLinkDown() {
+		return nil, nil, nil
+	}
+
+	if ingress.Device().ID() == node.Port().Device().ID() {
+		return node.Port(), mac, nil
+	}
+	path := finder.Path(ingress.Device().ID(), node.Port().Device().ID())
+	if len(path) == 0 {
+		return nil, nil, nil
+	}
+
+	return path[0][0], mac, nil
+}
+
+// installFlow installs a flow that routes the packets heading to ip via outPort of device.
+func (r *Router) installFlow(device *network.Device, ip net.IP, mac net.HardwareAddr, outPort uint32) error {
+	dst := &net.IPNet{IP: ip, Mask: net.CIDRMask(32, 32)}
+	// Already installed?
+	for _, f := range device.Flows(r.Name()) {
+		if v := f.Match.DstIP(); v != nil && v.IP.Equal(ip) {
+			return nil
+		}
+	}
+
+	f := device.Factory()
+	match, err := f.NewMatch()
+	if err != nil {
+		return err
+	}
+	match.SetVLANID(r.vlanID)
+	match.SetDstMAC(r.mac)
+	match.SetEtherType(0x0800)
+	match.SetDstIP(dst)
+
+	out := openflow.NewOutPort()
+	out.SetValue(outPort)
+	action, err := f.NewAction()
+	if err != nil {
+		return err
+	}
+	action.SetSrcMAC(r.mac)
+	action.SetDstMAC(mac)
+	action.SetDecTTL()
+	action.SetOutPort(out)
+	inst, err := f.NewInstruction()
+	if err != nil {
+		return err
+	}
+	inst.ApplyAction(action)
+
+	flow, err := f.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		return err
+	}
+	flow.SetTableID(device.FlowTableID())
+	flow.SetIdleTimeout(flowIdleTimeout)
+	flow.SetPriority(flowPriority)
+	flow.SetFlowMatch(match)
+	flow.SetFlowInstruction(inst)
+	if err := device.InstallFlow(r.Name(), flow); err != nil {
+		return err
+	}
+	r.log.Debug(fmt.Sprintf("Router: installed a flow rule.. Device=%v, DstIP=%v, DstMAC=%v, OutPort=%v", device.ID(), ip, mac, outPort))
+
+	return nil
+}
+
+// decrementTTL returns a copy of the IPv4 packet whose TTL is decremented. The header checksum is updated
+// incrementally (RFC 1624).
+func decrementTTL(packet []byte) []byte {
+	v := make([]byte, len(packet))
+	copy(v, packet)
+	if len(v) < 20 || v[8] == 0 {
+		return v
+	}
+
+	old := binary.BigEndian.Uint16(v[8:10])
+	v[8]--
+	sum := uint32(^binary.BigEndian.Uint16(v[10:12])) + uint32(^old) + uint32(binary.BigEndian.Uint16(v[8:10]))
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	binary.BigEndian.PutUint16(v[10:12], ^uint16(sum))
+
+	return v
+}
+
+// replyEcho answers the ICMP echo request for the gateway addresses. Other packets are dropped.
+func (r *Router) replyEcho(ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4) error {
+	// ICMP?
+	if ip.Protocol != 1 {
+		return nil
+	}
+	echo := new(protocol.ICMPEcho)
+	if err := echo.UnmarshalBinary(ip.Payload); err != nil || echo.Type != 8 {
+		return nil
+	}
+
+	reply, err := protocol.NewICMPEchoReply(echo.ID, echo.Sequence, echo.Payload).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return r.sendIPv4(ingress, eth.SrcMAC, ip.DstIP, ip.SrcIP, reply)
+}
+
+// sendICMPError sends msg to the sender of ip using the gateway address of the sender's network.
+func (r *Router) sendICMPError(ingress *network.Port, eth *protocol.Ethernet, ip *protocol.IPv4, gateways []gateway, msg encoding.BinaryMarshaler) error {
+	// Never send ICMP errors about the ICMP error messages (RFC 1122)
+	if ip.Protocol == 1 && len(ip.Payload) > 0 && ip.Payload[0] != 0 && ip.Payload[0] != 8 {
+		return nil
+	}
+	gw, ok := findNetwork(gateways, ip.SrcIP)
+	if !ok {
+		return nil
+	}
+
+	payload, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return r.sendIPv4(ingress, eth.SrcMAC, gw.address, ip.SrcIP, payload)
+}
+
+// sendIPv4 sends an ICMP packet from the router to the host attached to ingress.
+func (r *Router) sendIPv4(ingress *network.Port, dstMAC net.HardwareAddr, src, dst net.IP, icmp []byte) error {
+	ip, err := protocol.NewIPv4(src, dst, 1, icmp).MarshalBinary()
+	if err != nil {
+		return err
+	}
+	packet, err := (&protocol.Ethernet{
+		SrcMAC:  r.mac,
+		DstMAC:  dstMAC,
+		Type:    0x0800,
+		Payload: ip,
+	}).MarshalBinary()
+	if err != nil {
+		return err
+	}
+
+	return r.PacketOut(ingress, packet)
+}
+
+func (r *Router) OnTopologyChange(finder network.Finder) error {
+	// The egress ports of the routing flows may not be on the current path anymore
+	r.removeAllFlows(finder)
+
+	return r.BaseProcessor.OnTopologyChange(finder)
+}
+
+func (r *Router) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	r.removeAllFlows(finder)
+
+	return r.BaseProcessor.OnHostMoved(finder, host, prev)
+}
+
+func (r *Router) OnPortDown(finder network.Finder, port *network.Port) error {
+	device := port.Device()
+	for _, f := range device.Flows(r.Name()) {
+		outPort, ok := f.OutPort()
+		if !ok || outPort.Value() != port.Number() {
+			continue
+		}
+		if err := device.RemoveAppFlow(r.Name(), f.Cookie); err != nil {
+			return fmt.Errorf("removing flows heading to port %v: %v", port.ID(), err)
+		}
+	}
+
+	return r.BaseProcessor.OnPortDown(finder, port)
+}
+
+// removeAllFlows removes the routing flows from all the devices. They will be installed again by
+// the next PACKET_INs.
+func (r *Router) removeAllFlows(finder network.Finder) {
+	for _, d := range finder.Devices() {
+		if d.IsClosed() {
+			continue
+		}
+		if err := d.RemoveAppFlows(r.Name()); err != nil {
+			r.log.Err(fmt.Sprintf("Router: failed to remove the routing flows from %v: %v", d.ID(), err))
+		}
+	}
+}
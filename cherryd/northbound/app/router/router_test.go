@@ -0,0 +1,83 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package router
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+func TestDecrementTTL(t *testing.T) {
+	for _, ttl := range []uint8{2, 64, 128, 255} {
+		ip := protocol.NewIPv4(net.IPv4(10, 0, 0, 1), net.IPv4(10, 0, 1, 1), 17, []byte{1, 2, 3, 4, 5, 6, 7, 8})
+		ip.ID = uint16(ttl) * 257
+		ip.TTL = ttl
+		packet, err := ip.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		ip.TTL = ttl - 1
+		expected, err := ip.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if v := decrementTTL(packet); !bytes.Equal(v, expected) {
+			t.Fatalf("unexpected packet: TTL=%v, expected=%x, got=%x", ttl, expected, v)
+		}
+	}
+}
+
+func TestFindNetwork(t *testing.T) {
+	_, n1, _ := net.ParseCIDR("10.0.0.0/24")
+	_, n2, _ := net.ParseCIDR("10.0.1.0/25")
+	gateways := []gateway{
+		{network: n1, address: net.IPv4(10, 0, 0, 1)},
+		{network: n2, address: net.IPv4(10, 0, 1, 126)},
+	}
+
+	tests := []struct {
+		ip      net.IP
+		ok      bool
+		gateway net.IP
+	}{
+		{net.IPv4(10, 0, 0, 200), true, net.IPv4(10, 0, 0, 1)},
+		{net.IPv4(10, 0, 1, 1), true, net.IPv4(10, 0, 1, 126)},
+		{net.IPv4(10, 0, 1, 200), false, nil},
+		{net.IPv4(192, 168, 0, 1), false, nil},
+	}
+	for _, v := range tests {
+		gw, ok := findNetwork(gateways, v.ip)
+		if ok != v.ok {
+			t.Fatalf("unexpected result for %v: expected=%v, got=%v", v.ip, v.ok, ok)
+		}
+		if ok && !gw.address.Equal(v.gateway) {
+			t.Fatalf("unexpected gateway for %v: expected=%v, got=%v", v.ip, v.gateway, gw.address)
+		}
+	}
+	if _, ok := findGateway(gateways, net.IPv4(10, 0, 1, 126)); !ok {
+		t.Fatal("gateway address is not found")
+	}
+}
@@ -0,0 +1,372 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package northbound
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/log"
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/protocol"
+)
+
+type subscription struct {
+	name    string
+	types   map[app.EventType]bool
+	handler app.EventHandler
+	// Statistics that should be accessed atomically
+	handled, failed, timedOut uint64
+}
+
+// eventBus implements network.EventListener and app.EventBus.
+type eventBus struct {
+	mutex         sync.RWMutex
+	log           log.Logger
+	timeout       time.Duration
+	queueSize     int
+	subscriptions []*subscription
+	// Key is the device ID. Each device has its own queue and worker, so that events of a same device are
+	// dispatched in order and a slow device does not stall the others. Events not related to any device use
+	// the queue whose key is an empty string. The queue of a device is removed after its device down event.
+	queues map[string]*eventQueue
+	// Queues removed from queues whose workers are still dispatching the remaining events. Key is the device ID.
+	retired map[string]*eventQueue
+	dropped uint64
+}
+
+// eventQueue is the event queue of a device.
+type eventQueue struct {
+	mutex  sync.RWMutex
+	events chan app.Event
+	closed bool
+	// done is closed after the worker has dispatched all the events of this queue
+	done chan struct{}
+}
+
+// send returns closed if the queue has been closed, and then the event should be sent to the new queue of the
+// device. ok is false if the queue is full and block is false.
+func (r *eventQueue) send(e app.Event, block bool) (ok, closed bool) {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if r.closed {
+		return false, true
+	}
+	if block {
+		r.events <- e
+		return true, false
+	}
+	select {
+	case r.events <- e:
+		return true, false
+	default:
+		return false, false
+	}
+}
+
+// close waits for the senders blocked on the full queue, so it should not be called by the worker of the queue.
+func (r *eventQueue) close() {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return
+	}
+	r.closed = true
+	close(r.events)
+}
+
+func newEventBus(log log.Logger, queueSize int, timeout time.Duration) *eventBus {
+	if queueSize <= 0 || timeout <= 0 {
+		panic("invalid event bus parameters")
+	}
+
+	return &eventBus{
+		log:       log,
+		timeout:   timeout,
+		queueSize: queueSize,
+		queues:    make(map[string]*eventQueue),
+		retired:   make(map[string]*eventQueue),
+	}
+}
+
+func (r *eventBus) Subscribe(name string, types []app.EventType, h app.EventHandler) {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if h == nil {
+		panic("Handler is nil")
+	}
+
+	s := &subscription{
+		name:    name,
+		types:   make(map[app.EventType]bool),
+		handler: h,
+	}
+	for _, t := range types {
+		s.types[t] = true
+	}
+	r.subscriptions = append(r.subscriptions, s)
+	r.log.Debug(fmt.Sprintf("EventBus: %v subscribed to %v", name, types))
+}
+
+func (r *eventBus) publish(e app.Event) {
+	e.Timestamp = time.Now()
+	r.enqueue(e.DeviceID(), e)
+}
+
+// enqueue drops PACKET_IN if the queue is full, because the sender will retransmit it. The other events change
+// the states of the applications, so we wait until the queue has room for them.
+func (r *eventBus) enqueue(key string, e app.Event) {
+	for {
+		ok, closed := r.queue(key).send(e, e.Type != app.EventPacketIn)
+		// The queue has been retired by the device down event. Send to the new queue.
+		if closed {
+			continue
+		}
+		if !ok {
+			atomic.AddUint64(&r.dropped, 1)
+			r.log.Warning(fmt.Sprintf("EventBus: dropping %v event (DPID=%v) because the event queue is full", e.Type, e.DeviceID()))
+		}
+		return
+	}
+}
+
+// queue returns the event queue whose key is key. A new queue and its worker are created if it does not exist.
+func (r *eventBus) queue(key string) *eventQueue {
+	// Read lock
+	r.mutex.RLock()
+	queue, ok := r.queues[key]
+	r.mutex.RUnlock()
+	if ok {
+		return queue
+	}
+
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Double check
+	if queue, ok := r.queues[key]; ok {
+		return queue
+	}
+	queue = &eventQueue{
+		events: make(chan app.Event, r.queueSize),
+		done:   make(chan struct{}),
+	}
+	r.queues[key] = queue
+	// The events of the new queue should be dispatched after the remaining events of the retired one.
+	go r.work(key, queue, r.retired[key])
+
+	return queue
+}
+
+// retire removes the queue of a device that is down. Its worker exits after dispatching the remaining events.
+func (r *eventBus) retire(key string, queue *eventQueue) {
+	// Write lock
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.queues[key] != queue {
+		return
+	}
+	delete(r.queues, key)
+	r.retired[key] = queue
+	// The worker cannot close its own queue because it may wait for the blocked senders.
+	go queue.close()
+}
+
+func (r *eventBus) work(key string, queue *eventQueue, prev *eventQueue) {
+	defer func() {
+		close(queue.done)
+
+		// Write lock
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.retired[key] == queue {
+			delete(r.retired, key)
+		}
+	}()
+	if prev != nil {
+		<-prev.done
+	}
+
+	for e := range queue.events {
+		// PACKET_IN that has been waiting too long is useless because the sender may have already retransmitted
+		// it. Dropping them lets the queue catch up after a slow handler.
+		if e.Type == app.EventPacketIn && time.Since(e.Timestamp) > r.timeout {
+			atomic.AddUint64(&r.dropped, 1)
+			r.log.Debug(fmt.Sprintf("EventBus: dropping stale %v event (DPID=%v)", e.Type, e.DeviceID()))
+			continue
+		}
+		r.dispatch(e)
+		if e.Type == app.EventDeviceDown && key != "" {
+			r.retire(key, queue)
+		}
+	}
+}
+
+func (r *eventBus) dispatch(e app.Event) {
+	// Read lock
+	r.mutex.RLock()
+	subscriptions := r.subscriptions
+	r.mutex.RUnlock()
+
+	for _, s := range subscriptions {
+		if !s.types[e.Type] {
+			continue
+		}
+		r.call(s, e)
+	}
+}
+
+// call waits for the handler to keep the order of the events. A watchdog reports the handler when it runs longer
+// than the timeout, even if it never returns.
+func (r *eventBus) call(s *subscription, e app.Event) {
+	start := time.Now()
+	watchdog := time.AfterFunc(r.timeout, func() {
+		atomic.AddUint64(&s.timedOut, 1)
+		r.log.Warning(fmt.Sprintf("EventBus: %v has been handling %v event for more than %v (DPID=%v)", s.name, e.Type, r.timeout, e.DeviceID()))
+	})
+	err := s.handler(e)
+	if !watchdog.Stop() {
+		r.log.Warning(fmt.Sprintf("EventBus: %v finished handling %v event after %v (DPID=%v)", s.name, e.Type, time.Since(start), e.DeviceID()))
+	}
+
+	atomic.AddUint64(&s.handled, 1)
+	if err != nil {
+		atomic.AddUint64(&s.failed, 1)
+		r.log.Err(fmt.Sprintf("EventBus: %v failed to handle %v event (DPID=%v): %v", s.name, e.Type, e.DeviceID(), err))
+	}
+}
+
+func (r *eventBus) String() string {
+	// Read lock
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("EventBus Queues=%v, Dropped=%v\n", len(r.queues), atomic.LoadUint64(&r.dropped)))
+	for _, s := range r.subscriptions {
+		buf.WriteString(fmt.Sprintf("Subscription Name=%v, Handled=%v, Failed=%v, TimedOut=%v\n", s.name, atomic.LoadUint64(&s.handled), atomic.LoadUint64(&s.failed), atomic.LoadUint64(&s.timedOut)))
+	}
+
+	return buf.String()
+}
+
+func (r *eventBus) OnPacketIn(finder network.Finder, ingress *network.Port, eth *protocol.Ethernet) error {
+	r.publish(app.Event{Type: app.EventPacketIn, Finder: finder, Device: ingress.Device(), Port: ingress, Packet: eth})
+	return nil
+}
+
+func (r *eventBus) OnPortUp(finder network.Finder, port *network.Port) error {
+	r.publish(app.Event{Type: app.EventPortUp, Finder: finder, Device: port.Device(), Port: port})
+	return nil
+}
+
+func (r *eventBus) OnPortDown(finder network.Finder, port *network.Port) error {
+	r.publish(app.Event{Type: app.EventPortDown, Finder: finder, Device: port.Device(), Port: port})
+	return nil
+}
+
+func (r *eventBus) OnDeviceUp(finder network.Finder, device *network.Device) error {
+	r.publish(app.Event{Type: app.EventDeviceUp, Finder: finder, Device: device})
+	return nil
+}
+
+func (r *eventBus) OnDeviceDown(finder network.Finder, device *network.Device) error {
+	r.publish(app.Event{Type: app.EventDeviceDown, Finder: finder, Device: device})
+	return nil
+}
+
+func (r *eventBus) OnTopologyChange(finder network.Finder) error {
+	r.publish(app.Event{Type: app.EventTopologyChange, Finder: finder})
+	return nil
+}
+
+func (r *eventBus) OnHostMoved(finder network.Finder, host *network.Node, prev *network.Port) error {
+	r.publish(app.Event{Type: app.EventHostMoved, Finder: finder, Device: host.Port().Device(), Port: host.Port(), Host: host, PrevPort: prev})
+	return nil
+}
+
+func (r *eventBus) OnTopologyDiff(finder network.Finder, diff network.TopologyDiff) error {
+	r.publish(app.Event{Type: app.EventTopologyDiff, Finder: finder, Diff: &diff})
+	return nil
+}
+
+func (r *eventBus) OnMACFlapping(finder network.Finder, flapping network.MACFlapping) error {
+	e := app.Event{Type: app.EventMACFlapping, Finder: finder, Flapping: &flapping}
+	if flapping.Blocked != nil {
+		e.Device = flapping.Blocked.Device()
+		e.Port = flapping.Blocked
+	}
+	r.publish(e)
+	return nil
+}
+
+// chainHandler returns an event handler that executes the chain of processors whose first one is head.
+func chainHandler(head app.Processor) app.EventHandler {
+	return func(e app.Event) error {
+		switch e.Type {
+		case app.EventPacketIn:
+			return head.OnPacketIn(e.Finder, e.Port, e.Packet)
+		case app.EventPortUp:
+			return head.OnPortUp(e.Finder, e.Port)
+		case app.EventPortDown:
+			return head.OnPortDown(e.Finder, e.Port)
+		case app.EventDeviceUp:
+			return head.OnDeviceUp(e.Finder, e.Device)
+		case app.EventDeviceDown:
+			return head.OnDeviceDown(e.Finder, e.Device)
+		case app.EventTopologyChange:
+			return head.OnTopologyChange(e.Finder)
+		case app.EventHostMoved:
+			return head.OnHostMoved(e.Finder, e.Host, e.PrevPort)
+		case app.EventTopologyDiff:
+			return head.OnTopologyDiff(e.Finder, *e.Diff)
+		case app.EventMACFlapping:
+			return head.OnMACFlapping(e.Finder, *e.Flapping)
+		default:
+			return fmt.Errorf("unknown event type: %v", e.Type)
+		}
+	}
+}
+
+var allEventTypes = []app.EventType{
+	app.EventPacketIn,
+	app.EventPortUp,
+	app.EventPortDown,
+	app.EventDeviceUp,
+	app.EventDeviceDown,
+	app.EventTopologyChange,
+	app.EventHostMoved,
+	app.EventTopologyDiff,
+	app.EventMACFlapping,
+}
@@ -0,0 +1,223 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package northbound
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/superkkt/cherry/cherryd/network"
+	"github.com/superkkt/cherry/cherryd/northbound/app"
+)
+
+type dummyLogger struct{}
+
+func (r *dummyLogger) Debug(m string) (err error) {
+	return nil
+}
+
+func (r *dummyLogger) Err(m string) (err error) {
+	return nil
+}
+
+func (r *dummyLogger) Info(m string) (err error) {
+	return nil
+}
+
+func (r *dummyLogger) Notice(m string) (err error) {
+	return nil
+}
+
+func (r *dummyLogger) Warning(m string) (err error) {
+	return nil
+}
+
+func TestEventBusOrdering(t *testing.T) {
+	bus := newEventBus(new(dummyLogger), 100, time.Second)
+
+	result := make(chan time.Time, 100)
+	bus.Subscribe("Test", []app.EventType{app.EventTopologyChange}, func(e app.Event) error {
+		result <- e.Timestamp
+		return nil
+	})
+	// Should not be delivered to the subscriber
+	bus.OnDeviceUp(nil, nil)
+
+	for i := 0; i < 50; i++ {
+		bus.OnTopologyChange(nil)
+	}
+
+	var prev time.Time
+	for i := 0; i < 50; i++ {
+		select {
+		case v := <-result:
+			if v.Before(prev) {
+				t.Fatalf("unexpected event order: %v is delivered after %v", v, prev)
+			}
+			prev = v
+		case <-time.After(time.Second):
+			t.Fatalf("expected 50 events, got %v", i)
+		}
+	}
+}
+
+func TestEventBusTimeout(t *testing.T) {
+	bus := newEventBus(new(dummyLogger), 100, 10*time.Millisecond)
+
+	var running, overlapped, called uint32
+	bus.Subscribe("Slow", []app.EventType{app.EventTopologyChange, app.EventPacketIn}, func(e app.Event) error {
+		if atomic.AddUint32(&running, 1) > 1 {
+			atomic.StoreUint32(&overlapped, 1)
+		}
+		defer atomic.AddUint32(&running, ^uint32(0))
+		if e.Type == app.EventTopologyChange {
+			time.Sleep(50 * time.Millisecond)
+		}
+		return nil
+	})
+	bus.Subscribe("Fast", []app.EventType{app.EventPacketIn}, func(e app.Event) error {
+		atomic.AddUint32(&called, 1)
+		return nil
+	})
+
+	// The slow handler is waited for, so the PACKET_IN queued behind it becomes stale and is dropped.
+	bus.OnTopologyChange(nil)
+	bus.publish(app.Event{Type: app.EventPacketIn})
+	time.Sleep(200 * time.Millisecond)
+
+	if v := atomic.LoadUint32(&overlapped); v != 0 {
+		t.Fatal("handler is called while the previous call is still running")
+	}
+	if v := atomic.LoadUint64(&bus.subscriptions[0].timedOut); v != 1 {
+		t.Fatalf("expected 1 timeout of the slow handler, got %v", v)
+	}
+	if v := atomic.LoadUint32(&called); v != 0 {
+		t.Fatalf("expected the stale PACKET_IN to be dropped, got %v calls", v)
+	}
+	if v := atomic.LoadUint64(&bus.dropped); v != 1 {
+		t.Fatalf("expected 1 dropped event, got %v", v)
+	}
+}
+
+func TestEventBusDeviceIsolation(t *testing.T) {
+	bus := newEventBus(new(dummyLogger), 100, time.Second)
+
+	block := make(chan struct{})
+	defer close(block)
+	done := make(chan struct{}, 1)
+	bus.Subscribe("Test", []app.EventType{app.EventPortUp}, func(e app.Event) error {
+		if e.Port == nil {
+			// Blocks the queue of the first device
+			<-block
+			return nil
+		}
+		done <- struct{}{}
+		return nil
+	})
+
+	bus.enqueue("1", app.Event{Type: app.EventPortUp, Timestamp: time.Now()})
+	bus.enqueue("2", app.Event{Type: app.EventPortUp, Port: new(network.Port), Timestamp: time.Now()})
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("a slow device stalls the events of another device")
+	}
+}
+
+func TestEventBusQueueFull(t *testing.T) {
+	bus := newEventBus(new(dummyLogger), 1, time.Second)
+
+	block := make(chan struct{})
+	var called uint32
+	bus.Subscribe("Test", []app.EventType{app.EventPortUp, app.EventPortDown, app.EventPacketIn}, func(e app.Event) error {
+		if e.Type == app.EventPortUp {
+			<-block
+		}
+		atomic.AddUint32(&called, 1)
+		return nil
+	})
+
+	bus.enqueue("1", app.Event{Type: app.EventPortUp, Timestamp: time.Now()})
+	// Wait for the worker to take the first event
+	time.Sleep(50 * time.Millisecond)
+	bus.enqueue("1", app.Event{Type: app.EventPortDown, Timestamp: time.Now()})
+	// PACKET_IN is dropped if the queue is full
+	bus.enqueue("1", app.Event{Type: app.EventPacketIn, Timestamp: time.Now()})
+	if v := atomic.LoadUint64(&bus.dropped); v != 1 {
+		t.Fatalf("expected 1 dropped PACKET_IN, got %v", v)
+	}
+
+	// The other events wait until the queue has room for them
+	sent := make(chan struct{})
+	go func() {
+		bus.enqueue("1", app.Event{Type: app.EventPortDown, Timestamp: time.Now()})
+		close(sent)
+	}()
+	close(block)
+	select {
+	case <-sent:
+	case <-time.After(time.Second):
+		t.Fatal("the event is not enqueued after the queue has room")
+	}
+	time.Sleep(50 * time.Millisecond)
+	if v := atomic.LoadUint32(&called); v != 3 {
+		t.Fatalf("expected 3 handled events, got %v", v)
+	}
+}
+
+func TestEventBusDeviceDown(t *testing.T) {
+	bus := newEventBus(new(dummyLogger), 100, time.Second)
+
+	result := make(chan app.EventType, 10)
+	bus.Subscribe("Test", []app.EventType{app.EventDeviceUp, app.EventDeviceDown}, func(e app.Event) error {
+		if e.Type == app.EventDeviceDown {
+			time.Sleep(20 * time.Millisecond)
+		}
+		result <- e.Type
+		return nil
+	})
+
+	bus.enqueue("1", app.Event{Type: app.EventDeviceDown, Timestamp: time.Now()})
+	time.Sleep(50 * time.Millisecond)
+	bus.mutex.RLock()
+	_, ok := bus.queues["1"]
+	bus.mutex.RUnlock()
+	if ok {
+		t.Fatal("the queue of the device is not removed after the device down event")
+	}
+
+	// Events of the reconnected device are dispatched after the ones of the removed queue
+	bus.enqueue("1", app.Event{Type: app.EventDeviceDown, Timestamp: time.Now()})
+	bus.enqueue("1", app.Event{Type: app.EventDeviceUp, Timestamp: time.Now()})
+	for _, expected := range []app.EventType{app.EventDeviceDown, app.EventDeviceDown, app.EventDeviceUp} {
+		select {
+		case v := <-result:
+			if v != expected {
+				t.Fatalf("unexpected event order: expected=%v, got=%v", expected, v)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected %v event", expected)
+		}
+	}
+}
@@ -23,18 +23,27 @@ package northbound
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/dlintw/goconf"
 	"github.com/superkkt/cherry/cherryd/database"
 	"github.com/superkkt/cherry/cherryd/log"
 	"github.com/superkkt/cherry/cherryd/network"
 	"github.com/superkkt/cherry/cherryd/northbound/app"
+	"github.com/superkkt/cherry/cherryd/northbound/app/dhcp"
+	"github.com/superkkt/cherry/cherryd/northbound/app/firewall"
+	"github.com/superkkt/cherry/cherryd/northbound/app/floatingip"
 	"github.com/superkkt/cherry/cherryd/northbound/app/l2switch"
+	"github.com/superkkt/cherry/cherryd/northbound/app/loadbalancer"
+	"github.com/superkkt/cherry/cherryd/northbound/app/mirror"
 	"github.com/superkkt/cherry/cherryd/northbound/app/monitor"
 	"github.com/superkkt/cherry/cherryd/northbound/app/proxyarp"
+	"github.com/superkkt/cherry/cherryd/northbound/app/proxyndp"
+	"github.com/superkkt/cherry/cherryd/northbound/app/router"
 )
 
 type EventSender interface {
@@ -53,6 +62,43 @@ type Manager struct {
 	apps       map[string]*application // Registered applications
 	head, tail app.Processor
 	db         *database.MySQL
+	bus        *eventBus
+	chained    bool
+}
+
+const (
+	defaultEventQueueSize = 1024
+	defaultHandlerTimeout = 5 * time.Second
+)
+
+type busConfig struct {
+	queueSize int
+	timeout   time.Duration
+}
+
+// parseBusConfig reads the optional northbound section of the config file.
+func parseBusConfig(conf *goconf.ConfigFile) (*busConfig, error) {
+	v := &busConfig{
+		queueSize: defaultEventQueueSize,
+		timeout:   defaultHandlerTimeout,
+	}
+
+	if conf.HasOption("northbound", "queue_size") {
+		size, err := conf.GetInt("northbound", "queue_size")
+		if err != nil || size <= 0 {
+			return nil, errors.New("invalid northbound queue_size in the config file")
+		}
+		v.queueSize = size
+	}
+	if conf.HasOption("northbound", "handler_timeout") {
+		timeout, err := conf.GetInt("northbound", "handler_timeout")
+		if err != nil || timeout <= 0 {
+			return nil, errors.New("invalid northbound handler_timeout in the config file")
+		}
+		v.timeout = time.Duration(timeout) * time.Second
+	}
+
+	return v, nil
 }
 
 func NewManager(conf *goconf.ConfigFile, log log.Logger, db *database.MySQL) (*Manager, error) {
@@ -63,16 +109,29 @@ func NewManager(conf *goconf.ConfigFile, log log.Logger, db *database.MySQL) (*M
 		panic("nil logger")
 	}
 
+	c, err := parseBusConfig(conf)
+	if err != nil {
+		return nil, err
+	}
+
 	v := &Manager{
 		log:  log,
 		conf: conf,
 		apps: make(map[string]*application),
 		db:   db,
+		bus:  newEventBus(log, c.queueSize, c.timeout),
 	}
 	// Registering north-bound applications
 	v.register(l2switch.New(conf, log))
 	v.register(proxyarp.New(conf, log, db))
+	v.register(proxyndp.New(conf, log, db))
 	v.register(monitor.New(conf, log))
+	v.register(mirror.New(conf, log, db))
+	v.register(router.New(conf, log, db))
+	v.register(dhcp.New(conf, log, db))
+	v.register(firewall.New(conf, log, db))
+	v.register(loadbalancer.New(conf, log, db))
+	v.register(floatingip.New(conf, log, db))
 
 	return v, nil
 }
@@ -120,6 +179,7 @@ func (r *Manager) Enable(appName string) error {
 		return fmt.Errorf("checking dependencies: %v", err)
 	}
 	v.enabled = true
+	r.subscribe(app)
 	r.log.Debug(fmt.Sprintf("Enabled %v application..", appName))
 
 	if r.head == nil {
@@ -133,6 +193,15 @@ func (r *Manager) Enable(appName string) error {
 	return nil
 }
 
+// XXX: Caller should lock the mutex before they call this function
+func (r *Manager) subscribe(p app.Processor) {
+	s, ok := p.(app.Subscriber)
+	if !ok {
+		return
+	}
+	s.Subscribe(r.bus)
+}
+
 func (r *Manager) AddEventSender(sender EventSender) {
 	r.mutex.Lock()
 	defer r.mutex.Unlock()
@@ -140,7 +209,13 @@ func (r *Manager) AddEventSender(sender EventSender) {
 	if r.head == nil {
 		return
 	}
-	sender.SetEventListener(r.head)
+	// The chain of processors is executed by the event bus so that a slow application
+	// does not block the read loop of a switch.
+	if !r.chained {
+		r.bus.Subscribe("Chain", allEventTypes, chainHandler(r.head))
+		r.chained = true
+	}
+	sender.SetEventListener(r.bus)
 }
 
 func (r *Manager) String() string {
@@ -157,6 +232,7 @@ func (r *Manager) String() string {
 		}
 		app = next
 	}
+	buf.WriteString(r.bus.String())
 
 	return buf.String()
 }
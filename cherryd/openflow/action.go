@@ -27,30 +27,74 @@ import (
 	"net"
 )
 
+// Mirror is an additional output that receives a copy of the packet after the main output.
+type Mirror struct {
+	Port OutPort
+	// The copy is tagged with VLANID if it is not zero
+	VLANID uint16
+}
+
 type Action interface {
+	// AddMirror adds a mirror that receives a copy of the original packet after all the outputs. Untagged mirrors
+	// are applied before the tagged ones.
+	AddMirror(m Mirror)
+	// AddOutPort adds an additional output that receives the same packet as the main output, including the
+	// modifications done by the action.
+	AddOutPort(port OutPort)
+	// DecTTL returns whether the action decrements the IPv4 TTL before the output.
+	DecTTL() bool
+	DstIP() (ok bool, ip net.IP)
 	DstMAC() (ok bool, mac net.HardwareAddr)
+	// DstPort returns the TCP or UDP destination port that the action rewrites.
+	DstPort() (ok bool, protocol uint8, port uint16)
 	encoding.BinaryMarshaler
 	encoding.BinaryUnmarshaler
 	Queue() (ok bool, queue uint32)
 	// Error() returns last error message
 	Error() error
+	// ExtraOutPorts returns the additional outputs added by AddOutPort.
+	ExtraOutPorts() []OutPort
 	OutPort() OutPort
+	// SetDecTTL makes the action decrement the IPv4 TTL. OpenFlow 1.0 does not support it.
+	SetDecTTL()
+	SetDstIP(ip net.IP)
 	SetDstMAC(mac net.HardwareAddr)
+	// SetDstPort rewrites the destination port of the packet whose IP protocol is protocol (TCP or UDP).
+	// Zero protocol means both of them, which is only supported by OpenFlow 1.0.
+	SetDstPort(protocol uint8, port uint16)
 	SetQueue(queue uint32)
 	SetOutPort(port OutPort)
+	SetSrcIP(ip net.IP)
 	SetSrcMAC(mac net.HardwareAddr)
+	// SetSrcPort rewrites the source port of the packet whose IP protocol is protocol (TCP or UDP).
+	SetSrcPort(protocol uint8, port uint16)
 	SetVLANID(vid uint16)
+	SrcIP() (ok bool, ip net.IP)
 	SrcMAC() (ok bool, mac net.HardwareAddr)
+	SrcPort() (ok bool, protocol uint8, port uint16)
+	Mirrors() []Mirror
 	VLANID() (ok bool, vid uint16)
 }
 
+type transportPort struct {
+	protocol uint8
+	port     uint16
+}
+
 type BaseAction struct {
-	err    error
-	output OutPort
-	srcMAC *net.HardwareAddr
-	dstMAC *net.HardwareAddr
-	queue  int64
-	vlanID int32
+	err     error
+	output  OutPort
+	srcMAC  *net.HardwareAddr
+	dstMAC  *net.HardwareAddr
+	srcIP   net.IP
+	dstIP   net.IP
+	srcPort *transportPort
+	dstPort *transportPort
+	queue   int64
+	vlanID  int32
+	decTTL  bool
+	outputs []OutPort
+	mirrors []Mirror
 }
 
 func NewBaseAction() *BaseAction {
@@ -72,6 +116,14 @@ func (r *BaseAction) SetVLANID(vid uint16) {
 	r.vlanID = int32(vid)
 }
 
+func (r *BaseAction) DecTTL() bool {
+	return r.decTTL
+}
+
+func (r *BaseAction) SetDecTTL() {
+	r.decTTL = true
+}
+
 func (r *BaseAction) Queue() (ok bool, queue uint32) {
 	if r.queue == -1 {
 		return false, 0
@@ -84,6 +136,27 @@ func (r *BaseAction) SetQueue(queue uint32) {
 	r.queue = int64(queue)
 }
 
+func (r *BaseAction) AddMirror(m Mirror) {
+	r.mirrors = append(r.mirrors, m)
+}
+
+// Mirrors returns the untagged mirrors first, and then the tagged ones.
+func (r *BaseAction) Mirrors() []Mirror {
+	v := make([]Mirror, 0, len(r.mirrors))
+	for _, m := range r.mirrors {
+		if m.VLANID == 0 {
+			v = append(v, m)
+		}
+	}
+	for _, m := range r.mirrors {
+		if m.VLANID != 0 {
+			v = append(v, m)
+		}
+	}
+
+	return v
+}
+
 func (r *BaseAction) SetOutPort(port OutPort) {
 	r.output = port
 }
@@ -92,6 +165,14 @@ func (r *BaseAction) OutPort() OutPort {
 	return r.output
 }
 
+func (r *BaseAction) AddOutPort(port OutPort) {
+	r.outputs = append(r.outputs, port)
+}
+
+func (r *BaseAction) ExtraOutPorts() []OutPort {
+	return r.outputs
+}
+
 func (r *BaseAction) SetSrcMAC(mac net.HardwareAddr) {
 	if mac == nil || len(mac) < 6 {
 		r.err = fmt.Errorf("SetSrcMAC: %v", ErrInvalidMACAddress)
@@ -126,6 +207,79 @@ func (r *BaseAction) DstMAC() (ok bool, mac net.HardwareAddr) {
 	return true, *r.dstMAC
 }
 
+func (r *BaseAction) SetSrcIP(ip net.IP) {
+	if ip.To4() == nil {
+		r.err = fmt.Errorf("SetSrcIP: %v", ErrInvalidIPAddress)
+		return
+	}
+
+	r.srcIP = ip.To4()
+}
+
+func (r *BaseAction) SrcIP() (ok bool, ip net.IP) {
+	if r.srcIP == nil {
+		return false, net.IPv4zero
+	}
+
+	return true, r.srcIP
+}
+
+func (r *BaseAction) SetDstIP(ip net.IP) {
+	if ip.To4() == nil {
+		r.err = fmt.Errorf("SetDstIP: %v", ErrInvalidIPAddress)
+		return
+	}
+
+	r.dstIP = ip.To4()
+}
+
+func (r *BaseAction) DstIP() (ok bool, ip net.IP) {
+	if r.dstIP == nil {
+		return false, net.IPv4zero
+	}
+
+	return true, r.dstIP
+}
+
+func isTransportProtocol(protocol uint8) bool {
+	// Zero means both TCP and UDP, which is only supported by OpenFlow 1.0
+	return protocol == 0 || protocol == 0x06 || protocol == 0x11
+}
+
+func (r *BaseAction) SetSrcPort(protocol uint8, port uint16) {
+	if !isTransportProtocol(protocol) {
+		r.err = fmt.Errorf("SetSrcPort: %v", ErrUnsupportedIPProtocol)
+		return
+	}
+
+	r.srcPort = &transportPort{protocol: protocol, port: port}
+}
+
+func (r *BaseAction) SrcPort() (ok bool, protocol uint8, port uint16) {
+	if r.srcPort == nil {
+		return false, 0, 0
+	}
+
+	return true, r.srcPort.protocol, r.srcPort.port
+}
+
+func (r *BaseAction) SetDstPort(protocol uint8, port uint16) {
+	if !isTransportProtocol(protocol) {
+		r.err = fmt.Errorf("SetDstPort: %v", ErrUnsupportedIPProtocol)
+		return
+	}
+
+	r.dstPort = &transportPort{protocol: protocol, port: port}
+}
+
+func (r *BaseAction) DstPort() (ok bool, protocol uint8, port uint16) {
+	if r.dstPort == nil {
+		return false, 0, 0
+	}
+
+	return true, r.dstPort.protocol, r.dstPort.port
+}
+
 func (r *BaseAction) Error() error {
 	return r.err
 }
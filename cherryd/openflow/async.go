@@ -0,0 +1,36 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package openflow
+
+import (
+	"encoding"
+)
+
+// SetAsync configures the asynchronous messages that the switch sends to the controller. OpenFlow 1.0 does not
+// support it.
+type SetAsync interface {
+	encoding.BinaryMarshaler
+	Header
+	// SetInvalidTTLToController makes the switch send the packets whose TTL is invalid for the decrement TTL
+	// action to the master controller, which are dropped silently by default.
+	SetInvalidTTLToController(enable bool)
+}
@@ -36,6 +36,8 @@ var (
 	ErrMissingIPProtocol     = errors.New("missing IP protocol")
 	ErrMissingEtherType      = errors.New("missing Ethernet type")
 	ErrUnsupportedMatchType  = errors.New("unsupported flow match type")
+	ErrUnsupportedAction     = errors.New("unsupported action")
+	ErrUnsupportedPortConfig = errors.New("unsupported port configuration")
 )
 
 // Abstract factory
@@ -53,7 +55,7 @@ type Factory interface {
 	NewFlowMod(cmd FlowModCmd) (FlowMod, error)
 	NewFlowRemoved() (FlowRemoved, error)
 	NewFlowStatsRequest() (FlowStatsRequest, error)
-	// TODO: NewFlowStatsReply() (FlowStatsReply, error)
+	NewFlowStatsReply() (FlowStatsReply, error)
 	NewGetConfigRequest() (GetConfigRequest, error)
 	NewGetConfigReply() (GetConfigReply, error)
 	NewHello() (Hello, error)
@@ -63,8 +65,11 @@ type Factory interface {
 	NewPacketOut() (PacketOut, error)
 	NewPortDescRequest() (PortDescRequest, error)
 	NewPortDescReply() (PortDescReply, error)
+	NewPortMod() (PortMod, error)
 	NewPortStatus() (PortStatus, error)
 	NewQueueGetConfigRequest() (QueueGetConfigRequest, error)
+	NewRoleRequest() (RoleRequest, error)
+	NewSetAsync() (SetAsync, error)
 	NewSetConfig() (SetConfig, error)
 	NewTableFeaturesRequest() (TableFeaturesRequest, error)
 	// TODO: NewTableFeaturesReply() (TableFeaturesReply, error)
@@ -0,0 +1,508 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package fakeswitch
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/protocol"
+	"net"
+	"time"
+)
+
+type actionKind int
+
+const (
+	actionOutput actionKind = iota
+	actionSetVLAN
+	actionPushVLAN
+	actionStripVLAN
+	actionSetSrcMAC
+	actionSetDstMAC
+	actionDecTTL
+	actionSetSrcIP
+	actionSetDstIP
+	actionSetSrcPort
+	actionSetDstPort
+)
+
+type action struct {
+	kind   actionKind
+	port   uint32
+	vlanID uint16
+	mac    net.HardwareAddr
+	ip     net.IP
+	// TCP or UDP port to be set
+	tpPort uint16
+}
+
+// program is a sequence of the actions to be applied to a packet, which is followed by an optional
+// lookup on another flow table.
+type program struct {
+	actions []action
+	// Negative value means that there is no next table
+	gotoTable int
+}
+
+// Flow is an entry of the flow table.
+type Flow struct {
+	TableID     uint8
+	Priority    uint16
+	Cookie      uint64
+	IdleTimeout uint16
+	HardTimeout uint16
+	Match       openflow.Match
+	PacketCount uint64
+	ByteCount   uint64
+}
+
+type flowEntry struct {
+	Flow
+	fields         fields
+	flags          uint16
+	program        program
+	rawMatch       []byte
+	rawInstruction []byte
+	created        time.Time
+	used           time.Time
+}
+
+func newFlowEntry(m *flowMod) *flowEntry {
+	now := time.Now()
+	return &flowEntry{
+		Flow: Flow{
+			TableID:     m.tableID,
+			Priority:    m.priority,
+			Cookie:      m.cookie,
+			IdleTimeout: m.idleTimeout,
+			HardTimeout: m.hardTimeout,
+			Match:       m.match,
+		},
+		fields:         newFields(m.match),
+		flags:          m.flags,
+		program:        m.program,
+		rawMatch:       m.rawMatch,
+		rawInstruction: m.rawInstruction,
+		created:        now,
+		used:           now,
+	}
+}
+
+// flowList sorts the flows by the priority in descending order.
+type flowList []*flowEntry
+
+func (r flowList) Len() int           { return len(r) }
+func (r flowList) Less(i, j int) bool { return r[i].Priority > r[j].Priority }
+func (r flowList) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (r *flowEntry) duration() time.Duration {
+	return time.Now().Sub(r.created)
+}
+
+// expired returns the reason of FLOW_REMOVED if this flow has been expired.
+func (r *flowEntry) expired(now time.Time) (ok bool, reason uint8) {
+	if r.HardTimeout > 0 && now.Sub(r.created) >= time.Duration(r.HardTimeout)*time.Second {
+		return true, reasonHardTimeout
+	}
+	if r.IdleTimeout > 0 && now.Sub(r.used) >= time.Duration(r.IdleTimeout)*time.Second {
+		return true, reasonIdleTimeout
+	}
+
+	return false, 0
+}
+
+// isTableMiss returns whether this flow is a table-miss flow entry that matches all the packets with the lowest priority.
+func (r *flowEntry) isTableMiss() bool {
+	return r.Priority == 0 && r.fields.isWildcard()
+}
+
+// hasOutput returns whether this flow sends packets to port.
+func (r *flowEntry) hasOutput(port uint32) bool {
+	for _, v := range r.program.actions {
+		if v.kind == actionOutput && v.port == port {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fields is a flow match whose nil fields mean wildcards.
+type fields struct {
+	inPort    *uint32
+	srcMAC    net.HardwareAddr
+	dstMAC    net.HardwareAddr
+	etherType *uint16
+	vlanID    *uint16
+	protocol  *uint8
+	srcIP     *net.IPNet
+	dstIP     *net.IPNet
+	srcPort   *uint16
+	dstPort   *uint16
+}
+
+func newIPNet(ip *net.IPNet) *net.IPNet {
+	if ip == nil || ip.Mask == nil {
+		return nil
+	}
+	if ones, _ := ip.Mask.Size(); ones == 0 {
+		return nil
+	}
+
+	return &net.IPNet{IP: ip.IP.Mask(ip.Mask), Mask: ip.Mask}
+}
+
+func newFields(m openflow.Match) fields {
+	v := fields{}
+
+	if wildcard, port := m.InPort(); !wildcard {
+		n := port.Value()
+		v.inPort = &n
+	}
+	if wildcard, mac := m.SrcMAC(); !wildcard {
+		v.srcMAC = mac
+	}
+	if wildcard, mac := m.DstMAC(); !wildcard {
+		v.dstMAC = mac
+	}
+	if wildcard, t := m.EtherType(); !wildcard {
+		v.etherType = &t
+	}
+	if wildcard, vid := m.VLANID(); !wildcard {
+		// OpenFlow 1.3 may set OFPVID_PRESENT
+		vid &= 0x0FFF
+		v.vlanID = &vid
+	}
+	if wildcard, p := m.IPProtocol(); !wildcard {
+		v.protocol = &p
+	}
+	v.srcIP = newIPNet(m.SrcIP())
+	v.dstIP = newIPNet(m.DstIP())
+	if wildcard, p := m.SrcPort(); !wildcard {
+		v.srcPort = &p
+	}
+	if wildcard, p := m.DstPort(); !wildcard {
+		v.dstPort = &p
+	}
+
+	return v
+}
+
+func (r fields) isWildcard() bool {
+	return r.inPort == nil && r.srcMAC == nil && r.dstMAC == nil && r.etherType == nil && r.vlanID == nil &&
+		r.protocol == nil && r.srcIP == nil && r.dstIP == nil && r.srcPort == nil && r.dstPort == nil
+}
+
+func coverUint32(a, b *uint32) bool {
+	return a == nil || (b != nil && *a == *b)
+}
+
+func coverUint16(a, b *uint16) bool {
+	return a == nil || (b != nil && *a == *b)
+}
+
+func coverUint8(a, b *uint8) bool {
+	return a == nil || (b != nil && *a == *b)
+}
+
+func coverMAC(a, b net.HardwareAddr) bool {
+	return a == nil || (b != nil && bytes.Equal(a, b))
+}
+
+func coverIPNet(a, b *net.IPNet) bool {
+	if a == nil {
+		return true
+	}
+	if b == nil {
+		return false
+	}
+	ones1, _ := a.Mask.Size()
+	ones2, _ := b.Mask.Size()
+
+	return ones1 <= ones2 && a.Contains(b.IP)
+}
+
+// covers returns whether every packet that matches o also matches r.
+func (r fields) covers(o fields) bool {
+	return coverUint32(r.inPort, o.inPort) && coverMAC(r.srcMAC, o.srcMAC) && coverMAC(r.dstMAC, o.dstMAC) &&
+		coverUint16(r.etherType, o.etherType) && coverUint16(r.vlanID, o.vlanID) && coverUint8(r.protocol, o.protocol) &&
+		coverIPNet(r.srcIP, o.srcIP) && coverIPNet(r.dstIP, o.dstIP) && coverUint16(r.srcPort, o.srcPort) &&
+		coverUint16(r.dstPort, o.dstPort)
+}
+
+func (r fields) equal(o fields) bool {
+	return r.covers(o) && o.covers(r)
+}
+
+// match returns whether p matches r. Untagged packets are regarded as the ones tagged with nativeVLAN if it is not zero.
+func (r fields) match(p *packet, nativeVLAN uint16) bool {
+	if r.inPort != nil && *r.inPort != p.inPort {
+		return false
+	}
+	if r.srcMAC != nil && !bytes.Equal(r.srcMAC, p.srcMAC) {
+		return false
+	}
+	if r.dstMAC != nil && !bytes.Equal(r.dstMAC, p.dstMAC) {
+		return false
+	}
+	if r.etherType != nil && *r.etherType != p.etherType {
+		return false
+	}
+	if r.vlanID != nil {
+		vid := p.vlanID
+		if !p.tagged {
+			vid = nativeVLAN
+		}
+		if (!p.tagged && nativeVLAN == 0) || *r.vlanID != vid {
+			return false
+		}
+	}
+	if r.protocol == nil && r.srcIP == nil && r.dstIP == nil && r.srcPort == nil && r.dstPort == nil {
+		return true
+	}
+
+	// Below fields are only valid for IPv4 packets
+	if !p.ipv4 {
+		return false
+	}
+	if r.protocol != nil && *r.protocol != p.protocol {
+		return false
+	}
+	if r.srcIP != nil && !r.srcIP.Contains(p.srcIP) {
+		return false
+	}
+	if r.dstIP != nil && !r.dstIP.Contains(p.dstIP) {
+		return false
+	}
+	if r.srcPort == nil && r.dstPort == nil {
+		return true
+	}
+	if !p.transport {
+		return false
+	}
+	if r.srcPort != nil && *r.srcPort != p.srcPort {
+		return false
+	}
+	if r.dstPort != nil && *r.dstPort != p.dstPort {
+		return false
+	}
+
+	return true
+}
+
+// packet is a set of the header fields that are used to look up the flow table.
+type packet struct {
+	inPort    uint32
+	srcMAC    net.HardwareAddr
+	dstMAC    net.HardwareAddr
+	etherType uint16
+	tagged    bool
+	vlanID    uint16
+	ipv4      bool
+	protocol  uint8
+	srcIP     net.IP
+	dstIP     net.IP
+	transport bool
+	srcPort   uint16
+	dstPort   uint16
+}
+
+func parsePacket(inPort uint32, frame []byte) (*packet, error) {
+	if len(frame) < 14 {
+		return nil, errors.New("too short Ethernet frame")
+	}
+
+	p := &packet{
+		inPort:    inPort,
+		dstMAC:    net.HardwareAddr(frame[0:6]),
+		srcMAC:    net.HardwareAddr(frame[6:12]),
+		etherType: binary.BigEndian.Uint16(frame[12:14]),
+	}
+	payload := frame[14:]
+	// IEEE 802.1Q-tagged frame?
+	if p.etherType == 0x8100 {
+		if len(frame) < 18 {
+			return nil, errors.New("too short 802.1Q frame")
+		}
+		p.tagged = true
+		p.vlanID = binary.BigEndian.Uint16(frame[14:16]) & 0x0FFF
+		p.etherType = binary.BigEndian.Uint16(frame[16:18])
+		payload = frame[18:]
+	}
+	if p.etherType != 0x0800 || len(payload) < 20 {
+		return p, nil
+	}
+
+	p.ipv4 = true
+	p.protocol = payload[9]
+	p.srcIP = net.IP(payload[12:16])
+	p.dstIP = net.IP(payload[16:20])
+	headerLen := int(payload[0]&0x0F) * 4
+	// TCP or UDP?
+	if (p.protocol == 6 || p.protocol == 17) && len(payload) >= headerLen+4 {
+		p.transport = true
+		p.srcPort = binary.BigEndian.Uint16(payload[headerLen : headerLen+2])
+		p.dstPort = binary.BigEndian.Uint16(payload[headerLen+2 : headerLen+4])
+	}
+
+	return p, nil
+}
+
+func isTagged(frame []byte) bool {
+	return len(frame) >= 18 && binary.BigEndian.Uint16(frame[12:14]) == 0x8100
+}
+
+// vlanID returns the VLAN ID of frame. ok is false if frame is untagged.
+func vlanID(frame []byte) (ok bool, vid uint16) {
+	if !isTagged(frame) {
+		return false, 0
+	}
+
+	return true, binary.BigEndian.Uint16(frame[14:16]) & 0x0FFF
+}
+
+func pushVLAN(frame []byte, vid uint16) []byte {
+	v := make([]byte, len(frame)+4)
+	copy(v[0:12], frame[0:12])
+	binary.BigEndian.PutUint16(v[12:14], 0x8100)
+	binary.BigEndian.PutUint16(v[14:16], vid&0x0FFF)
+	copy(v[16:], frame[12:])
+
+	return v
+}
+
+func stripVLAN(frame []byte) []byte {
+	if !isTagged(frame) {
+		return frame
+	}
+
+	v := make([]byte, len(frame)-4)
+	copy(v[0:12], frame[0:12])
+	copy(v[12:], frame[16:])
+
+	return v
+}
+
+// apply applies a to frame and returns the modified frame. frame is not changed.
+func (r action) apply(frame []byte) []byte {
+	switch r.kind {
+	case actionSetVLAN:
+		if !isTagged(frame) {
+			return pushVLAN(frame, r.vlanID)
+		}
+		v := make([]byte, len(frame))
+		copy(v, frame)
+		tci := binary.BigEndian.Uint16(v[14:16])
+		binary.BigEndian.PutUint16(v[14:16], tci&0xF000|r.vlanID&0x0FFF)
+		return v
+	case actionPushVLAN:
+		// QinQ is not supported
+		if isTagged(frame) {
+			return frame
+		}
+		return pushVLAN(frame, 0)
+	case actionStripVLAN:
+		return stripVLAN(frame)
+	case actionSetSrcMAC, actionSetDstMAC:
+		v := make([]byte, len(frame))
+		copy(v, frame)
+		if r.kind == actionSetSrcMAC {
+			copy(v[6:12], r.mac)
+		} else {
+			copy(v[0:6], r.mac)
+		}
+		return v
+	case actionDecTTL:
+		return decTTL(frame)
+	case actionSetSrcIP, actionSetDstIP, actionSetSrcPort, actionSetDstPort:
+		return r.rewriteIPv4(frame)
+	default:
+		return frame
+	}
+}
+
+// decTTL decrements the TTL of the IPv4 packet in frame and updates the header checksum incrementally
+// (RFC 1624). Other frames are returned as is.
+func decTTL(frame []byte) []byte {
+	offset := 14
+	if isTagged(frame) {
+		offset = 18
+	}
+	if len(frame) < offset+20 || binary.BigEndian.Uint16(frame[offset-2:offset]) != 0x0800 {
+		return frame
+	}
+	header := frame[offset:]
+	if header[8] == 0 {
+		return frame
+	}
+
+	v := make([]byte, len(frame))
+	copy(v, frame)
+	header = v[offset:]
+	old := binary.BigEndian.Uint16(header[8:10])
+	header[8]--
+	sum := uint32(^binary.BigEndian.Uint16(header[10:12])) + uint32(^old) + uint32(binary.BigEndian.Uint16(header[8:10]))
+	for sum>>16 != 0 {
+		sum = sum&0xFFFF + sum>>16
+	}
+	binary.BigEndian.PutUint16(header[10:12], ^uint16(sum))
+
+	return v
+}
+
+// rewriteIPv4 applies r, which rewrites an IPv4 address or a TCP/UDP port, to the IPv4 packet in frame.
+// Checksums are updated as real switches do. Other frames are returned as is.
+func (r action) rewriteIPv4(frame []byte) []byte {
+	offset := 14
+	if isTagged(frame) {
+		offset = 18
+	}
+	if len(frame) < offset+20 || binary.BigEndian.Uint16(frame[offset-2:offset]) != 0x0800 {
+		return frame
+	}
+
+	var srcIP, dstIP net.IP
+	var srcPort, dstPort uint16
+	switch r.kind {
+	case actionSetSrcIP:
+		srcIP = r.ip
+	case actionSetDstIP:
+		dstIP = r.ip
+	case actionSetSrcPort:
+		srcPort = r.tpPort
+	default:
+		dstPort = r.tpPort
+	}
+	packet, err := protocol.RewriteIPv4(frame[offset:], srcIP, dstIP, srcPort, dstPort)
+	if err != nil {
+		// Not a TCP or UDP packet
+		return frame
+	}
+
+	v := make([]byte, offset+len(packet))
+	copy(v, frame[:offset])
+	copy(v[offset:], packet)
+
+	return v
+}
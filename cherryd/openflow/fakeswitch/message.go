@@ -0,0 +1,763 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package fakeswitch
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/openflow/of10"
+	"github.com/superkkt/cherry/cherryd/openflow/of13"
+	"net"
+)
+
+// OpenFlow 1.3 action types that the of13 package does not define
+const (
+	ofp13PushVLAN = 17
+	ofp13PopVLAN  = 18
+)
+
+// Reasons of PACKET_IN and FLOW_REMOVED that are the same in OpenFlow 1.0 and 1.3
+const (
+	reasonNoMatch = 0
+	reasonAction  = 1
+
+	reasonIdleTimeout = 0
+	reasonHardTimeout = 1
+	reasonDelete      = 2
+)
+
+var errUnsupportedAction = errors.New("unsupported action")
+
+func newMessage(version, msgType uint8, xid uint32, payload []byte) []byte {
+	v := make([]byte, 8+len(payload))
+	v[0] = version
+	v[1] = msgType
+	binary.BigEndian.PutUint16(v[2:4], uint16(len(v)))
+	binary.BigEndian.PutUint32(v[4:8], xid)
+	copy(v[8:], payload)
+
+	return v
+}
+
+// normalizePort converts an OpenFlow 1.0 port number into the 32-bit number of OpenFlow 1.3 so that
+// we can handle the reserved ports, such as FLOOD and CONTROLLER, in the same way.
+func normalizePort(version uint8, port uint32) uint32 {
+	if version == openflow.OF10_VERSION && port >= of10.OFPP_MAX {
+		return port | 0xFFFF0000
+	}
+
+	return port
+}
+
+func marshalString(v []byte, s string) {
+	copy(v, s)
+}
+
+func marshalPort(version uint8, p *port) []byte {
+	var state uint32
+	if p.linkDown {
+		state = of13.OFPPS_LINK_DOWN
+	}
+
+	if version == openflow.OF10_VERSION {
+		v := make([]byte, 48)
+		binary.BigEndian.PutUint16(v[0:2], uint16(p.number))
+		copy(v[2:8], p.mac)
+		marshalString(v[8:24], p.name)
+		binary.BigEndian.PutUint32(v[24:28], p.config)
+		binary.BigEndian.PutUint32(v[28:32], state)
+		features := uint32(of10.OFPPF_1GB_FD | of10.OFPPF_COPPER)
+		binary.BigEndian.PutUint32(v[32:36], features)
+		binary.BigEndian.PutUint32(v[36:40], features)
+		binary.BigEndian.PutUint32(v[40:44], features)
+		// v[44:48] is peer features
+		return v
+	}
+
+	v := make([]byte, 64)
+	binary.BigEndian.PutUint32(v[0:4], p.number)
+	// v[4:8] is padding
+	copy(v[8:14], p.mac)
+	// v[14:16] is padding
+	marshalString(v[16:32], p.name)
+	binary.BigEndian.PutUint32(v[32:36], p.config)
+	binary.BigEndian.PutUint32(v[36:40], state)
+	features := uint32(of13.OFPPF_1GB_FD | of13.OFPPF_COPPER)
+	binary.BigEndian.PutUint32(v[40:44], features)
+	binary.BigEndian.PutUint32(v[44:48], features)
+	binary.BigEndian.PutUint32(v[48:52], features)
+	// v[52:56] is peer features
+	// Current and maximum speeds in kbps
+	binary.BigEndian.PutUint32(v[56:60], 1000000)
+	binary.BigEndian.PutUint32(v[60:64], 1000000)
+
+	return v
+}
+
+func newHello(version uint8) []byte {
+	return newMessage(version, of13.OFPT_HELLO, 0, nil)
+}
+
+func newEchoReply(version uint8, xid uint32, data []byte) []byte {
+	return newMessage(version, of13.OFPT_ECHO_REPLY, xid, data)
+}
+
+func newFeaturesReply(version uint8, xid uint32, dpid uint64, ports []*port) []byte {
+	v := make([]byte, 24)
+	binary.BigEndian.PutUint64(v[0:8], dpid)
+	// v[8:12] is number of buffers. We do not buffer any packet.
+
+	if version == openflow.OF10_VERSION {
+		v[12] = 1 // Number of tables
+		// v[16:20] is capabilities and v[20:24] is supported actions
+		binary.BigEndian.PutUint32(v[20:24], 1<<of10.OFPAT_OUTPUT|1<<of10.OFPAT_SET_VLAN_VID|1<<of10.OFPAT_STRIP_VLAN|1<<of10.OFPAT_SET_DL_SRC|1<<of10.OFPAT_SET_DL_DST|
+			1<<of10.OFPAT_SET_NW_SRC|1<<of10.OFPAT_SET_NW_DST|1<<of10.OFPAT_SET_TP_SRC|1<<of10.OFPAT_SET_TP_DST)
+		for _, p := range ports {
+			v = append(v, marshalPort(version, p)...)
+		}
+		return newMessage(version, of10.OFPT_FEATURES_REPLY, xid, v)
+	}
+
+	v[12] = 0xFE // Number of tables
+	// v[13] is auxiliary ID, v[14:16] is padding, v[16:20] is capabilities, and v[20:24] is reserved.
+	return newMessage(version, of13.OFPT_FEATURES_REPLY, xid, v)
+}
+
+func newGetConfigReply(version uint8, xid uint32) []byte {
+	v := make([]byte, 4)
+	// v[0:2] is flags
+	binary.BigEndian.PutUint16(v[2:4], 0xFFFF) // Max bytes of new flow that we send to the controller
+
+	return newMessage(version, of13.OFPT_GET_CONFIG_REPLY, xid, v)
+}
+
+func newBarrierReply(version uint8, xid uint32) []byte {
+	if version == openflow.OF10_VERSION {
+		return newMessage(version, of10.OFPT_BARRIER_REPLY, xid, nil)
+	}
+
+	return newMessage(version, of13.OFPT_BARRIER_REPLY, xid, nil)
+}
+
+func newRoleReply(xid uint32, payload []byte) []byte {
+	v := make([]byte, 16)
+	copy(v, payload)
+
+	return newMessage(openflow.OF13_VERSION, of13.OFPT_ROLE_REPLY, xid, v)
+}
+
+// newQueueGetConfigReply returns a reply that says there is no queue on the port.
+func newQueueGetConfigReply(version uint8, xid uint32, port uint32) []byte {
+	v := make([]byte, 8)
+	if version == openflow.OF10_VERSION {
+		binary.BigEndian.PutUint16(v[0:2], uint16(port))
+		// v[2:8] is padding
+		return newMessage(version, of10.OFPT_QUEUE_GET_CONFIG_REPLY, xid, v)
+	}
+
+	binary.BigEndian.PutUint32(v[0:4], port)
+	// v[4:8] is padding
+	return newMessage(version, of13.OFPT_QUEUE_GET_CONFIG_REPLY, xid, v)
+}
+
+// newStatsReply returns STATS_REPLY of OpenFlow 1.0 or MULTIPART_REPLY of OpenFlow 1.3.
+func newStatsReply(version uint8, xid uint32, statsType uint16, body []byte) []byte {
+	if version == openflow.OF10_VERSION {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint16(v[0:2], statsType)
+		// v[2:4] is flags
+		return newMessage(version, of10.OFPT_STATS_REPLY, xid, append(v, body...))
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint16(v[0:2], statsType)
+	// v[2:4] is flags and v[4:8] is padding
+	return newMessage(version, of13.OFPT_MULTIPART_REPLY, xid, append(v, body...))
+}
+
+func newDescReply(version uint8, xid uint32, dpid uint64) []byte {
+	v := make([]byte, 1056)
+	marshalString(v[0:256], "Cherry")
+	marshalString(v[256:512], "Fake Switch")
+	marshalString(v[512:768], "fakeswitch")
+	marshalString(v[768:800], net.HardwareAddr(dpidBytes(dpid)).String())
+	marshalString(v[800:1056], "Fake OpenFlow switch for testing")
+
+	// OFPST_DESC and OFPMP_DESC are same
+	return newStatsReply(version, xid, of13.OFPMP_DESC, v)
+}
+
+func dpidBytes(dpid uint64) []byte {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint64(v, dpid)
+
+	return v
+}
+
+func newPortDescReply(xid uint32, ports []*port) []byte {
+	v := make([]byte, 0)
+	for _, p := range ports {
+		v = append(v, marshalPort(openflow.OF13_VERSION, p)...)
+	}
+
+	return newStatsReply(openflow.OF13_VERSION, xid, of13.OFPMP_PORT_DESC, v)
+}
+
+func marshalFlowStats(version uint8, f *flowEntry) []byte {
+	duration := f.duration()
+	if version == openflow.OF10_VERSION {
+		v := make([]byte, 88)
+		v[2] = f.TableID
+		// v[3] is padding
+		copy(v[4:44], f.rawMatch)
+		binary.BigEndian.PutUint32(v[44:48], uint32(duration.Seconds()))
+		binary.BigEndian.PutUint32(v[48:52], uint32(duration.Nanoseconds()%1e9))
+		binary.BigEndian.PutUint16(v[52:54], f.Priority)
+		binary.BigEndian.PutUint16(v[54:56], f.IdleTimeout)
+		binary.BigEndian.PutUint16(v[56:58], f.HardTimeout)
+		// v[58:64] is padding
+		binary.BigEndian.PutUint64(v[64:72], f.Cookie)
+		binary.BigEndian.PutUint64(v[72:80], f.PacketCount)
+		binary.BigEndian.PutUint64(v[80:88], f.ByteCount)
+		v = append(v, f.rawInstruction...)
+		binary.BigEndian.PutUint16(v[0:2], uint16(len(v)))
+		return v
+	}
+
+	v := make([]byte, 48)
+	v[2] = f.TableID
+	// v[3] is padding
+	binary.BigEndian.PutUint32(v[4:8], uint32(duration.Seconds()))
+	binary.BigEndian.PutUint32(v[8:12], uint32(duration.Nanoseconds()%1e9))
+	binary.BigEndian.PutUint16(v[12:14], f.Priority)
+	binary.BigEndian.PutUint16(v[14:16], f.IdleTimeout)
+	binary.BigEndian.PutUint16(v[16:18], f.HardTimeout)
+	binary.BigEndian.PutUint16(v[18:20], f.flags)
+	// v[20:24] is padding
+	binary.BigEndian.PutUint64(v[24:32], f.Cookie)
+	binary.BigEndian.PutUint64(v[32:40], f.PacketCount)
+	binary.BigEndian.PutUint64(v[40:48], f.ByteCount)
+	v = append(v, f.rawMatch...)
+	v = append(v, f.rawInstruction...)
+	binary.BigEndian.PutUint16(v[0:2], uint16(len(v)))
+
+	return v
+}
+
+func newFlowStatsReply(version uint8, xid uint32, flows []*flowEntry) []byte {
+	v := make([]byte, 0)
+	for _, f := range flows {
+		v = append(v, marshalFlowStats(version, f)...)
+	}
+
+	// OFPST_FLOW and OFPMP_FLOW are same
+	return newStatsReply(version, xid, of13.OFPMP_FLOW, v)
+}
+
+func newPacketIn(version uint8, inPort uint32, reason, tableID uint8, cookie uint64, data []byte) ([]byte, error) {
+	if version == openflow.OF10_VERSION {
+		v := make([]byte, 10)
+		binary.BigEndian.PutUint32(v[0:4], of10.OFP_NO_BUFFER)
+		binary.BigEndian.PutUint16(v[4:6], uint16(len(data)))
+		binary.BigEndian.PutUint16(v[6:8], uint16(inPort))
+		v[8] = reason
+		// v[9] is padding
+		return newMessage(version, of10.OFPT_PACKET_IN, 0, append(v, data...)), nil
+	}
+
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint32(v[0:4], of13.OFP_NO_BUFFER)
+	binary.BigEndian.PutUint16(v[4:6], uint16(len(data)))
+	v[6] = reason
+	v[7] = tableID
+	binary.BigEndian.PutUint64(v[8:16], cookie)
+
+	match := of13.NewMatch()
+	port := openflow.NewInPort()
+	port.SetValue(inPort)
+	match.SetInPort(port)
+	m, err := match.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	v = append(v, m...)
+	// Two bytes of padding precede the packet data
+	v = append(v, 0, 0)
+
+	return newMessage(version, of13.OFPT_PACKET_IN, 0, append(v, data...)), nil
+}
+
+func newPortStatus(version uint8, reason uint8, p *port) []byte {
+	v := make([]byte, 8)
+	v[0] = reason
+	// v[1:8] is padding
+	v = append(v, marshalPort(version, p)...)
+
+	if version == openflow.OF10_VERSION {
+		return newMessage(version, of10.OFPT_PORT_STATUS, 0, v)
+	}
+
+	return newMessage(version, of13.OFPT_PORT_STATUS, 0, v)
+}
+
+func newFlowRemoved(version uint8, f *flowEntry, reason uint8) []byte {
+	duration := f.duration()
+	if version == openflow.OF10_VERSION {
+		v := make([]byte, 80)
+		copy(v[0:40], f.rawMatch)
+		binary.BigEndian.PutUint64(v[40:48], f.Cookie)
+		binary.BigEndian.PutUint16(v[48:50], f.Priority)
+		v[50] = reason
+		// v[51] is padding
+		binary.BigEndian.PutUint32(v[52:56], uint32(duration.Seconds()))
+		binary.BigEndian.PutUint32(v[56:60], uint32(duration.Nanoseconds()%1e9))
+		binary.BigEndian.PutUint16(v[60:62], f.IdleTimeout)
+		// v[62:64] is padding
+		binary.BigEndian.PutUint64(v[64:72], f.PacketCount)
+		binary.BigEndian.PutUint64(v[72:80], f.ByteCount)
+		return newMessage(version, of10.OFPT_FLOW_REMOVED, 0, v)
+	}
+
+	v := make([]byte, 40)
+	binary.BigEndian.PutUint64(v[0:8], f.Cookie)
+	binary.BigEndian.PutUint16(v[8:10], f.Priority)
+	v[10] = reason
+	v[11] = f.TableID
+	binary.BigEndian.PutUint32(v[12:16], uint32(duration.Seconds()))
+	binary.BigEndian.PutUint32(v[16:20], uint32(duration.Nanoseconds()%1e9))
+	binary.BigEndian.PutUint16(v[20:22], f.IdleTimeout)
+	binary.BigEndian.PutUint16(v[22:24], f.HardTimeout)
+	binary.BigEndian.PutUint64(v[24:32], f.PacketCount)
+	binary.BigEndian.PutUint64(v[32:40], f.ByteCount)
+	v = append(v, f.rawMatch...)
+
+	return newMessage(version, of13.OFPT_FLOW_REMOVED, 0, v)
+}
+
+type flowMod struct {
+	command            uint8
+	cookie, cookieMask uint64
+	tableID            uint8
+	idleTimeout        uint16
+	hardTimeout        uint16
+	priority           uint16
+	outPort            uint32
+	flags              uint16
+	match              openflow.Match
+	rawMatch           []byte
+	rawInstruction     []byte
+	program            program
+}
+
+func decodeFlowMod(version uint8, payload []byte) (*flowMod, error) {
+	if version == openflow.OF10_VERSION {
+		return decodeOF10FlowMod(payload)
+	}
+
+	return decodeOF13FlowMod(payload)
+}
+
+func decodeOF10FlowMod(payload []byte) (*flowMod, error) {
+	if len(payload) < 64 {
+		return nil, openflow.ErrInvalidPacketLength
+	}
+
+	match := of10.NewMatch()
+	if err := match.UnmarshalBinary(payload[0:40]); err != nil {
+		return nil, err
+	}
+	actions, err := decodeOF10Actions(payload[64:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &flowMod{
+		command:        uint8(binary.BigEndian.Uint16(payload[48:50])),
+		cookie:         binary.BigEndian.Uint64(payload[40:48]),
+		idleTimeout:    binary.BigEndian.Uint16(payload[50:52]),
+		hardTimeout:    binary.BigEndian.Uint16(payload[52:54]),
+		priority:       binary.BigEndian.Uint16(payload[54:56]),
+		outPort:        normalizePort(openflow.OF10_VERSION, uint32(binary.BigEndian.Uint16(payload[60:62]))),
+		flags:          binary.BigEndian.Uint16(payload[62:64]),
+		match:          match,
+		rawMatch:       payload[0:40],
+		rawInstruction: payload[64:],
+		program:        program{actions: actions, gotoTable: -1},
+	}, nil
+}
+
+// paddedLength returns the length of an OpenFlow 1.3 match including its padding.
+func paddedLength(length int) int {
+	if rem := length % 8; rem > 0 {
+		length += 8 - rem
+	}
+
+	return length
+}
+
+func decodeOF13FlowMod(payload []byte) (*flowMod, error) {
+	if len(payload) < 44 {
+		return nil, openflow.ErrInvalidPacketLength
+	}
+
+	match := of13.NewMatch()
+	if err := match.UnmarshalBinary(payload[40:]); err != nil {
+		return nil, err
+	}
+	end := 40 + paddedLength(int(binary.BigEndian.Uint16(payload[42:44])))
+	if len(payload) < end {
+		return nil, openflow.ErrInvalidPacketLength
+	}
+	program, err := decodeOF13Instructions(payload[end:])
+	if err != nil {
+		return nil, err
+	}
+
+	return &flowMod{
+		command:        payload[17],
+		cookie:         binary.BigEndian.Uint64(payload[0:8]),
+		cookieMask:     binary.BigEndian.Uint64(payload[8:16]),
+		tableID:        payload[16],
+		idleTimeout:    binary.BigEndian.Uint16(payload[18:20]),
+		hardTimeout:    binary.BigEndian.Uint16(payload[20:22]),
+		priority:       binary.BigEndian.Uint16(payload[22:24]),
+		outPort:        binary.BigEndian.Uint32(payload[28:32]),
+		flags:          binary.BigEndian.Uint16(payload[36:38]),
+		match:          match,
+		rawMatch:       payload[40:end],
+		rawInstruction: payload[end:],
+		program:        program,
+	}, nil
+}
+
+func decodeOF13Instructions(data []byte) (program, error) {
+	result := program{gotoTable: -1}
+
+	buf := data
+	for len(buf) >= 4 {
+		length := int(binary.BigEndian.Uint16(buf[2:4]))
+		if length < 8 || len(buf) < length {
+			return program{}, openflow.ErrInvalidPacketLength
+		}
+
+		switch binary.BigEndian.Uint16(buf[0:2]) {
+		case of13.OFPIT_GOTO_TABLE:
+			result.gotoTable = int(buf[4])
+		case of13.OFPIT_APPLY_ACTIONS, of13.OFPIT_WRITE_ACTIONS:
+			actions, err := decodeOF13Actions(buf[8:length])
+			if err != nil {
+				return program{}, err
+			}
+			result.actions = append(result.actions, actions...)
+		default:
+			// Do nothing
+		}
+
+		buf = buf[length:]
+	}
+
+	return result, nil
+}
+
+func decodeActions(version uint8, data []byte) ([]action, error) {
+	if version == openflow.OF10_VERSION {
+		return decodeOF10Actions(data)
+	}
+
+	return decodeOF13Actions(data)
+}
+
+func decodeOF10Actions(data []byte) ([]action, error) {
+	result := make([]action, 0)
+
+	buf := data
+	for len(buf) >= 4 {
+		length := int(binary.BigEndian.Uint16(buf[2:4]))
+		if length < 8 || len(buf) < length {
+			return nil, openflow.ErrInvalidPacketLength
+		}
+
+		switch binary.BigEndian.Uint16(buf[0:2]) {
+		case of10.OFPAT_OUTPUT, of10.OFPAT_ENQUEUE:
+			port := normalizePort(openflow.OF10_VERSION, uint32(binary.BigEndian.Uint16(buf[4:6])))
+			result = append(result, action{kind: actionOutput, port: port})
+		case of10.OFPAT_SET_VLAN_VID:
+			result = append(result, action{kind: actionSetVLAN, vlanID: binary.BigEndian.Uint16(buf[4:6]) & 0x0FFF})
+		case of10.OFPAT_STRIP_VLAN:
+			result = append(result, action{kind: actionStripVLAN})
+		case of10.OFPAT_SET_DL_SRC, of10.OFPAT_SET_DL_DST:
+			if length < 16 {
+				return nil, openflow.ErrInvalidPacketLength
+			}
+			kind := actionSetSrcMAC
+			if binary.BigEndian.Uint16(buf[0:2]) == of10.OFPAT_SET_DL_DST {
+				kind = actionSetDstMAC
+			}
+			mac := make(net.HardwareAddr, 6)
+			copy(mac, buf[4:10])
+			result = append(result, action{kind: kind, mac: mac})
+		case of10.OFPAT_SET_NW_SRC, of10.OFPAT_SET_NW_DST:
+			kind := actionSetSrcIP
+			if binary.BigEndian.Uint16(buf[0:2]) == of10.OFPAT_SET_NW_DST {
+				kind = actionSetDstIP
+			}
+			result = append(result, action{kind: kind, ip: net.IPv4(buf[4], buf[5], buf[6], buf[7])})
+		case of10.OFPAT_SET_TP_SRC, of10.OFPAT_SET_TP_DST:
+			kind := actionSetSrcPort
+			if binary.BigEndian.Uint16(buf[0:2]) == of10.OFPAT_SET_TP_DST {
+				kind = actionSetDstPort
+			}
+			result = append(result, action{kind: kind, tpPort: binary.BigEndian.Uint16(buf[4:6])})
+		default:
+			return nil, errUnsupportedAction
+		}
+
+		buf = buf[length:]
+	}
+
+	return result, nil
+}
+
+func decodeOF13Actions(data []byte) ([]action, error) {
+	result := make([]action, 0)
+
+	buf := data
+	for len(buf) >= 4 {
+		length := int(binary.BigEndian.Uint16(buf[2:4]))
+		if length < 8 || len(buf) < length {
+			return nil, openflow.ErrInvalidPacketLength
+		}
+
+		switch binary.BigEndian.Uint16(buf[0:2]) {
+		case of13.OFPAT_OUTPUT:
+			result = append(result, action{kind: actionOutput, port: binary.BigEndian.Uint32(buf[4:8])})
+		case ofp13PushVLAN:
+			result = append(result, action{kind: actionPushVLAN})
+		case ofp13PopVLAN:
+			result = append(result, action{kind: actionStripVLAN})
+		case of13.OFPAT_DEC_NW_TTL:
+			result = append(result, action{kind: actionDecTTL})
+		case of13.OFPAT_SET_FIELD:
+			a, err := decodeSetField(buf[4:length])
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, a)
+		default:
+			return nil, errUnsupportedAction
+		}
+
+		buf = buf[length:]
+	}
+
+	return result, nil
+}
+
+func decodeSetField(tlv []byte) (action, error) {
+	if len(tlv) < 4 {
+		return action{}, openflow.ErrInvalidPacketLength
+	}
+	header := binary.BigEndian.Uint32(tlv[0:4])
+	if header>>16 != 0x8000 {
+		return action{}, errors.New("unsupported TLV class")
+	}
+	length := int(header & 0xFF)
+	if len(tlv) < 4+length {
+		return action{}, openflow.ErrInvalidPacketLength
+	}
+	value := tlv[4 : 4+length]
+
+	switch header >> 9 & 0x7F {
+	case of13.OFPXMT_OFB_ETH_SRC, of13.OFPXMT_OFB_ETH_DST:
+		if length != 6 {
+			return action{}, openflow.ErrInvalidPacketLength
+		}
+		kind := actionSetSrcMAC
+		if header>>9&0x7F == of13.OFPXMT_OFB_ETH_DST {
+			kind = actionSetDstMAC
+		}
+		mac := make(net.HardwareAddr, 6)
+		copy(mac, value)
+		return action{kind: kind, mac: mac}, nil
+	case of13.OFPXMT_OFB_VLAN_VID:
+		if length != 2 {
+			return action{}, openflow.ErrInvalidPacketLength
+		}
+		return action{kind: actionSetVLAN, vlanID: binary.BigEndian.Uint16(value) & 0x0FFF}, nil
+	case of13.OFPXMT_OFB_IPV4_SRC, of13.OFPXMT_OFB_IPV4_DST:
+		if length != 4 {
+			return action{}, openflow.ErrInvalidPacketLength
+		}
+		kind := actionSetSrcIP
+		if header>>9&0x7F == of13.OFPXMT_OFB_IPV4_DST {
+			kind = actionSetDstIP
+		}
+		return action{kind: kind, ip: net.IPv4(value[0], value[1], value[2], value[3])}, nil
+	case of13.OFPXMT_OFB_TCP_SRC, of13.OFPXMT_OFB_TCP_DST, of13.OFPXMT_OFB_UDP_SRC, of13.OFPXMT_OFB_UDP_DST:
+		if length != 2 {
+			return action{}, openflow.ErrInvalidPacketLength
+		}
+		kind := actionSetDstPort
+		if field := header >> 9 & 0x7F; field == of13.OFPXMT_OFB_TCP_SRC || field == of13.OFPXMT_OFB_UDP_SRC {
+			kind = actionSetSrcPort
+		}
+		return action{kind: kind, tpPort: binary.BigEndian.Uint16(value)}, nil
+	default:
+		return action{}, errUnsupportedAction
+	}
+}
+
+type packetOut struct {
+	inPort  uint32
+	actions []action
+	data    []byte
+}
+
+func decodePacketOut(version uint8, payload []byte) (*packetOut, error) {
+	var inPort uint32
+	var offset, length int
+
+	if version == openflow.OF10_VERSION {
+		if len(payload) < 8 {
+			return nil, openflow.ErrInvalidPacketLength
+		}
+		inPort = normalizePort(version, uint32(binary.BigEndian.Uint16(payload[4:6])))
+		offset = 8
+		length = int(binary.BigEndian.Uint16(payload[6:8]))
+	} else {
+		if len(payload) < 16 {
+			return nil, openflow.ErrInvalidPacketLength
+		}
+		inPort = binary.BigEndian.Uint32(payload[4:8])
+		offset = 16
+		length = int(binary.BigEndian.Uint16(payload[8:10]))
+	}
+	if len(payload) < offset+length {
+		return nil, openflow.ErrInvalidPacketLength
+	}
+
+	actions, err := decodeActions(version, payload[offset:offset+length])
+	if err != nil {
+		return nil, err
+	}
+
+	return &packetOut{
+		inPort:  inPort,
+		actions: actions,
+		data:    payload[offset+length:],
+	}, nil
+}
+
+type portMod struct {
+	number       uint32
+	config, mask uint32
+}
+
+func decodePortMod(version uint8, payload []byte) (*portMod, error) {
+	if version == openflow.OF10_VERSION {
+		if len(payload) < 24 {
+			return nil, openflow.ErrInvalidPacketLength
+		}
+		return &portMod{
+			number: uint32(binary.BigEndian.Uint16(payload[0:2])),
+			config: binary.BigEndian.Uint32(payload[8:12]),
+			mask:   binary.BigEndian.Uint32(payload[12:16]),
+		}, nil
+	}
+
+	if len(payload) < 32 {
+		return nil, openflow.ErrInvalidPacketLength
+	}
+	return &portMod{
+		number: binary.BigEndian.Uint32(payload[0:4]),
+		config: binary.BigEndian.Uint32(payload[16:20]),
+		mask:   binary.BigEndian.Uint32(payload[20:24]),
+	}, nil
+}
+
+type flowStatsRequest struct {
+	tableID            uint8
+	outPort            uint32
+	cookie, cookieMask uint64
+	match              openflow.Match
+}
+
+// decodeFlowStatsRequest decodes the body of a flow stats request, which follows the type and flags.
+func decodeFlowStatsRequest(version uint8, body []byte) (*flowStatsRequest, error) {
+	if version == openflow.OF10_VERSION {
+		if len(body) < 44 {
+			return nil, openflow.ErrInvalidPacketLength
+		}
+		match := of10.NewMatch()
+		if err := match.UnmarshalBinary(body[0:40]); err != nil {
+			return nil, err
+		}
+		return &flowStatsRequest{
+			tableID: 0xFF,
+			outPort: normalizePort(version, uint32(binary.BigEndian.Uint16(body[42:44]))),
+			match:   match,
+		}, nil
+	}
+
+	// body[0:4] is padding of the multipart request
+	if len(body) < 40 {
+		return nil, openflow.ErrInvalidPacketLength
+	}
+	match := of13.NewMatch()
+	if err := match.UnmarshalBinary(body[36:]); err != nil {
+		return nil, err
+	}
+	return &flowStatsRequest{
+		tableID:    body[4],
+		outPort:    binary.BigEndian.Uint32(body[8:12]),
+		cookie:     binary.BigEndian.Uint64(body[20:28]),
+		cookieMask: binary.BigEndian.Uint64(body[28:36]),
+		match:      match,
+	}, nil
+}
+
+// Error types and codes that are the same in OpenFlow 1.0 and 1.3
+const (
+	errorBadRequest = 1
+	errorBadAction  = 2
+
+	codeBadLength = 6
+	codeBadType   = 0
+)
+
+func newError(version uint8, xid uint32, cause error, msg []byte) []byte {
+	v := make([]byte, 4)
+	if cause == errUnsupportedAction {
+		binary.BigEndian.PutUint16(v[0:2], errorBadAction)
+		binary.BigEndian.PutUint16(v[2:4], codeBadType)
+	} else {
+		binary.BigEndian.PutUint16(v[0:2], errorBadRequest)
+		binary.BigEndian.PutUint16(v[2:4], codeBadLength)
+	}
+	// At least 64 bytes of the failed request
+	if len(msg) > 64 {
+		msg = msg[:64]
+	}
+
+	return newMessage(version, of13.OFPT_ERROR, xid, append(v, msg...))
+}
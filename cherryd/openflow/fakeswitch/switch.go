@@ -0,0 +1,926 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+// Package fakeswitch implements an in-memory OpenFlow switch that speaks OpenFlow 1.0 and 1.3.
+// It answers the handshake of the controller, keeps its flow table in memory, and forwards
+// Ethernet frames among the switches linked to each other so that we can test the controller
+// without physical switches.
+package fakeswitch
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/openflow/of10"
+	"github.com/superkkt/cherry/cherryd/openflow/of13"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// Maximum number of frames waiting to be processed. Frames are dropped if the queue is full.
+	ingressQueueSize = 1024
+	// Interval to expire the flows whose timeouts are passed
+	expireInterval = 500 * time.Millisecond
+)
+
+var (
+	ErrUnsupportedVersion = errors.New("unsupported OpenFlow version")
+	ErrUnknownPort        = errors.New("unknown port number")
+	ErrAlreadyConnected   = errors.New("already connected to a controller")
+	ErrNotConnected       = errors.New("not connected to a controller")
+	ErrClosed             = errors.New("closed switch")
+)
+
+type port struct {
+	number   uint32
+	mac      net.HardwareAddr
+	name     string
+	config   uint32 // Bitmap of OFPPC_* flags
+	linkDown bool
+	peer     *endpoint
+	// Frames sent to the hosts attached to this port
+	received [][]byte
+}
+
+type endpoint struct {
+	sw   *Switch
+	port uint32
+}
+
+type frame struct {
+	inPort uint32
+	data   []byte
+}
+
+// Switch is a fake OpenFlow switch.
+type Switch struct {
+	mutex      sync.Mutex
+	version    uint8
+	dpid       uint64
+	nativeVLAN uint16
+	ports      map[uint32]*port
+	flows      []*flowEntry // Sorted by the priority in descending order
+	conn       net.Conn
+	out        *outbox
+	ingress    chan frame
+	done       chan struct{}
+	closed     bool
+}
+
+// New returns a switch that has numPorts ports numbered from 1 and speaks OpenFlow version.
+func New(version uint8, dpid uint64, numPorts int) (*Switch, error) {
+	if version != openflow.OF10_VERSION && version != openflow.OF13_VERSION {
+		return nil, ErrUnsupportedVersion
+	}
+
+	v := &Switch{
+		version: version,
+		dpid:    dpid,
+		ports:   make(map[uint32]*port),
+		ingress: make(chan frame, ingressQueueSize),
+		done:    make(chan struct{}),
+	}
+	for i := 1; i <= numPorts; i++ {
+		n := uint32(i)
+		v.ports[n] = &port{
+			number: n,
+			mac:    net.HardwareAddr([]byte{0x02, byte(dpid >> 24), byte(dpid >> 16), byte(dpid >> 8), byte(dpid), byte(n)}),
+			name:   fmt.Sprintf("eth%v", n),
+		}
+	}
+	go v.run()
+
+	return v, nil
+}
+
+func (r *Switch) String() string {
+	return fmt.Sprintf("FakeSwitch DPID=%v, Version=%v, # of ports=%v", r.dpid, r.version, len(r.ports))
+}
+
+func (r *Switch) DPID() uint64 {
+	return r.dpid
+}
+
+func (r *Switch) Version() uint8 {
+	return r.version
+}
+
+// SetNativeVLAN sets the VLAN ID that untagged frames belong to. The frames tagged with this VLAN ID are
+// sent to the ports untagged, like the access ports of a legacy switch. Zero disables the native VLAN.
+func (r *Switch) SetNativeVLAN(vid uint16) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.nativeVLAN = vid & 0x0FFF
+}
+
+// PortMAC returns the hardware address of the port.
+func (r *Switch) PortMAC(num uint32) (net.HardwareAddr, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, ok := r.ports[num]
+	if !ok {
+		return nil, ErrUnknownPort
+	}
+
+	return p.mac, nil
+}
+
+// Link connects port a of switch sa and port b of switch sb with a virtual link.
+func Link(sa *Switch, a uint32, sb *Switch, b uint32) error {
+	if err := sa.setPeer(a, &endpoint{sw: sb, port: b}); err != nil {
+		return err
+	}
+	if err := sb.setPeer(b, &endpoint{sw: sa, port: a}); err != nil {
+		sa.setPeer(a, nil)
+		return err
+	}
+
+	return nil
+}
+
+// Unlink removes the virtual link on port a of switch sa.
+func Unlink(sa *Switch, a uint32) error {
+	sa.mutex.Lock()
+	p, ok := sa.ports[a]
+	if !ok {
+		sa.mutex.Unlock()
+		return ErrUnknownPort
+	}
+	peer := p.peer
+	p.peer = nil
+	sa.mutex.Unlock()
+
+	if peer != nil {
+		return peer.sw.setPeer(peer.port, nil)
+	}
+
+	return nil
+}
+
+func (r *Switch) setPeer(num uint32, peer *endpoint) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, ok := r.ports[num]
+	if !ok {
+		return ErrUnknownPort
+	}
+	p.peer = peer
+
+	return nil
+}
+
+// Serve answers the requests from the controller on conn until conn is closed. The switch sends
+// HELLO first as soon as Serve is called.
+func (r *Switch) Serve(conn net.Conn) error {
+	r.mutex.Lock()
+	if r.closed {
+		r.mutex.Unlock()
+		return ErrClosed
+	}
+	if r.conn != nil {
+		r.mutex.Unlock()
+		return ErrAlreadyConnected
+	}
+	out := newOutbox()
+	r.conn = conn
+	r.out = out
+	r.mutex.Unlock()
+
+	go out.run(conn)
+	defer func() {
+		r.mutex.Lock()
+		r.conn = nil
+		r.out = nil
+		r.mutex.Unlock()
+		out.close()
+		conn.Close()
+	}()
+
+	out.push(newHello(r.version))
+
+	reader := bufio.NewReader(conn)
+	for {
+		msg, err := readMessage(reader)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		r.handle(msg)
+	}
+}
+
+func readMessage(r io.Reader) ([]byte, error) {
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	length := int(binary.BigEndian.Uint16(header[2:4]))
+	if length < 8 {
+		return nil, openflow.ErrInvalidPacketLength
+	}
+	msg := make([]byte, length)
+	copy(msg, header)
+	if _, err := io.ReadFull(r, msg[8:]); err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// Close disconnects the controller and stops forwarding frames.
+func (r *Switch) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	close(r.done)
+	if r.conn != nil {
+		return r.conn.Close()
+	}
+
+	return nil
+}
+
+// send sends msg to the controller. msg is discarded if the switch is not connected.
+func (r *Switch) send(msg []byte) error {
+	r.mutex.Lock()
+	out := r.out
+	r.mutex.Unlock()
+
+	if out == nil {
+		return ErrNotConnected
+	}
+	out.push(msg)
+
+	return nil
+}
+
+func (r *Switch) handle(msg []byte) {
+	xid := binary.BigEndian.Uint32(msg[4:8])
+	payload := msg[8:]
+
+	var err error
+	switch msg[1] {
+	case of13.OFPT_HELLO, of13.OFPT_ECHO_REPLY, of13.OFPT_SET_CONFIG, of13.OFPT_ERROR:
+		// Do nothing
+	case of13.OFPT_ECHO_REQUEST:
+		err = r.send(newEchoReply(r.version, xid, payload))
+	case of13.OFPT_FEATURES_REQUEST:
+		err = r.send(newFeaturesReply(r.version, xid, r.dpid, r.sortedPorts()))
+	case of13.OFPT_GET_CONFIG_REQUEST:
+		err = r.send(newGetConfigReply(r.version, xid))
+	default:
+		if r.version == openflow.OF10_VERSION {
+			err = r.handleOF10(msg[1], xid, payload)
+		} else {
+			err = r.handleOF13(msg[1], xid, payload)
+		}
+	}
+	if err != nil && err != ErrNotConnected {
+		r.send(newError(r.version, xid, err, msg))
+	}
+}
+
+func (r *Switch) handleOF10(msgType uint8, xid uint32, payload []byte) error {
+	switch msgType {
+	case of10.OFPT_FLOW_MOD:
+		return r.handleFlowMod(xid, payload)
+	case of10.OFPT_PACKET_OUT:
+		return r.handlePacketOut(payload)
+	case of10.OFPT_PORT_MOD:
+		return r.handlePortMod(payload)
+	case of10.OFPT_STATS_REQUEST:
+		return r.handleStatsRequest(xid, payload, 4)
+	case of10.OFPT_BARRIER_REQUEST:
+		return r.send(newBarrierReply(r.version, xid))
+	case of10.OFPT_QUEUE_GET_CONFIG_REQUEST:
+		if len(payload) < 2 {
+			return openflow.ErrInvalidPacketLength
+		}
+		return r.send(newQueueGetConfigReply(r.version, xid, uint32(binary.BigEndian.Uint16(payload[0:2]))))
+	default:
+		// Unsupported message. Do nothing.
+		return nil
+	}
+}
+
+func (r *Switch) handleOF13(msgType uint8, xid uint32, payload []byte) error {
+	switch msgType {
+	case of13.OFPT_FLOW_MOD:
+		return r.handleFlowMod(xid, payload)
+	case of13.OFPT_PACKET_OUT:
+		return r.handlePacketOut(payload)
+	case of13.OFPT_PORT_MOD:
+		return r.handlePortMod(payload)
+	case of13.OFPT_MULTIPART_REQUEST:
+		return r.handleStatsRequest(xid, payload, 8)
+	case of13.OFPT_BARRIER_REQUEST:
+		return r.send(newBarrierReply(r.version, xid))
+	case of13.OFPT_QUEUE_GET_CONFIG_REQUEST:
+		if len(payload) < 4 {
+			return openflow.ErrInvalidPacketLength
+		}
+		return r.send(newQueueGetConfigReply(r.version, xid, binary.BigEndian.Uint32(payload[0:4])))
+	case of13.OFPT_ROLE_REQUEST:
+		return r.send(newRoleReply(xid, payload))
+	default:
+		// Unsupported message. Do nothing.
+		return nil
+	}
+}
+
+// handleStatsRequest handles STATS_REQUEST of OpenFlow 1.0 and MULTIPART_REQUEST of OpenFlow 1.3 whose body starts at offset.
+func (r *Switch) handleStatsRequest(xid uint32, payload []byte, offset int) error {
+	if len(payload) < offset {
+		return openflow.ErrInvalidPacketLength
+	}
+
+	// OFPST_* and OFPMP_* have same values for DESC and FLOW
+	switch binary.BigEndian.Uint16(payload[0:2]) {
+	case of13.OFPMP_DESC:
+		return r.send(newDescReply(r.version, xid, r.dpid))
+	case of13.OFPMP_FLOW:
+		req, err := decodeFlowStatsRequest(r.version, payload[4:])
+		if err != nil {
+			return err
+		}
+		return r.send(newFlowStatsReply(r.version, xid, r.queryFlows(req)))
+	case of13.OFPMP_PORT_DESC:
+		if r.version == openflow.OF10_VERSION {
+			return nil
+		}
+		return r.send(newPortDescReply(xid, r.sortedPorts()))
+	default:
+		// Unsupported statistics. Do nothing.
+		return nil
+	}
+}
+
+type portList []*port
+
+func (r portList) Len() int           { return len(r) }
+func (r portList) Less(i, j int) bool { return r[i].number < r[j].number }
+func (r portList) Swap(i, j int)      { r[i], r[j] = r[j], r[i] }
+
+func (r *Switch) sortedPorts() []*port {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := make([]*port, 0, len(r.ports))
+	for _, p := range r.ports {
+		// Copy to avoid the race condition with the caller
+		v := *p
+		result = append(result, &v)
+	}
+	sort.Sort(portList(result))
+
+	return result
+}
+
+func (r *Switch) handlePortMod(payload []byte) error {
+	mod, err := decodePortMod(r.version, payload)
+	if err != nil {
+		return err
+	}
+
+	r.mutex.Lock()
+	p, ok := r.ports[mod.number]
+	if !ok {
+		r.mutex.Unlock()
+		return ErrUnknownPort
+	}
+	p.config = p.config&^mod.mask | mod.config&mod.mask
+	status := newPortStatus(r.version, of13.OFPPR_MODIFY, p)
+	r.mutex.Unlock()
+
+	return r.send(status)
+}
+
+func (r *Switch) handlePacketOut(payload []byte) error {
+	out, err := decodePacketOut(r.version, payload)
+	if err != nil {
+		return err
+	}
+	r.execute(out.inPort, out.data, out.actions, nil)
+
+	return nil
+}
+
+// SetPortStatus changes the link state of the port, and then sends PORT_STATUS to the controller.
+func (r *Switch) SetPortStatus(num uint32, up bool) error {
+	r.mutex.Lock()
+	p, ok := r.ports[num]
+	if !ok {
+		r.mutex.Unlock()
+		return ErrUnknownPort
+	}
+	p.linkDown = !up
+	status := newPortStatus(r.version, of13.OFPPR_MODIFY, p)
+	r.mutex.Unlock()
+
+	return r.send(status)
+}
+
+// SendPortStatus sends PORT_STATUS of the port to the controller without changing its state.
+func (r *Switch) SendPortStatus(num uint32) error {
+	r.mutex.Lock()
+	p, ok := r.ports[num]
+	if !ok {
+		r.mutex.Unlock()
+		return ErrUnknownPort
+	}
+	status := newPortStatus(r.version, of13.OFPPR_MODIFY, p)
+	r.mutex.Unlock()
+
+	return r.send(status)
+}
+
+// SendPacketIn sends PACKET_IN that carries data received on the port to the controller regardless of the flow table.
+func (r *Switch) SendPacketIn(num uint32, data []byte) error {
+	r.mutex.Lock()
+	_, ok := r.ports[num]
+	r.mutex.Unlock()
+	if !ok {
+		return ErrUnknownPort
+	}
+
+	msg, err := newPacketIn(r.version, num, reasonNoMatch, 0, 0, data)
+	if err != nil {
+		return err
+	}
+
+	return r.send(msg)
+}
+
+// Inject puts data into the port as if a host attached to the port sent it. The frame is processed asynchronously.
+func (r *Switch) Inject(num uint32, data []byte) error {
+	r.mutex.Lock()
+	_, ok := r.ports[num]
+	r.mutex.Unlock()
+	if !ok {
+		return ErrUnknownPort
+	}
+	r.receive(num, data)
+
+	return nil
+}
+
+// Received returns the frames that have been sent to the hosts attached to the port.
+func (r *Switch) Received(num uint32) [][]byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p, ok := r.ports[num]
+	if !ok {
+		return nil
+	}
+	result := make([][]byte, len(p.received))
+	copy(result, p.received)
+
+	return result
+}
+
+// ClearReceived removes the frames that have been sent to the hosts attached to the port.
+func (r *Switch) ClearReceived(num uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if p, ok := r.ports[num]; ok {
+		p.received = nil
+	}
+}
+
+// Flows returns the entries of the flow table.
+func (r *Switch) Flows() []Flow {
+	r.expire()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := make([]Flow, len(r.flows))
+	for i, v := range r.flows {
+		result[i] = v.Flow
+	}
+
+	return result
+}
+
+func (r *Switch) receive(num uint32, data []byte) {
+	v := make([]byte, len(data))
+	copy(v, data)
+
+	select {
+	case r.ingress <- frame{inPort: num, data: v}:
+	default:
+		// Drop the frame if the queue is full
+	}
+}
+
+func (r *Switch) run() {
+	ticker := time.NewTicker(expireInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case f := <-r.ingress:
+			r.process(f.inPort, f.data)
+		case <-ticker.C:
+			r.expire()
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// process looks up the flow table for a frame received on the port, and then executes the actions of the matched flow.
+func (r *Switch) process(inPort uint32, data []byte) {
+	r.mutex.Lock()
+	p, ok := r.ports[inPort]
+	if !ok || p.linkDown || p.config&(of13.OFPPC_PORT_DOWN|of13.OFPPC_NO_RECV) != 0 {
+		r.mutex.Unlock()
+		return
+	}
+	r.mutex.Unlock()
+
+	table := uint8(0)
+	for {
+		pkt, err := parsePacket(inPort, data)
+		if err != nil {
+			return
+		}
+		flow := r.lookup(table, pkt, len(data))
+		if flow == nil {
+			// OpenFlow 1.3 switches drop the table-missed packets unless there is a table-miss flow entry.
+			if r.version == openflow.OF10_VERSION {
+				r.sendPacketIn(inPort, reasonNoMatch, table, 0, data)
+			}
+			return
+		}
+		data = r.execute(inPort, data, flow.program.actions, flow)
+		if flow.program.gotoTable < 0 {
+			return
+		}
+		table = uint8(flow.program.gotoTable)
+	}
+}
+
+func (r *Switch) lookup(table uint8, p *packet, length int) *flowEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	for _, v := range r.flows {
+		if v.TableID != table || !v.fields.match(p, r.nativeVLAN) {
+			continue
+		}
+		if ok, _ := v.expired(now); ok {
+			continue
+		}
+		v.PacketCount++
+		v.ByteCount += uint64(length)
+		v.used = now
+		return v
+	}
+
+	return nil
+}
+
+// execute applies the actions to data received on inPort, and then returns the modified data.
+func (r *Switch) execute(inPort uint32, data []byte, actions []action, flow *flowEntry) []byte {
+	for _, v := range actions {
+		if v.kind != actionOutput {
+			data = v.apply(data)
+			continue
+		}
+		r.output(inPort, v.port, data, flow)
+	}
+
+	return data
+}
+
+func (r *Switch) output(inPort, outPort uint32, data []byte, flow *flowEntry) {
+	switch outPort {
+	case of13.OFPP_CONTROLLER:
+		reason, table, cookie := uint8(reasonAction), uint8(0), uint64(0)
+		if flow != nil {
+			if flow.isTableMiss() {
+				reason = reasonNoMatch
+			}
+			table, cookie = flow.TableID, flow.Cookie
+		}
+		r.sendPacketIn(inPort, reason, table, cookie, data)
+	case of13.OFPP_IN_PORT:
+		r.transmit(inPort, data)
+	case of13.OFPP_FLOOD, of13.OFPP_ALL:
+		for _, p := range r.sortedPorts() {
+			if p.number == inPort {
+				continue
+			}
+			// OpenFlow 1.3 does not have OFPPC_NO_FLOOD
+			if outPort == of13.OFPP_FLOOD && r.version == openflow.OF10_VERSION && p.config&of10.OFPPC_NO_FLOOD != 0 {
+				continue
+			}
+			r.transmit(p.number, data)
+		}
+	case of13.OFPP_TABLE:
+		r.process(inPort, data)
+	default:
+		// Packets are not sent back to the ingress port unless OFPP_IN_PORT is used
+		if outPort > of13.OFPP_MAX || outPort == inPort {
+			return
+		}
+		r.transmit(outPort, data)
+	}
+}
+
+func (r *Switch) sendPacketIn(inPort uint32, reason, table uint8, cookie uint64, data []byte) {
+	r.mutex.Lock()
+	p, ok := r.ports[inPort]
+	if ok && p.config&of13.OFPPC_NO_PACKET_IN != 0 {
+		r.mutex.Unlock()
+		return
+	}
+	r.mutex.Unlock()
+
+	msg, err := newPacketIn(r.version, inPort, reason, table, cookie, data)
+	if err != nil {
+		return
+	}
+	r.send(msg)
+}
+
+// transmit sends data to the port, which is received by the linked switch or the attached hosts.
+func (r *Switch) transmit(num uint32, data []byte) {
+	r.mutex.Lock()
+	p, ok := r.ports[num]
+	if !ok || p.linkDown || p.config&(of13.OFPPC_PORT_DOWN|of13.OFPPC_NO_FWD) != 0 {
+		r.mutex.Unlock()
+		return
+	}
+	// Frames in the native VLAN leave the switch untagged
+	if ok, vid := vlanID(data); ok && r.nativeVLAN != 0 && vid == r.nativeVLAN {
+		data = stripVLAN(data)
+	}
+	peer := p.peer
+	if peer == nil {
+		v := make([]byte, len(data))
+		copy(v, data)
+		p.received = append(p.received, v)
+	}
+	r.mutex.Unlock()
+
+	if peer != nil {
+		peer.sw.receive(peer.port, data)
+	}
+}
+
+func (r *Switch) handleFlowMod(xid uint32, payload []byte) error {
+	mod, err := decodeFlowMod(r.version, payload)
+	if err != nil {
+		return err
+	}
+
+	var removed []*flowEntry
+	switch mod.command {
+	case of13.OFPFC_ADD:
+		r.addFlow(mod)
+	case of13.OFPFC_MODIFY, of13.OFPFC_MODIFY_STRICT:
+		r.modifyFlow(mod, mod.command == of13.OFPFC_MODIFY_STRICT)
+	case of13.OFPFC_DELETE, of13.OFPFC_DELETE_STRICT:
+		removed = r.deleteFlow(mod, mod.command == of13.OFPFC_DELETE_STRICT)
+	default:
+		return fmt.Errorf("unknown flow mod command: %v", mod.command)
+	}
+	for _, v := range removed {
+		r.notifyFlowRemoved(v, reasonDelete)
+	}
+
+	return nil
+}
+
+// addFlow adds a new flow entry. The existing one that has the same match and priority is replaced by the new one.
+func (r *Switch) addFlow(mod *flowMod) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	flow := newFlowEntry(mod)
+	for i, v := range r.flows {
+		if v.TableID == flow.TableID && v.Priority == flow.Priority && v.fields.equal(flow.fields) {
+			r.flows[i] = flow
+			return
+		}
+	}
+	r.flows = append(r.flows, flow)
+	// Stable sort to look up the flows in the installation order if they have same priority
+	sort.Stable(flowList(r.flows))
+}
+
+func (r *Switch) modifyFlow(mod *flowMod, strict bool) {
+	r.mutex.Lock()
+	modified := false
+	target := newFields(mod.match)
+	for _, v := range r.flows {
+		if !r.isTarget(v, mod.tableID, target, mod.priority, strict) {
+			continue
+		}
+		v.program = mod.program
+		v.rawInstruction = mod.rawInstruction
+		modified = true
+	}
+	r.mutex.Unlock()
+
+	// MODIFY adds a new flow if there is no matched flow
+	if !modified {
+		r.addFlow(mod)
+	}
+}
+
+func (r *Switch) isTarget(flow *flowEntry, table uint8, target fields, priority uint16, strict bool) bool {
+	// OpenFlow 1.0 has only one table
+	if r.version != openflow.OF10_VERSION && table != 0xFF && flow.TableID != table {
+		return false
+	}
+	if strict {
+		return flow.Priority == priority && flow.fields.equal(target)
+	}
+
+	return target.covers(flow.fields)
+}
+
+func (r *Switch) deleteFlow(mod *flowMod, strict bool) []*flowEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	removed := make([]*flowEntry, 0)
+	remained := make([]*flowEntry, 0, len(r.flows))
+	target := newFields(mod.match)
+	for _, v := range r.flows {
+		ok := r.isTarget(v, mod.tableID, target, mod.priority, strict)
+		ok = ok && v.Cookie&mod.cookieMask == mod.cookie&mod.cookieMask
+		if mod.outPort != of13.OFPP_ANY {
+			ok = ok && v.hasOutput(mod.outPort)
+		}
+		if ok {
+			removed = append(removed, v)
+		} else {
+			remained = append(remained, v)
+		}
+	}
+	r.flows = remained
+
+	return removed
+}
+
+func (r *Switch) queryFlows(req *flowStatsRequest) []*flowEntry {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	result := make([]*flowEntry, 0)
+	target := newFields(req.match)
+	for _, v := range r.flows {
+		if !r.isTarget(v, req.tableID, target, 0, false) {
+			continue
+		}
+		if v.Cookie&req.cookieMask != req.cookie&req.cookieMask {
+			continue
+		}
+		if req.outPort != of13.OFPP_ANY && !v.hasOutput(req.outPort) {
+			continue
+		}
+		result = append(result, v)
+	}
+
+	return result
+}
+
+// expire removes the flows whose timeouts are passed.
+func (r *Switch) expire() {
+	type expiration struct {
+		flow   *flowEntry
+		reason uint8
+	}
+
+	r.mutex.Lock()
+	now := time.Now()
+	expired := make([]expiration, 0)
+	remained := make([]*flowEntry, 0, len(r.flows))
+	for _, v := range r.flows {
+		if ok, reason := v.expired(now); ok {
+			expired = append(expired, expiration{v, reason})
+		} else {
+			remained = append(remained, v)
+		}
+	}
+	r.flows = remained
+	r.mutex.Unlock()
+
+	for _, v := range expired {
+		r.notifyFlowRemoved(v.flow, v.reason)
+	}
+}
+
+func (r *Switch) notifyFlowRemoved(flow *flowEntry, reason uint8) {
+	if flow.flags&of13.OFPFF_SEND_FLOW_REM == 0 {
+		return
+	}
+	r.send(newFlowRemoved(r.version, flow, reason))
+}
+
+// outbox is an unbounded queue of the messages to be sent to the controller. It decouples the writer from
+// the reader of the connection so that the switch does not block the controller that also writes synchronously.
+type outbox struct {
+	mutex   sync.Mutex
+	queue   [][]byte
+	signal  chan struct{}
+	done    chan struct{}
+	stopped bool
+}
+
+func newOutbox() *outbox {
+	return &outbox{
+		signal: make(chan struct{}, 1),
+		done:   make(chan struct{}),
+	}
+}
+
+func (r *outbox) push(msg []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return
+	}
+	r.queue = append(r.queue, msg)
+	select {
+	case r.signal <- struct{}{}:
+	default:
+	}
+}
+
+func (r *outbox) pop() [][]byte {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	v := r.queue
+	r.queue = nil
+
+	return v
+}
+
+func (r *outbox) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.stopped {
+		return
+	}
+	r.stopped = true
+	close(r.done)
+}
+
+func (r *outbox) run(w io.Writer) {
+	for {
+		select {
+		case <-r.signal:
+			for _, msg := range r.pop() {
+				if _, err := w.Write(msg); err != nil {
+					return
+				}
+			}
+		case <-r.done:
+			return
+		}
+	}
+}
@@ -0,0 +1,318 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package fakeswitch
+
+import (
+	"bytes"
+	"encoding"
+	"github.com/superkkt/cherry/cherryd/openflow"
+	"github.com/superkkt/cherry/cherryd/openflow/of10"
+	"github.com/superkkt/cherry/cherryd/openflow/of13"
+	"net"
+	"testing"
+	"time"
+)
+
+var (
+	hostA = net.HardwareAddr([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x0A})
+	hostB = net.HardwareAddr([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x0B})
+	hostC = net.HardwareAddr([]byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x0C})
+)
+
+// controller is a minimal controller that sends raw OpenFlow messages to a fake switch.
+type controller struct {
+	t        *testing.T
+	conn     net.Conn
+	version  uint8
+	factory  openflow.Factory
+	messages chan []byte
+}
+
+func newController(t *testing.T, sw *Switch) *controller {
+	c, s := net.Pipe()
+	go sw.Serve(s)
+
+	v := &controller{t: t, conn: c, version: sw.Version(), messages: make(chan []byte, 1024)}
+	if sw.Version() == openflow.OF10_VERSION {
+		v.factory = of10.NewFactory()
+	} else {
+		v.factory = of13.NewFactory()
+	}
+	go func() {
+		for {
+			msg, err := readMessage(c)
+			if err != nil {
+				close(v.messages)
+				return
+			}
+			v.messages <- msg
+		}
+	}()
+	// The switch should send HELLO first
+	if msg := v.expect(of13.OFPT_HELLO); msg[0] != sw.Version() {
+		t.Fatalf("expected HELLO of version %v, got %v", sw.Version(), msg[0])
+	}
+
+	return v
+}
+
+func (r *controller) write(msg encoding.BinaryMarshaler) {
+	packet, err := msg.MarshalBinary()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.conn.Write(packet); err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// expect returns the next message whose type is msgType, skipping the others.
+func (r *controller) expect(msgType uint8) []byte {
+	timeout := time.After(3 * time.Second)
+	for {
+		select {
+		case msg, ok := <-r.messages:
+			if !ok {
+				r.t.Fatalf("connection closed while waiting for message type %v", msgType)
+			}
+			if msg[1] == msgType {
+				return msg
+			}
+		case <-timeout:
+			r.t.Fatalf("timeout while waiting for message type %v", msgType)
+		}
+	}
+}
+
+// barrier makes sure that the switch has processed all the previous messages.
+func (r *controller) barrier() {
+	msg, err := r.factory.NewBarrierRequest()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	r.write(msg)
+	if r.version == openflow.OF10_VERSION {
+		r.expect(of10.OFPT_BARRIER_REPLY)
+	} else {
+		r.expect(of13.OFPT_BARRIER_REPLY)
+	}
+}
+
+func (r *controller) installFlow(inPort uint32, dst, rewrite net.HardwareAddr, outPort uint32) {
+	match, err := r.factory.NewMatch()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	in := openflow.NewInPort()
+	in.SetValue(inPort)
+	match.SetInPort(in)
+	match.SetDstMAC(dst)
+
+	out := openflow.NewOutPort()
+	out.SetValue(outPort)
+	action, err := r.factory.NewAction()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	action.SetDstMAC(rewrite)
+	action.SetOutPort(out)
+	inst, err := r.factory.NewInstruction()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	inst.ApplyAction(action)
+
+	flow, err := r.factory.NewFlowMod(openflow.FlowAdd)
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	flow.SetCookie(0x1234)
+	flow.SetPriority(10)
+	flow.SetFlowMatch(match)
+	flow.SetFlowInstruction(inst)
+	r.write(flow)
+	r.barrier()
+}
+
+func (r *controller) removeAllFlows() {
+	match, err := r.factory.NewMatch()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	flow, err := r.factory.NewFlowMod(openflow.FlowDelete)
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	flow.SetTableID(0xFF)
+	flow.SetFlowMatch(match)
+	r.write(flow)
+}
+
+func (r *controller) flood(data []byte) {
+	out := openflow.NewOutPort()
+	out.SetFlood()
+	action, err := r.factory.NewAction()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	action.SetOutPort(out)
+	msg, err := r.factory.NewPacketOut()
+	if err != nil {
+		r.t.Fatalf("unexpected error: %v", err)
+	}
+	msg.SetInPort(openflow.NewInPort())
+	msg.SetAction(action)
+	msg.SetData(data)
+	r.write(msg)
+	r.barrier()
+}
+
+func newFrame(src, dst net.HardwareAddr) []byte {
+	v := make([]byte, 60)
+	copy(v[0:6], dst)
+	copy(v[6:12], src)
+	// Experimental EtherType
+	v[12], v[13] = 0x88, 0xB5
+
+	return v
+}
+
+func waitFrames(t *testing.T, sw *Switch, port uint32, n int) [][]byte {
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		frames := sw.Received(port)
+		if len(frames) >= n {
+			return frames
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v frames on port %v, got %v", n, port, len(frames))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func testForwarding(t *testing.T, version uint8) {
+	sw1, err := New(version, 1, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sw1.Close()
+	sw2, err := New(version, 2, 3)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer sw2.Close()
+	if err := Link(sw1, 2, sw2, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c1 := newController(t, sw1)
+	defer c1.conn.Close()
+	c2 := newController(t, sw2)
+	defer c2.conn.Close()
+
+	// hostA on sw1:1 -> hostB on sw2:3, which is rewritten to hostC on sw2
+	c1.installFlow(1, hostB, hostB, 2)
+	c2.installFlow(1, hostB, hostC, 3)
+	if err := sw1.Inject(1, newFrame(hostA, hostB)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	frames := waitFrames(t, sw2, 3, 1)
+	if !bytes.Equal(frames[0][0:6], hostC) || !bytes.Equal(frames[0][6:12], hostA) {
+		t.Fatalf("unexpected frame: dst=%v, src=%v", net.HardwareAddr(frames[0][0:6]), net.HardwareAddr(frames[0][6:12]))
+	}
+	flows := sw1.Flows()
+	if len(flows) != 1 || flows[0].Cookie != 0x1234 || flows[0].PacketCount != 1 {
+		t.Fatalf("unexpected flows: %+v", flows)
+	}
+
+	// PACKET_OUT to the flood port
+	c1.flood(newFrame(hostB, hostA))
+	waitFrames(t, sw1, 1, 1)
+	waitFrames(t, sw1, 3, 1)
+	if n := len(sw2.Received(3)); n != 1 {
+		t.Fatalf("expected the flooded frame to be dropped on sw2, got %v frames", n)
+	}
+
+	// Removed flows are notified by FLOW_REMOVED
+	c1.removeAllFlows()
+	if version == openflow.OF10_VERSION {
+		c1.expect(of10.OFPT_FLOW_REMOVED)
+	} else {
+		c1.expect(of13.OFPT_FLOW_REMOVED)
+	}
+	if len(sw1.Flows()) != 0 {
+		t.Fatalf("expected no flows, got %v", sw1.Flows())
+	}
+
+	// Table-missed packets are sent to the controller only by OpenFlow 1.0 switches
+	if err := sw1.Inject(1, newFrame(hostA, hostB)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if version == openflow.OF10_VERSION {
+		msg := c1.expect(of10.OFPT_PACKET_IN)
+		packetIn, err := c1.factory.NewPacketIn()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := packetIn.UnmarshalBinary(msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if packetIn.InPort() != 1 || !bytes.Equal(packetIn.Data(), newFrame(hostA, hostB)) {
+			t.Fatalf("unexpected PACKET_IN: inport=%v", packetIn.InPort())
+		}
+	}
+}
+
+func TestForwardingOF10(t *testing.T) {
+	testForwarding(t, openflow.OF10_VERSION)
+}
+
+func TestForwardingOF13(t *testing.T) {
+	testForwarding(t, openflow.OF13_VERSION)
+}
+
+func TestNativeVLAN(t *testing.T) {
+	match := of13.NewMatch()
+	match.SetVLANID(1000)
+	f := newFields(match)
+	frame := newFrame(hostA, hostB)
+
+	p, err := parsePacket(1, frame)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if f.match(p, 0) {
+		t.Fatalf("expected an untagged frame not to match VLAN 1000 without the native VLAN")
+	}
+	if !f.match(p, 1000) {
+		t.Fatalf("expected an untagged frame to match VLAN 1000 of the native VLAN")
+	}
+
+	tagged := action{kind: actionSetVLAN, vlanID: 1000}.apply(frame)
+	if ok, vid := vlanID(tagged); !ok || vid != 1000 || len(tagged) != len(frame)+4 {
+		t.Fatalf("unexpected tagged frame: ok=%v, vid=%v, length=%v", ok, vid, len(tagged))
+	}
+	if !bytes.Equal(stripVLAN(tagged), frame) {
+		t.Fatalf("expected the original frame after stripping the VLAN tag")
+	}
+}
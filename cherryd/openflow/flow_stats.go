@@ -40,4 +40,22 @@ type FlowStatsRequest interface {
 	TableID() uint8
 }
 
-// TODO: Implement FlowStatsReply
+// FlowStats is an individual flow entry reported by a switch.
+type FlowStats struct {
+	TableID     uint8
+	Priority    uint16
+	Cookie      uint64
+	PacketCount uint64
+	ByteCount   uint64
+	Match       Match
+	// OutPorts are the physical ports to which the flow outputs the packets
+	OutPorts []uint32
+}
+
+type FlowStatsReply interface {
+	Header
+	encoding.BinaryUnmarshaler
+	Flows() []FlowStats
+	// More returns whether the switch will send more replies for the same request.
+	More() bool
+}
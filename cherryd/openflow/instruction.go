@@ -26,6 +26,8 @@ import (
 )
 
 type Instruction interface {
+	// Action returns nil if the instruction does not have an action
+	Action() Action
 	ApplyAction(act Action)
 	encoding.BinaryMarshaler
 	Error() error
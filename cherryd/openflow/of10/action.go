@@ -106,6 +106,30 @@ func marshalMAC(t uint16, mac net.HardwareAddr) ([]byte, error) {
 	return v, nil
 }
 
+func marshalIP(t uint16, ip net.IP) ([]byte, error) {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return nil, openflow.ErrInvalidIPAddress
+	}
+
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint16(v[0:2], t)
+	binary.BigEndian.PutUint16(v[2:4], 8)
+	copy(v[4:8], ipv4)
+
+	return v, nil
+}
+
+func marshalTransportPort(t uint16, port uint16) ([]byte, error) {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint16(v[0:2], t)
+	binary.BigEndian.PutUint16(v[2:4], 8)
+	binary.BigEndian.PutUint16(v[4:6], port)
+	// v[6:8] is padding
+
+	return v, nil
+}
+
 func marshalVLANID(vid uint16) ([]byte, error) {
 	v := make([]byte, 8)
 	binary.BigEndian.PutUint16(v[0:2], uint16(OFPAT_SET_VLAN_VID))
@@ -116,10 +140,53 @@ func marshalVLANID(vid uint16) ([]byte, error) {
 	return v, nil
 }
 
+// matchedVLANID returns the VLAN ID of the packets matched with match. It returns zero if the packets are
+// untagged, or match is nil or does not specify the VLAN ID.
+func matchedVLANID(match openflow.Match) uint16 {
+	m, ok := match.(*Match)
+	if !ok {
+		return 0
+	}
+	wildcard, vid := m.VLANID()
+	// 0xFFFF is OFP_VLAN_NONE, which matches the untagged packets
+	if wildcard || vid == 0xFFFF {
+		return 0
+	}
+
+	return vid
+}
+
+func marshalStripVLAN() []byte {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint16(v[0:2], uint16(OFPAT_STRIP_VLAN))
+	binary.BigEndian.PutUint16(v[2:4], 8)
+	// v[4:8] is padding
+
+	return v
+}
+
+// marshalAction marshals action that is applied to the packets matched with match, which can be nil if the
+// packets are unknown.
+func marshalAction(action openflow.Action, match openflow.Match) ([]byte, error) {
+	if v, ok := action.(*Action); ok {
+		return v.marshal(match)
+	}
+
+	return action.MarshalBinary()
+}
+
 func (r *Action) MarshalBinary() ([]byte, error) {
+	return r.marshal(nil)
+}
+
+func (r *Action) marshal(match openflow.Match) ([]byte, error) {
 	if err := r.Error(); err != nil {
 		return nil, err
 	}
+	// OpenFlow 1.0 does not have an action to decrement the TTL
+	if r.DecTTL() {
+		return nil, openflow.ErrUnsupportedAction
+	}
 
 	result := make([]byte, 0)
 	if ok, srcMAC := r.SrcMAC(); ok {
@@ -136,9 +203,38 @@ func (r *Action) MarshalBinary() ([]byte, error) {
 		}
 		result = append(result, v...)
 	}
+	if ok, srcIP := r.SrcIP(); ok {
+		v, err := marshalIP(OFPAT_SET_NW_SRC, srcIP)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+	if ok, dstIP := r.DstIP(); ok {
+		v, err := marshalIP(OFPAT_SET_NW_DST, dstIP)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+	// OpenFlow 1.0 rewrites the port regardless of whether the packet is TCP or UDP
+	if ok, _, srcPort := r.SrcPort(); ok {
+		v, err := marshalTransportPort(OFPAT_SET_TP_SRC, srcPort)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+	if ok, _, dstPort := r.DstPort(); ok {
+		v, err := marshalTransportPort(OFPAT_SET_TP_DST, dstPort)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
 
-	ok, vlanID := r.VLANID()
-	if ok {
+	setVLAN, vlanID := r.VLANID()
+	if setVLAN {
 		v, err := marshalVLANID(vlanID)
 		if err != nil {
 			return nil, err
@@ -151,15 +247,73 @@ func (r *Action) MarshalBinary() ([]byte, error) {
 	var err error
 	// Need QoS?
 	ok, queueID := r.Queue()
-	if ok {
-		buf, err = marshalQueue(r.OutPort(), queueID)
-	} else {
-		buf, err = marshalOutPort(r.OutPort())
+	for _, p := range append([]openflow.OutPort{r.OutPort()}, r.ExtraOutPorts()...) {
+		if ok {
+			buf, err = marshalQueue(p, queueID)
+		} else {
+			buf, err = marshalOutPort(p)
+		}
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, buf...)
 	}
-	if err != nil {
-		return nil, err
+
+	// Mirrors are applied after the main output so that they do not modify the original packet
+	mirrors := r.Mirrors()
+	if len(mirrors) > 0 && match != nil {
+		// Restore the MAC addresses rewritten by the main output if the original ones are known
+		if ok, _ := r.SrcMAC(); ok {
+			if wildcard, mac := match.SrcMAC(); !wildcard {
+				v, err := marshalMAC(OFPAT_SET_DL_SRC, mac)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, v...)
+			}
+		}
+		if ok, _ := r.DstMAC(); ok {
+			if wildcard, mac := match.DstMAC(); !wildcard {
+				v, err := marshalMAC(OFPAT_SET_DL_DST, mac)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, v...)
+			}
+		}
+	}
+	// VLAN ID of the packet, which is zero if it is untagged
+	origVLANID := matchedVLANID(match)
+	cur := origVLANID
+	if setVLAN {
+		cur = vlanID
+	}
+	for _, m := range mirrors {
+		// Each copy has the original VLAN tag unless it is tagged with the VLAN ID of the mirror
+		vid := origVLANID
+		if m.VLANID != 0 {
+			vid = m.VLANID
+		}
+		switch {
+		case vid == cur:
+			// Nothing to change
+		case vid == 0:
+			result = append(result, marshalStripVLAN()...)
+		default:
+			v, err := marshalVLANID(vid)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v...)
+		}
+		cur = vid
+
+		v, err := marshalOutPort(m.Port)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
 	}
-	result = append(result, buf...)
 
 	return result, nil
 }
@@ -200,6 +354,33 @@ func (r *Action) UnmarshalBinary(data []byte) error {
 			if err := r.Error(); err != nil {
 				return err
 			}
+		case OFPAT_SET_NW_SRC, OFPAT_SET_NW_DST:
+			if len(buf) < 8 {
+				return openflow.ErrInvalidPacketLength
+			}
+			ip := net.IPv4(buf[4], buf[5], buf[6], buf[7])
+			if t == OFPAT_SET_NW_SRC {
+				r.SetSrcIP(ip)
+			} else {
+				r.SetDstIP(ip)
+			}
+			if err := r.Error(); err != nil {
+				return err
+			}
+		case OFPAT_SET_TP_SRC, OFPAT_SET_TP_DST:
+			if len(buf) < 8 {
+				return openflow.ErrInvalidPacketLength
+			}
+			// OpenFlow 1.0 does not distinguish TCP and UDP ports
+			port := binary.BigEndian.Uint16(buf[4:6])
+			if t == OFPAT_SET_TP_SRC {
+				r.SetSrcPort(0, port)
+			} else {
+				r.SetDstPort(0, port)
+			}
+			if err := r.Error(); err != nil {
+				return err
+			}
 		case OFPAT_ENQUEUE:
 			if len(buf) < 16 {
 				return openflow.ErrInvalidPacketLength
@@ -169,6 +169,10 @@ const (
 	OFPST_VENDOR = 0xffff
 )
 
+const (
+	OFPSF_REPLY_MORE = 1 << 0 /* More replies to follow. */
+)
+
 const (
 	OFPC_FRAG_NORMAL = iota /* No special handling for fragments. */
 	OFPC_FRAG_DROP          /* Drop fragments. */
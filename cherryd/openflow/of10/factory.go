@@ -99,6 +99,8 @@ func getFlowModCmd(cmd openflow.FlowModCmd) uint16 {
 		c = OFPFC_MODIFY
 	case openflow.FlowDelete:
 		c = OFPFC_DELETE
+	case openflow.FlowDeleteStrict:
+		c = OFPFC_DELETE_STRICT
 	default:
 		panic(fmt.Sprintf("unexpected FlowModCmd: %v", cmd))
 	}
@@ -138,7 +140,9 @@ func (r *Factory) NewFlowStatsRequest() (openflow.FlowStatsRequest, error) {
 	return NewFlowStatsRequest(r.getTransactionID()), nil
 }
 
-// TODO: NewFlowStatsReply() (openflow.FlowStatsReply, error) {
+func (r *Factory) NewFlowStatsReply() (openflow.FlowStatsReply, error) {
+	return new(FlowStatsReply), nil
+}
 
 func (r *Factory) NewPortDescRequest() (openflow.PortDescRequest, error) {
 	return nil, errors.New("of10 does not support PortDescRequest")
@@ -148,6 +152,18 @@ func (r *Factory) NewPortDescReply() (openflow.PortDescReply, error) {
 	return nil, errors.New("of10 does not support PortDescReply")
 }
 
+func (r *Factory) NewPortMod() (openflow.PortMod, error) {
+	return NewPortMod(r.getTransactionID()), nil
+}
+
+func (r *Factory) NewRoleRequest() (openflow.RoleRequest, error) {
+	return nil, errors.New("of10 does not support RoleRequest")
+}
+
+func (r *Factory) NewSetAsync() (openflow.SetAsync, error) {
+	return nil, errors.New("of10 does not support SetAsync")
+}
+
 func (r *Factory) NewTableFeaturesRequest() (openflow.TableFeaturesRequest, error) {
 	return nil, errors.New("of10 does not support TableFeaturesRequest")
 }
@@ -170,7 +170,7 @@ func (r *FlowMod) MarshalBinary() ([]byte, error) {
 	result = append(result, v...)
 
 	if r.instruction != nil {
-		instruction, err := r.instruction.MarshalBinary()
+		instruction, err := marshalInstruction(r.instruction, r.match)
 		if err != nil {
 			return nil, err
 		}
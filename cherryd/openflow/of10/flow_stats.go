@@ -108,4 +108,78 @@ func (r *FlowStatsRequest) MarshalBinary() ([]byte, error) {
 	return r.Message.MarshalBinary()
 }
 
-// TODO: Implement FlowStatsReply
+type FlowStatsReply struct {
+	openflow.Message
+	more  bool
+	flows []openflow.FlowStats
+}
+
+func (r *FlowStatsReply) Flows() []openflow.FlowStats {
+	return r.flows
+}
+
+func (r *FlowStatsReply) More() bool {
+	return r.more
+}
+
+func (r *FlowStatsReply) UnmarshalBinary(data []byte) error {
+	if err := r.Message.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	payload := r.Payload()
+	if payload == nil || len(payload) < 4 {
+		return openflow.ErrInvalidPacketLength
+	}
+	// payload[0:2] is type of ofp_stats_reply
+	r.more = binary.BigEndian.Uint16(payload[2:4])&OFPSF_REPLY_MORE != 0
+
+	buf := payload[4:]
+	for len(buf) > 0 {
+		if len(buf) < 88 {
+			return openflow.ErrInvalidPacketLength
+		}
+		length := int(binary.BigEndian.Uint16(buf[0:2]))
+		if length < 88 || len(buf) < length {
+			return openflow.ErrInvalidPacketLength
+		}
+		match := NewMatch()
+		if err := match.UnmarshalBinary(buf[4:44]); err != nil {
+			return err
+		}
+		r.flows = append(r.flows, openflow.FlowStats{
+			TableID:  buf[2],
+			Priority: binary.BigEndian.Uint16(buf[52:54]),
+			// buf[44:52] is duration and buf[54:64] is timeouts and padding
+			Cookie:      binary.BigEndian.Uint64(buf[64:72]),
+			PacketCount: binary.BigEndian.Uint64(buf[72:80]),
+			ByteCount:   binary.BigEndian.Uint64(buf[80:88]),
+			Match:       match,
+			// Actions follow the fixed part
+			OutPorts: outputPorts(buf[88:length]),
+		})
+		buf = buf[length:]
+	}
+
+	return nil
+}
+
+// outputPorts returns the physical ports of the output actions in actions.
+func outputPorts(actions []byte) []uint32 {
+	result := make([]uint32, 0)
+	for len(actions) >= 8 {
+		length := int(binary.BigEndian.Uint16(actions[2:4]))
+		if length < 8 || len(actions) < length {
+			break
+		}
+		if binary.BigEndian.Uint16(actions[0:2]) == OFPAT_OUTPUT {
+			port := binary.BigEndian.Uint16(actions[4:6])
+			if port <= OFPP_MAX {
+				result = append(result, uint32(port))
+			}
+		}
+		actions = actions[length:]
+	}
+
+	return result
+}
@@ -35,6 +35,10 @@ func (r *Instruction) Error() error {
 	return r.err
 }
 
+func (r *Instruction) Action() openflow.Action {
+	return r.action
+}
+
 func (r *Instruction) GotoTable(tableID uint8) {
 	// OpenFlow 1.0 does not support GotoTable
 }
@@ -54,6 +58,11 @@ func (r *Instruction) ApplyAction(act openflow.Action) {
 }
 
 func (r *Instruction) MarshalBinary() ([]byte, error) {
+	return r.marshal(nil)
+}
+
+// marshal marshals the instruction whose actions are applied to the packets matched with match.
+func (r *Instruction) marshal(match openflow.Match) ([]byte, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
@@ -62,5 +71,14 @@ func (r *Instruction) MarshalBinary() ([]byte, error) {
 		return nil, errors.New("empty action of an instruction")
 	}
 
-	return r.action.MarshalBinary()
+	return marshalAction(r.action, match)
+}
+
+// marshalInstruction marshals inst whose actions are applied to the packets matched with match.
+func marshalInstruction(inst openflow.Instruction, match openflow.Match) ([]byte, error) {
+	if v, ok := inst.(*Instruction); ok {
+		return v.marshal(match)
+	}
+
+	return inst.MarshalBinary()
 }
@@ -62,6 +62,14 @@ func (r Port) IsPortDown() bool {
 	return false
 }
 
+func (r Port) IsNoFlood() bool {
+	return r.config&OFPPC_NO_FLOOD != 0
+}
+
+func (r Port) IsNoPacketIn() bool {
+	return r.config&OFPPC_NO_PACKET_IN != 0
+}
+
 func (r Port) IsLinkDown() bool {
 	if r.state&OFPPS_LINK_DOWN != 0 {
 		return true
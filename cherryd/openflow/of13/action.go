@@ -78,6 +78,11 @@ func marshalMAC(t uint8, mac net.HardwareAddr) ([]byte, error) {
 		return nil, err
 	}
 
+	return marshalSetField(tlv), nil
+}
+
+// marshalSetField returns a SET_FIELD action that contains tlv.
+func marshalSetField(tlv []byte) []byte {
 	v := make([]byte, 4+len(tlv))
 	binary.BigEndian.PutUint16(v[0:2], OFPAT_SET_FIELD)
 	// Add padding to align as a multiple of 8
@@ -88,18 +93,136 @@ func marshalMAC(t uint8, mac net.HardwareAddr) ([]byte, error) {
 	binary.BigEndian.PutUint16(v[2:4], uint16(len(v)))
 	copy(v[4:], tlv)
 
-	return v, nil
+	return v
+}
+
+func marshalIP(t uint8, ip net.IP) ([]byte, error) {
+	ipv4 := ip.To4()
+	if ipv4 == nil {
+		return nil, openflow.ErrInvalidIPAddress
+	}
+
+	tlv, err := marshalUint32TLV(t, binary.BigEndian.Uint32(ipv4))
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalSetField(tlv), nil
+}
+
+func marshalTransportPort(protocol uint8, src bool, port uint16) ([]byte, error) {
+	var t uint8
+	switch protocol {
+	// TCP
+	case 0x06:
+		t = OFPXMT_OFB_TCP_DST
+		if src {
+			t = OFPXMT_OFB_TCP_SRC
+		}
+	// UDP
+	case 0x11:
+		t = OFPXMT_OFB_UDP_DST
+		if src {
+			t = OFPXMT_OFB_UDP_SRC
+		}
+	default:
+		return nil, openflow.ErrUnsupportedIPProtocol
+	}
+
+	tlv, err := marshalUint16TLV(t, port)
+	if err != nil {
+		return nil, err
+	}
+
+	return marshalSetField(tlv), nil
 }
 
 // TODO: Marshal Enqueue
 
-// TODO: Marshal SetVLANVID
+func marshalDecTTL() ([]byte, error) {
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint16(v[0:2], OFPAT_DEC_NW_TTL)
+	binary.BigEndian.PutUint16(v[2:4], 8)
+	// v[4:8] is padding
+
+	return v, nil
+}
+
+// vlanTag is the VLAN tag of a packet.
+type vlanTag struct {
+	tagged bool
+	vid    uint16
+}
+
+// matchedTag returns the VLAN tag of the packets matched with match. The packets are assumed to be untagged if
+// match is nil or does not specify the VLAN ID.
+func matchedTag(match openflow.Match) vlanTag {
+	m, ok := match.(*Match)
+	if !ok {
+		return vlanTag{}
+	}
+	wildcard, vid := m.VLANID()
+	vid &= 0xFFF
+	if wildcard || vid == 0 {
+		return vlanTag{}
+	}
+
+	return vlanTag{tagged: true, vid: vid}
+}
+
+// marshalVLANTag changes the VLAN tag of a packet from cur to tag. Unlike OpenFlow 1.0, SET_FIELD of OpenFlow 1.3
+// only modifies an existing tag, so we should push a new tag first to tag an untagged packet.
+func marshalVLANTag(cur, tag vlanTag) ([]byte, error) {
+	if cur == tag {
+		return nil, nil
+	}
+	if !tag.tagged {
+		pop := make([]byte, 8)
+		binary.BigEndian.PutUint16(pop[0:2], OFPAT_POP_VLAN)
+		binary.BigEndian.PutUint16(pop[2:4], 8)
+		// pop[4:8] is padding
+		return pop, nil
+	}
+
+	result := make([]byte, 0)
+	if !cur.tagged {
+		push := make([]byte, 8)
+		binary.BigEndian.PutUint16(push[0:2], OFPAT_PUSH_VLAN)
+		binary.BigEndian.PutUint16(push[2:4], 8)
+		binary.BigEndian.PutUint16(push[4:6], 0x8100)
+		// push[6:8] is padding
+		result = append(result, push...)
+	}
+	// OFPVID_PRESENT should be set to tag the packet
+	tlv, err := marshalUint16TLV(OFPXMT_OFB_VLAN_VID, tag.vid|0x1000)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(result, marshalSetField(tlv)...), nil
+}
+
+// marshalAction marshals action that is applied to the packets matched with match, which can be nil if the
+// packets are unknown.
+func marshalAction(action openflow.Action, match openflow.Match) ([]byte, error) {
+	if v, ok := action.(*Action); ok {
+		return v.marshal(match)
+	}
+
+	return action.MarshalBinary()
+}
 
 func (r *Action) MarshalBinary() ([]byte, error) {
+	return r.marshal(nil)
+}
+
+func (r *Action) marshal(match openflow.Match) ([]byte, error) {
 	if err := r.Error(); err != nil {
 		return nil, err
 	}
 
+	orig := matchedTag(match)
+	cur := orig
 	result := make([]byte, 0)
 	if ok, srcMAC := r.SrcMAC(); ok {
 		v, err := marshalMAC(OFPXMT_OFB_ETH_SRC, srcMAC)
@@ -115,12 +238,101 @@ func (r *Action) MarshalBinary() ([]byte, error) {
 		}
 		result = append(result, v...)
 	}
+	if ok, srcIP := r.SrcIP(); ok {
+		v, err := marshalIP(OFPXMT_OFB_IPV4_SRC, srcIP)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+	if ok, dstIP := r.DstIP(); ok {
+		v, err := marshalIP(OFPXMT_OFB_IPV4_DST, dstIP)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+	if ok, protocol, srcPort := r.SrcPort(); ok {
+		v, err := marshalTransportPort(protocol, true, srcPort)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+	if ok, protocol, dstPort := r.DstPort(); ok {
+		v, err := marshalTransportPort(protocol, false, dstPort)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+	if ok, vlanID := r.VLANID(); ok {
+		tag := vlanTag{tagged: true, vid: vlanID}
+		v, err := marshalVLANTag(cur, tag)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+		cur = tag
+	}
+	if r.DecTTL() {
+		v, err := marshalDecTTL()
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
 
-	v, err := marshalOutput(r.OutPort())
-	if err != nil {
-		return nil, err
+	for _, p := range append([]openflow.OutPort{r.OutPort()}, r.ExtraOutPorts()...) {
+		v, err := marshalOutput(p)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+	}
+
+	// Mirrors are applied after the main output so that they do not modify the original packet
+	mirrors := r.Mirrors()
+	if len(mirrors) > 0 && match != nil {
+		// Restore the MAC addresses rewritten by the main output if the original ones are known
+		if ok, _ := r.SrcMAC(); ok {
+			if wildcard, mac := match.SrcMAC(); !wildcard {
+				v, err := marshalMAC(OFPXMT_OFB_ETH_SRC, mac)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, v...)
+			}
+		}
+		if ok, _ := r.DstMAC(); ok {
+			if wildcard, mac := match.DstMAC(); !wildcard {
+				v, err := marshalMAC(OFPXMT_OFB_ETH_DST, mac)
+				if err != nil {
+					return nil, err
+				}
+				result = append(result, v...)
+			}
+		}
+	}
+	for _, m := range mirrors {
+		// Each copy has the original VLAN tag unless it is tagged with the VLAN ID of the mirror
+		tag := orig
+		if m.VLANID != 0 {
+			tag = vlanTag{tagged: true, vid: m.VLANID}
+		}
+		v, err := marshalVLANTag(cur, tag)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
+		cur = tag
+
+		v, err = marshalOutput(m.Port)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, v...)
 	}
-	result = append(result, v...)
 
 	return result, nil
 }
@@ -149,6 +361,8 @@ func (r *Action) UnmarshalBinary(data []byte) error {
 			if err := r.Error(); err != nil {
 				return err
 			}
+		case OFPAT_DEC_NW_TTL:
+			r.SetDecTTL()
 		case OFPAT_SET_FIELD:
 			if len(buf) < 8 {
 				return openflow.ErrInvalidPacketLength
@@ -177,6 +391,37 @@ func (r *Action) UnmarshalBinary(data []byte) error {
 				if err := r.Error(); err != nil {
 					return err
 				}
+			case OFPXMT_OFB_IPV4_SRC, OFPXMT_OFB_IPV4_DST:
+				if len(buf) < 12 {
+					return openflow.ErrInvalidPacketLength
+				}
+				ip := net.IPv4(buf[8], buf[9], buf[10], buf[11])
+				if field == OFPXMT_OFB_IPV4_SRC {
+					r.SetSrcIP(ip)
+				} else {
+					r.SetDstIP(ip)
+				}
+				if err := r.Error(); err != nil {
+					return err
+				}
+			case OFPXMT_OFB_TCP_SRC, OFPXMT_OFB_TCP_DST, OFPXMT_OFB_UDP_SRC, OFPXMT_OFB_UDP_DST:
+				if len(buf) < 10 {
+					return openflow.ErrInvalidPacketLength
+				}
+				port := binary.BigEndian.Uint16(buf[8:10])
+				switch field {
+				case OFPXMT_OFB_TCP_SRC:
+					r.SetSrcPort(0x06, port)
+				case OFPXMT_OFB_TCP_DST:
+					r.SetDstPort(0x06, port)
+				case OFPXMT_OFB_UDP_SRC:
+					r.SetSrcPort(0x11, port)
+				default:
+					r.SetDstPort(0x11, port)
+				}
+				if err := r.Error(); err != nil {
+					return err
+				}
 			default:
 				// Do nothing
 			}
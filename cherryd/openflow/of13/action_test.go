@@ -0,0 +1,131 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package of13
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/superkkt/cherry/cherryd/openflow"
+)
+
+func TestMarshalTaggedMirror(t *testing.T) {
+	out := openflow.NewOutPort()
+	out.SetValue(1)
+	mirror := openflow.NewOutPort()
+	mirror.SetValue(2)
+
+	action := NewAction()
+	action.SetOutPort(out)
+	action.AddMirror(openflow.Mirror{Port: mirror, VLANID: 100})
+	v, err := action.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		// Main output to port 1
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// PUSH_VLAN should precede SET_FIELD
+		0x00, 0x11, 0x00, 0x08, 0x81, 0x00, 0x00, 0x00,
+		// SET_FIELD vlan_vid=100 with OFPVID_PRESENT
+		0x00, 0x19, 0x00, 0x10, 0x80, 0x00, 0x0C, 0x02, 0x10, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Mirror output to port 2
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x02, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if !bytes.Equal(v, expected) {
+		t.Fatalf("unexpected marshaled action:\nexpected %x\n     got %x", expected, v)
+	}
+}
+
+func TestMarshalTaggedMatch(t *testing.T) {
+	match := NewMatch()
+	match.SetVLANID(10)
+	match.SetDstMAC(net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55})
+
+	out := openflow.NewOutPort()
+	out.SetValue(1)
+	mirror := openflow.NewOutPort()
+	mirror.SetValue(2)
+
+	action := NewAction()
+	action.SetDstMAC(net.HardwareAddr{0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB})
+	action.SetVLANID(20)
+	action.SetOutPort(out)
+	action.AddMirror(openflow.Mirror{Port: mirror})
+	v, err := marshalAction(action, match)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		// SET_FIELD eth_dst=66:77:88:99:aa:bb
+		0x00, 0x19, 0x00, 0x10, 0x80, 0x00, 0x06, 0x06, 0x66, 0x77, 0x88, 0x99, 0xAA, 0xBB, 0x00, 0x00,
+		// SET_FIELD vlan_vid=20 without PUSH_VLAN as the packets are already tagged
+		0x00, 0x19, 0x00, 0x10, 0x80, 0x00, 0x0C, 0x02, 0x10, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Main output to port 1
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// SET_FIELD eth_dst=00:11:22:33:44:55 to restore the original destination
+		0x00, 0x19, 0x00, 0x10, 0x80, 0x00, 0x06, 0x06, 0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x00,
+		// SET_FIELD vlan_vid=10 to restore the original VLAN ID
+		0x00, 0x19, 0x00, 0x10, 0x80, 0x00, 0x0C, 0x02, 0x10, 0x0A, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Mirror output to port 2
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x02, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if !bytes.Equal(v, expected) {
+		t.Fatalf("unexpected marshaled action:\nexpected %x\n     got %x", expected, v)
+	}
+}
+
+func TestMarshalExtraOutPorts(t *testing.T) {
+	out := openflow.NewOutPort()
+	out.SetValue(1)
+	extra := openflow.NewOutPort()
+	extra.SetValue(2)
+	mirror := openflow.NewOutPort()
+	mirror.SetValue(3)
+
+	action := NewAction()
+	action.SetOutPort(out)
+	action.AddOutPort(extra)
+	action.AddMirror(openflow.Mirror{Port: mirror, VLANID: 100})
+	v, err := action.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		// Main output to port 1
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x01, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Extra output to port 2 without VLAN changes
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x02, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// PUSH_VLAN and SET_FIELD vlan_vid=100 for the mirror
+		0x00, 0x11, 0x00, 0x08, 0x81, 0x00, 0x00, 0x00,
+		0x00, 0x19, 0x00, 0x10, 0x80, 0x00, 0x0C, 0x02, 0x10, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Mirror output to port 3
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x03, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	if !bytes.Equal(v, expected) {
+		t.Fatalf("unexpected marshaled action:\nexpected %x\n     got %x", expected, v)
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package of13
+
+import (
+	"encoding/binary"
+
+	"github.com/superkkt/cherry/cherryd/openflow"
+)
+
+// SetAsync is OFPT_SET_ASYNC. The first element of each mask is for the master and equal roles, and the second
+// one is for the slave role.
+type SetAsync struct {
+	openflow.Message
+	packetInMask    [2]uint32
+	portStatusMask  [2]uint32
+	flowRemovedMask [2]uint32
+}
+
+// NewSetAsync returns OFPT_SET_ASYNC that has the default configuration of the switch.
+func NewSetAsync(xid uint32) openflow.SetAsync {
+	return &SetAsync{
+		Message:         openflow.NewMessage(openflow.OF13_VERSION, OFPT_SET_ASYNC, xid),
+		packetInMask:    [2]uint32{1<<OFPR_NO_MATCH | 1<<OFPR_ACTION, 0},
+		portStatusMask:  [2]uint32{1<<OFPPR_ADD | 1<<OFPPR_DELETE | 1<<OFPPR_MODIFY, 1<<OFPPR_ADD | 1<<OFPPR_DELETE | 1<<OFPPR_MODIFY},
+		flowRemovedMask: [2]uint32{1<<OFPRR_IDLE_TIMEOUT | 1<<OFPRR_HARD_TIMEOUT | 1<<OFPRR_DELETE | 1<<OFPRR_GROUP_DELETE, 0},
+	}
+}
+
+func (r *SetAsync) SetInvalidTTLToController(enable bool) {
+	if enable {
+		r.packetInMask[0] |= 1 << OFPR_INVALID_TTL
+	} else {
+		r.packetInMask[0] &^= 1 << OFPR_INVALID_TTL
+	}
+}
+
+func (r *SetAsync) MarshalBinary() ([]byte, error) {
+	v := make([]byte, 24)
+	for i := 0; i < 2; i++ {
+		binary.BigEndian.PutUint32(v[i*4:i*4+4], r.packetInMask[i])
+		binary.BigEndian.PutUint32(v[8+i*4:8+i*4+4], r.portStatusMask[i])
+		binary.BigEndian.PutUint32(v[16+i*4:16+i*4+4], r.flowRemovedMask[i])
+	}
+	r.SetPayload(v)
+
+	return r.Message.MarshalBinary()
+}
@@ -0,0 +1,50 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package of13
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMarshalSetAsync(t *testing.T) {
+	msg := NewSetAsync(1)
+	msg.SetInvalidTTLToController(true)
+	v, err := msg.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{
+		// Header
+		0x04, OFPT_SET_ASYNC, 0x00, 0x20, 0x00, 0x00, 0x00, 0x01,
+		// PACKET_IN of the master has the invalid TTL reason, and the slave has nothing
+		0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x00,
+		// PORT_STATUS of the master and slave
+		0x00, 0x00, 0x00, 0x07, 0x00, 0x00, 0x00, 0x07,
+		// FLOW_REMOVED of the master and slave
+		0x00, 0x00, 0x00, 0x0F, 0x00, 0x00, 0x00, 0x00,
+	}
+	if !bytes.Equal(v, expected) {
+		t.Fatalf("unexpected marshaled message:\nexpected %x\n     got %x", expected, v)
+	}
+}
@@ -65,8 +65,11 @@ const (
 )
 
 const (
-	OFPAT_OUTPUT    = 0
-	OFPAT_SET_FIELD = 25
+	OFPAT_OUTPUT     = 0
+	OFPAT_PUSH_VLAN  = 17
+	OFPAT_POP_VLAN   = 18
+	OFPAT_DEC_NW_TTL = 24
+	OFPAT_SET_FIELD  = 25
 )
 
 const (
@@ -251,6 +254,10 @@ const (
 	OFPMP_EXPERIMENTER = 0xffff
 )
 
+const (
+	OFPMPF_REPLY_MORE = 1 << 0 /* More replies to follow. */
+)
+
 const (
 	OFPG_ANY = 0xffffffff
 )
@@ -268,6 +275,19 @@ const (
 	OFPPR_MODIFY = 2
 )
 
+const (
+	OFPR_NO_MATCH    = 0 /* No matching flow (table-miss flow entry). */
+	OFPR_ACTION      = 1 /* Action explicitly output to controller. */
+	OFPR_INVALID_TTL = 2 /* Packet has invalid TTL */
+)
+
+const (
+	OFPRR_IDLE_TIMEOUT = 0 /* Flow idle time exceeded idle_timeout. */
+	OFPRR_HARD_TIMEOUT = 1 /* Time exceeded hard_timeout. */
+	OFPRR_DELETE       = 2 /* Evicted by a DELETE flow mod. */
+	OFPRR_GROUP_DELETE = 3 /* Group was removed. */
+)
+
 const (
 	OFPIT_GOTO_TABLE     = 1      /* Setup the next table in the lookup pipeline */
 	OFPIT_WRITE_METADATA = 2      /* Setup the metadata field for use later in pipeline */
@@ -277,3 +297,10 @@ const (
 	OFPIT_METER          = 6      /* Apply meter (rate limiter) */
 	OFPIT_EXPERIMENTER   = 0xFFFF /* Experimenter instruction */
 )
+
+const (
+	OFPCR_ROLE_NOCHANGE = 0 /* Don't change current role. */
+	OFPCR_ROLE_EQUAL    = 1 /* Default role, full access. */
+	OFPCR_ROLE_MASTER   = 2 /* Full access, at most one master. */
+	OFPCR_ROLE_SLAVE    = 3 /* Read-only access. */
+)
@@ -98,6 +98,8 @@ func getFlowModCmd(cmd openflow.FlowModCmd) uint8 {
 		c = OFPFC_MODIFY
 	case openflow.FlowDelete:
 		c = OFPFC_DELETE
+	case openflow.FlowDeleteStrict:
+		c = OFPFC_DELETE_STRICT
 	default:
 		panic(fmt.Sprintf("unexpected FlowModCmd: %v", cmd))
 	}
@@ -137,7 +139,9 @@ func (r *Factory) NewFlowStatsRequest() (openflow.FlowStatsRequest, error) {
 	return NewFlowStatsRequest(r.getTransactionID()), nil
 }
 
-// TODO: NewFlowStatsReply() (openflow.FlowStatsReply, error) {
+func (r *Factory) NewFlowStatsReply() (openflow.FlowStatsReply, error) {
+	return new(FlowStatsReply), nil
+}
 
 func (r *Factory) NewPortDescRequest() (openflow.PortDescRequest, error) {
 	return NewPortDescRequest(r.getTransactionID()), nil
@@ -147,6 +151,18 @@ func (r *Factory) NewPortDescReply() (openflow.PortDescReply, error) {
 	return new(PortDescReply), nil
 }
 
+func (r *Factory) NewPortMod() (openflow.PortMod, error) {
+	return NewPortMod(r.getTransactionID()), nil
+}
+
+func (r *Factory) NewRoleRequest() (openflow.RoleRequest, error) {
+	return NewRoleRequest(r.getTransactionID()), nil
+}
+
+func (r *Factory) NewSetAsync() (openflow.SetAsync, error) {
+	return NewSetAsync(r.getTransactionID()), nil
+}
+
 func (r *Factory) NewTableFeaturesRequest() (openflow.TableFeaturesRequest, error) {
 	return NewTableFeaturesRequest(r.getTransactionID()), nil
 }
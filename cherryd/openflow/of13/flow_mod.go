@@ -173,7 +173,7 @@ func (r *FlowMod) MarshalBinary() ([]byte, error) {
 	}
 	v = append(v, match...)
 	if r.instruction != nil {
-		ins, err := r.instruction.MarshalBinary()
+		ins, err := marshalInstruction(r.instruction, r.match)
 		if err != nil {
 			return nil, err
 		}
@@ -52,11 +52,15 @@ type writeAction struct {
 }
 
 func (r *writeAction) MarshalBinary() ([]byte, error) {
+	return r.marshal(nil)
+}
+
+func (r *writeAction) marshal(match openflow.Match) ([]byte, error) {
 	if r.action == nil {
 		return nil, errors.New("empty action")
 	}
 
-	action, err := r.action.MarshalBinary()
+	action, err := marshalAction(r.action, match)
 	if err != nil {
 		return nil, err
 	}
@@ -74,11 +78,15 @@ type applyAction struct {
 }
 
 func (r *applyAction) MarshalBinary() ([]byte, error) {
+	return r.marshal(nil)
+}
+
+func (r *applyAction) marshal(match openflow.Match) ([]byte, error) {
 	if r.action == nil {
 		return nil, errors.New("empty action")
 	}
 
-	action, err := r.action.MarshalBinary()
+	action, err := marshalAction(r.action, match)
 	if err != nil {
 		return nil, err
 	}
@@ -95,6 +103,17 @@ func (r *Instruction) Error() error {
 	return r.err
 }
 
+func (r *Instruction) Action() openflow.Action {
+	switch v := r.value.(type) {
+	case *writeAction:
+		return v.action
+	case *applyAction:
+		return v.action
+	default:
+		return nil
+	}
+}
+
 func (r *Instruction) GotoTable(tableID uint8) {
 	r.value = &gotoTable{tableID: tableID}
 }
@@ -114,13 +133,32 @@ func (r *Instruction) ApplyAction(act openflow.Action) {
 }
 
 func (r *Instruction) MarshalBinary() ([]byte, error) {
+	return r.marshal(nil)
+}
+
+// marshal marshals the instruction whose actions are applied to the packets matched with match.
+func (r *Instruction) marshal(match openflow.Match) ([]byte, error) {
 	if r.err != nil {
 		return nil, r.err
 	}
 
-	if r.value == nil {
+	switch v := r.value.(type) {
+	case nil:
 		return nil, errors.New("empty action of an instruction")
+	case *writeAction:
+		return v.marshal(match)
+	case *applyAction:
+		return v.marshal(match)
+	default:
+		return v.MarshalBinary()
+	}
+}
+
+// marshalInstruction marshals inst whose actions are applied to the packets matched with match.
+func marshalInstruction(inst openflow.Instruction, match openflow.Match) ([]byte, error) {
+	if v, ok := inst.(*Instruction); ok {
+		return v.marshal(match)
 	}
 
-	return r.value.MarshalBinary()
+	return inst.MarshalBinary()
 }
@@ -110,4 +110,95 @@ func (r *FlowStatsRequest) MarshalBinary() ([]byte, error) {
 	return r.Message.MarshalBinary()
 }
 
-// TODO: Implement FlowStatsReply
+type FlowStatsReply struct {
+	openflow.Message
+	more  bool
+	flows []openflow.FlowStats
+}
+
+func (r *FlowStatsReply) Flows() []openflow.FlowStats {
+	return r.flows
+}
+
+func (r *FlowStatsReply) More() bool {
+	return r.more
+}
+
+func (r *FlowStatsReply) UnmarshalBinary(data []byte) error {
+	if err := r.Message.UnmarshalBinary(data); err != nil {
+		return err
+	}
+
+	payload := r.Payload()
+	if payload == nil || len(payload) < 8 {
+		return openflow.ErrInvalidPacketLength
+	}
+	// payload[0:2] is type and payload[4:8] is padding of ofp_multipart_reply
+	r.more = binary.BigEndian.Uint16(payload[2:4])&OFPMPF_REPLY_MORE != 0
+
+	buf := payload[8:]
+	for len(buf) > 0 {
+		if len(buf) < 56 {
+			return openflow.ErrInvalidPacketLength
+		}
+		length := int(binary.BigEndian.Uint16(buf[0:2]))
+		if length < 56 || len(buf) < length {
+			return openflow.ErrInvalidPacketLength
+		}
+		match := NewMatch()
+		if err := match.UnmarshalBinary(buf[48:length]); err != nil {
+			return err
+		}
+		// Instructions follow the match that is padded to a multiple of 8 bytes
+		instStart := 48 + (int(binary.BigEndian.Uint16(buf[50:52]))+7)/8*8
+		if instStart > length {
+			return openflow.ErrInvalidPacketLength
+		}
+		r.flows = append(r.flows, openflow.FlowStats{
+			TableID: buf[2],
+			// buf[4:12] is duration
+			Priority: binary.BigEndian.Uint16(buf[12:14]),
+			// buf[14:24] is timeouts, flags and padding
+			Cookie:      binary.BigEndian.Uint64(buf[24:32]),
+			PacketCount: binary.BigEndian.Uint64(buf[32:40]),
+			ByteCount:   binary.BigEndian.Uint64(buf[40:48]),
+			Match:       match,
+			OutPorts:    outputPorts(buf[instStart:length]),
+		})
+		buf = buf[length:]
+	}
+
+	return nil
+}
+
+// outputPorts returns the physical ports of the output actions in the apply and write actions instructions.
+func outputPorts(instructions []byte) []uint32 {
+	result := make([]uint32, 0)
+	for len(instructions) >= 8 {
+		length := int(binary.BigEndian.Uint16(instructions[2:4]))
+		if length < 8 || len(instructions) < length {
+			break
+		}
+		t := binary.BigEndian.Uint16(instructions[0:2])
+		if t == OFPIT_APPLY_ACTIONS || t == OFPIT_WRITE_ACTIONS {
+			// instructions[4:8] is padding
+			actions := instructions[8:length]
+			for len(actions) >= 8 {
+				n := int(binary.BigEndian.Uint16(actions[2:4]))
+				if n < 8 || len(actions) < n {
+					break
+				}
+				if binary.BigEndian.Uint16(actions[0:2]) == OFPAT_OUTPUT {
+					port := binary.BigEndian.Uint32(actions[4:8])
+					if port <= OFPP_MAX {
+						result = append(result, port)
+					}
+				}
+				actions = actions[n:]
+			}
+		}
+		instructions = instructions[length:]
+	}
+
+	return result
+}
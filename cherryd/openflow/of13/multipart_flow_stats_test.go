@@ -0,0 +1,49 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+
+package of13
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOutputPorts(t *testing.T) {
+	instructions := []byte{
+		// GOTO_TABLE is ignored
+		0x00, 0x01, 0x00, 0x08, 0x01, 0x00, 0x00, 0x00,
+		// APPLY_ACTIONS
+		0x00, 0x04, 0x00, 0x28, 0x00, 0x00, 0x00, 0x00,
+		// Output to port 3
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x03, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Output to the controller is not a physical port
+		0x00, 0x00, 0x00, 0x10, 0xFF, 0xFF, 0xFF, 0xFD, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// WRITE_ACTIONS
+		0x00, 0x03, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00,
+		// Output to port 7
+		0x00, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x07, 0xFF, 0xFF, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+	}
+	ports := outputPorts(instructions)
+	if expected := []uint32{3, 7}; !reflect.DeepEqual(ports, expected) {
+		t.Fatalf("unexpected output ports: expected %v, got %v", expected, ports)
+	}
+}
@@ -63,6 +63,15 @@ func (r Port) IsPortDown() bool {
 	return false
 }
 
+func (r Port) IsNoFlood() bool {
+	// OpenFlow 1.3 does not have OFPPC_NO_FLOOD
+	return false
+}
+
+func (r Port) IsNoPacketIn() bool {
+	return r.config&OFPPC_NO_PACKET_IN != 0
+}
+
 func (r Port) IsLinkDown() bool {
 	if r.state&OFPPS_LINK_DOWN != 0 {
 		return true
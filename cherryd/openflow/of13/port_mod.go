@@ -0,0 +1,75 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package of13
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/superkkt/cherry/cherryd/openflow"
+)
+
+type PortMod struct {
+	*openflow.BasePortMod
+}
+
+func NewPortMod(xid uint32) openflow.PortMod {
+	return &PortMod{
+		openflow.NewBasePortMod(openflow.NewMessage(openflow.OF13_VERSION, OFPT_PORT_MOD, xid)),
+	}
+}
+
+func (r *PortMod) MarshalBinary() ([]byte, error) {
+	if err := r.Error(); err != nil {
+		return nil, err
+	}
+	if r.HWAddr() == nil {
+		return nil, errors.New("empty hardware address")
+	}
+
+	var config uint32
+	c := r.Config()
+	if c.PortDown {
+		config |= OFPPC_PORT_DOWN
+	}
+	// OpenFlow 1.3 removed OFPPC_NO_FLOOD
+	if c.NoFlood {
+		return nil, openflow.ErrUnsupportedPortConfig
+	}
+	if c.NoPacketIn {
+		config |= OFPPC_NO_PACKET_IN
+	}
+
+	v := make([]byte, 32)
+	binary.BigEndian.PutUint32(v[0:4], r.PortNumber())
+	// v[4:8] is padding
+	copy(v[8:14], r.HWAddr())
+	// v[14:16] is padding
+	binary.BigEndian.PutUint32(v[16:20], config)
+	// Mask of the config flags that we change
+	binary.BigEndian.PutUint32(v[20:24], OFPPC_PORT_DOWN|OFPPC_NO_PACKET_IN)
+	// v[24:28] is advertise. Zero means that we do not change the advertised features.
+	// v[28:32] is padding
+
+	r.SetPayload(v)
+	return r.Message.MarshalBinary()
+}
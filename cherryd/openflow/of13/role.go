@@ -0,0 +1,63 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package of13
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/superkkt/cherry/cherryd/openflow"
+)
+
+type RoleRequest struct {
+	*openflow.BaseRoleRequest
+}
+
+func NewRoleRequest(xid uint32) openflow.RoleRequest {
+	return &RoleRequest{
+		openflow.NewBaseRoleRequest(openflow.NewMessage(openflow.OF13_VERSION, OFPT_ROLE_REQUEST, xid)),
+	}
+}
+
+func (r *RoleRequest) MarshalBinary() ([]byte, error) {
+	var role uint32
+	switch r.Role() {
+	case openflow.RoleNoChange:
+		role = OFPCR_ROLE_NOCHANGE
+	case openflow.RoleEqual:
+		role = OFPCR_ROLE_EQUAL
+	case openflow.RoleMaster:
+		role = OFPCR_ROLE_MASTER
+	case openflow.RoleSlave:
+		role = OFPCR_ROLE_SLAVE
+	default:
+		return nil, fmt.Errorf("invalid controller role: %v", r.Role())
+	}
+
+	v := make([]byte, 16)
+	binary.BigEndian.PutUint32(v[0:4], role)
+	// v[4:8] is padding
+	binary.BigEndian.PutUint64(v[8:16], r.GenerationID())
+
+	r.SetPayload(v)
+	return r.Message.MarshalBinary()
+}
@@ -139,6 +139,8 @@ type Port interface {
 	Name() string
 	IsPortDown() bool // Is the port Administratively down?
 	IsLinkDown() bool // Is a physical link on the port down?
+	IsNoFlood() bool
+	IsNoPacketIn() bool
 	IsCopper() bool
 	IsFiber() bool
 	IsAutoNego() bool
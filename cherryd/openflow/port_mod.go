@@ -0,0 +1,96 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package openflow
+
+import (
+	"encoding"
+	"net"
+)
+
+// PortConfig is a set of the port configuration flags that we are able to change by PORT_MOD.
+type PortConfig struct {
+	// Port is administratively down
+	PortDown bool
+	// Do not include this port when flooding (OpenFlow 1.0 only)
+	NoFlood bool
+	// Do not send PACKET_IN messages for this port
+	NoPacketIn bool
+}
+
+type PortMod interface {
+	Config() PortConfig
+	encoding.BinaryMarshaler
+	Error() error
+	Header
+	HWAddr() net.HardwareAddr
+	PortNumber() uint32
+	SetConfig(c PortConfig)
+	// SetHWAddr sets the hardware address of the port. It should be same with the address of PORT_STATUS or FEATURES_REPLY.
+	SetHWAddr(mac net.HardwareAddr)
+	SetPortNumber(num uint32)
+}
+
+type BasePortMod struct {
+	err error
+	Message
+	number uint32
+	hwAddr net.HardwareAddr
+	config PortConfig
+}
+
+func NewBasePortMod(msg Message) *BasePortMod {
+	return &BasePortMod{
+		Message: msg,
+	}
+}
+
+func (r *BasePortMod) Error() error {
+	return r.err
+}
+
+func (r *BasePortMod) Config() PortConfig {
+	return r.config
+}
+
+func (r *BasePortMod) SetConfig(c PortConfig) {
+	r.config = c
+}
+
+func (r *BasePortMod) HWAddr() net.HardwareAddr {
+	return r.hwAddr
+}
+
+func (r *BasePortMod) SetHWAddr(mac net.HardwareAddr) {
+	if mac == nil || len(mac) < 6 {
+		r.err = ErrInvalidMACAddress
+		return
+	}
+	r.hwAddr = mac
+}
+
+func (r *BasePortMod) PortNumber() uint32 {
+	return r.number
+}
+
+func (r *BasePortMod) SetPortNumber(num uint32) {
+	r.number = num
+}
@@ -0,0 +1,73 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package openflow
+
+import (
+	"encoding"
+)
+
+type ControllerRole uint8
+
+const (
+	RoleNoChange ControllerRole = iota
+	RoleEqual
+	RoleMaster
+	RoleSlave
+)
+
+type RoleRequest interface {
+	encoding.BinaryMarshaler
+	GenerationID() uint64
+	Header
+	Role() ControllerRole
+	// SetGenerationID sets the generation ID that should be increased whenever the master controller changes.
+	SetGenerationID(id uint64)
+	SetRole(role ControllerRole)
+}
+
+type BaseRoleRequest struct {
+	Message
+	role       ControllerRole
+	generation uint64
+}
+
+func NewBaseRoleRequest(msg Message) *BaseRoleRequest {
+	return &BaseRoleRequest{
+		Message: msg,
+	}
+}
+
+func (r *BaseRoleRequest) Role() ControllerRole {
+	return r.role
+}
+
+func (r *BaseRoleRequest) SetRole(role ControllerRole) {
+	r.role = role
+}
+
+func (r *BaseRoleRequest) GenerationID() uint64 {
+	return r.generation
+}
+
+func (r *BaseRoleRequest) SetGenerationID(id uint64) {
+	r.generation = id
+}
@@ -30,6 +30,7 @@ import (
 	"github.com/superkkt/cherry/cherryd/openflow/of10"
 	"github.com/superkkt/cherry/cherryd/openflow/of13"
 	"golang.org/x/net/context"
+	"sync/atomic"
 	"time"
 )
 
@@ -55,8 +56,8 @@ type Transceiver struct {
 	observer    Handler
 	version     uint8
 	factory     openflow.Factory
-	timestamp   time.Time     // Last activated time
-	latency     time.Duration // Network latency measured by echo request and reply
+	timestamp   time.Time // Last activated time
+	latency     int64     // Network latency measured by echo request and reply, which should be accessed atomically
 	pingCounter uint
 	closed      bool
 }
@@ -68,6 +69,7 @@ type Handler interface {
 	OnGetConfigReply(openflow.Factory, Writer, openflow.GetConfigReply) error
 	OnDescReply(openflow.Factory, Writer, openflow.DescReply) error
 	OnPortDescReply(openflow.Factory, Writer, openflow.PortDescReply) error
+	OnFlowStatsReply(openflow.Factory, Writer, openflow.FlowStatsReply) error
 	OnPortStatus(openflow.Factory, Writer, openflow.PortStatus) error
 	OnFlowRemoved(openflow.Factory, Writer, openflow.FlowRemoved) error
 	OnPacketIn(openflow.Factory, Writer, openflow.PacketIn) error
@@ -96,8 +98,9 @@ func (r *Transceiver) Version() (negotiated bool, version uint8) {
 	return true, r.version
 }
 
+// Latency returns the round-trip time between the controller and the switch.
 func (r *Transceiver) Latency() time.Duration {
-	return r.latency
+	return time.Duration(atomic.LoadInt64(&r.latency))
 }
 
 func (r *Transceiver) negotiate(packet []byte) error {
@@ -280,6 +283,8 @@ func (r *Transceiver) parseOF10Message(packet []byte) error {
 		switch binary.BigEndian.Uint16(packet[8:10]) {
 		case of10.OFPST_DESC:
 			return r.handleDescReply(packet)
+		case of10.OFPST_FLOW:
+			return r.handleFlowStatsReply(packet)
 		default:
 			// Unsupported message. Do nothing.
 			return nil
@@ -316,6 +321,8 @@ func (r *Transceiver) parseOF13Message(packet []byte) error {
 			return r.handleDescReply(packet)
 		case of13.OFPMP_PORT_DESC:
 			return r.handlePortDescReply(packet)
+		case of13.OFPMP_FLOW:
+			return r.handleFlowStatsReply(packet)
 		default:
 			// Unsupported message. Do nothing.
 			return nil
@@ -374,7 +381,7 @@ func (r *Transceiver) handleEchoReply(packet []byte) error {
 		return err
 	}
 	// Update network latency
-	r.latency = time.Now().Sub(timestamp)
+	atomic.StoreInt64(&r.latency, int64(time.Now().Sub(timestamp)))
 	// Reset ping counter to zero
 	r.pingCounter = 0
 
@@ -453,6 +460,18 @@ func (r *Transceiver) handlePortDescReply(packet []byte) error {
 	return r.observer.OnPortDescReply(r.factory, r, msg)
 }
 
+func (r *Transceiver) handleFlowStatsReply(packet []byte) error {
+	msg, err := r.factory.NewFlowStatsReply()
+	if err != nil {
+		return err
+	}
+	if err := msg.UnmarshalBinary(packet); err != nil {
+		return err
+	}
+
+	return r.observer.OnFlowStatsReply(r.factory, r, msg)
+}
+
 func (r *Transceiver) handlePortStatus(packet []byte) error {
 	msg, err := r.factory.NewPortStatus()
 	if err != nil {
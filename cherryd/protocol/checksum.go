@@ -53,3 +53,16 @@ func calculateChecksum(header []byte) uint16 {
 
 	return ^uint16(sum)
 }
+
+// updateChecksum returns the checksum that is incrementally updated when old is replaced with new (RFC 1624).
+// old and new should have the same even length.
+func updateChecksum(checksum uint16, old, new []byte) uint16 {
+	sum := uint32(^checksum)
+	for i := 0; i+1 < len(old) && i+1 < len(new); i += 2 {
+		sum += uint32(^binary.BigEndian.Uint16(old[i : i+2]))
+		sum += uint32(binary.BigEndian.Uint16(new[i : i+2]))
+	}
+	sum = aroundCarry(sum)
+
+	return ^uint16(sum)
+}
@@ -0,0 +1,226 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// DHCP message types (RFC 2132)
+const (
+	DHCPDiscover = 1
+	DHCPOffer    = 2
+	DHCPRequest  = 3
+	DHCPDecline  = 4
+	DHCPAck      = 5
+	DHCPNak      = 6
+	DHCPRelease  = 7
+	DHCPInform   = 8
+)
+
+// DHCP option codes (RFC 2132)
+const (
+	DHCPOptSubnetMask   = 1
+	DHCPOptRouter       = 3
+	DHCPOptDNS          = 6
+	DHCPOptRequestedIP  = 50
+	DHCPOptLeaseTime    = 51
+	DHCPOptMessageType  = 53
+	DHCPOptServerID     = 54
+	DHCPOptRenewalTime  = 58
+	DHCPOptRebindTime   = 59
+	dhcpOptPad          = 0
+	dhcpOptEnd          = 255
+	dhcpMagicCookie     = 0x63825363
+	dhcpFixedHeaderSize = 236
+)
+
+type DHCPOption struct {
+	Code uint8
+	Data []byte
+}
+
+// DHCP is a DHCP message (RFC 2131) whose hardware address is an Ethernet MAC address.
+type DHCP struct {
+	Op    uint8
+	Hops  uint8
+	XID   uint32
+	Secs  uint16
+	Flags uint16
+	// Client, your (client), next server, and relay agent IP addresses
+	CIAddr  net.IP
+	YIAddr  net.IP
+	SIAddr  net.IP
+	GIAddr  net.IP
+	CHAddr  net.HardwareAddr
+	Options []DHCPOption
+}
+
+// NewDHCPReply returns a reply message of request whose type is t. The options of the reply are
+// empty except the message type.
+func NewDHCPReply(request *DHCP, t uint8) *DHCP {
+	return &DHCP{
+		Op:      2, // BOOTREPLY
+		XID:     request.XID,
+		Flags:   request.Flags,
+		CIAddr:  net.IPv4zero,
+		YIAddr:  net.IPv4zero,
+		SIAddr:  net.IPv4zero,
+		GIAddr:  request.GIAddr,
+		CHAddr:  request.CHAddr,
+		Options: []DHCPOption{{Code: DHCPOptMessageType, Data: []byte{t}}},
+	}
+}
+
+// IsBroadcast returns whether the client asks the server to broadcast the replies.
+func (r *DHCP) IsBroadcast() bool {
+	return r.Flags&0x8000 != 0
+}
+
+// Option returns the data of the first option whose code is code.
+func (r *DHCP) Option(code uint8) (data []byte, ok bool) {
+	for _, v := range r.Options {
+		if v.Code == code {
+			return v.Data, true
+		}
+	}
+
+	return nil, false
+}
+
+// AddOption appends an option whose code is code. Data longer than 255 bytes is truncated.
+func (r *DHCP) AddOption(code uint8, data []byte) {
+	if len(data) > 255 {
+		data = data[:255]
+	}
+	r.Options = append(r.Options, DHCPOption{Code: code, Data: data})
+}
+
+// MessageType returns the value of the DHCP message type option. ok is false if the message
+// does not have the option, which means the message is a BOOTP message.
+func (r *DHCP) MessageType() (t uint8, ok bool) {
+	v, ok := r.Option(DHCPOptMessageType)
+	if !ok || len(v) != 1 {
+		return 0, false
+	}
+
+	return v[0], true
+}
+
+func marshalIPv4(dst []byte, ip net.IP) error {
+	if ip == nil {
+		return nil
+	}
+	v := ip.To4()
+	if v == nil {
+		return errors.New("not an IPv4 address")
+	}
+	copy(dst, v)
+
+	return nil
+}
+
+func (r DHCP) MarshalBinary() ([]byte, error) {
+	if len(r.CHAddr) != 6 {
+		return nil, errors.New("invalid client hardware address")
+	}
+
+	v := make([]byte, dhcpFixedHeaderSize+4)
+	v[0] = r.Op
+	v[1] = 1 // Ethernet
+	v[2] = 6 // Length of the MAC address
+	v[3] = r.Hops
+	binary.BigEndian.PutUint32(v[4:8], r.XID)
+	binary.BigEndian.PutUint16(v[8:10], r.Secs)
+	binary.BigEndian.PutUint16(v[10:12], r.Flags)
+	for i, ip := range []net.IP{r.CIAddr, r.YIAddr, r.SIAddr, r.GIAddr} {
+		offset := 12 + i*4
+		if err := marshalIPv4(v[offset:offset+4], ip); err != nil {
+			return nil, err
+		}
+	}
+	copy(v[28:34], r.CHAddr)
+	// v[34:236] is the padding of chaddr, sname, and file
+	binary.BigEndian.PutUint32(v[236:240], dhcpMagicCookie)
+
+	for _, opt := range r.Options {
+		if len(opt.Data) > 255 {
+			return nil, errors.New("too long DHCP option")
+		}
+		v = append(v, opt.Code, uint8(len(opt.Data)))
+		v = append(v, opt.Data...)
+	}
+	v = append(v, dhcpOptEnd)
+	// Minimum length of a BOOTP message is 300 bytes
+	if len(v) < 300 {
+		v = append(v, bytes.Repeat([]byte{0}, 300-len(v))...)
+	}
+
+	return v, nil
+}
+
+func (r *DHCP) UnmarshalBinary(data []byte) error {
+	if len(data) < dhcpFixedHeaderSize+4 {
+		return errors.New("invalid DHCP packet length")
+	}
+	if data[1] != 1 || data[2] != 6 {
+		return errors.New("unsupported DHCP hardware address type")
+	}
+	if binary.BigEndian.Uint32(data[236:240]) != dhcpMagicCookie {
+		return errors.New("invalid DHCP magic cookie")
+	}
+
+	r.Op = data[0]
+	r.Hops = data[3]
+	r.XID = binary.BigEndian.Uint32(data[4:8])
+	r.Secs = binary.BigEndian.Uint16(data[8:10])
+	r.Flags = binary.BigEndian.Uint16(data[10:12])
+	r.CIAddr = net.IP(data[12:16])
+	r.YIAddr = net.IP(data[16:20])
+	r.SIAddr = net.IP(data[20:24])
+	r.GIAddr = net.IP(data[24:28])
+	r.CHAddr = net.HardwareAddr(data[28:34])
+
+	r.Options = make([]DHCPOption, 0)
+	buf := data[dhcpFixedHeaderSize+4:]
+	for len(buf) > 0 {
+		code := buf[0]
+		if code == dhcpOptEnd {
+			break
+		}
+		if code == dhcpOptPad {
+			buf = buf[1:]
+			continue
+		}
+		if len(buf) < 2 || len(buf) < 2+int(buf[1]) {
+			return errors.New("invalid DHCP option length")
+		}
+		length := int(buf[1])
+		r.Options = append(r.Options, DHCPOption{Code: code, Data: buf[2 : 2+length]})
+		buf = buf[2+length:]
+	}
+
+	return nil
+}
@@ -0,0 +1,90 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestDHCPMarshal(t *testing.T) {
+	request := &DHCP{
+		Op:     1,
+		XID:    0x3903F326,
+		Flags:  0x8000,
+		CIAddr: net.IPv4zero,
+		YIAddr: net.IPv4zero,
+		SIAddr: net.IPv4zero,
+		GIAddr: net.IPv4zero,
+		CHAddr: net.HardwareAddr{0x00, 0x0c, 0x29, 0x01, 0x02, 0x03},
+	}
+	request.AddOption(DHCPOptMessageType, []byte{DHCPRequest})
+	request.AddOption(DHCPOptRequestedIP, net.IPv4(10, 0, 0, 5).To4())
+
+	data, err := request.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 300 {
+		t.Fatalf("unexpected message length: expected=300, got=%v", len(data))
+	}
+
+	v := new(DHCP)
+	if err := v.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+	if v.Op != 1 || v.XID != request.XID || !v.IsBroadcast() || !bytes.Equal(v.CHAddr, request.CHAddr) {
+		t.Fatalf("unexpected message: %+v", v)
+	}
+	if msgType, ok := v.MessageType(); !ok || msgType != DHCPRequest {
+		t.Fatalf("unexpected message type: %v", msgType)
+	}
+	if ip, ok := v.Option(DHCPOptRequestedIP); !ok || !net.IP(ip).Equal(net.IPv4(10, 0, 0, 5)) {
+		t.Fatalf("unexpected requested IP address: %v", net.IP(ip))
+	}
+
+	reply := NewDHCPReply(v, DHCPAck)
+	if reply.Op != 2 || reply.XID != v.XID || !bytes.Equal(reply.CHAddr, v.CHAddr) {
+		t.Fatalf("unexpected reply: %+v", reply)
+	}
+	if msgType, ok := reply.MessageType(); !ok || msgType != DHCPAck {
+		t.Fatalf("unexpected reply type: %v", msgType)
+	}
+}
+
+func TestDHCPUnmarshalInvalid(t *testing.T) {
+	if err := new(DHCP).UnmarshalBinary(make([]byte, 100)); err == nil {
+		t.Fatal("expected an error for a short message")
+	}
+
+	request := &DHCP{Op: 1, CHAddr: net.HardwareAddr{0, 1, 2, 3, 4, 5}}
+	data, err := request.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Option whose length exceeds the message
+	data = append(data[:240], DHCPOptMessageType, 10, 1)
+	if err := new(DHCP).UnmarshalBinary(data); err == nil {
+		t.Fatal("expected an error for a truncated option")
+	}
+}
@@ -94,3 +94,69 @@ func (r *ICMPEcho) UnmarshalBinary(data []byte) error {
 
 	return nil
 }
+
+// ICMPError is an ICMP error message such as destination unreachable and time exceeded, which carries
+// the IP header and the first 8 bytes of the original datagram.
+type ICMPError struct {
+	ICMP
+	Payload []byte
+}
+
+// NewICMPDestinationUnreachable returns a destination unreachable message for the original IPv4 packet.
+func NewICMPDestinationUnreachable(code uint8, original []byte) *ICMPError {
+	return newICMPError(3, code, original)
+}
+
+// NewICMPTimeExceeded returns a time exceeded message for the original IPv4 packet.
+func NewICMPTimeExceeded(code uint8, original []byte) *ICMPError {
+	return newICMPError(11, code, original)
+}
+
+func newICMPError(t, code uint8, original []byte) *ICMPError {
+	length := len(original)
+	if length >= 20 {
+		// IP header and the first 8 bytes of the payload (RFC 792)
+		if v := int(original[0]&0xF)*4 + 8; v < length {
+			length = v
+		}
+	}
+	payload := make([]byte, length)
+	copy(payload, original)
+
+	return &ICMPError{
+		ICMP: ICMP{
+			Type: t,
+			Code: code,
+		},
+		Payload: payload,
+	}
+}
+
+func (r ICMPError) MarshalBinary() ([]byte, error) {
+	v := make([]byte, 8)
+	v[0] = r.Type
+	v[1] = r.Code
+	// v[2:4] is checksum, and v[4:8] is unused
+	v = append(v, r.Payload...)
+
+	checksum := calculateChecksum(v)
+	binary.BigEndian.PutUint16(v[2:4], checksum)
+
+	return v, nil
+}
+
+func (r *ICMPError) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("invalid ICMP packet length")
+	}
+	if data[0] != 3 && data[0] != 11 {
+		return errors.New("packet is not an ICMP error message")
+	}
+
+	r.Type = data[0]
+	r.Code = data[1]
+	r.Checksum = binary.BigEndian.Uint16(data[2:4])
+	r.Payload = data[8:]
+
+	return nil
+}
@@ -0,0 +1,231 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Next header value of ICMPv6
+const ICMPv6Protocol = 58
+
+const (
+	ICMPv6EchoRequest           = 128
+	ICMPv6EchoReply             = 129
+	ICMPv6RouterSolicitation    = 133
+	ICMPv6RouterAdvertisement   = 134
+	ICMPv6NeighborSolicitation  = 135
+	ICMPv6NeighborAdvertisement = 136
+)
+
+// NDP option types
+const (
+	ndpOptSourceLinkAddr = 1
+	ndpOptTargetLinkAddr = 2
+)
+
+type ICMPv6 struct {
+	srcIP    net.IP
+	dstIP    net.IP
+	Type     uint8
+	Code     uint8
+	Checksum uint16
+	// Message body that follows the checksum
+	Payload []byte
+}
+
+// ICMPv6 checksum needs a pseudo header that has src and dst IPv6 addresses.
+func (r *ICMPv6) SetPseudoHeader(src, dst net.IP) {
+	r.srcIP = src
+	r.dstIP = dst
+}
+
+func (r ICMPv6) MarshalBinary() ([]byte, error) {
+	v := make([]byte, 4)
+	v[0] = r.Type
+	v[1] = r.Code
+	// v[2:4] is checksum
+	if r.Payload != nil {
+		v = append(v, r.Payload...)
+	}
+
+	pseudo, err := ipv6PseudoHeader(r.srcIP, r.dstIP, ICMPv6Protocol, len(v))
+	if err != nil {
+		return nil, err
+	}
+	checksum := calculateChecksum(append(pseudo, v...))
+	binary.BigEndian.PutUint16(v[2:4], checksum)
+
+	return v, nil
+}
+
+func (r *ICMPv6) UnmarshalBinary(data []byte) error {
+	if len(data) < 4 {
+		return errors.New("invalid ICMPv6 packet length")
+	}
+
+	r.Type = data[0]
+	r.Code = data[1]
+	r.Checksum = binary.BigEndian.Uint16(data[2:4])
+	if len(data) > 4 {
+		r.Payload = data[4:]
+	}
+
+	return nil
+}
+
+// NeighborSolicitation is the message body of an ICMPv6 neighbor solicitation (RFC 4861).
+type NeighborSolicitation struct {
+	Target net.IP
+	// SourceLinkAddr is nil if the message does not have the source link-layer address option,
+	// which is the case of the duplicate address detection.
+	SourceLinkAddr net.HardwareAddr
+}
+
+func (r NeighborSolicitation) MarshalBinary() ([]byte, error) {
+	return marshalNDP(0, r.Target, ndpOptSourceLinkAddr, r.SourceLinkAddr)
+}
+
+func (r *NeighborSolicitation) UnmarshalBinary(data []byte) error {
+	target, mac, err := unmarshalNDP(data, ndpOptSourceLinkAddr)
+	if err != nil {
+		return err
+	}
+	r.Target = target
+	r.SourceLinkAddr = mac
+
+	return nil
+}
+
+// NeighborAdvertisement is the message body of an ICMPv6 neighbor advertisement (RFC 4861).
+type NeighborAdvertisement struct {
+	Router    bool
+	Solicited bool
+	Override  bool
+	Target    net.IP
+	// TargetLinkAddr is nil if the message does not have the target link-layer address option.
+	TargetLinkAddr net.HardwareAddr
+}
+
+func (r NeighborAdvertisement) MarshalBinary() ([]byte, error) {
+	var flags uint32
+	if r.Router {
+		flags |= 1 << 31
+	}
+	if r.Solicited {
+		flags |= 1 << 30
+	}
+	if r.Override {
+		flags |= 1 << 29
+	}
+
+	return marshalNDP(flags, r.Target, ndpOptTargetLinkAddr, r.TargetLinkAddr)
+}
+
+func (r *NeighborAdvertisement) UnmarshalBinary(data []byte) error {
+	target, mac, err := unmarshalNDP(data, ndpOptTargetLinkAddr)
+	if err != nil {
+		return err
+	}
+	flags := binary.BigEndian.Uint32(data[0:4])
+	r.Router = flags&(1<<31) != 0
+	r.Solicited = flags&(1<<30) != 0
+	r.Override = flags&(1<<29) != 0
+	r.Target = target
+	r.TargetLinkAddr = mac
+
+	return nil
+}
+
+func marshalNDP(flags uint32, target net.IP, optType uint8, mac net.HardwareAddr) ([]byte, error) {
+	if target == nil || target.To16() == nil || target.To4() != nil {
+		return nil, errors.New("target address is not an IPv6 address")
+	}
+
+	v := make([]byte, 20)
+	binary.BigEndian.PutUint32(v[0:4], flags)
+	copy(v[4:20], target.To16())
+	if mac == nil {
+		return v, nil
+	}
+	if len(mac) != 6 {
+		return nil, errors.New("invalid link-layer address")
+	}
+	// Length of the option is in units of 8 octets
+	opt := []byte{optType, 1}
+
+	return append(v, append(opt, mac...)...), nil
+}
+
+// unmarshalNDP returns the target address and the link-layer address in the option whose type is optType.
+func unmarshalNDP(data []byte, optType uint8) (target net.IP, mac net.HardwareAddr, err error) {
+	if len(data) < 20 {
+		return nil, nil, errors.New("invalid NDP message length")
+	}
+	target = net.IP(data[4:20])
+
+	options := data[20:]
+	for len(options) > 0 {
+		if len(options) < 2 || options[1] == 0 || len(options) < int(options[1])*8 {
+			return nil, nil, errors.New("invalid NDP option length")
+		}
+		length := int(options[1]) * 8
+		if options[0] == optType && length == 8 {
+			mac = net.HardwareAddr(options[2:8])
+		}
+		options = options[length:]
+	}
+
+	return target, mac, nil
+}
+
+// NewNeighborAdvertisementPacket returns an Ethernet frame of the neighbor advertisement that tells target is
+// at mac. dstMAC and dstIP are the destination of the frame.
+func NewNeighborAdvertisementPacket(mac net.HardwareAddr, target net.IP, solicited bool, dstMAC net.HardwareAddr, dstIP net.IP) ([]byte, error) {
+	body, err := NeighborAdvertisement{Solicited: solicited, Override: true, Target: target, TargetLinkAddr: mac}.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	icmp := ICMPv6{Type: ICMPv6NeighborAdvertisement, Payload: body}
+	icmp.SetPseudoHeader(target, dstIP)
+	msg, err := icmp.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	ip := NewIPv6(target, dstIP, ICMPv6Protocol, msg)
+	// NDP messages are only accepted if their hop limit is 255
+	ip.HopLimit = 255
+	packet, err := ip.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	eth := Ethernet{
+		SrcMAC:  mac,
+		DstMAC:  dstMAC,
+		Type:    0x86DD,
+		Payload: packet,
+	}
+
+	return eth.MarshalBinary()
+}
@@ -0,0 +1,122 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestNeighborAdvertisementPacket(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	target, dst := net.ParseIP("2001:db8::10"), net.ParseIP("2001:db8::20")
+	dstMAC := net.HardwareAddr{0x00, 0x66, 0x77, 0x88, 0x99, 0xaa}
+
+	packet, err := NewNeighborAdvertisementPacket(mac, target, true, dstMAC, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	eth := new(Ethernet)
+	if err := eth.UnmarshalBinary(packet); err != nil {
+		t.Fatal(err)
+	}
+	if eth.Type != 0x86DD || !bytes.Equal(eth.SrcMAC, mac) || !bytes.Equal(eth.DstMAC, dstMAC) {
+		t.Fatalf("unexpected Ethernet header: %+v", eth)
+	}
+	ip := new(IPv6)
+	if err := ip.UnmarshalBinary(eth.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if ip.NextHeader != ICMPv6Protocol || ip.HopLimit != 255 || !ip.SrcIP.Equal(target) || !ip.DstIP.Equal(dst) {
+		t.Fatalf("unexpected IPv6 header: %+v", ip)
+	}
+	// Checksum over the pseudo header and the message including its checksum should be zero
+	pseudo, err := ipv6PseudoHeader(ip.SrcIP, ip.DstIP, ICMPv6Protocol, len(ip.Payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := calculateChecksum(append(pseudo, ip.Payload...)); v != 0 {
+		t.Fatalf("invalid ICMPv6 checksum: %x", v)
+	}
+
+	icmp := new(ICMPv6)
+	if err := icmp.UnmarshalBinary(ip.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if icmp.Type != ICMPv6NeighborAdvertisement {
+		t.Fatalf("unexpected ICMPv6 type: %v", icmp.Type)
+	}
+	na := new(NeighborAdvertisement)
+	if err := na.UnmarshalBinary(icmp.Payload); err != nil {
+		t.Fatal(err)
+	}
+	if na.Router || !na.Solicited || !na.Override || !na.Target.Equal(target) || !bytes.Equal(na.TargetLinkAddr, mac) {
+		t.Fatalf("unexpected neighbor advertisement: %+v", na)
+	}
+}
+
+func TestNeighborSolicitation(t *testing.T) {
+	mac := net.HardwareAddr{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}
+	target := net.ParseIP("2001:db8::10")
+
+	v, err := NeighborSolicitation{Target: target, SourceLinkAddr: mac}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := new(NeighborSolicitation)
+	if err := ns.UnmarshalBinary(v); err != nil {
+		t.Fatal(err)
+	}
+	if !ns.Target.Equal(target) || !bytes.Equal(ns.SourceLinkAddr, mac) {
+		t.Fatalf("unexpected neighbor solicitation: %+v", ns)
+	}
+
+	// Duplicate address detection does not have the source link-layer address option
+	v, err = NeighborSolicitation{Target: target}.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns = new(NeighborSolicitation)
+	if err := ns.UnmarshalBinary(v); err != nil {
+		t.Fatal(err)
+	}
+	if ns.SourceLinkAddr != nil {
+		t.Fatalf("unexpected source link-layer address: %v", ns.SourceLinkAddr)
+	}
+
+	// Zero length option
+	if err := ns.UnmarshalBinary(append(v, 1, 0, 0, 0, 0, 0, 0, 0)); err == nil {
+		t.Fatal("expected an error for the invalid option")
+	}
+}
+
+func TestIPv6MulticastAddresses(t *testing.T) {
+	ip := net.ParseIP("2001:db8::12:3456")
+	if v := SolicitedNodeAddress(ip); !v.Equal(net.ParseIP("ff02::1:ff12:3456")) {
+		t.Fatalf("unexpected solicited-node address: %v", v)
+	}
+	if v := IPv6MulticastMAC(IPv6AllNodes); !bytes.Equal(v, net.HardwareAddr{0x33, 0x33, 0, 0, 0, 1}) {
+		t.Fatalf("unexpected multicast MAC address: %v", v)
+	}
+}
@@ -138,3 +138,92 @@ func (r *IPv4) UnmarshalBinary(data []byte) error {
 
 	return nil
 }
+
+// RewriteIPv4 returns a copy of packet, which is an IPv4 packet including its header, whose addresses and
+// TCP or UDP ports are replaced with the non-nil IP addresses and non-zero ports. The IPv4 header checksum and
+// the TCP or UDP checksum are updated incrementally so that the payload does not need to be parsed.
+func RewriteIPv4(packet []byte, srcIP, dstIP net.IP, srcPort, dstPort uint16) ([]byte, error) {
+	if len(packet) < 20 || packet[0]>>4 != 4 {
+		return nil, errors.New("invalid IPv4 packet")
+	}
+	headerLen := int(packet[0]&0xF) * 4
+	if headerLen < 20 || len(packet) < headerLen {
+		return nil, errors.New("invalid IPv4 header length")
+	}
+
+	v := make([]byte, len(packet))
+	copy(v, packet)
+	header := v[:headerLen]
+	segment := v[headerLen:]
+
+	// Offset of the TCP or UDP checksum. Negative value means that there is no checksum to be updated.
+	checksum := -1
+	transport := false
+	// Only the first fragment has the transport header
+	if binary.BigEndian.Uint16(header[6:8])&0x1FFF == 0 {
+		switch header[9] {
+		case 6: // TCP
+			if len(segment) >= 20 {
+				transport = true
+				checksum = 16
+			}
+		case 17: // UDP
+			if len(segment) >= 8 {
+				transport = true
+				// Zero checksum means that the sender did not calculate it
+				if binary.BigEndian.Uint16(segment[6:8]) != 0 {
+					checksum = 6
+				}
+			}
+		}
+	}
+	if (srcPort != 0 || dstPort != 0) && !transport {
+		return nil, errors.New("missing TCP or UDP header")
+	}
+
+	replace := func(field, value []byte, pseudo bool) {
+		old := make([]byte, len(field))
+		copy(old, field)
+		copy(field, value)
+		if pseudo {
+			sum := binary.BigEndian.Uint16(header[10:12])
+			binary.BigEndian.PutUint16(header[10:12], updateChecksum(sum, old, value))
+		}
+		if checksum < 0 {
+			return
+		}
+		sum := updateChecksum(binary.BigEndian.Uint16(segment[checksum:checksum+2]), old, value)
+		// Zero UDP checksum is transmitted as all ones
+		if header[9] == 17 && sum == 0 {
+			sum = 0xFFFF
+		}
+		binary.BigEndian.PutUint16(segment[checksum:checksum+2], sum)
+	}
+
+	if srcIP != nil {
+		ip := srcIP.To4()
+		if ip == nil {
+			return nil, errors.New("source IP address is not an IPv4 address")
+		}
+		replace(header[12:16], ip, true)
+	}
+	if dstIP != nil {
+		ip := dstIP.To4()
+		if ip == nil {
+			return nil, errors.New("destination IP address is not an IPv4 address")
+		}
+		replace(header[16:20], ip, true)
+	}
+	if srcPort != 0 {
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, srcPort)
+		replace(segment[0:2], port, false)
+	}
+	if dstPort != 0 {
+		port := make([]byte, 2)
+		binary.BigEndian.PutUint16(port, dstPort)
+		replace(segment[2:4], port, false)
+	}
+
+	return v, nil
+}
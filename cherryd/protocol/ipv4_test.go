@@ -0,0 +1,72 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package protocol
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func newTCPPacket(t *testing.T, srcIP, dstIP net.IP, srcPort, dstPort uint16) []byte {
+	segment := TCP{SrcPort: srcPort, DstPort: dstPort, Sequence: 0x12345678, Flags: 0x02, WindowSize: 0xFFFF, Payload: []byte("hello")}
+	segment.SetPseudoHeader(srcIP, dstIP)
+	payload, err := segment.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet, err := NewIPv4(srcIP, dstIP, 6, payload).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return packet
+}
+
+func TestRewriteIPv4(t *testing.T) {
+	client, vip, backend := net.IPv4(10, 0, 0, 5), net.IPv4(10, 0, 0, 100), net.IPv4(10, 0, 1, 7)
+
+	packet := newTCPPacket(t, client, vip, 40000, 80)
+	v, err := RewriteIPv4(packet, nil, backend, 0, 8080)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The incrementally updated checksums should be same with the ones calculated from scratch
+	expected := newTCPPacket(t, client, backend, 40000, 8080)
+	if !bytes.Equal(v, expected) {
+		t.Fatalf("unexpected packet:\nexpected=%x\ngot=%x", expected, v)
+	}
+	if bytes.Equal(packet, v) {
+		t.Fatal("the original packet should not be modified")
+	}
+
+	icmp, err := NewIPv4(client, vip, 1, []byte{8, 0, 0xF7, 0xFF, 0, 0, 0, 0}).MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RewriteIPv4(icmp, nil, backend, 0, 8080); err == nil {
+		t.Fatal("rewriting ports of an ICMP packet should fail")
+	}
+	if _, err := RewriteIPv4(icmp, nil, backend, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,155 @@
+/*
+ * Cherry - An OpenFlow Controller
+ *
+ * Copyright (C) 2015 Samjung Data Service, Inc. All rights reserved.
+ * Kitae Kim <superkkt@sds.co.kr>
+ *
+ * This program is free software; you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation; either version 2 of the License, or
+ * any later version.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License along
+ * with this program; if not, write to the Free Software Foundation, Inc.,
+ * 51 Franklin Street, Fifth Floor, Boston, MA 02110-1301 USA.
+ */
+
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+)
+
+// Multicast addresses of all the nodes and all the routers on the link
+var (
+	IPv6AllNodes   = net.ParseIP("ff02::1")
+	IPv6AllRouters = net.ParseIP("ff02::2")
+)
+
+// IPv6 is an IPv6 packet. Extension headers, if any, are not parsed and remain in the payload.
+type IPv6 struct {
+	Version      uint8
+	TrafficClass uint8
+	FlowLabel    uint32
+	// Length of the payload, which includes the extension headers
+	Length     uint16
+	NextHeader uint8
+	HopLimit   uint8
+	SrcIP      net.IP
+	DstIP      net.IP
+	Payload    []byte
+}
+
+func NewIPv6(src, dst net.IP, nextHeader uint8, payload []byte) *IPv6 {
+	if len(payload) > 0xFFFF {
+		panic("payload is too long")
+	}
+
+	return &IPv6{
+		Version:    6,
+		Length:     uint16(len(payload)),
+		NextHeader: nextHeader,
+		HopLimit:   64,
+		SrcIP:      src,
+		DstIP:      dst,
+		Payload:    payload,
+	}
+}
+
+func (r IPv6) MarshalBinary() ([]byte, error) {
+	if r.SrcIP == nil || r.DstIP == nil {
+		return nil, errors.New("nil IP address")
+	}
+	srcIP := r.SrcIP.To16()
+	if srcIP == nil || r.SrcIP.To4() != nil {
+		return nil, errors.New("source IP address is not an IPv6 address")
+	}
+	dstIP := r.DstIP.To16()
+	if dstIP == nil || r.DstIP.To4() != nil {
+		return nil, errors.New("destination IP address is not an IPv6 address")
+	}
+
+	header := make([]byte, 40)
+	binary.BigEndian.PutUint32(header[0:4], uint32(r.Version&0xF)<<28|uint32(r.TrafficClass)<<20|r.FlowLabel&0xFFFFF)
+	binary.BigEndian.PutUint16(header[4:6], r.Length)
+	header[6] = r.NextHeader
+	header[7] = r.HopLimit
+	copy(header[8:24], srcIP)
+	copy(header[24:40], dstIP)
+
+	if r.Payload == nil {
+		return header, nil
+	}
+	return append(header, r.Payload...), nil
+}
+
+func (r *IPv6) UnmarshalBinary(data []byte) error {
+	if len(data) < 40 {
+		return errors.New("invalid IPv6 packet length")
+	}
+
+	v := binary.BigEndian.Uint32(data[0:4])
+	r.Version = uint8(v >> 28)
+	if r.Version != 6 {
+		return errors.New("invalid IPv6 version")
+	}
+	r.TrafficClass = uint8(v >> 20)
+	r.FlowLabel = v & 0xFFFFF
+	r.Length = binary.BigEndian.Uint16(data[4:6])
+	r.NextHeader = data[6]
+	r.HopLimit = data[7]
+	r.SrcIP = net.IP(data[8:24])
+	r.DstIP = net.IP(data[24:40])
+	if len(data) < 40+int(r.Length) {
+		return errors.New("truncated IPv6 packet")
+	}
+	if r.Length > 0 {
+		r.Payload = data[40 : 40+int(r.Length)]
+	}
+
+	return nil
+}
+
+// SolicitedNodeAddress returns the solicited-node multicast address of ip (RFC 4291).
+func SolicitedNodeAddress(ip net.IP) net.IP {
+	v := net.ParseIP("ff02::1:ff00:0")
+	copy(v[13:16], ip.To16()[13:16])
+
+	return v
+}
+
+// IPv6MulticastMAC returns the Ethernet address of the IPv6 multicast address ip (RFC 2464).
+func IPv6MulticastMAC(ip net.IP) net.HardwareAddr {
+	v := net.HardwareAddr{0x33, 0x33, 0, 0, 0, 0}
+	copy(v[2:6], ip.To16()[12:16])
+
+	return v
+}
+
+// ipv6PseudoHeader returns the pseudo header of the upper-layer checksum (RFC 2460).
+func ipv6PseudoHeader(src, dst net.IP, nextHeader uint8, length int) ([]byte, error) {
+	if src == nil || dst == nil {
+		return nil, errors.New("nil pseudo IP addresses")
+	}
+	if src.To16() == nil || src.To4() != nil {
+		return nil, errors.New("source IP address is not an IPv6 address")
+	}
+	if dst.To16() == nil || dst.To4() != nil {
+		return nil, errors.New("destination IP address is not an IPv6 address")
+	}
+
+	v := make([]byte, 40)
+	copy(v[0:16], src.To16())
+	copy(v[16:32], dst.To16())
+	binary.BigEndian.PutUint32(v[32:36], uint32(length))
+	v[39] = nextHeader
+
+	return v, nil
+}
@@ -36,10 +36,17 @@ type LLDPPortID struct {
 	Data    []byte
 }
 
+// LLDPOption is an optional TLV that follows the three mandatory TLVs.
+type LLDPOption struct {
+	Type uint8
+	Data []byte
+}
+
 type LLDP struct {
 	ChassisID LLDPChassisID
 	PortID    LLDPPortID
 	TTL       uint16
+	Options   []LLDPOption
 }
 
 func (r *LLDP) marshalChassisID() ([]byte, error) {
@@ -115,12 +122,62 @@ func (r *LLDP) MarshalBinary() ([]byte, error) {
 	}
 	v = append(v, ttl...)
 
+	for _, o := range r.Options {
+		option, err := marshalOption(o)
+		if err != nil {
+			return nil, err
+		}
+		v = append(v, option...)
+	}
+
 	// End of TLV
 	v = append(v, []byte{0, 0}...)
 
 	return v, nil
 }
 
+func marshalOption(o LLDPOption) ([]byte, error) {
+	// Type 0 is the end of LLDPDU
+	if o.Type == 0 || o.Type > 127 {
+		return nil, errors.New("invalid option TLV type")
+	}
+	if len(o.Data) > 511 {
+		return nil, errors.New("too long option TLV")
+	}
+
+	length := len(o.Data)
+	header := uint16(uint16(o.Type)<<9 | uint16(length&0x1FF))
+
+	v := make([]byte, length+2)
+	binary.BigEndian.PutUint16(v[0:2], header)
+	copy(v[2:], o.Data)
+
+	return v, nil
+}
+
+func (r *LLDP) unmarshalOptions(data []byte) error {
+	r.Options = nil
+	for len(data) >= 2 {
+		header := binary.BigEndian.Uint16(data[0:2])
+		tlvType := uint8((header >> 9) & 0x7F)
+		tlvLength := int(header & 0x1FF)
+		// End of LLDPDU
+		if tlvType == 0 {
+			return nil
+		}
+		if len(data) < tlvLength+2 {
+			return errors.New("invalid option TLV length")
+		}
+		r.Options = append(r.Options, LLDPOption{
+			Type: tlvType,
+			Data: data[2 : 2+tlvLength],
+		})
+		data = data[2+tlvLength:]
+	}
+
+	return nil
+}
+
 func (r *LLDP) unmarshalChassisID(data []byte) (n int, err error) {
 	length := len(data)
 	if length < 2 {
@@ -216,10 +273,15 @@ func (r *LLDP) UnmarshalBinary(data []byte) error {
 	if length < offset {
 		return errors.New("invalid LLDP packet length")
 	}
-	_, err = r.unmarshalTTL(data[offset:])
+	n, err = r.unmarshalTTL(data[offset:])
 	if err != nil {
 		return err
 	}
+	offset += n
 
-	return nil
+	if length < offset {
+		return errors.New("invalid LLDP packet length")
+	}
+
+	return r.unmarshalOptions(data[offset:])
 }